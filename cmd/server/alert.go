@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+// alertLocks serializes evaluations of alert states of each node in this instance.
+// Nodes are spread over a fixed number of locks, so that reports of other nodes are evaluated concurrently.
+var alertLocks [64]sync.Mutex
+
+// alertLock returns the lock of alert states of the node.
+func alertLock(nodeID string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(nodeID))
+	return &alertLocks[h.Sum32()%uint32(len(alertLocks))]
+}
+
+// evaluateAlerts evaluates all enabled alert rules on the report and updates alert states.
+// States are kept only while values exceed thresholds. Returns events of fired and resolved alerts.
+func evaluateAlerts(report kaginawa.Report) []kaginawa.NodeEvent {
+	rules, err := alertRuleCache.get()
+	if err != nil {
+		log.Printf("failed to list alert rules: %v", err)
+		return nil
+	}
+	lock := alertLock(report.ID)
+	lock.Lock()
+	defer lock.Unlock()
+	var events []kaginawa.NodeEvent
+	now := time.Now().UTC().Unix()
+	for _, rule := range rules {
+		if !rule.AppliesTo(report) {
+			continue
+		}
+		value, ok := rule.Value(report)
+		if !ok {
+			continue
+		}
+		alert, err := db.GetAlert(rule.Name, report.ID)
+		if err != nil {
+			log.Printf("failed to get alert %s of %s: %v", rule.Name, report.ID, err)
+			continue
+		}
+		if alert == nil {
+			if !rule.Exceeds(value) {
+				continue // no states for healthy nodes
+			}
+			alert = &kaginawa.Alert{Rule: rule.Name, NodeID: report.ID}
+		}
+		eventType := alert.Evaluate(rule, report, value, now)
+		if alert.Count == 0 && !alert.Firing {
+			if err := db.DeleteAlert(rule.Name, report.ID); err != nil {
+				log.Printf("failed to delete alert %s of %s: %v", rule.Name, report.ID, err)
+				continue
+			}
+		} else if err := db.PutAlert(*alert); err != nil {
+			log.Printf("failed to put alert %s of %s: %v", rule.Name, report.ID, err)
+			continue
+		}
+		if len(eventType) == 0 {
+			continue
+		}
+		event := kaginawa.NewReportEvent(eventType, report)
+		event.Errors = nil
+		event.Rule = rule.Name
+		event.Condition = rule.Condition()
+		event.Value = &value
+		events = append(events, event)
+	}
+	return events
+}
+
+// handleNewAlertRule handles alert rule registration and update requests.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleNewAlertRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	session := getSession(r)
+	if !session.isLoggedIn() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	rule := kaginawa.AlertRule{
+		Name:      strings.TrimSpace(r.FormValue("name")),
+		Metric:    r.FormValue("metric"),
+		Operator:  r.FormValue("operator"),
+		CustomIDs: splitCommaList(r.FormValue("custom-ids")),
+		Enabled:   r.FormValue("enabled") == "yes",
+		UpdatedBy: session.email(),
+	}
+	threshold, err := strconv.ParseFloat(strings.TrimSpace(r.FormValue("threshold")), 64)
+	if err != nil {
+		http.Error(w, "Invalid threshold value", http.StatusBadRequest)
+		return
+	}
+	rule.Threshold = threshold
+	if v := strings.TrimSpace(r.FormValue("consecutive")); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid consecutive value", http.StatusBadRequest)
+			return
+		}
+		rule.Consecutive = n
+	}
+	if _, err := putAlertRule(rule); err != nil {
+		writeFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleDeleteAlertRule handles alert rule deletion requests.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleDeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !getSession(r).isLoggedIn() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	name := r.FormValue("name")
+	if len(name) == 0 {
+		http.Error(w, "Name is empty", http.StatusBadRequest)
+		return
+	}
+	if err := db.DeleteAlertRule(name); err != nil {
+		log.Printf("failed to delete alert rule: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	alertRuleCache.invalidate()
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleAlertRules handles list of alert rules requests.
+//
+// - Method: GET, HEAD
+// - Client: API
+// - Access: Admin
+// - Response: JSON
+func handleAlertRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if validateAPIKey(r, kaginawa.ScopeAlertsRead) == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	rules, err := db.ListAlertRules()
+	if err != nil {
+		log.Printf("failed to list alert rules: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if rules == nil {
+		rules = []kaginawa.AlertRule{}
+	}
+	writeJSON(w, rules)
+}
+
+// handleAlertRule handles single alert rule requests.
+//
+// - Method: GET, HEAD, PUT or DELETE
+// - Client: API
+// - Access: Admin
+// - Response: JSON (GET, HEAD or PUT) or 204 no content (DELETE)
+func handleAlertRule(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if len(name) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	scope := kaginawa.ScopeAlertsWrite
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		scope = kaginawa.ScopeAlertsRead
+	case http.MethodPut, http.MethodDelete:
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	apiKey := validateAPIKey(r, scope)
+	if apiKey == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var rule kaginawa.AlertRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		rule.Name = name
+		rule.UpdatedBy = apiKey.Label
+		stored, err := putAlertRule(rule)
+		if err != nil {
+			writeFormError(w, err)
+			return
+		}
+		writeJSON(w, stored)
+		return
+	case http.MethodDelete:
+		if err := db.DeleteAlertRule(name); err != nil {
+			log.Printf("failed to delete alert rule: %v", err)
+			http.Error(w, "Database unavailable", http.StatusInternalServerError)
+			return
+		}
+		alertRuleCache.invalidate()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	rule, err := db.GetAlertRule(name)
+	if err != nil {
+		log.Printf("failed to get alert rule: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if rule == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, rule)
+}
+
+// handleAlerts handles list of alert states requests. Set "firing=true" to list firing alerts only.
+//
+// - Method: GET, HEAD
+// - Client: API
+// - Access: Admin
+// - Response: JSON
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if validateAPIKey(r, kaginawa.ScopeAlertsRead) == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	firingOnly, _ := strconv.ParseBool(r.URL.Query().Get("firing"))
+	alerts, err := db.ListAlerts(firingOnly)
+	if err != nil {
+		log.Printf("failed to list alerts: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if alerts == nil {
+		alerts = []kaginawa.Alert{}
+	}
+	writeJSON(w, alerts)
+}
+
+// putAlertRule validates and puts the rule. Returns the stored rule.
+func putAlertRule(rule kaginawa.AlertRule) (kaginawa.AlertRule, error) {
+	if len(rule.Name) == 0 {
+		return rule, errors.New("name is empty")
+	}
+	if err := rule.Validate(); err != nil {
+		return rule, err
+	}
+	rule.UpdatedAt = time.Now().UTC().Unix()
+	if err := db.PutAlertRule(rule); err != nil {
+		return rule, fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+	}
+	alertRuleCache.invalidate()
+	return rule, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+func TestEvaluateAlerts(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	invalidateListCaches()
+	for _, rule := range []kaginawa.AlertRule{
+		{Name: "disk", Metric: kaginawa.MetricDiskUsage, Operator: ">", Threshold: 90, Consecutive: 2, Enabled: true},
+		{Name: "errors", Metric: kaginawa.MetricErrors, Operator: ">", Threshold: 0, Enabled: true,
+			CustomIDs: []string{"site2"}},
+		{Name: "rtt", Metric: kaginawa.MetricRTT, Operator: ">", Threshold: 500},
+	} {
+		if err := db.PutAlertRule(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	full := kaginawa.Report{ID: "node1", CustomID: "site1", DiskTotalBytes: 100, DiskUsedBytes: 95, RTTMills: 800,
+		Errors: []string{"disk full"}}
+	if events := evaluateAlerts(full); len(events) != 0 {
+		t.Errorf("expected no events on the first report, got %+v", events)
+	}
+	events := evaluateAlerts(full)
+	if len(events) != 1 || events[0].Type != kaginawa.EventAlertFiring || events[0].Rule != "disk" ||
+		events[0].Condition != "disk_usage > 90" || *events[0].Value != 95 {
+		t.Fatalf("expected disk alert firing, got %+v", events)
+	}
+	if events := evaluateAlerts(full); len(events) != 0 {
+		t.Errorf("expected deduplicated alert, got %+v", events)
+	}
+	if alerts, _ := db.ListAlerts(true); len(alerts) != 1 || alerts[0].Count != 3 {
+		t.Errorf("expected 1 firing alert, got %+v", alerts)
+	}
+	healthy := full
+	healthy.DiskUsedBytes = 50
+	events = evaluateAlerts(healthy)
+	if len(events) != 1 || events[0].Type != kaginawa.EventAlertResolved {
+		t.Fatalf("expected disk alert resolved, got %+v", events)
+	}
+	if alerts, _ := db.ListAlerts(false); len(alerts) != 0 {
+		t.Errorf("expected resolved alert state deleted, got %+v", alerts)
+	}
+
+	// States are not stored for healthy nodes
+	if events := evaluateAlerts(kaginawa.Report{ID: "node2", CustomID: "site2"}); len(events) != 0 {
+		t.Errorf("expected no events, got %+v", events)
+	}
+	if alert, _ := db.GetAlert("errors", "node2"); alert != nil {
+		t.Errorf("expected no alert state, got %+v", alert)
+	}
+	if err := db.DeleteAlertRule("disk"); err != nil {
+		t.Fatal(err)
+	}
+	if alert, _ := db.GetAlert("disk", "node1"); alert != nil {
+		t.Errorf("expected alert states deleted with the rule, got %+v", alert)
+	}
+}
+
+func TestHandleAlertRule(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	invalidateListCaches()
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:    kaginawa.HashAPIKey(testAPIKey),
+		Label:  "alert key",
+		Scopes: []string{kaginawa.ScopeAlertsRead, kaginawa.ScopeAlertsWrite},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	request := func(method, path, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://localhost:8080"+path, strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"name": "disk"})
+		req.Header.Set("Authorization", "token "+testAPIKey)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	for _, body := range []string{
+		`{"metric":"cpu","operator":">","threshold":90}`,
+		`{"metric":"disk_usage","operator":"!=","threshold":90}`,
+		`{"metric":"disk_usage","operator":">","threshold":90,"consecutive":-1}`,
+	} {
+		if w := request(http.MethodPut, "/alert-rules/disk", body, handleAlertRule); w.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, body, w.Code)
+		}
+	}
+	body := `{"metric":"disk_usage","operator":">","threshold":90,"consecutive":3,"enabled":true}`
+	w := request(http.MethodPut, "/alert-rules/disk", body, handleAlertRule)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"updated_by":"alert key"`) {
+		t.Fatalf("expected stored rule, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request(http.MethodGet, "/alert-rules", "", handleAlertRules); !strings.Contains(w.Body.String(), `"name":"disk"`) {
+		t.Errorf("expected list of rules, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := db.PutAlert(kaginawa.Alert{Rule: "disk", NodeID: "node1", Firing: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutAlert(kaginawa.Alert{Rule: "disk", NodeID: "node2"}); err != nil {
+		t.Fatal(err)
+	}
+	if w := request(http.MethodGet, "/alerts?firing=true", "", handleAlerts); strings.Count(w.Body.String(), `"rule"`) != 1 {
+		t.Errorf("expected 1 firing alert, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request(http.MethodGet, "/alerts", "", handleAlerts); strings.Count(w.Body.String(), `"rule"`) != 2 {
+		t.Errorf("expected 2 alerts, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request(http.MethodDelete, "/alert-rules/disk", "", handleAlertRule); w.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if w := request(http.MethodGet, "/alert-rules/disk", "", handleAlertRule); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
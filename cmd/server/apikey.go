@@ -4,32 +4,80 @@ import (
 	"log"
 	"net/http"
 	"strings"
+
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
 )
 
-func validateAPIKey(r *http.Request, admin bool) bool {
+// validateAPIKey validates the api key of the request and its scope. Returns nil if unauthorized.
+// Validated keys are cached for a few seconds, so keys of privileged requests are checked again in the database
+// to reject keys just disabled or deleted on other instances.
+func validateAPIKey(r *http.Request, scope string) *kaginawa.APIKey {
+	key := lookupAPIKey(r)
+	if key == nil || !key.HasScope(scope) {
+		return nil
+	}
+	if !privilegedScope(key, scope) {
+		return key
+	}
+	if current := currentAPIKey(key); current != nil && current.HasScope(scope) {
+		return current
+	}
+	return nil
+}
+
+// currentAPIKey queries the validated key again in the database bypassing the cache. Returns nil if no longer active.
+func currentAPIKey(key *kaginawa.APIKey) *kaginawa.APIKey {
+	current, err := db.GetAPIKey(key.Key)
+	if err != nil {
+		log.Printf("failed to get api key: %v", err)
+		return nil
+	}
+	if current == nil || !current.IsActive() {
+		return nil
+	}
+	return current
+}
+
+// privilegedScope reports whether the scope modifies resources or executes commands, or the key is an admin key.
+// Reports are excluded because agents submit them frequently.
+func privilegedScope(key *kaginawa.APIKey, scope string) bool {
+	if scope == kaginawa.ScopeReportWrite {
+		return false
+	}
+	return key.Admin || scope == kaginawa.ScopeCommandExec || strings.HasSuffix(scope, ":write")
+}
+
+// lookupAPIKey validates the api key of the request regardless of scopes. Returns nil if not valid.
+func lookupAPIKey(r *http.Request) *kaginawa.APIKey {
 	apiKey := extractAPIKey(r)
 	if len(apiKey) == 0 {
+		return nil
+	}
+	key, err := db.ValidateAPIKey(apiKey)
+	if err != nil {
+		log.Printf("failed to validate api key: %v", err)
+		return nil
+	}
+	return key
+}
+
+// allowsNode checks the api key is able to access the node. Nil key means a logged-in browser session.
+func allowsNode(key *kaginawa.APIKey, report *kaginawa.Report) bool {
+	return key == nil || key.AllowsCustomID(report.CustomID)
+}
+
+// allowsCustomIDs checks the filter of custom IDs selects nodes allowed for the api key only. An empty filter selects
+// all nodes, so it is allowed for keys not restricted to custom IDs only. Nil key means a logged-in browser session.
+func allowsCustomIDs(key *kaginawa.APIKey, customIDs []string) bool {
+	if key == nil || len(key.CustomIDs) == 0 {
+		return true
+	}
+	if len(customIDs) == 0 {
 		return false
 	}
-	if admin {
-		if ok, _, err := db.ValidateAdminAPIKey(apiKey); !ok {
-			if err != nil {
-				log.Printf("failed to validate admin api key: %v", err)
-				return false
-			}
-			if !ok {
-				return false
-			}
-		}
-	} else {
-		if ok, _, err := db.ValidateAPIKey(apiKey); !ok {
-			if err != nil {
-				log.Printf("failed to validate api key: %v", err)
-				return false
-			}
-			if !ok {
-				return false
-			}
+	for _, customID := range customIDs {
+		if !key.AllowsCustomID(customID) {
+			return false
 		}
 	}
 	return true
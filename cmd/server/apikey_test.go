@@ -10,41 +10,59 @@ import (
 
 func TestValidateAPIKey(t *testing.T) {
 	db = kaginawa.NewMemDB()
-	if err := db.PutAPIKey(kaginawa.APIKey{Key: "test-normal", Label: "normal key label"}); err != nil {
+	if err := db.PutAPIKey(kaginawa.APIKey{Key: kaginawa.HashAPIKey("test-normal"), Label: "normal key label"}); err != nil {
 		t.Fatalf("failed to put test data: %v", err)
 	}
-	if err := db.PutAPIKey(kaginawa.APIKey{Key: "test-admin", Label: "admin key label", Admin: true}); err != nil {
+	if err := db.PutAPIKey(kaginawa.APIKey{Key: kaginawa.HashAPIKey("test-admin"), Label: "admin key label", Admin: true}); err != nil {
+		t.Fatalf("failed to put test data: %v", err)
+	}
+
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:    kaginawa.HashAPIKey("test-scoped"),
+		Label:  "scoped key label",
+		Scopes: []string{kaginawa.ScopeNodesRead},
+	}); err != nil {
 		t.Fatalf("failed to put test data: %v", err)
 	}
 
 	// normal key tests
 	req1 := httptest.NewRequest(http.MethodGet, "http://localhost:8080/nodes", nil)
 	req1.Header.Set("Authorization", "token test-normal")
-	if !validateAPIKey(req1, false) {
-		t.Errorf("unexpected validation result: non-admin key with non-admin option")
+	if validateAPIKey(req1, kaginawa.ScopeReportWrite) == nil {
+		t.Errorf("unexpected validation result: non-admin key with report:write scope")
 	}
-	if validateAPIKey(req1, true) {
-		t.Errorf("unexpected validation result: non-admin key with admin option")
+	if validateAPIKey(req1, kaginawa.ScopeNodesRead) != nil {
+		t.Errorf("unexpected validation result: non-admin key with nodes:read scope")
 	}
 
 	// admin key tests
 	req2 := httptest.NewRequest(http.MethodGet, "http://localhost:8080/nodes", nil)
 	req2.Header.Set("Authorization", "token test-admin")
-	if !validateAPIKey(req2, false) {
-		t.Errorf("unexpected validation result: admin key with non-admin option")
+	if validateAPIKey(req2, kaginawa.ScopeReportWrite) == nil {
+		t.Errorf("unexpected validation result: admin key with report:write scope")
 	}
-	if !validateAPIKey(req2, true) {
-		t.Errorf("unexpected validation result: admin key with admin option")
+	if validateAPIKey(req2, kaginawa.ScopeNodesRead) == nil {
+		t.Errorf("unexpected validation result: admin key with nodes:read scope")
 	}
 
-	// unregistered key tests
+	// scoped key tests
 	req3 := httptest.NewRequest(http.MethodGet, "http://localhost:8080/nodes", nil)
-	req2.Header.Set("Authorization", "token unknown")
-	if validateAPIKey(req3, false) {
-		t.Errorf("unexpected validation result: unregistered key with non-admin option")
+	req3.Header.Set("Authorization", "token test-scoped")
+	if validateAPIKey(req3, kaginawa.ScopeNodesRead) == nil {
+		t.Errorf("unexpected validation result: scoped key with granted scope")
+	}
+	if validateAPIKey(req3, kaginawa.ScopeServersRead) != nil {
+		t.Errorf("unexpected validation result: scoped key with non-granted scope")
+	}
+
+	// unregistered key tests
+	req4 := httptest.NewRequest(http.MethodGet, "http://localhost:8080/nodes", nil)
+	req4.Header.Set("Authorization", "token unknown")
+	if validateAPIKey(req4, kaginawa.ScopeReportWrite) != nil {
+		t.Errorf("unexpected validation result: unregistered key with report:write scope")
 	}
-	if validateAPIKey(req3, true) {
-		t.Errorf("unexpected validation result: unregistered key with admin option")
+	if validateAPIKey(req4, kaginawa.ScopeNodesRead) != nil {
+		t.Errorf("unexpected validation result: unregistered key with nodes:read scope")
 	}
 }
 
@@ -56,3 +74,66 @@ func TestExtractAPIKey(t *testing.T) {
 		t.Errorf("expected %s, got %s", "test-key", key)
 	}
 }
+
+func TestValidateAPIKey_inactive(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	if err := db.PutAPIKey(kaginawa.APIKey{Key: kaginawa.HashAPIKey("test-disabled"), Admin: true, Disabled: true}); err != nil {
+		t.Fatalf("failed to put test data: %v", err)
+	}
+	if err := db.PutAPIKey(kaginawa.APIKey{Key: kaginawa.HashAPIKey("test-expired"), Admin: true, ExpiresAt: 1}); err != nil {
+		t.Fatalf("failed to put test data: %v", err)
+	}
+	if err := db.PutAPIKey(kaginawa.APIKey{Key: kaginawa.HashAPIKey("test-deleted"), Admin: true}); err != nil {
+		t.Fatalf("failed to put test data: %v", err)
+	}
+	if err := db.DeleteAPIKey(kaginawa.HashAPIKey("test-deleted")); err != nil {
+		t.Fatalf("failed to delete test data: %v", err)
+	}
+	for _, key := range []string{"test-disabled", "test-expired", "test-deleted"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/nodes", nil)
+		req.Header.Set("Authorization", "token "+key)
+		if validateAPIKey(req, kaginawa.ScopeReportWrite) != nil {
+			t.Errorf("unexpected validation result: %s with report:write scope", key)
+		}
+		if validateAPIKey(req, kaginawa.ScopeNodesRead) != nil {
+			t.Errorf("unexpected validation result: %s with nodes:read scope", key)
+		}
+	}
+}
+
+// staleKeyDB returns the cached api key validated before changed by other instances.
+type staleKeyDB struct {
+	kaginawa.DB
+	cached kaginawa.APIKey
+}
+
+func (db staleKeyDB) ValidateAPIKey(string) (*kaginawa.APIKey, error) {
+	key := db.cached
+	return &key, nil
+}
+
+func TestValidateAPIKey_disabledOnOtherInstance(t *testing.T) {
+	cached := kaginawa.APIKey{Key: kaginawa.HashAPIKey("test-stale"), Scopes: []string{
+		kaginawa.ScopeReportWrite, kaginawa.ScopeNodesRead, kaginawa.ScopeServersWrite, kaginawa.ScopeCommandExec,
+	}}
+	mem := kaginawa.NewMemDB()
+	disabled := cached
+	disabled.Disabled = true
+	if err := mem.PutAPIKey(disabled); err != nil {
+		t.Fatalf("failed to put test data: %v", err)
+	}
+	db = staleKeyDB{DB: mem, cached: cached}
+	defer func() { db = kaginawa.NewMemDB() }()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/nodes", nil)
+	req.Header.Set("Authorization", "token test-stale")
+	for _, scope := range []string{kaginawa.ScopeServersWrite, kaginawa.ScopeCommandExec} {
+		if validateAPIKey(req, scope) != nil {
+			t.Errorf("expected disabled key rejected with %s scope", scope)
+		}
+	}
+	for _, scope := range []string{kaginawa.ScopeReportWrite, kaginawa.ScopeNodesRead} {
+		if validateAPIKey(req, scope) == nil {
+			t.Errorf("expected cached key accepted with %s scope", scope)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	defaultCommandLogsLimit = 100
+	nodeCommandLogsLimit    = 20
+)
+
+// Sources of command execution.
+const (
+	commandSourceCommand  = "command"
+	commandSourceStream   = "stream"
+	commandSourceJob      = "job"
+	commandSourceUpload   = "upload"
+	commandSourceDownload = "download"
+	commandSourceForward  = "forward"
+	commandSourceSchedule = "schedule"
+	commandSourceTerminal = "terminal"
+)
+
+// commandActor returns who requests the command execution: api key label or user email.
+func commandActor(apiKey *kaginawa.APIKey, r *http.Request) string {
+	if apiKey != nil {
+		if len(apiKey.Label) > 0 {
+			return apiKey.Label
+		}
+		return "api key " + apiKey.Prefix
+	}
+	return getSession(r).email()
+}
+
+// recordCommand persists an audit log of the command execution. Failures are only logged.
+func recordCommand(req *commandRequest, source string, exit commandExit, duration time.Duration, outputBytes int) {
+	serverMetrics.observeCommand(source, exit)
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		log.Printf("failed to generate command log id: %v", err)
+		return
+	}
+	entry := kaginawa.CommandLog{
+		ID:          id.String(),
+		NodeID:      req.id,
+		Actor:       req.actor,
+		Source:      source,
+		User:        req.user,
+		Profile:     req.profile,
+		Saved:       req.saved,
+		Command:     req.command,
+		ExitCode:    exit.ExitCode,
+		TimedOut:    exit.TimedOut,
+		Error:       exit.Error,
+		DurationMS:  duration.Milliseconds(),
+		OutputBytes: outputBytes,
+		Timestamp:   time.Now().UTC().Unix(),
+	}
+	if err := db.PutCommandLog(entry); err != nil {
+		log.Printf("failed to put command log of %s: %v", req.id, err)
+	}
+}
+
+func recordCommandResult(req *commandRequest, source string, result *commandResult) {
+	exit := commandExit{ExitCode: result.ExitCode, TimedOut: result.TimedOut, Error: result.Error}
+	duration := time.Duration(result.DurationMS) * time.Millisecond
+	recordCommand(req, source, exit, duration, len(result.Stdout)+len(result.Stderr))
+}
+
+// handleCommandLogs handles list of command execution logs for specified node.
+//
+// - Method: GET
+// - Client: Browser or API
+// - Access: Admin
+// - Response: JSON
+func handleCommandLogs(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if len(id) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	apiKey := validateAPIKey(r, kaginawa.ScopeCommandsRead)
+	if apiKey == nil {
+		if !getSession(r).isLoggedIn() {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+	} else if len(apiKey.CustomIDs) > 0 {
+		report, err := db.GetReportByID(id)
+		if err != nil {
+			log.Printf("failed to get report: %v", err)
+			http.Error(w, "Database unavailable", http.StatusInternalServerError)
+			return
+		}
+		if report == nil || !allowsNode(apiKey, report) {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	limit := defaultCommandLogsLimit
+	if v := r.URL.Query().Get("limit"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit value", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	logs, err := db.ListCommandLogs(id, limit)
+	if err != nil {
+		log.Printf("failed to list command logs: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if logs == nil {
+		logs = []kaginawa.CommandLog{}
+	}
+	writeJSON(w, logs)
+}
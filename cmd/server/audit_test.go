@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+func TestRecordCommand(t *testing.T) {
+	setupTestRelay(t)
+	for _, command := range []string{"echo hello", "exit 3"} {
+		form := url.Values{"user": {testSSHUser}, "password": {testSSHPassword}, "command": {command}}
+		handleCommand(httptest.NewRecorder(), newTestCommandRequest("/nodes/node1/command", form))
+	}
+	form := url.Values{"user": {testSSHUser}, "password": {testSSHPassword}, "command": {"stderr oops"}}
+	handleCommandStream(httptest.NewRecorder(), newTestCommandRequest("/nodes/node1/command/stream", form))
+
+	logs, err := db.ListCommandLogs("node1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 3 {
+		t.Fatalf("expected 3 logs, got %d", len(logs))
+	}
+	expected := []kaginawa.CommandLog{
+		{Source: commandSourceStream, Command: "stderr oops", ExitCode: 0, OutputBytes: 5},
+		{Source: commandSourceCommand, Command: "exit 3", ExitCode: 3},
+		{Source: commandSourceCommand, Command: "echo hello", ExitCode: 0, OutputBytes: 6},
+	}
+	for i, e := range expected {
+		actual := logs[i]
+		if actual.NodeID != "node1" || actual.Actor != "command key" || actual.User != testSSHUser {
+			t.Errorf("unexpected log attributes: %+v", actual)
+		}
+		if actual.Source != e.Source || actual.Command != e.Command || actual.ExitCode != e.ExitCode ||
+			actual.OutputBytes != e.OutputBytes {
+			t.Errorf("expected %+v, got %+v", e, actual)
+		}
+	}
+}
+
+func TestHandleCommandLogs(t *testing.T) {
+	setupTestRelay(t)
+	sessionStore = sessions.NewCookieStore([]byte("test"))
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:    kaginawa.HashAPIKey("audit-key"),
+		Scopes: []string{kaginawa.ScopeCommandsRead},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "2"} {
+		if err := db.PutCommandLog(kaginawa.CommandLog{ID: id, NodeID: "node1", Command: "uptime"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		key    string
+		status int
+		count  int
+	}{
+		{"audit-key", http.StatusOK, 1},
+		{testAPIKey, http.StatusForbidden, 0}, // command:exec scope cannot read logs
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/nodes/node1/command-logs?limit=1", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "node1"})
+		req.Header.Set("Authorization", "token "+test.key)
+		w := httptest.NewRecorder()
+		handleCommandLogs(w, req)
+		if w.Code != test.status {
+			t.Fatalf("expected status %d, got %d: %s", test.status, w.Code, w.Body.String())
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var logs []kaginawa.CommandLog
+		if err := json.Unmarshal(w.Body.Bytes(), &logs); err != nil {
+			t.Fatal(err)
+		}
+		if len(logs) != test.count || logs[0].ID != "2" {
+			t.Errorf("expected latest %d log, got %+v", test.count, logs)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+// listCacheTTL defines lifetime of cached lists. Other instances pick up changes after this duration.
+const listCacheTTL = 30 * time.Second
+
+var (
+	alertRuleCache = newListCache(func() ([]kaginawa.AlertRule, error) { return db.ListAlertRules() })
+	hookCache      = newListCache(func() ([]kaginawa.ReportHook, error) { return db.ListReportHooks() })
+	notifierCache  = newListCache(func() ([]kaginawa.Notifier, error) { return db.ListNotifiers() })
+	// fleetCache holds the latest reports of all nodes with list view attributes, also refreshed by every liveness check.
+	fleetCache = newListCache(func() ([]kaginawa.Report, error) {
+		return db.ListReports(0, 0, 0, kaginawa.ListViewAttributes)
+	})
+)
+
+// listCache caches a list loaded from the database on hot paths such as reports and metrics scrapes.
+// Changes of this instance are applied immediately by invalidate.
+type listCache[T any] struct {
+	load       func() ([]T, error)
+	mutex      sync.Mutex
+	value      []T
+	loadedAt   time.Time
+	generation int // incremented by invalidate to discard loads in progress
+}
+
+func newListCache[T any](load func() ([]T, error)) *listCache[T] {
+	return &listCache[T]{load: load}
+}
+
+// get returns the cached list, or loads it if expired. The lock is not held while loading.
+func (c *listCache[T]) get() ([]T, error) {
+	c.mutex.Lock()
+	if time.Since(c.loadedAt) < listCacheTTL {
+		defer c.mutex.Unlock()
+		return c.value, nil
+	}
+	generation := c.generation
+	c.mutex.Unlock()
+	value, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.generation == generation {
+		c.value = value
+		c.loadedAt = time.Now()
+	}
+	return value, nil
+}
+
+// store replaces the cached list with the list loaded by others.
+func (c *listCache[T]) store(value []T) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+	c.value = value
+	c.loadedAt = time.Now()
+}
+
+// invalidate discards the cached list.
+func (c *listCache[T]) invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+	c.value = nil
+	c.loadedAt = time.Time{}
+}
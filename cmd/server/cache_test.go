@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+func TestListCache(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	loads := 0
+	cache := newListCache(func() ([]kaginawa.AlertRule, error) {
+		loads++
+		return db.ListAlertRules()
+	})
+	if err := db.PutAlertRule(kaginawa.AlertRule{Name: "disk"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		rules, err := cache.get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rules) != 1 {
+			t.Errorf("expected 1 rule, got %+v", rules)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("expected loaded once, got %d", loads)
+	}
+	if err := db.PutAlertRule(kaginawa.AlertRule{Name: "load"}); err != nil {
+		t.Fatal(err)
+	}
+	cache.invalidate()
+	rules, err := cache.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || loads != 2 {
+		t.Errorf("expected reloaded after invalidate, got %+v after %d loads", rules, loads)
+	}
+}
+
+// invalidateListCaches discards cached lists of the previous database replaced by tests.
+func invalidateListCaches() {
+	alertRuleCache.invalidate()
+	hookCache.invalidate()
+	notifierCache.invalidate()
+	fleetCache.invalidate()
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+// credentialProfileSummary defines the API representation of a credential profile. Secrets are never returned.
+type credentialProfileSummary struct {
+	Name        string   `json:"name"`
+	User        string   `json:"user"`
+	HasKey      bool     `json:"has_key"`
+	HasPassword bool     `json:"has_password"`
+	CustomIDs   []string `json:"custom_ids"`
+	NodeIDs     []string `json:"node_ids"`
+}
+
+func newCredentialProfileSummary(profile kaginawa.CredentialProfile) credentialProfileSummary {
+	summary := credentialProfileSummary{
+		Name:        profile.Name,
+		User:        profile.User,
+		HasKey:      len(profile.Key) > 0,
+		HasPassword: len(profile.Password) > 0,
+		CustomIDs:   profile.CustomIDs,
+		NodeIDs:     profile.NodeIDs,
+	}
+	if summary.CustomIDs == nil {
+		summary.CustomIDs = []string{}
+	}
+	if summary.NodeIDs == nil {
+		summary.NodeIDs = []string{}
+	}
+	return summary
+}
+
+// resolveCredentials fills target credentials of the form. Credentials entered by the client are used as is.
+// Otherwise, the selected credential profile or the profile assigned to the node is used.
+// Selected profiles must be assigned to the node or must not be assigned to any nodes.
+func resolveCredentials(form commandForm, report *kaginawa.Report) (commandForm, error) {
+	if len(form.profile) == 0 && len(form.user) > 0 {
+		return form, nil
+	}
+	var profile *kaginawa.CredentialProfile
+	if len(form.profile) > 0 {
+		p, err := db.GetCredentialProfile(form.profile)
+		if err != nil {
+			return form, fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+		}
+		if p == nil || !p.UsableFor(*report) {
+			return form, fmt.Errorf("credential profile not found: %s", form.profile)
+		}
+		profile = p
+	} else {
+		profiles, err := db.ListCredentialProfiles()
+		if err != nil {
+			return form, fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+		}
+		profile = kaginawa.FindAssignedProfile(profiles, *report)
+		if profile == nil {
+			return form, errors.New("user name required")
+		}
+	}
+	form.user = profile.User
+	form.key = profile.Key
+	form.password = profile.Password
+	form.profile = profile.Name
+	return form, nil
+}
+
+// handleNewCredentialProfile handles credential profile registration and update requests.
+// Empty key and password keep current ones.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleNewCredentialProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !getSession(r).isLoggedIn() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	profile := kaginawa.CredentialProfile{
+		Name:      strings.TrimSpace(r.FormValue("name")),
+		User:      strings.TrimSpace(r.FormValue("user")),
+		Key:       strings.TrimSpace(r.FormValue("key")),
+		Password:  strings.TrimSpace(r.FormValue("password")),
+		CustomIDs: splitCommaList(r.FormValue("custom-ids")),
+		NodeIDs:   splitCommaList(r.FormValue("node-ids")),
+	}
+	if _, err := putCredentialProfile(profile); err != nil {
+		writeFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleDeleteCredentialProfile handles credential profile deletion requests.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleDeleteCredentialProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !getSession(r).isLoggedIn() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	name := r.FormValue("name")
+	if len(name) == 0 {
+		http.Error(w, "Name is empty", http.StatusBadRequest)
+		return
+	}
+	if err := db.DeleteCredentialProfile(name); err != nil {
+		log.Printf("failed to delete credential profile: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleCredentialProfiles handles list of credential profiles requests.
+//
+// - Method: GET, HEAD
+// - Client: API
+// - Access: Admin
+// - Response: JSON
+func handleCredentialProfiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if validateAPIKey(r, kaginawa.ScopeCredentialsRead) == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	profiles, err := db.ListCredentialProfiles()
+	if err != nil {
+		log.Printf("failed to list credential profiles: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	summaries := make([]credentialProfileSummary, 0, len(profiles))
+	for _, profile := range profiles {
+		summaries = append(summaries, newCredentialProfileSummary(profile))
+	}
+	writeJSON(w, summaries)
+}
+
+// handleCredentialProfile handles single credential profile requests. Empty key and password of PUT keep current ones.
+//
+// - Method: GET, HEAD, PUT or DELETE
+// - Client: API
+// - Access: Admin
+// - Response: JSON (GET, HEAD or PUT) or 204 no content (DELETE)
+func handleCredentialProfile(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if len(name) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	scope := kaginawa.ScopeCredentialsWrite
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		scope = kaginawa.ScopeCredentialsRead
+	case http.MethodPut, http.MethodDelete:
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if validateAPIKey(r, scope) == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var profile kaginawa.CredentialProfile
+		if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		profile.Name = name
+		stored, err := putCredentialProfile(profile)
+		if err != nil {
+			writeFormError(w, err)
+			return
+		}
+		writeJSON(w, newCredentialProfileSummary(stored))
+		return
+	case http.MethodDelete:
+		if err := db.DeleteCredentialProfile(name); err != nil {
+			log.Printf("failed to delete credential profile: %v", err)
+			http.Error(w, "Database unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	profile, err := db.GetCredentialProfile(name)
+	if err != nil {
+		log.Printf("failed to get credential profile: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if profile == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, newCredentialProfileSummary(*profile))
+}
+
+// putCredentialProfile validates and puts the profile. Empty key and password fall back to the current entry.
+// Returns the stored profile.
+func putCredentialProfile(profile kaginawa.CredentialProfile) (kaginawa.CredentialProfile, error) {
+	if len(profile.Name) == 0 {
+		return profile, errors.New("name is empty")
+	}
+	if len(profile.User) == 0 {
+		return profile, errors.New("user is empty")
+	}
+	if len(profile.Key) == 0 && len(profile.Password) == 0 {
+		current, err := db.GetCredentialProfile(profile.Name)
+		if err != nil {
+			return profile, fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+		}
+		if current == nil {
+			return profile, errors.New("key or password is empty")
+		}
+		profile.Key = current.Key
+		profile.Password = current.Password
+	}
+	if len(profile.Key) > 0 {
+		if _, err := createSSHConfig(profile.User, profile.Key, "", nil); err != nil {
+			return profile, errors.New("invalid ssh key")
+		}
+	}
+	if err := db.PutCredentialProfile(profile); err != nil {
+		return profile, fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+	}
+	return profile, nil
+}
+
+// splitCommaList splits comma separated values. Empty values are skipped.
+func splitCommaList(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+func TestHandleCommand_profile(t *testing.T) {
+	setupTestRelay(t)
+	for _, profile := range []kaginawa.CredentialProfile{
+		{Name: "assigned", User: testSSHUser, Password: testSSHPassword, NodeIDs: []string{"node1"}},
+		{Name: "wrong", User: testSSHUser, Password: "wrong"},
+		{Name: "other-site", User: testSSHUser, Password: testSSHPassword, CustomIDs: []string{"site2"}},
+	} {
+		if err := db.PutCredentialProfile(profile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		form   url.Values
+		status int
+	}{
+		{url.Values{"command": {"echo hello"}}, http.StatusOK},
+		{url.Values{"profile": {"assigned"}, "command": {"echo hello"}}, http.StatusOK},
+		{url.Values{"profile": {"wrong"}, "command": {"echo hello"}}, http.StatusServiceUnavailable},
+		{url.Values{"profile": {"unknown"}, "command": {"echo hello"}}, http.StatusBadRequest},
+		{url.Values{"profile": {"other-site"}, "command": {"echo hello"}}, http.StatusBadRequest},
+		{url.Values{"user": {testSSHUser}, "password": {"wrong"}, "command": {"echo hello"}}, http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handleCommand(w, newTestCommandRequest("/nodes/node1/command", test.form))
+		if w.Code != test.status {
+			t.Errorf("expected status %d for %v, got %d: %s", test.status, test.form, w.Code, w.Body.String())
+		}
+	}
+	logs, err := db.ListCommandLogs("node1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) == 0 || logs[len(logs)-1].Profile != "assigned" || logs[len(logs)-1].User != testSSHUser {
+		t.Errorf("expected profile recorded, got %+v", logs)
+	}
+
+	if err := db.DeleteCredentialProfile("assigned"); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handleCommand(w, newTestCommandRequest("/nodes/node1/command", url.Values{"command": {"echo hello"}}))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d without credentials, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestHandleCommand_restrictedKeyProfile(t *testing.T) {
+	_, target := setupTestRelay(t)
+	if err := db.PutReport(kaginawa.Report{
+		ID:            "node1",
+		CustomID:      "site1",
+		SSHServerHost: "127.0.0.1",
+		SSHRemotePort: target.port(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:       kaginawa.HashAPIKey(testAPIKey),
+		Label:     "site1 key",
+		Scopes:    []string{kaginawa.ScopeCommandExec},
+		CustomIDs: []string{"site1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, profile := range []kaginawa.CredentialProfile{
+		{Name: "site1", User: testSSHUser, Password: testSSHPassword, CustomIDs: []string{"site1"}},
+		{Name: "site2", User: testSSHUser, Password: "site2-secret", CustomIDs: []string{"site2"}},
+		{Name: "node2", User: testSSHUser, Password: "node2-secret", NodeIDs: []string{"node2"}},
+	} {
+		if err := db.PutCredentialProfile(profile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		profile string
+		status  int
+	}{
+		{"site1", http.StatusOK},
+		{"site2", http.StatusBadRequest}, // never sent to the node of other sites
+		{"node2", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		form := url.Values{"profile": {test.profile}, "command": {"echo hello"}}
+		handleCommand(w, newTestCommandRequest("/nodes/node1/command", form))
+		if w.Code != test.status {
+			t.Errorf("expected status %d for profile %s, got %d: %s", test.status, test.profile, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestHandleCredentialProfile(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:    kaginawa.HashAPIKey(testAPIKey),
+		Scopes: []string{kaginawa.ScopeCredentialsRead, kaginawa.ScopeCredentialsWrite},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	request := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://localhost:8080/credentials/default", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"name": "default"})
+		req.Header.Set("Authorization", "token "+testAPIKey)
+		w := httptest.NewRecorder()
+		handleCredentialProfile(w, req)
+		return w
+	}
+
+	if w := request(http.MethodPut, `{"user":"pi"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d without secrets, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := request(http.MethodPut, `{"user":"pi","password":"raspberry","custom_ids":["site1"]}`); w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	w := request(http.MethodPut, `{"user":"admin","custom_ids":["site2"]}`) // keeps current password
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	w = request(http.MethodGet, "")
+	if strings.Contains(w.Body.String(), "raspberry") {
+		t.Fatalf("expected password redacted, got %s", w.Body.String())
+	}
+	var summary credentialProfileSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.User != "admin" || !summary.HasPassword || summary.HasKey || summary.CustomIDs[0] != "site2" {
+		t.Errorf("unexpected profile: %+v", summary)
+	}
+	stored, err := db.GetCredentialProfile("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Password != "raspberry" {
+		t.Errorf("expected password kept, got %q", stored.Password)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/credentials", nil)
+	req.Header.Set("Authorization", "token "+testAPIKey)
+	list := httptest.NewRecorder()
+	handleCredentialProfiles(list, req)
+	if list.Code != http.StatusOK || !strings.Contains(list.Body.String(), `"name":"default"`) {
+		t.Errorf("expected list of profiles, got %d: %s", list.Code, list.Body.String())
+	}
+
+	if w := request(http.MethodDelete, ""); w.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if w := request(http.MethodGet, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultForwardTTL = 10 * time.Minute
+	maxForwardTTL     = 24 * time.Hour
+	forwardTokenBytes = 32
+)
+
+var (
+	portForwards    = newPortForwardStore()
+	forwardUpgrader = websocket.Upgrader{} // rejects cross-origin requests by default
+
+	// forwardOrigin is a dedicated origin (e.g. https://forwards.example.com) of the http proxy.
+	// If empty, forwarded pages are served from the origin of this server in a sandbox without scripts.
+	forwardOrigin string
+)
+
+// portForward defines a temporary forwarding to a tcp port of the node. The token authenticates users of the forwarding.
+type portForward struct {
+	Token     string `json:"token"`
+	NodeID    string `json:"node_id"`
+	Port      int    `json:"port"`
+	Actor     string `json:"actor"`
+	URL       string `json:"url"`        // URL (path if no dedicated origin) of the http proxy
+	SocketURL string `json:"socket_url"` // Path of the websocket-to-tcp bridge
+	ExpiresAt int64  `json:"expires_at"`
+	client    *ssh.Client
+	closeFn   func()
+	proxy     *httputil.ReverseProxy
+}
+
+// dial opens a tcp connection to the forwarded port through the ssh connection of the node.
+func (f *portForward) dial() (net.Conn, error) {
+	return f.client.Dial("tcp", net.JoinHostPort("localhost", strconv.Itoa(f.Port)))
+}
+
+// portForwardStore holds active port forwardings. Forwardings are closed on expiry.
+type portForwardStore struct {
+	mutex    sync.RWMutex
+	forwards map[string]*portForward
+}
+
+func newPortForwardStore() *portForwardStore {
+	return &portForwardStore{forwards: make(map[string]*portForward)}
+}
+
+func (s *portForwardStore) add(forward *portForward, ttl time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.forwards[forward.Token] = forward
+	time.AfterFunc(ttl, func() { s.remove(forward.Token) })
+}
+
+// get returns the forwarding. Returns nil if not found or expired.
+func (s *portForwardStore) get(token string) *portForward {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	forward, ok := s.forwards[token]
+	if !ok || time.Now().Unix() >= forward.ExpiresAt {
+		return nil
+	}
+	return forward
+}
+
+// remove closes the forwarding and its connections. Returns false if not found.
+func (s *portForwardStore) remove(token string) bool {
+	s.mutex.Lock()
+	forward, ok := s.forwards[token]
+	delete(s.forwards, token)
+	s.mutex.Unlock()
+	if !ok {
+		return false
+	}
+	forward.closeFn()
+	log.Printf("FORWARD closed: port %d of %s", forward.Port, forward.NodeID)
+	return true
+}
+
+// handleNewForward handles open a port forwarding to the node.
+//
+// - Method: POST
+// - Client: Browser or API
+// - Access: Admin
+// - Response: JSON
+func handleNewForward(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	req := parseNodeRequest(w, r, parseCredentialForm)
+	if req == nil {
+		return
+	}
+	port, err := strconv.Atoi(r.FormValue("port"))
+	if err != nil || port < 1 || port > 65535 {
+		http.Error(w, "Invalid port value", http.StatusBadRequest)
+		return
+	}
+	ttl := defaultForwardTTL
+	if v := r.FormValue("ttl"); len(v) > 0 {
+		minutes, err := strconv.Atoi(v)
+		if err != nil || minutes < 1 || time.Duration(minutes)*time.Minute > maxForwardTTL {
+			http.Error(w, "Invalid ttl value", http.StatusBadRequest)
+			return
+		}
+		ttl = time.Duration(minutes) * time.Minute
+	}
+	token, err := newForwardToken()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	req.command = fmt.Sprintf("forward port %d", port)
+
+	client, closeFn, err := connectTargetWithRetry(req.server, req.report, req.serverConfig, req.targetConfig)
+	recordCommand(req, commandSourceForward, newCommandExit(err), 0, 0)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	forward := &portForward{
+		Token:     token,
+		NodeID:    req.id,
+		Port:      port,
+		Actor:     req.actor,
+		URL:       forwardOrigin + forwardProxyPath(token),
+		SocketURL: "/forwards/" + token + "/socket",
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+		client:    client,
+		closeFn:   closeFn,
+	}
+	forward.proxy = newForwardProxy(forward)
+	portForwards.add(forward, ttl)
+	log.Printf("FORWARD opened by %s: port %d of %s for %s", req.actor, port, req.id, ttl)
+	w.Header().Set("Location", forward.URL)
+	w.Header().Set("Content-Type", contentTypeJSON) // writeJSON cannot set headers after WriteHeader
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, forward)
+}
+
+func newForwardToken() (string, error) {
+	b := make([]byte, forwardTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func forwardProxyPath(token string) string {
+	return "/forwards/" + token + "/http/"
+}
+
+// newForwardProxy creates a reverse proxy to the forwarded port. Credentials of this server are not forwarded,
+// and responses are not able to set cookies or run scripts with the origin of this server.
+func newForwardProxy(forward *portForward) *httputil.ReverseProxy {
+	prefix := strings.TrimSuffix(forwardProxyPath(forward.Token), "/")
+	return &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = "http"
+			r.URL.Host = net.JoinHostPort("localhost", strconv.Itoa(forward.Port))
+			r.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+			r.URL.RawPath = ""
+			r.Host = r.URL.Host
+			r.Header.Del("Authorization")
+			removeCookie(r, sessionName)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			resp.Header.Del("Set-Cookie")
+			if len(forwardOrigin) > 0 {
+				resp.Header.Set("Content-Security-Policy", "sandbox allow-scripts allow-forms allow-popups allow-downloads")
+			} else {
+				resp.Header.Set("Content-Security-Policy", "sandbox allow-forms allow-popups allow-downloads")
+			}
+			return nil
+		},
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return forward.dial()
+			},
+			MaxIdleConns:    1,
+			IdleConnTimeout: time.Minute,
+		},
+	}
+}
+
+// removeCookie removes the named cookie from the request header.
+func removeCookie(r *http.Request, name string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			r.AddCookie(cookie)
+		}
+	}
+}
+
+// handleForward handles close a port forwarding.
+//
+// - Method: DELETE
+// - Client: Browser or API
+// - Access: Token
+// - Response: Empty
+func handleForward(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !portForwards.remove(mux.Vars(r)["token"]) {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleForwardProxy handles http requests to the forwarded port.
+//
+// - Method: Any
+// - Client: Browser or API
+// - Access: Token
+// - Response: Response of the forwarded port
+func handleForwardProxy(w http.ResponseWriter, r *http.Request) {
+	forward := portForwards.get(mux.Vars(r)["token"])
+	if forward == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if u, err := url.Parse(forwardOrigin); err == nil && len(u.Host) > 0 && r.Host != u.Host {
+		http.Redirect(w, r, forwardOrigin+r.URL.RequestURI(), http.StatusTemporaryRedirect) // never serve on this origin
+		return
+	}
+	forward.proxy.ServeHTTP(w, r)
+}
+
+// handleForwardSocket handles bridge binary websocket messages to the tcp connection of the forwarded port.
+//
+// - Method: GET (WebSocket)
+// - Client: Browser or API
+// - Access: Token
+// - Response: WebSocket
+func handleForwardSocket(w http.ResponseWriter, r *http.Request) {
+	forward := portForwards.get(mux.Vars(r)["token"])
+	if forward == nil {
+		http.NotFound(w, r)
+		return
+	}
+	target, err := forward.dial()
+	if err != nil {
+		log.Printf("failed to dial port %d of %s: %v", forward.Port, forward.NodeID, err)
+		http.Error(w, "Port unavailable", http.StatusBadGateway)
+		return
+	}
+	conn, err := forwardUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("failed to upgrade forward socket: %v", err)
+		safeClose(target, "forwarded connection")
+		return
+	}
+	defer safeClose(conn, "forward socket")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := target.Read(buf)
+			if n > 0 {
+				if err := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+				return
+			}
+		}
+	}()
+	for {
+		_, reader, err := conn.NextReader()
+		if err != nil {
+			break
+		}
+		if _, err := io.Copy(target, reader); err != nil {
+			break
+		}
+	}
+	_ = target.Close() // unblock reader
+	<-done
+}
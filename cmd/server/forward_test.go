@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+func newTestForwardRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/forwards/{token}", handleForward)
+	r.HandleFunc("/forwards/{token}/socket", handleForwardSocket)
+	r.PathPrefix("/forwards/{token}/http/").HandlerFunc(handleForwardProxy)
+	return r
+}
+
+func newTestForward(t *testing.T, port int) portForward {
+	t.Helper()
+	form := url.Values{"user": {testSSHUser}, "password": {testSSHPassword}, "port": {fmt.Sprint(port)}}
+	w := httptest.NewRecorder()
+	handleNewForward(w, newTestCommandRequest("/nodes/node1/forwards", form))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var forward portForward
+	if err := json.Unmarshal(w.Body.Bytes(), &forward); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { portForwards.remove(forward.Token) })
+	return forward
+}
+
+func TestHandleForwardProxy(t *testing.T) {
+	setupTestRelay(t)
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: sessionName, Value: "overwritten"})
+		_, _ = fmt.Fprintf(w, "path=%s cookie=%s auth=%s", r.URL.Path, r.Header.Get("Cookie"), r.Header.Get("Authorization"))
+	}))
+	defer service.Close()
+	forward := newTestForward(t, service.Listener.Addr().(*net.TCPAddr).Port)
+	router := newTestForwardRouter()
+
+	req := httptest.NewRequest(http.MethodGet, forward.URL+"status", nil)
+	req.Header.Set("Authorization", "token "+testAPIKey)
+	req.AddCookie(&http.Cookie{Name: sessionName, Value: "secret"})
+	req.AddCookie(&http.Cookie{Name: "app", Value: "1"})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if expected := "path=/status cookie=app=1 auth="; w.Body.String() != expected {
+		t.Errorf("expected %q, got %q", expected, w.Body.String())
+	}
+	if cookie := w.Header().Get("Set-Cookie"); len(cookie) > 0 {
+		t.Errorf("expected cookies of the node dropped, got %q", cookie)
+	}
+	if csp := w.Header().Get("Content-Security-Policy"); !strings.HasPrefix(csp, "sandbox") || strings.Contains(csp, "allow-scripts") {
+		t.Errorf("expected sandbox without scripts, got %q", csp)
+	}
+
+	// Dedicated origin
+	forwardOrigin = "https://forwards.example.com"
+	defer func() { forwardOrigin = "" }()
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, forward.URL+"status", nil))
+	if location := w.Header().Get("Location"); w.Code != http.StatusTemporaryRedirect ||
+		location != forwardOrigin+forward.URL+"status" {
+		t.Errorf("expected redirect to the dedicated origin, got %d %q", w.Code, location)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, forwardOrigin+forward.URL+"status", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Security-Policy"), "allow-scripts") {
+		t.Errorf("expected proxied response on the dedicated origin, got %d %q", w.Code, w.Header().Get("Content-Security-Policy"))
+	}
+	forwardOrigin = ""
+
+	// Close the forwarding
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/forwards/"+forward.Token, nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, forward.URL, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d after close, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestHandleForwardSocket(t *testing.T) {
+	setupTestRelay(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(listener, "echo listener")
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer safeClose(conn, "echo connection")
+		_, _ = io.Copy(conn, conn)
+	}()
+	forward := newTestForward(t, listener.Addr().(*net.TCPAddr).Port)
+	server := httptest.NewServer(newTestForwardRouter())
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+forward.SocketURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(conn, "test socket")
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "ping" {
+		t.Errorf("expected echo, got %q", data)
+	}
+}
+
+func TestHandleNewForward_invalidPort(t *testing.T) {
+	setupTestRelay(t)
+	form := url.Values{"user": {testSSHUser}, "password": {testSSHPassword}, "port": {"70000"}}
+	w := httptest.NewRecorder()
+	handleNewForward(w, newTestCommandRequest("/nodes/node1/forwards", form))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultRelayProbeInterval = time.Minute
+	defaultRelayProbeTimeout  = 10 * time.Second
+)
+
+var relayHealths = newRelayHealthStore()
+
+// probeRelay dials the ssh server with its stored credentials. Host key is verified only if already trusted.
+var probeRelay = func(server kaginawa.SSHServer, timeout time.Duration) error {
+	hostKeyCallback := verifyHostKey("ssh server "+server.Host, server.HostKey, nil)
+	config, err := createSSHConfig(server.User, server.Key, server.Password, hostKeyCallback)
+	if err != nil {
+		return err
+	}
+	config.Timeout = timeout
+	conn, err := ssh.Dial("tcp", server.Addr(), config)
+	if err != nil {
+		return err
+	}
+	safeClose(conn, "ssh relay probe connection")
+	return nil
+}
+
+// relayHealth defines result of the latest health check of a ssh server.
+type relayHealth struct {
+	Host      string `json:"host"`
+	Healthy   bool   `json:"healthy"`
+	LatencyMS int64  `json:"latency_ms"`
+	LastError string `json:"last_error,omitempty"`
+	CheckedAt int64  `json:"checked_at"`
+}
+
+type relayHealthStore struct {
+	mutex   sync.RWMutex
+	entries map[string]relayHealth
+}
+
+func newRelayHealthStore() *relayHealthStore {
+	return &relayHealthStore{entries: make(map[string]relayHealth)}
+}
+
+func (s *relayHealthStore) set(health relayHealth) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries[health.Host] = health
+}
+
+// retain drops entries of removed servers.
+func (s *relayHealthStore) retain(servers []kaginawa.SSHServer) {
+	hosts := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		hosts[server.Host] = true
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for host := range s.entries {
+		if !hosts[host] {
+			delete(s.entries, host)
+		}
+	}
+}
+
+// list returns all entries sorted by host.
+func (s *relayHealthStore) list() []relayHealth {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	healths := make([]relayHealth, 0, len(s.entries))
+	for _, h := range s.entries {
+		healths = append(healths, h)
+	}
+	sort.Slice(healths, func(i, j int) bool { return healths[i].Host < healths[j].Host })
+	return healths
+}
+
+// byHost returns all entries keyed by host.
+func (s *relayHealthStore) byHost() map[string]relayHealth {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	healths := make(map[string]relayHealth, len(s.entries))
+	for k, v := range s.entries {
+		healths[k] = v
+	}
+	return healths
+}
+
+// filter excludes unhealthy servers. Servers not checked yet are treated as healthy.
+// Returns all servers if none of them are healthy.
+func (s *relayHealthStore) filter(servers []kaginawa.SSHServer) []kaginawa.SSHServer {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	healthy := make([]kaginawa.SSHServer, 0, len(servers))
+	for _, server := range servers {
+		if h, ok := s.entries[server.Host]; ok && !h.Healthy {
+			continue
+		}
+		healthy = append(healthy, server)
+	}
+	if len(healthy) == 0 {
+		return servers
+	}
+	return healthy
+}
+
+// checkRelays probes all ssh servers concurrently and records results.
+func checkRelays(servers []kaginawa.SSHServer, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server kaginawa.SSHServer) {
+			defer wg.Done()
+			begin := time.Now()
+			err := probeRelay(server, timeout)
+			health := relayHealth{
+				Host:      server.Host,
+				Healthy:   err == nil,
+				LatencyMS: time.Since(begin).Milliseconds(),
+				CheckedAt: time.Now().UTC().Unix(),
+			}
+			if err != nil {
+				health.LastError = err.Error()
+				log.Printf("ssh server %s is unhealthy: %v", server.Host, err)
+			}
+			relayHealths.set(health)
+		}(server)
+	}
+	wg.Wait()
+	relayHealths.retain(servers)
+}
+
+// startRelayProber checks health of ssh servers on every interval in background.
+func startRelayProber(interval, timeout time.Duration) {
+	go func() {
+		for {
+			checkRelays(kaginawa.SSHServers(), timeout)
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// handleRelayHealth handles health check results of ssh servers.
+//
+// - Method: GET, HEAD
+// - Client: API
+// - Access: Admin
+// - Response: JSON
+func handleRelayHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if validateAPIKey(r, kaginawa.ScopeServersRead) == nil && !getSession(r).isLoggedIn() {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, relayHealths.list())
+}
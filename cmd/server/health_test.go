@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+func TestCheckRelays(t *testing.T) {
+	// Reserve a port and close it to get an unreachable address
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	safeClose(listener, "listener")
+
+	relayHealths = newRelayHealthStore()
+	servers := []kaginawa.SSHServer{{Host: "127.0.0.1", Port: port, User: "u", Password: "p"}}
+	checkRelays(servers, time.Second)
+	healths := relayHealths.list()
+	if len(healths) != 1 {
+		t.Fatalf("expected 1 health, got %d", len(healths))
+	}
+	if healths[0].Healthy || len(healths[0].LastError) == 0 || healths[0].CheckedAt == 0 {
+		t.Errorf("expected unhealthy with error, got %+v", healths[0])
+	}
+
+	// Removed servers are dropped
+	checkRelays(nil, time.Second)
+	if healths := relayHealths.list(); len(healths) != 0 {
+		t.Errorf("expected no healths, got %+v", healths)
+	}
+}
+
+func TestRelayHealthStore_filter(t *testing.T) {
+	defer func(f func(kaginawa.SSHServer, time.Duration) error) { probeRelay = f }(probeRelay)
+	probeRelay = func(server kaginawa.SSHServer, _ time.Duration) error {
+		if server.Host == "dead" {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	relayHealths = newRelayHealthStore()
+	servers := []kaginawa.SSHServer{{Host: "alive"}, {Host: "dead"}}
+	checkRelays(servers, time.Second)
+	servers = append(servers, kaginawa.SSHServer{Host: "new"})
+	filtered := relayHealths.filter(servers)
+	if len(filtered) != 2 || filtered[0].Host != "alive" || filtered[1].Host != "new" {
+		t.Errorf("expected alive and new, got %v", filtered)
+	}
+	if all := relayHealths.filter(servers[1:2]); len(all) != 1 {
+		t.Errorf("expected fallback to all servers, got %v", all)
+	}
+}
+
+func TestHandleRelayHealth(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:    kaginawa.HashAPIKey(testAPIKey),
+		Label:  "servers key",
+		Scopes: []string{kaginawa.ScopeServersRead},
+	}); err != nil {
+		t.Fatalf("failed to put test key: %v", err)
+	}
+	relayHealths = newRelayHealthStore()
+	relayHealths.set(relayHealth{Host: "ssh.example.com", Healthy: true, LatencyMS: 12, CheckedAt: 1})
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/relay-health", nil)
+	req.Header.Set("Authorization", "token "+testAPIKey)
+	w := httptest.NewRecorder()
+	handleRelayHealth(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"host":"ssh.example.com","healthy":true,"latency_ms":12`) {
+		t.Errorf("unexpected body: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	defaultReportHookRetries = 3
+	defaultReportHookBackoff = 2 * time.Second
+	reportHookWorkers        = 8    // Number of concurrent deliveries of this instance
+	reportHookQueueSize      = 1024 // Deliveries waiting for workers, dropped if full
+	maxDeadLetters           = 1000 // Dead letters kept for each hook, older ones are deleted
+	reportHookPauseAfter     = 10   // Consecutive dead letters to disable the hook
+	deliveryHeader           = "X-Kaginawa-Delivery"
+	reportEvent              = "report"
+)
+
+var (
+	reportHookRetries = defaultReportHookRetries // Number of retries after the first attempt
+	reportHookBackoff = defaultReportHookBackoff // Wait before the first retry, doubled on every retry
+	reportHookQueue   = make(chan reportDelivery, reportHookQueueSize)
+	hookFailures      = make(map[string]int) // Consecutive dead letters of each hook in this instance
+	hookFailuresMutex sync.Mutex
+)
+
+// reportDelivery defines a queued delivery of the report to the hook.
+type reportDelivery struct {
+	hook   kaginawa.ReportHook
+	report kaginawa.Report
+	body   []byte
+}
+
+// startReportHookWorkers starts workers delivering queued reports to report hooks.
+func startReportHookWorkers(n int) {
+	for i := 0; i < n; i++ {
+		go func() {
+			for d := range reportHookQueue {
+				deliverReportHook(d.hook, d.report, d.body)
+			}
+		}()
+	}
+}
+
+// deliverReport queues the report for all report hooks accepting it without blocking.
+// Deliveries are dropped and logged if the queue is full.
+func deliverReport(report kaginawa.Report) {
+	hooks, err := hookCache.get()
+	if err != nil {
+		log.Printf("failed to list report hooks: %v", err)
+		return
+	}
+	var accepted []kaginawa.ReportHook
+	for _, hook := range hooks {
+		if hook.Accepts(report) {
+			accepted = append(accepted, hook)
+		}
+	}
+	if len(accepted) == 0 {
+		return
+	}
+	report.APIKey = "" // never expose hashes of api keys
+	body, err := json.Marshal(report)
+	if err != nil {
+		log.Printf("failed to marshal report of %s: %v", report.ID, err)
+		return
+	}
+	for _, hook := range accepted {
+		select {
+		case reportHookQueue <- reportDelivery{hook, report, body}:
+		default:
+			log.Printf("report hook queue is full, dropped report of %s to %s", report.ID, hook.Name)
+		}
+	}
+}
+
+// deliverReportHook posts the body to the hook with retries. Puts a dead letter if all attempts failed.
+func deliverReportHook(hook kaginawa.ReportHook, report kaginawa.Report, body []byte) {
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		log.Printf("failed to generate delivery id: %v", err)
+		return
+	}
+	for attempt := 0; ; attempt++ {
+		err := postReportHook(hook, id.String(), body)
+		if err == nil {
+			countHookFailure(hook.Name, false)
+			return
+		}
+		if attempt < reportHookRetries {
+			time.Sleep(reportHookBackoff << attempt)
+			continue
+		}
+		log.Printf("failed to deliver report of %s to %s after %d attempts: %v", report.ID, hook.Name, attempt+1, err)
+		letter := kaginawa.DeadLetter{
+			ID:        id.String(),
+			Hook:      hook.Name,
+			NodeID:    report.ID,
+			CustomID:  report.CustomID,
+			Payload:   string(body),
+			Attempts:  attempt + 1,
+			LastError: err.Error(),
+			CreatedAt: time.Now().UTC().Unix(),
+		}
+		if err := db.PutDeadLetter(letter); err != nil {
+			log.Printf("failed to put dead letter of %s: %v", hook.Name, err)
+		}
+		if err := db.TrimDeadLetters(hook.Name, maxDeadLetters); err != nil {
+			log.Printf("failed to trim dead letters of %s: %v", hook.Name, err)
+		}
+		if countHookFailure(hook.Name, true) >= reportHookPauseAfter {
+			pauseReportHook(hook.Name)
+		}
+		return
+	}
+}
+
+// countHookFailure counts consecutive dead letters of the hook. Successful deliveries reset the count.
+// Returns the current count.
+func countHookFailure(name string, failed bool) int {
+	hookFailuresMutex.Lock()
+	defer hookFailuresMutex.Unlock()
+	if !failed {
+		delete(hookFailures, name)
+		return 0
+	}
+	hookFailures[name]++
+	return hookFailures[name]
+}
+
+// pauseReportHook disables the hook, which is re-enabled by updating the hook.
+func pauseReportHook(name string) {
+	hook, err := db.GetReportHook(name)
+	if err != nil {
+		log.Printf("failed to get report hook %s: %v", name, err)
+		return
+	}
+	if hook == nil || !hook.Enabled {
+		return
+	}
+	hook.Enabled = false
+	hook.UpdatedBy = fmt.Sprintf("paused after %d dead letters", reportHookPauseAfter)
+	hook.UpdatedAt = time.Now().UTC().Unix()
+	if err := db.PutReportHook(*hook); err != nil {
+		log.Printf("failed to pause report hook %s: %v", name, err)
+		return
+	}
+	hookCache.invalidate()
+	countHookFailure(name, false)
+	log.Printf("paused report hook %s after %d consecutive dead letters", name, reportHookPauseAfter)
+}
+
+// postReportHook posts the body once with delivery ID and signature headers.
+func postReportHook(hook kaginawa.ReportHook, id string, body []byte) error {
+	header := http.Header{}
+	header.Set(eventHeader, reportEvent)
+	header.Set(deliveryHeader, id)
+	if len(hook.SigningKey) > 0 {
+		header.Set(signatureHeader, signPayload(hook.SigningKey, body))
+	}
+	return postJSON(hook.URL, body, header)
+}
+
+// reportHookSummary defines the API representation of a report hook. Signing key is never returned.
+type reportHookSummary struct {
+	Name          string   `json:"name"`
+	URL           string   `json:"url"`
+	HasSigningKey bool     `json:"has_signing_key"`
+	CustomIDs     []string `json:"custom_ids"`
+	APIKeys       []string `json:"api_keys"`
+	Enabled       bool     `json:"enabled"`
+	UpdatedBy     string   `json:"updated_by,omitempty"`
+	UpdatedAt     int64    `json:"updated_at,omitempty"`
+}
+
+func newReportHookSummary(h kaginawa.ReportHook) reportHookSummary {
+	summary := reportHookSummary{
+		Name:          h.Name,
+		URL:           h.URL,
+		HasSigningKey: len(h.SigningKey) > 0,
+		CustomIDs:     h.CustomIDs,
+		APIKeys:       h.APIKeys,
+		Enabled:       h.Enabled,
+		UpdatedBy:     h.UpdatedBy,
+		UpdatedAt:     h.UpdatedAt,
+	}
+	for _, list := range []*[]string{&summary.CustomIDs, &summary.APIKeys} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+	return summary
+}
+
+// handleNewReportHook handles report hook registration and update requests. Empty signing key keeps the current one.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleNewReportHook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	session := getSession(r)
+	if !session.isLoggedIn() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	hook := kaginawa.ReportHook{
+		Name:       strings.TrimSpace(r.FormValue("name")),
+		URL:        strings.TrimSpace(r.FormValue("url")),
+		SigningKey: strings.TrimSpace(r.FormValue("signing-key")),
+		CustomIDs:  splitCommaList(r.FormValue("custom-ids")),
+		APIKeys:    r.Form["api-key"],
+		Enabled:    r.FormValue("enabled") == "yes",
+		UpdatedBy:  session.email(),
+	}
+	if _, err := putReportHook(hook); err != nil {
+		writeFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleDeleteReportHook handles report hook deletion requests.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleDeleteReportHook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !getSession(r).isLoggedIn() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	name := r.FormValue("name")
+	if len(name) == 0 {
+		http.Error(w, "Name is empty", http.StatusBadRequest)
+		return
+	}
+	if err := db.DeleteReportHook(name); err != nil {
+		log.Printf("failed to delete report hook: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	hookCache.invalidate()
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleReportHooks handles list of report hooks requests.
+//
+// - Method: GET, HEAD
+// - Client: API
+// - Access: Admin
+// - Response: JSON
+func handleReportHooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	apiKey := validateAPIKey(r, kaginawa.ScopeHooksRead)
+	if apiKey == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	hooks, err := db.ListReportHooks()
+	if err != nil {
+		log.Printf("failed to list report hooks: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	summaries := make([]reportHookSummary, 0, len(hooks))
+	for _, hook := range hooks {
+		if allowsCustomIDs(apiKey, hook.CustomIDs) {
+			summaries = append(summaries, newReportHookSummary(hook))
+		}
+	}
+	writeJSON(w, summaries)
+}
+
+// handleReportHook handles single report hook requests. Empty signing key of PUT keeps the current one.
+// Keys restricted to custom IDs can manage hooks delivering reports of the allowed custom IDs only.
+//
+// - Method: GET, HEAD, PUT or DELETE
+// - Client: API
+// - Access: Admin
+// - Response: JSON (GET, HEAD or PUT) or 204 no content (DELETE)
+func handleReportHook(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if len(name) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	scope := kaginawa.ScopeHooksWrite
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		scope = kaginawa.ScopeHooksRead
+	case http.MethodPut, http.MethodDelete:
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	apiKey := validateAPIKey(r, scope)
+	if apiKey == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	current, err := db.GetReportHook(name)
+	if err != nil {
+		log.Printf("failed to get report hook: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if current != nil && !allowsCustomIDs(apiKey, current.CustomIDs) {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var hook kaginawa.ReportHook
+		if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if !allowsCustomIDs(apiKey, hook.CustomIDs) {
+			http.Error(w, "Custom IDs must be allowed for the API key", http.StatusForbidden)
+			return
+		}
+		hook.Name = name
+		hook.UpdatedBy = apiKey.Label
+		stored, err := putReportHook(hook)
+		if err != nil {
+			writeFormError(w, err)
+			return
+		}
+		writeJSON(w, newReportHookSummary(stored))
+		return
+	case http.MethodDelete:
+		if err := db.DeleteReportHook(name); err != nil {
+			log.Printf("failed to delete report hook: %v", err)
+			http.Error(w, "Database unavailable", http.StatusInternalServerError)
+			return
+		}
+		hookCache.invalidate()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if current == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, newReportHookSummary(*current))
+}
+
+// handleDeadLetters handles list of dead letters of the report hook requests.
+//
+// - Method: GET, HEAD
+// - Client: API
+// - Access: Admin
+// - Response: JSON
+func handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	apiKey := validateAPIKey(r, kaginawa.ScopeHooksRead)
+	if apiKey == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	letters, err := db.ListDeadLetters(mux.Vars(r)["name"], 0)
+	if err != nil {
+		log.Printf("failed to list dead letters: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	visible := []kaginawa.DeadLetter{}
+	for _, letter := range letters {
+		if apiKey.AllowsCustomID(letter.CustomID) {
+			visible = append(visible, letter)
+		}
+	}
+	writeJSON(w, visible)
+}
+
+// handleDeadLetter handles redelivery and deletion of a dead letter.
+// Redelivered letters are deleted on success, and attempts are counted up on failure.
+//
+// - Method: POST (redeliver) or DELETE
+// - Client: API
+// - Access: Admin
+// - Response: 204 no content, 502 bad gateway if the redelivery failed
+func handleDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	apiKey := validateAPIKey(r, kaginawa.ScopeHooksWrite)
+	if apiKey == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	name, id := mux.Vars(r)["name"], mux.Vars(r)["id"]
+	letter, err := db.GetDeadLetter(name, id)
+	if err != nil {
+		log.Printf("failed to get dead letter: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if letter != nil && !apiKey.AllowsCustomID(letter.CustomID) {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		if err := db.DeleteDeadLetter(name, id); err != nil {
+			log.Printf("failed to delete dead letter: %v", err)
+			http.Error(w, "Database unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	hook, err := db.GetReportHook(name)
+	if err != nil {
+		log.Printf("failed to get report hook: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if hook == nil || letter == nil || !allowsCustomIDs(apiKey, hook.CustomIDs) {
+		http.NotFound(w, r)
+		return
+	}
+	if err := postReportHook(*hook, letter.ID, []byte(letter.Payload)); err != nil {
+		letter.Attempts++
+		letter.LastError = err.Error()
+		if err := db.PutDeadLetter(*letter); err != nil {
+			log.Printf("failed to put dead letter: %v", err)
+		}
+		http.Error(w, "Failed to deliver: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	if err := db.DeleteDeadLetter(name, id); err != nil {
+		log.Printf("failed to delete dead letter: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// putReportHook validates and puts the report hook. Empty signing key falls back to the current entry.
+// Returns the stored report hook.
+func putReportHook(hook kaginawa.ReportHook) (kaginawa.ReportHook, error) {
+	if len(hook.Name) == 0 {
+		return hook, errors.New("name is empty")
+	}
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return hook, errors.New("invalid url")
+	}
+	for _, key := range hook.APIKeys {
+		apiKey, err := db.GetAPIKey(key)
+		if err != nil {
+			return hook, fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+		}
+		if apiKey == nil {
+			return hook, fmt.Errorf("unknown api key: %s", key)
+		}
+	}
+	if len(hook.SigningKey) == 0 {
+		current, err := db.GetReportHook(hook.Name)
+		if err != nil {
+			return hook, fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+		}
+		if current != nil {
+			hook.SigningKey = current.SigningKey
+		}
+	}
+	hook.UpdatedAt = time.Now().UTC().Unix()
+	if err := db.PutReportHook(hook); err != nil {
+		return hook, fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+	}
+	hookCache.invalidate()
+	countHookFailure(hook.Name, false) // resume paused hooks
+	return hook, nil
+}
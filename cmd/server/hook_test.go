@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+// drainReportHookQueue delivers all queued reports in the current goroutine.
+func drainReportHookQueue() {
+	for {
+		select {
+		case d := <-reportHookQueue:
+			deliverReportHook(d.hook, d.report, d.body)
+		default:
+			return
+		}
+	}
+}
+
+func TestDeliverReport(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	invalidateListCaches()
+	reportHookRetries = 2
+	reportHookBackoff = time.Millisecond
+	defer func() {
+		reportHookRetries = defaultReportHookRetries
+		reportHookBackoff = defaultReportHookBackoff
+	}()
+	hook := newTestHookServer(t)
+	broken := newTestHookServer(t)
+	broken.status = http.StatusServiceUnavailable
+	for _, h := range []kaginawa.ReportHook{
+		{Name: "all", URL: hook.URL + "/all", SigningKey: "secret", Enabled: true},
+		{Name: "site2", URL: hook.URL + "/site2", CustomIDs: []string{"site2"}, Enabled: true},
+		{Name: "agent", URL: hook.URL + "/agent", APIKeys: []string{"hmac-sha256:hash"}, Enabled: true},
+		{Name: "other", URL: hook.URL + "/other", APIKeys: []string{"hmac-sha256:other"}, Enabled: true},
+		{Name: "disabled", URL: hook.URL + "/disabled"},
+		{Name: "broken", URL: broken.URL, Enabled: true},
+	} {
+		if err := db.PutReportHook(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deliverReport(kaginawa.Report{ID: "node1", CustomID: "site1", APIKey: "hmac-sha256:hash"})
+	drainReportHookQueue()
+
+	requests, bodies := hook.received()
+	var paths []string
+	for _, r := range requests {
+		paths = append(paths, r.URL.Path)
+		if r.Header.Get(eventHeader) != reportEvent || len(r.Header.Get(deliveryHeader)) == 0 {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+	}
+	if len(paths) != 2 || !strings.Contains(strings.Join(paths, ","), "/all") ||
+		!strings.Contains(strings.Join(paths, ","), "/agent") {
+		t.Fatalf("expected deliveries to /all and /agent, got %v", paths)
+	}
+	for i, r := range requests {
+		if r.URL.Path == "/all" && r.Header.Get(signatureHeader) != signPayload("secret", bodies[i]) {
+			t.Errorf("unexpected signature: %s", r.Header.Get(signatureHeader))
+		}
+		var report kaginawa.Report
+		if err := json.Unmarshal(bodies[i], &report); err != nil {
+			t.Fatal(err)
+		}
+		if report.ID != "node1" || len(report.APIKey) > 0 {
+			t.Errorf("unexpected payload: %s", bodies[i])
+		}
+	}
+
+	if requests, _ := broken.received(); len(requests) != 3 {
+		t.Errorf("expected 3 attempts, got %d", len(requests))
+	}
+	letters, err := db.ListDeadLetters("broken", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].Attempts != 3 || letters[0].NodeID != "node1" ||
+		!strings.Contains(letters[0].LastError, "503") || !strings.Contains(letters[0].Payload, `"node1"`) {
+		t.Fatalf("expected dead letter, got %+v", letters)
+	}
+	if requests, _ := broken.received(); requests[0].Header.Get(deliveryHeader) != letters[0].ID {
+		t.Errorf("expected delivery id kept as dead letter id")
+	}
+}
+
+func TestDeliverReportHook_pause(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	invalidateListCaches()
+	reportHookRetries = 0
+	defer func() { reportHookRetries = defaultReportHookRetries }()
+	broken := newTestHookServer(t)
+	broken.status = http.StatusServiceUnavailable
+	hook := kaginawa.ReportHook{Name: "broken", URL: broken.URL, Enabled: true}
+	if _, err := putReportHook(hook); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxDeadLetters+1; i++ {
+		if err := db.PutDeadLetter(kaginawa.DeadLetter{ID: fmt.Sprintf("%05d", i), Hook: "broken"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < reportHookPauseAfter; i++ {
+		deliverReportHook(hook, kaginawa.Report{ID: "node1"}, []byte("{}"))
+	}
+	if count, _ := db.CountDeadLetters("broken"); count != maxDeadLetters {
+		t.Errorf("expected %d dead letters kept, got %d", maxDeadLetters, count)
+	}
+	if letters, _ := db.ListDeadLetters("broken", 1); len(letters) != 1 || strings.HasPrefix(letters[0].ID, "0") {
+		t.Errorf("expected newest dead letters kept, got %+v", letters)
+	}
+	paused, err := db.GetReportHook("broken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paused.Enabled {
+		t.Errorf("expected hook paused after %d dead letters", reportHookPauseAfter)
+	}
+	if _, err := putReportHook(hook); err != nil {
+		t.Fatal(err)
+	}
+	if n := countHookFailure("broken", true); n != 1 {
+		t.Errorf("expected failure count reset by update, got %d", n)
+	}
+}
+
+func TestHandleReportHook(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	invalidateListCaches()
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:    kaginawa.HashAPIKey(testAPIKey),
+		Label:  "hook key",
+		Scopes: []string{kaginawa.ScopeHooksRead, kaginawa.ScopeHooksWrite},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	hook := newTestHookServer(t)
+	request := func(method, path, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://localhost:8080"+path, strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"name": "warehouse", "id": "letter1"})
+		req.Header.Set("Authorization", "token "+testAPIKey)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	for _, body := range []string{
+		`{"url":"ftp://example.com"}`,
+		`{"url":"` + hook.URL + `","api_keys":["hook key"]}`,
+	} {
+		if w := request(http.MethodPut, "/report-hooks/warehouse", body, handleReportHook); w.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, body, w.Code)
+		}
+	}
+	body := `{"url":"` + hook.URL + `","signing_key":"secret","enabled":true}`
+	if w := request(http.MethodPut, "/report-hooks/warehouse", body, handleReportHook); w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	// Empty signing key keeps the current one
+	body = `{"url":"` + hook.URL + `","custom_ids":["site1"],"api_keys":["` + kaginawa.HashAPIKey(testAPIKey) + `"],"enabled":true}`
+	w := request(http.MethodPut, "/report-hooks/warehouse", body, handleReportHook)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "secret") {
+		t.Fatalf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	var summary reportHookSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if !summary.HasSigningKey || summary.UpdatedBy != "hook key" || len(summary.CustomIDs) != 1 ||
+		len(summary.APIKeys) != 1 {
+		t.Errorf("unexpected report hook: %+v", summary)
+	}
+	if w := request(http.MethodGet, "/report-hooks", "", handleReportHooks); !strings.Contains(w.Body.String(), `"name":"warehouse"`) {
+		t.Errorf("expected list of report hooks, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Redelivery of dead letters
+	if err := db.PutDeadLetter(kaginawa.DeadLetter{ID: "letter1", Hook: "warehouse", Payload: `{"id":"node1"}`, Attempts: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if w := request(http.MethodGet, "/report-hooks/warehouse/dead-letters", "", handleDeadLetters); !strings.Contains(w.Body.String(), `"id":"letter1"`) {
+		t.Errorf("expected list of dead letters, got %d: %s", w.Code, w.Body.String())
+	}
+	hook.status = http.StatusInternalServerError
+	if w := request(http.MethodPost, "/report-hooks/warehouse/dead-letters/letter1", "", handleDeadLetter); w.Code != http.StatusBadGateway {
+		t.Errorf("expected status %d, got %d", http.StatusBadGateway, w.Code)
+	}
+	if letter, _ := db.GetDeadLetter("warehouse", "letter1"); letter == nil || letter.Attempts != 5 {
+		t.Errorf("expected attempts counted up, got %+v", letter)
+	}
+	hook.status = http.StatusOK
+	if w := request(http.MethodPost, "/report-hooks/warehouse/dead-letters/letter1", "", handleDeadLetter); w.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	requests, bodies := hook.received()
+	if last := len(requests) - 1; requests[last].Header.Get(deliveryHeader) != "letter1" ||
+		requests[last].Header.Get(signatureHeader) != signPayload("secret", bodies[last]) {
+		t.Errorf("expected signed redelivery, got %v", requests[last].Header)
+	}
+	if letter, _ := db.GetDeadLetter("warehouse", "letter1"); letter != nil {
+		t.Errorf("expected dead letter deleted, got %+v", letter)
+	}
+
+	if w := request(http.MethodDelete, "/report-hooks/warehouse", "", handleReportHook); w.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if w := request(http.MethodGet, "/report-hooks/warehouse", "", handleReportHook); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestHandleReportHook_restrictedKey(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	invalidateListCaches()
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:       kaginawa.HashAPIKey(testAPIKey),
+		Scopes:    []string{kaginawa.ScopeHooksRead, kaginawa.ScopeHooksWrite},
+		CustomIDs: []string{"site1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutReportHook(kaginawa.ReportHook{Name: "site2", URL: "http://example.com", CustomIDs: []string{"site2"}}); err != nil {
+		t.Fatal(err)
+	}
+	request := func(method, name, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://localhost:8080/report-hooks/"+name, strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"name": name})
+		req.Header.Set("Authorization", "token "+testAPIKey)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	for _, body := range []string{
+		`{"url":"http://example.com","enabled":true}`,
+		`{"url":"http://example.com","custom_ids":["site1","site2"],"enabled":true}`,
+	} {
+		if w := request(http.MethodPut, "tenant", body, handleReportHook); w.Code != http.StatusForbidden {
+			t.Errorf("expected status %d for %s, got %d", http.StatusForbidden, body, w.Code)
+		}
+	}
+	body := `{"url":"http://example.com","custom_ids":["site1"],"enabled":true}`
+	if w := request(http.MethodPut, "tenant", body, handleReportHook); w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := request(http.MethodPut, "site2", body, handleReportHook); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for hook of other custom IDs, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := request(http.MethodDelete, "site2", "", handleReportHook); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for hook of other custom IDs, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := request(http.MethodGet, "", "", handleReportHooks); strings.Contains(w.Body.String(), `"name":"site2"`) ||
+		!strings.Contains(w.Body.String(), `"name":"tenant"`) {
+		t.Errorf("expected hooks of allowed custom IDs only, got %s", w.Body.String())
+	}
+	if hook, err := db.GetReportHook("site2"); err != nil || hook == nil || len(hook.CustomIDs) != 1 {
+		t.Errorf("expected hook of other custom IDs unchanged, got %+v, %v", hook, err)
+	}
+}
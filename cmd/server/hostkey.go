@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+	"golang.org/x/crypto/ssh"
+)
+
+// knownHostsDisabled disables verification of host keys of nodes, set by KNOWN_HOSTS_DISABLED.
+var knownHostsDisabled bool
+
+// hostKeyChangedError reports that the presented host key does not match the trusted one.
+type hostKeyChangedError struct {
+	name     string
+	expected string
+	actual   string
+}
+
+func (e *hostKeyChangedError) Error() string {
+	return fmt.Sprintf("host key of %s has changed (trusted %s, presented %s), "+
+		"reset the trusted key on the admin console if the change is expected", e.name, e.expected, e.actual)
+}
+
+// verifyHostKey builds a trust-on-first-use callback. An empty known key trusts the presented key and
+// passes it to save. A nil save accepts unknown keys without trusting them.
+func verifyHostKey(name, known string, save func(key string) error) ssh.HostKeyCallback {
+	return func(_ string, _ net.Addr, key ssh.PublicKey) error {
+		presented := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+		if len(known) == 0 {
+			if save == nil {
+				return nil
+			}
+			if err := save(presented); err != nil {
+				return fmt.Errorf("failed to trust host key of %s: %w", name, err)
+			}
+			log.Printf("trusted host key of %s on first use: %s", name, ssh.FingerprintSHA256(key))
+			return nil
+		}
+		if known != presented {
+			return &hostKeyChangedError{name: name, expected: fingerprint(known), actual: ssh.FingerprintSHA256(key)}
+		}
+		return nil
+	}
+}
+
+// serverHostKeyCallback verifies the host key of the ssh server, and trusts it on first use.
+func serverHostKeyCallback(server kaginawa.SSHServer) ssh.HostKeyCallback {
+	return verifyHostKey("ssh server "+server.Host, server.HostKey, func(key string) error {
+		current, err := db.GetSSHServerByHost(server.Host)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return fmt.Errorf("ssh server %s not found", server.Host)
+		}
+		current.HostKey = key
+		if err := db.PutSSHServer(*current); err != nil {
+			return err
+		}
+		refreshSSHServers()
+		return nil
+	})
+}
+
+// nodeHostKeyCallback verifies the host key of the node, and trusts it on first use.
+// Any key is accepted without trusting it if verification is disabled by KNOWN_HOSTS_DISABLED.
+func nodeHostKeyCallback(id string) (ssh.HostKeyCallback, error) {
+	if knownHostsDisabled {
+		return verifyHostKey("node "+id, "", nil), nil
+	}
+	known, err := db.GetKnownHost(id)
+	if err != nil {
+		return nil, err
+	}
+	var key string
+	if known != nil {
+		key = known.Key
+	}
+	return verifyHostKey("node "+id, key, func(key string) error {
+		return db.PutKnownHost(kaginawa.KnownHost{ID: id, Key: key, Timestamp: time.Now().UTC().Unix()})
+	}), nil
+}
+
+// fingerprint formats SHA256 fingerprint of the authorized_keys formatted key.
+func fingerprint(authorizedKey string) string {
+	if len(authorizedKey) == 0 {
+		return ""
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return "(invalid key)"
+	}
+	return ssh.FingerprintSHA256(key)
+}
+
+// handleResetServerHostKey handles trusted host key reset requests of ssh servers.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleResetServerHostKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !getSession(r).isLoggedIn() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	server, err := db.GetSSHServerByHost(r.FormValue("host"))
+	if err != nil {
+		log.Printf("failed to get ssh server: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if server == nil {
+		http.NotFound(w, r)
+		return
+	}
+	server.HostKey = ""
+	if err := db.PutSSHServer(*server); err != nil {
+		log.Printf("failed to put ssh server: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	refreshSSHServers()
+	log.Printf("host key of ssh server %s reset by %s", server.Host, getSession(r).name())
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleResetNodeHostKey handles trusted host key reset requests of nodes.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleResetNodeHostKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if len(id) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !getSession(r).isLoggedIn() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if err := db.DeleteKnownHost(id); err != nil {
+		log.Printf("failed to delete known host %s: %v", id, err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("host key of node %s reset by %s", id, getSession(r).name())
+	http.Redirect(w, r, "/nodes/"+id, http.StatusSeeOther)
+}
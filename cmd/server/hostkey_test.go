@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestNodeHostKeyCallback(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	trusted := newTestHostKey(t)
+
+	// Trust on first use
+	callback, err := nodeHostKeyCallback("node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := callback("", nil, trusted); err != nil {
+		t.Fatalf("expected first key to be trusted, got %v", err)
+	}
+	known, err := db.GetKnownHost("node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if known == nil || fingerprint(known.Key) != ssh.FingerprintSHA256(trusted) {
+		t.Fatalf("expected trusted key stored, got %v", known)
+	}
+
+	// Same key
+	callback, err = nodeHostKeyCallback("node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := callback("", nil, trusted); err != nil {
+		t.Errorf("expected trusted key accepted, got %v", err)
+	}
+
+	// Changed key
+	err = callback("", nil, newTestHostKey(t))
+	var changed *hostKeyChangedError
+	if !errors.As(err, &changed) {
+		t.Fatalf("expected hostKeyChangedError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), ssh.FingerprintSHA256(trusted)) {
+		t.Errorf("expected trusted fingerprint in error, got %v", err)
+	}
+}
+
+func TestNodeHostKeyCallback_disabled(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	knownHostsDisabled = true
+	t.Cleanup(func() { knownHostsDisabled = false })
+	if err := db.PutKnownHost(kaginawa.KnownHost{ID: "node1", Key: "trusted"}); err != nil {
+		t.Fatal(err)
+	}
+	callback, err := nodeHostKeyCallback("node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := callback("", nil, newTestHostKey(t)); err != nil {
+		t.Errorf("expected any key accepted, got %v", err)
+	}
+	if known, err := db.GetKnownHost("node1"); err != nil || known == nil || known.Key != "trusted" {
+		t.Errorf("expected trusted key unchanged, got %v, %v", known, err)
+	}
+}
+
+func TestServerHostKeyCallback(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	server := kaginawa.SSHServer{Host: "ssh.example.com", Port: 22, User: "u", Password: "p"}
+	if err := db.PutSSHServer(server); err != nil {
+		t.Fatal(err)
+	}
+	key := newTestHostKey(t)
+	if err := serverHostKeyCallback(server)("", nil, key); err != nil {
+		t.Fatalf("expected first key to be trusted, got %v", err)
+	}
+	stored, err := db.GetSSHServerByHost(server.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fingerprint(stored.HostKey) != ssh.FingerprintSHA256(key) {
+		t.Fatalf("expected trusted key stored, got %s", stored.HostKey)
+	}
+	if servers := kaginawa.SSHServers(); len(servers) != 1 || servers[0].HostKey != stored.HostKey {
+		t.Errorf("expected cache refreshed, got %v", servers)
+	}
+	if err := serverHostKeyCallback(*stored)("", nil, newTestHostKey(t)); err == nil {
+		t.Error("expected error for changed key")
+	}
+}
+
+func TestVerifyHostKey_noSave(t *testing.T) {
+	if err := verifyHostKey("probe", "", nil)("", nil, newTestHostKey(t)); err != nil {
+		t.Errorf("expected unknown key accepted, got %v", err)
+	}
+}
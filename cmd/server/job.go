@@ -0,0 +1,319 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	defaultJobConcurrency = 10
+	maxJobConcurrency     = 100
+	commandJobTTL         = time.Hour
+)
+
+// Status of each node of a command job.
+const (
+	jobStatusPending = "pending"
+	jobStatusRunning = "running"
+	jobStatusDone    = "done"
+	jobStatusError   = "error"
+)
+
+var commandJobs = newCommandJobStore()
+
+// commandJob defines a command execution over multiple nodes.
+type commandJob struct {
+	ID          string             `json:"id"`
+	Command     string             `json:"command"`
+	User        string             `json:"user"`
+	Concurrency int                `json:"concurrency"`
+	Total       int                `json:"total"`
+	Completed   int                `json:"completed"`
+	Done        bool               `json:"done"`
+	StartedAt   int64              `json:"started_at"`
+	FinishedAt  int64              `json:"finished_at,omitempty"`
+	Results     []commandJobResult `json:"results"`
+}
+
+// commandJobResult defines the command execution status of a node.
+type commandJobResult struct {
+	ID       string         `json:"id"`
+	CustomID string         `json:"custom_id,omitempty"`
+	Hostname string         `json:"hostname,omitempty"`
+	Status   string         `json:"status"`
+	Error    string         `json:"error,omitempty"`
+	Result   *commandResult `json:"result,omitempty"`
+}
+
+// commandJobStore holds command jobs on memory. Finished jobs are dropped after commandJobTTL.
+type commandJobStore struct {
+	mutex sync.RWMutex
+	jobs  map[string]*commandJob
+}
+
+func newCommandJobStore() *commandJobStore {
+	return &commandJobStore{jobs: make(map[string]*commandJob)}
+}
+
+func (s *commandJobStore) add(job *commandJob) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	threshold := time.Now().Add(-commandJobTTL).Unix()
+	for id, j := range s.jobs {
+		if j.Done && j.FinishedAt < threshold {
+			delete(s.jobs, id)
+		}
+	}
+	s.jobs[job.ID] = job
+}
+
+// get returns a snapshot of the job. Returns nil if not found.
+func (s *commandJobStore) get(id string) *commandJob {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil
+	}
+	snapshot := *job
+	snapshot.Results = append([]commandJobResult(nil), job.Results...)
+	return &snapshot
+}
+
+// update modifies the result of the i-th node.
+func (s *commandJobStore) update(id string, i int, fn func(result *commandJobResult)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return
+	}
+	fn(&job.Results[i])
+	if job.Results[i].Status == jobStatusDone || job.Results[i].Status == jobStatusError {
+		job.Completed++
+	}
+}
+
+// visibleTo returns a copy of the job with results of the nodes allowed for the api key.
+// Nil key means a logged-in browser session. Returns nil if no nodes of the job are allowed.
+func (j *commandJob) visibleTo(apiKey *kaginawa.APIKey) *commandJob {
+	if apiKey == nil || len(apiKey.CustomIDs) == 0 {
+		return j
+	}
+	visible := *j
+	visible.Results = nil
+	visible.Completed = 0
+	for _, result := range j.Results {
+		if !allowsNode(apiKey, &kaginawa.Report{ID: result.ID, CustomID: result.CustomID}) {
+			continue
+		}
+		visible.Results = append(visible.Results, result)
+		if result.Status == jobStatusDone || result.Status == jobStatusError {
+			visible.Completed++
+		}
+	}
+	if len(visible.Results) == 0 && len(j.Results) > 0 {
+		return nil
+	}
+	visible.Total = len(visible.Results)
+	if visible.Results == nil {
+		visible.Results = []commandJobResult{}
+	}
+	return &visible
+}
+
+func (s *commandJobStore) finish(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if job, ok := s.jobs[id]; ok {
+		job.Done = true
+		job.FinishedAt = time.Now().Unix()
+	}
+}
+
+// handleCommandJobs handles start a command execution over nodes matching the selector.
+// Selector parameters are same as the node list (custom-id, hostname, global-addr, local-addr, version and minutes).
+//
+// - Method: POST
+// - Client: Browser or API
+// - Access: Admin
+// - Response: JSON
+func handleCommandJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	apiKey := validateAPIKey(r, kaginawa.ScopeCommandExec)
+	if apiKey == nil && !getSession(r).isLoggedIn() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	form, err := parseCommandForm(r)
+	if err != nil {
+		writeFormError(w, err)
+		return
+	}
+	selector, err := parseNodeSelector(r.Form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if selector.empty() {
+		http.Error(w, "Node selector required", http.StatusBadRequest)
+		return
+	}
+	concurrency := defaultJobConcurrency
+	if v := r.FormValue("concurrency"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxJobConcurrency {
+			http.Error(w, "Invalid concurrency value", http.StatusBadRequest)
+			return
+		}
+		concurrency = n
+	}
+	matches, err := selector.selectReports(kaginawa.ListViewAttributes)
+	if err != nil {
+		log.Printf("failed to list reports: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	var reports []kaginawa.Report
+	for i := range matches {
+		if allowsNode(apiKey, &matches[i]) {
+			reports = append(reports, matches[i])
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ID < reports[j].ID })
+	job, err := newCommandJob(reports, form, concurrency)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	commandJobs.add(job)
+	snapshot := commandJobs.get(job.ID)
+	log.Printf("COMMAND JOB %s started on %d nodes: %s", job.ID, job.Total, job.Command)
+	go runCommandJob(job.ID, reports, form, concurrency, commandActor(apiKey, r), commandSourceJob)
+	w.Header().Set("Location", "/command-jobs/"+job.ID)
+	w.Header().Set("Content-Type", contentTypeJSON) // writeJSON cannot set headers after WriteHeader
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, snapshot)
+}
+
+// newCommandJob creates a pending command job of the nodes.
+func newCommandJob(reports []kaginawa.Report, form commandForm, concurrency int) (*commandJob, error) {
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	job := &commandJob{
+		ID:          id.String(),
+		Command:     form.command,
+		User:        form.user,
+		Concurrency: concurrency,
+		Total:       len(reports),
+		StartedAt:   time.Now().Unix(),
+		Results:     make([]commandJobResult, len(reports)),
+	}
+	for i, report := range reports {
+		job.Results[i] = commandJobResult{
+			ID:       report.ID,
+			CustomID: report.CustomID,
+			Hostname: report.Hostname,
+			Status:   jobStatusPending,
+		}
+	}
+	return job, nil
+}
+
+// runCommandJob executes the command on each node with bounded concurrency.
+func runCommandJob(id string, reports []kaginawa.Report, form commandForm, concurrency int, actor, source string) {
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+	for i := range reports {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			commandJobs.update(id, i, func(result *commandJobResult) { result.Status = jobStatusRunning })
+			result, err := execNodeCommand(&reports[i], form, actor, source)
+			commandJobs.update(id, i, func(r *commandJobResult) {
+				if err != nil {
+					r.Status = jobStatusError
+					r.Error = err.Error()
+					return
+				}
+				r.Status = jobStatusDone
+				r.Result = result
+			})
+		}(i)
+	}
+	wg.Wait()
+	commandJobs.finish(id)
+	log.Printf("COMMAND JOB %s finished", id)
+}
+
+func execNodeCommand(report *kaginawa.Report, form commandForm, actor, source string) (*commandResult, error) {
+	req, err := newCommandRequest(report, form)
+	if err != nil {
+		req = &commandRequest{
+			id:      report.ID,
+			actor:   actor,
+			user:    form.user,
+			profile: form.profile,
+			command: form.command,
+			saved:   form.savedName(),
+		}
+		recordCommand(req, source, newCommandExit(err), 0, 0)
+		return nil, err
+	}
+	req.actor = actor
+	result, err := execResult(req)
+	if err != nil {
+		recordCommand(req, source, newCommandExit(err), 0, 0)
+		return nil, err
+	}
+	recordCommandResult(req, source, result)
+	return result, nil
+}
+
+// handleCommandJob handles get progress and results of a command job.
+// Results are limited to the nodes allowed for the api key.
+//
+// - Method: GET
+// - Client: Browser or API
+// - Access: Admin
+// - Response: JSON
+func handleCommandJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	apiKey := validateAPIKey(r, kaginawa.ScopeCommandExec)
+	if apiKey == nil && !getSession(r).isLoggedIn() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	job := commandJobs.get(mux.Vars(r)["id"])
+	if job != nil {
+		job = job.visibleTo(apiKey)
+	}
+	if job == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, job)
+}
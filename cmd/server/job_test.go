@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+func TestHandleCommandJobs(t *testing.T) {
+	_, target := setupTestRelay(t)
+	for _, report := range []kaginawa.Report{
+		{ID: "node1", CustomID: "group1", SSHServerHost: "127.0.0.1", SSHRemotePort: target.port()},
+		{ID: "node2", CustomID: "group1"}, // ssh not connected
+		{ID: "node3", CustomID: "group2", SSHServerHost: "127.0.0.1", SSHRemotePort: target.port()},
+	} {
+		if err := db.PutReport(report); err != nil {
+			t.Fatal(err)
+		}
+	}
+	form := url.Values{
+		"user":        {testSSHUser},
+		"password":    {testSSHPassword},
+		"command":     {"echo hello"},
+		"custom-id":   {"group1"},
+		"concurrency": {"2"},
+	}
+	req := newTestCommandRequest("/command-jobs", form)
+	w := httptest.NewRecorder()
+	handleCommandJobs(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, w.Code, w.Body.String())
+	}
+	var job commandJob
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Total != 2 {
+		t.Fatalf("expected 2 nodes, got %d", job.Total)
+	}
+	if w.Header().Get("Location") != "/command-jobs/"+job.ID {
+		t.Errorf("unexpected location: %s", w.Header().Get("Location"))
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !job.Done {
+		if time.Now().After(deadline) {
+			t.Fatalf("job not finished: %+v", job)
+		}
+		time.Sleep(50 * time.Millisecond)
+		req := httptest.NewRequest(http.MethodGet, "/command-jobs/"+job.ID, nil)
+		req = mux.SetURLVars(req, map[string]string{"id": job.ID})
+		req.Header.Set("Authorization", "token "+testAPIKey)
+		w := httptest.NewRecorder()
+		handleCommandJob(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if job.Completed != 2 {
+		t.Errorf("expected 2 completed, got %d", job.Completed)
+	}
+	if r := job.Results[0]; r.ID != "node1" || r.Status != jobStatusDone || r.Result == nil || r.Result.Stdout != "hello\n" {
+		t.Errorf("unexpected result of node1: %+v", r)
+	}
+	if r := job.Results[1]; r.ID != "node2" || r.Status != jobStatusError || !strings.Contains(r.Error, "not connected") {
+		t.Errorf("unexpected result of node2: %+v", r)
+	}
+	logs, err := db.ListCommandLogs("node2", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Command != "echo hello" || logs[0].ExitCode != -1 || !strings.Contains(logs[0].Error, "not connected") {
+		t.Errorf("expected failed attempt of node2 recorded, got %+v", logs)
+	}
+}
+
+func TestHandleCommandJobs_selectorRequired(t *testing.T) {
+	setupTestRelay(t)
+	form := url.Values{"user": {testSSHUser}, "password": {testSSHPassword}, "command": {"echo hello"}}
+	w := httptest.NewRecorder()
+	handleCommandJobs(w, newTestCommandRequest("/command-jobs", form))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestHandleCommandJob_notFound(t *testing.T) {
+	setupTestRelay(t)
+	req := httptest.NewRequest(http.MethodGet, "/command-jobs/unknown", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "unknown"})
+	req.Header.Set("Authorization", "token "+testAPIKey)
+	w := httptest.NewRecorder()
+	handleCommandJob(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestCommandJob_VisibleTo(t *testing.T) {
+	job := &commandJob{
+		ID:        "job1",
+		Total:     3,
+		Completed: 2,
+		Results: []commandJobResult{
+			{ID: "node1", CustomID: "site1", Status: jobStatusDone},
+			{ID: "node2", CustomID: "site2", Status: jobStatusDone},
+			{ID: "node3", CustomID: "site1", Status: jobStatusRunning},
+		},
+	}
+	if visible := job.visibleTo(nil); visible != job {
+		t.Error("expected all results for browser sessions")
+	}
+	visible := job.visibleTo(&kaginawa.APIKey{CustomIDs: []string{"site1"}})
+	if visible == nil || visible.Total != 2 || visible.Completed != 1 || len(visible.Results) != 2 ||
+		visible.Results[0].ID != "node1" || visible.Results[1].ID != "node3" {
+		t.Errorf("expected results of site1 only, got %+v", visible)
+	}
+	if len(job.Results) != 3 {
+		t.Error("original job modified")
+	}
+	if visible := job.visibleTo(&kaginawa.APIKey{CustomIDs: []string{"site3"}}); visible != nil {
+		t.Errorf("expected job of other sites hidden, got %+v", visible)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+const (
+	defaultLivenessCheckInterval = time.Minute
+	defaultReportInterval        = 3 // minutes, used by online until an interval report is received
+	defaultOfflineMissedReports  = 3
+)
+
+var liveness = newLivenessTracker(defaultOfflineMissedReports)
+
+// nodeLiveness defines the evaluated state of a node.
+type nodeLiveness struct {
+	interval int // Expected report interval minutes of the newest interval report, 0 until received
+	lastSeen int64
+	offline  bool
+	since    int64 // Server time of the last report before offline, identifies the offline period
+}
+
+// livenessEvent defines a raised event with the offline period, so that server instances claim the same event once.
+type livenessEvent struct {
+	kaginawa.NodeEvent
+	since int64
+}
+
+// livenessTracker detects offline and recovered nodes from server times of their latest reports.
+type livenessTracker struct {
+	mutex       sync.Mutex
+	missed      int // Number of missed reports until offline
+	nodes       map[string]*nodeLiveness
+	initialized bool
+}
+
+func newLivenessTracker(missed int) *livenessTracker {
+	return &livenessTracker{missed: missed, nodes: make(map[string]*nodeLiveness)}
+}
+
+// evaluate updates states of the nodes and returns raised events.
+// The first evaluation records the states without events, so restarting the server does not raise
+// offline events of the nodes already offline. Nodes are never raised offline until an interval report is seen,
+// because the report interval is unknown.
+func (l *livenessTracker) evaluate(reports []kaginawa.Report, now time.Time) []livenessEvent {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	var events []livenessEvent
+	seen := make(map[string]bool, len(reports))
+	for _, report := range reports {
+		seen[report.ID] = true
+		state, ok := l.nodes[report.ID]
+		if !ok {
+			state = &nodeLiveness{}
+			l.nodes[report.ID] = state
+		}
+		if report.IsIntervalReport() {
+			state.interval = report.Trigger
+		}
+		state.lastSeen = report.ServerTime
+		if state.interval == 0 {
+			continue // unknown interval
+		}
+		offline := now.After(l.deadline(report.ServerTime, state.interval))
+		if offline == state.offline {
+			continue
+		}
+		state.offline = offline
+		if offline {
+			state.since = report.ServerTime
+		}
+		if !l.initialized {
+			continue
+		}
+		event := kaginawa.NodeEvent{
+			Type:      kaginawa.EventRecovered,
+			NodeID:    report.ID,
+			CustomID:  report.CustomID,
+			Hostname:  report.Hostname,
+			LastSeen:  report.ServerTime,
+			Interval:  state.interval,
+			Timestamp: now.UTC().Unix(),
+		}
+		if offline {
+			event.Type = kaginawa.EventOffline
+		}
+		events = append(events, livenessEvent{NodeEvent: event, since: state.since})
+	}
+	for id := range l.nodes {
+		if !seen[id] {
+			delete(l.nodes, id) // deleted node
+		}
+	}
+	l.initialized = true
+	return events
+}
+
+// online reports whether the node of the report is considered online at the time.
+// Uses the interval of the last evaluation if known, otherwise the trigger of the report.
+func (l *livenessTracker) online(report kaginawa.Report, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	interval := defaultReportInterval
+	if state, ok := l.nodes[report.ID]; ok && state.interval > 0 {
+		interval = state.interval
+	}
+	if report.IsIntervalReport() {
+		interval = report.Trigger
+	}
+	return !now.After(l.deadline(report.ServerTime, interval))
+}
+
+// deadline returns the time until the node is considered online.
+func (l *livenessTracker) deadline(lastSeen int64, interval int) time.Time {
+	return time.Unix(lastSeen, 0).Add(time.Duration(interval*l.missed) * time.Minute)
+}
+
+// checkLiveness evaluates all nodes and delivers raised events claimed by this instance.
+// Listed reports are also cached for fleet metrics, and observed for the least-loaded relay policy.
+func checkLiveness(now time.Time) {
+	reports, err := db.ListReports(0, 0, 0, kaginawa.ListViewAttributes)
+	if err != nil {
+		log.Printf("failed to list reports for liveness check: %v", err)
+		return
+	}
+	fleetCache.store(reports)
+	relayLoads.Observe(reports)
+	var events []kaginawa.NodeEvent
+	for _, event := range liveness.evaluate(reports, now) {
+		claimed, err := db.ClaimNodeEvent(event.NodeID, event.Type, event.since)
+		if err != nil {
+			log.Printf("failed to claim %s event of %s: %v", event.Type, event.NodeID, err)
+			continue
+		}
+		if claimed {
+			events = append(events, event.NodeEvent)
+		}
+	}
+	dispatchEvents(events)
+}
+
+// startLivenessChecker checks liveness of nodes on every interval in background.
+func startLivenessChecker(interval time.Duration) {
+	go func() {
+		for {
+			checkLiveness(time.Now())
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// observeRelayLoads observes connected nodes of relays from the cached fleet reports.
+func observeRelayLoads() {
+	reports, err := fleetCache.get()
+	if err != nil {
+		log.Printf("failed to list reports for relay loads: %v", err)
+		return
+	}
+	relayLoads.Observe(reports)
+}
+
+// startRelayLoadsObserver observes relay loads on every interval in background.
+// Used instead of the liveness checker when it is disabled.
+func startRelayLoadsObserver(interval time.Duration) {
+	go func() {
+		for {
+			observeRelayLoads()
+			time.Sleep(interval)
+		}
+	}()
+}
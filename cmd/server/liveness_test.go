@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+type recordingNotifier struct {
+	events []kaginawa.NodeEvent
+	err    error
+}
+
+func (n *recordingNotifier) notify(event kaginawa.NodeEvent) error {
+	n.events = append(n.events, event)
+	return n.err
+}
+
+func TestCheckLiveness(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	liveness = newLivenessTracker(defaultOfflineMissedReports)
+	recorder := &recordingNotifier{}
+	failing := &recordingNotifier{err: errors.New("unreachable")}
+	notifiers = []notifier{recorder, failing}
+	defer func() { notifiers = []notifier{logNotifier{}} }()
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	put := func(id string, trigger int, serverTime time.Time) {
+		t.Helper()
+		if err := db.PutReport(kaginawa.Report{ID: id, CustomID: "site-" + id, Trigger: trigger, ServerTime: serverTime.Unix()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("interval", 10, now.Add(-20*time.Minute))
+	put("dead", 1, now.Add(-time.Hour))
+
+	// Already offline nodes are not raised at the first evaluation
+	checkLiveness(now)
+	if len(recorder.events) != 0 {
+		t.Fatalf("expected no events, got %+v", recorder.events)
+	}
+
+	// 3 missed reports of 10 minutes interval
+	checkLiveness(now.Add(9 * time.Minute))
+	if len(recorder.events) != 0 {
+		t.Fatalf("expected no events, got %+v", recorder.events)
+	}
+	checkLiveness(now.Add(11 * time.Minute))
+	if len(recorder.events) != 1 {
+		t.Fatalf("expected offline event, got %+v", recorder.events)
+	}
+	event := recorder.events[0]
+	if event.Type != kaginawa.EventOffline || event.NodeID != "interval" || event.CustomID != "site-interval" || event.Interval != 10 {
+		t.Errorf("unexpected event: %+v", event)
+	}
+	if len(failing.events) != 1 {
+		t.Errorf("expected delivery to all notifiers, got %d", len(failing.events))
+	}
+	checkLiveness(now.Add(12 * time.Minute))
+	if len(recorder.events) != 1 {
+		t.Fatalf("expected no duplicated events, got %+v", recorder.events)
+	}
+
+	// Boot report keeps the known interval
+	put("interval", 0, now.Add(13*time.Minute))
+	checkLiveness(now.Add(14 * time.Minute))
+	if len(recorder.events) != 2 || recorder.events[1].Type != kaginawa.EventRecovered || recorder.events[1].Interval != 10 {
+		t.Fatalf("expected recovered event, got %+v", recorder.events)
+	}
+	checkLiveness(now.Add(42 * time.Minute))
+	if len(recorder.events) != 2 {
+		t.Errorf("expected online within 30 minutes, got %+v", recorder.events)
+	}
+	checkLiveness(now.Add(44 * time.Minute))
+	if len(recorder.events) != 3 || recorder.events[2].Type != kaginawa.EventOffline {
+		t.Errorf("expected offline event, got %+v", recorder.events)
+	}
+}
+
+func TestCheckLiveness_unknownInterval(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	liveness = newLivenessTracker(defaultOfflineMissedReports)
+	recorder := &recordingNotifier{}
+	notifiers = []notifier{recorder}
+	defer func() { notifiers = []notifier{logNotifier{}} }()
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	if err := db.PutReport(kaginawa.Report{ID: "booted", Trigger: -1, ServerTime: now.Unix()}); err != nil {
+		t.Fatal(err)
+	}
+	checkLiveness(now)
+	checkLiveness(now.Add(time.Hour))
+	if len(recorder.events) != 0 {
+		t.Fatalf("expected no events until an interval report is seen, got %+v", recorder.events)
+	}
+	if err := db.PutReport(kaginawa.Report{ID: "booted", Trigger: 30, ServerTime: now.Add(time.Hour).Unix()}); err != nil {
+		t.Fatal(err)
+	}
+	checkLiveness(now.Add(2 * time.Hour))
+	if len(recorder.events) != 0 {
+		t.Fatalf("expected online within 90 minutes, got %+v", recorder.events)
+	}
+	checkLiveness(now.Add(3 * time.Hour))
+	if len(recorder.events) != 1 || recorder.events[0].Type != kaginawa.EventOffline || recorder.events[0].Interval != 30 {
+		t.Errorf("expected offline event with interval 30, got %+v", recorder.events)
+	}
+}
+
+func TestCheckLiveness_multipleInstances(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	recorder := &recordingNotifier{}
+	notifiers = []notifier{recorder}
+	defer func() { notifiers = []notifier{logNotifier{}} }()
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	if err := db.PutReport(kaginawa.Report{ID: "node1", Trigger: 10, ServerTime: now.Unix()}); err != nil {
+		t.Fatal(err)
+	}
+	instances := []*livenessTracker{
+		newLivenessTracker(defaultOfflineMissedReports),
+		newLivenessTracker(defaultOfflineMissedReports),
+	}
+	check := func(at time.Time) {
+		for _, instance := range instances {
+			liveness = instance
+			checkLiveness(at)
+		}
+	}
+	check(now)
+	check(now.Add(31 * time.Minute))
+	if len(recorder.events) != 1 || recorder.events[0].Type != kaginawa.EventOffline {
+		t.Fatalf("expected offline event delivered once, got %+v", recorder.events)
+	}
+
+	// The second instance sees the second report after recovery
+	if err := db.PutReport(kaginawa.Report{ID: "node1", Trigger: 10, ServerTime: now.Add(40 * time.Minute).Unix()}); err != nil {
+		t.Fatal(err)
+	}
+	liveness = instances[0]
+	checkLiveness(now.Add(41 * time.Minute))
+	if err := db.PutReport(kaginawa.Report{ID: "node1", Trigger: 10, ServerTime: now.Add(50 * time.Minute).Unix()}); err != nil {
+		t.Fatal(err)
+	}
+	liveness = instances[1]
+	checkLiveness(now.Add(51 * time.Minute))
+	if len(recorder.events) != 2 || recorder.events[1].Type != kaginawa.EventRecovered {
+		t.Errorf("expected recovered event delivered once, got %+v", recorder.events)
+	}
+}
+
+func TestObserveRelayLoads(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	invalidateListCaches()
+	defer func() { relayLoads = kaginawa.NewRelayLoads(relayLoadsTTL) }()
+	relayLoads = kaginawa.NewRelayLoads(relayLoadsTTL)
+	if err := db.PutReport(kaginawa.Report{ID: "node1", SSHServerHost: "relay1", SSHRemotePort: 10022}); err != nil {
+		t.Fatal(err)
+	}
+	observeRelayLoads()
+	if counts := relayLoads.Counts(""); counts["relay1"] != 1 {
+		t.Errorf("expected connected node counted, got %v", counts)
+	}
+
+	for policies, expected := range map[string]bool{"": false, "sticky": false, "sticky,least-loaded": true} {
+		selector, err := kaginawa.NewRelaySelector(policies, nil, relayLoads)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if usesLeastLoaded(selector) != expected {
+			t.Errorf("expected %v for %q", expected, policies)
+		}
+	}
+}
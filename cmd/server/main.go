@@ -4,7 +4,10 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
@@ -23,7 +26,29 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Initialize master keys
+	masterKeys, err := kaginawa.LoadKeyRing()
+	if err != nil {
+		log.Fatalf("failed to load master keys: %v", err)
+	}
+	kaginawa.MasterKeys = masterKeys
+
 	// Initialize database
+	kaginawa.APIKeySalt = os.Getenv("API_KEY_SALT")
+	if len(kaginawa.APIKeySalt) == 0 {
+		log.Print("WARNING: API_KEY_SALT is not set, api keys are hashed without a secret and leaked hashes can be " +
+			"brute-forced offline. Set a random API_KEY_SALT before registering api keys.")
+	}
+	if v := os.Getenv("KNOWN_HOSTS_DISABLED"); len(v) > 0 {
+		disabled, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("invalid KNOWN_HOSTS_DISABLED: %s", v)
+		}
+		knownHostsDisabled = disabled
+	}
+	if knownHostsDisabled {
+		log.Print("WARNING: KNOWN_HOSTS_DISABLED is set, host keys of nodes are not verified.")
+	}
 	mongoURI := os.Getenv("MONGODB_URI")
 	dynamoKeys := os.Getenv("DYNAMO_KEYS")
 	sessionTTL := 0
@@ -32,13 +57,16 @@ func main() {
 		if err != nil {
 			log.Fatalf("failed to initialize database: %v", err)
 		}
-		db = mongoDB
+		db = observeDB(mongoDB, "mongodb")
 	} else if len(dynamoKeys) > 0 {
 		dynamoDB, err := kaginawa.NewDynamoDB()
 		if err != nil {
 			log.Fatalf("failed to initialize database: %v", err)
 		}
-		db = dynamoDB
+		if !dynamoDB.HasKnownHosts() && !knownHostsDisabled {
+			log.Fatal("missing env var: DYNAMO_KNOWN_HOSTS (set KNOWN_HOSTS_DISABLED=true to skip host key verification of nodes)")
+		}
+		db = observeDB(dynamoDB, "dynamodb")
 		sessionTTL = dynamoDB.SessionTTLSeconds()
 	} else {
 		log.Fatal("Database not configured!")
@@ -49,6 +77,24 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Migrate plaintext api keys
+	migrated, err := kaginawa.MigrateAPIKeys(db)
+	if err != nil {
+		log.Fatalf("failed to migrate api keys: %v", err)
+	}
+	if migrated > 0 {
+		log.Printf("%d plaintext api keys migrated.", migrated)
+	}
+
+	// Migrate labels of api keys in report hooks
+	migrated, err = kaginawa.MigrateReportHookAPIKeys(db)
+	if err != nil {
+		log.Fatalf("failed to migrate report hooks: %v", err)
+	}
+	if migrated > 0 {
+		log.Printf("%d report hooks migrated to hashed api keys.", migrated)
+	}
+
 	// Load api keys
 	apiKeys, err := db.ListAPIKeys()
 	if err != nil {
@@ -56,13 +102,162 @@ func main() {
 	}
 	log.Printf("%d api keys loaded.", len(apiKeys))
 
+	// Encrypt secrets of ssh servers, credential profiles, notifiers and report hooks by the primary master key
+	for _, table := range kaginawa.SecretTables(db) {
+		rotated, err := table.Rotate()
+		if err != nil {
+			log.Fatalf("failed to rotate secrets of %s: %v", table.Name, err)
+		}
+		if rotated > 0 {
+			log.Printf("%d %s encrypted by master key %s.", rotated, table.Name, masterKeys.PrimaryKeyID())
+		}
+	}
+
 	// Load ssh servers
 	servers, err := db.ListSSHServers()
 	if err != nil {
 		log.Fatalf("failed to list ssh servers: %v", err)
 	}
+	kaginawa.SetSSHServers(servers)
 	log.Printf("%d ssh servers loaded.", len(servers))
 
+	// Initialize relay selection
+	pins, err := kaginawa.ParseRelayPins(os.Getenv("RELAY_PINS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	selector, err := kaginawa.NewRelaySelector(os.Getenv("RELAY_POLICY"), pins, relayLoads)
+	if err != nil {
+		log.Fatal(err)
+	}
+	relaySelector = selector
+
+	// Start health check of ssh servers
+	probeInterval := defaultRelayProbeInterval
+	if v := os.Getenv("RELAY_PROBE_INTERVAL"); len(v) > 0 {
+		sec, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("invalid RELAY_PROBE_INTERVAL: %v", err)
+		}
+		probeInterval = time.Duration(sec) * time.Second
+	}
+	if probeInterval > 0 {
+		startRelayProber(probeInterval, defaultRelayProbeTimeout)
+	}
+
+	// Initialize web terminal
+	if v := os.Getenv("TERMINAL_IDLE_TIMEOUT"); len(v) > 0 {
+		sec, err := strconv.Atoi(v)
+		if err != nil || sec < 1 {
+			log.Fatalf("invalid TERMINAL_IDLE_TIMEOUT: %s", v)
+		}
+		terminalIdleTimeout = time.Duration(sec) * time.Second
+	}
+	if v := os.Getenv("TERMINAL_MAX_DURATION"); len(v) > 0 {
+		sec, err := strconv.Atoi(v)
+		if err != nil || sec < 1 {
+			log.Fatalf("invalid TERMINAL_MAX_DURATION: %s", v)
+		}
+		terminalMaxDuration = time.Duration(sec) * time.Second
+	}
+
+	// Initialize file transfer
+	if v := os.Getenv("SFTP_MAX_UPLOAD_MB"); len(v) > 0 {
+		mb, err := strconv.Atoi(v)
+		if err != nil || mb < 1 {
+			log.Fatalf("invalid SFTP_MAX_UPLOAD_MB: %s", v)
+		}
+		maxUploadBytes = int64(mb) << 20
+	}
+	if v := os.Getenv("SFTP_MAX_DOWNLOAD_MB"); len(v) > 0 {
+		mb, err := strconv.Atoi(v)
+		if err != nil || mb < 1 {
+			log.Fatalf("invalid SFTP_MAX_DOWNLOAD_MB: %s", v)
+		}
+		maxDownloadBytes = int64(mb) << 20
+	}
+
+	// Initialize port forwarding
+	if v := os.Getenv("FORWARD_ORIGIN"); len(v) > 0 {
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 || len(u.Path) > 0 {
+			log.Fatalf("invalid FORWARD_ORIGIN: %s", v)
+		}
+		forwardOrigin = v
+	} else {
+		log.Print("FORWARD_ORIGIN is not set, scripts of forwarded web pages are disabled")
+	}
+
+	// Initialize relay connection pool
+	relayKeepAlive, relayIdleTimeout := defaultRelayKeepAlive, defaultRelayIdleTimeout
+	if v := os.Getenv("RELAY_KEEPALIVE_INTERVAL"); len(v) > 0 {
+		sec, err := strconv.Atoi(v)
+		if err != nil || sec < 1 {
+			log.Fatalf("invalid RELAY_KEEPALIVE_INTERVAL: %s", v)
+		}
+		relayKeepAlive = time.Duration(sec) * time.Second
+	}
+	if v := os.Getenv("RELAY_IDLE_TIMEOUT"); len(v) > 0 {
+		sec, err := strconv.Atoi(v)
+		if err != nil || sec < 0 {
+			log.Fatalf("invalid RELAY_IDLE_TIMEOUT: %s", v)
+		}
+		relayIdleTimeout = time.Duration(sec) * time.Second
+	}
+	relayConns = newRelayPool(relayKeepAlive, relayIdleTimeout)
+
+	// Start scheduler of commands
+	schedulerEnabled := true
+	if v := os.Getenv("SCHEDULER_ENABLED"); len(v) > 0 {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("invalid SCHEDULER_ENABLED: %s", v)
+		}
+		schedulerEnabled = enabled
+	}
+	if schedulerEnabled {
+		startScheduler()
+	}
+
+	// Start offline detection of nodes
+	livenessInterval := defaultLivenessCheckInterval
+	if v := os.Getenv("OFFLINE_CHECK_INTERVAL"); len(v) > 0 {
+		sec, err := strconv.Atoi(v)
+		if err != nil || sec < 0 {
+			log.Fatalf("invalid OFFLINE_CHECK_INTERVAL: %s", v)
+		}
+		livenessInterval = time.Duration(sec) * time.Second
+	}
+	if v := os.Getenv("OFFLINE_MISSED_REPORTS"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("invalid OFFLINE_MISSED_REPORTS: %s", v)
+		}
+		liveness = newLivenessTracker(n)
+	}
+	if livenessInterval > 0 {
+		startLivenessChecker(livenessInterval)
+	} else if usesLeastLoaded(relaySelector) {
+		startRelayLoadsObserver(defaultLivenessCheckInterval) // connected nodes are observed by the liveness checker
+	}
+
+	// Configure retries of report hooks
+	if v := os.Getenv("REPORT_HOOK_RETRIES"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid REPORT_HOOK_RETRIES: %s", v)
+		}
+		reportHookRetries = n
+	}
+	if v := os.Getenv("REPORT_HOOK_BACKOFF"); len(v) > 0 {
+		sec, err := strconv.Atoi(v)
+		if err != nil || sec < 1 {
+			log.Fatalf("invalid REPORT_HOOK_BACKOFF: %s", v)
+		}
+		reportHookBackoff = time.Duration(sec) * time.Second
+	}
+	startReportHookWorkers(reportHookWorkers)
+
 	// Start listing
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -81,14 +276,67 @@ func main() {
 	r.HandleFunc("/nodes", handleNodes)
 	r.HandleFunc("/nodes/{id}", handleNode)
 	r.HandleFunc("/nodes/{id}/command", handleCommand)
+	r.HandleFunc("/nodes/{id}/command/stream", handleCommandStream)
+	r.HandleFunc("/nodes/{id}/upload", handleUpload)
+	r.HandleFunc("/nodes/{id}/download", handleDownload)
+	r.HandleFunc("/nodes/{id}/forwards", handleNewForward)
+	r.HandleFunc("/nodes/{id}/terminal", handleTerminal)
+	r.HandleFunc("/nodes/{id}/terminal/socket", handleTerminalSocket)
 	r.HandleFunc("/nodes/{id}/histories", handleHistories)
+	r.HandleFunc("/nodes/{id}/command-logs", handleCommandLogs)
 	r.HandleFunc("/nodes/{id}/delete", handleNodeDelete)
+	r.HandleFunc("/nodes/{id}/reset-host-key", handleResetNodeHostKey)
 	r.HandleFunc("/admin", handleAdmin)
 	r.HandleFunc("/install-script", handleInstallScript)
 	r.HandleFunc("/new-key", handleNewAPIKey)
+	r.HandleFunc("/disable-key", handleDisableAPIKey)
+	r.HandleFunc("/delete-key", handleDeleteAPIKey)
 	r.HandleFunc("/new-server", handleNewSSHServer)
+	r.HandleFunc("/edit-server", handleEditSSHServer)
+	r.HandleFunc("/delete-server", handleDeleteSSHServer)
+	r.HandleFunc("/reset-host-key", handleResetServerHostKey)
 	r.HandleFunc("/gen-key", handleGenerateKey)
+	r.HandleFunc("/servers", handleSSHServers)
+	r.HandleFunc("/relay-health", handleRelayHealth)
 	r.HandleFunc("/servers/{id}", handleSSHServer)
+	r.HandleFunc("/new-credential", handleNewCredentialProfile)
+	r.HandleFunc("/delete-credential", handleDeleteCredentialProfile)
+	r.HandleFunc("/credentials", handleCredentialProfiles)
+	r.HandleFunc("/credentials/{name}", handleCredentialProfile)
+	r.HandleFunc("/new-saved-command", handleNewSavedCommand)
+	r.HandleFunc("/delete-saved-command", handleDeleteSavedCommand)
+	r.HandleFunc("/saved-commands", handleSavedCommands)
+	r.HandleFunc("/saved-commands/{name}", handleSavedCommand)
+	r.HandleFunc("/new-notifier", handleNewNotifier)
+	r.HandleFunc("/delete-notifier", handleDeleteNotifier)
+	r.HandleFunc("/notifiers", handleNotifiers)
+	r.HandleFunc("/notifiers/{name}", handleNotifier)
+	r.HandleFunc("/notifiers/{name}/test", handleTestNotifier)
+	r.HandleFunc("/new-alert-rule", handleNewAlertRule)
+	r.HandleFunc("/delete-alert-rule", handleDeleteAlertRule)
+	r.HandleFunc("/alert-rules", handleAlertRules)
+	r.HandleFunc("/alert-rules/{name}", handleAlertRule)
+	r.HandleFunc("/alerts", handleAlerts)
+	r.HandleFunc("/new-report-hook", handleNewReportHook)
+	r.HandleFunc("/delete-report-hook", handleDeleteReportHook)
+	r.HandleFunc("/report-hooks", handleReportHooks)
+	r.HandleFunc("/report-hooks/{name}", handleReportHook)
+	r.HandleFunc("/report-hooks/{name}/dead-letters", handleDeadLetters)
+	r.HandleFunc("/report-hooks/{name}/dead-letters/{id}", handleDeadLetter)
+	r.HandleFunc("/metrics", handleMetrics)
+	r.HandleFunc("/reports/stream", handleReportStream)
+	r.HandleFunc("/forwards/{token}", handleForward)
+	r.HandleFunc("/forwards/{token}/socket", handleForwardSocket)
+	r.PathPrefix("/forwards/{token}/http/").HandlerFunc(handleForwardProxy)
+	r.HandleFunc("/command-jobs", handleCommandJobs)
+	r.HandleFunc("/command-jobs/{id}", handleCommandJob)
+	r.HandleFunc("/schedules", handleSchedules)
+	r.HandleFunc("/schedules/{name}", handleSchedule)
+	r.HandleFunc("/schedules/{name}/runs", handleScheduleRuns)
+	r.HandleFunc("/schedules/{name}/run", handleRunSchedule)
+	r.HandleFunc("/new-schedule", handleNewSchedule)
+	r.HandleFunc("/toggle-schedule", handleToggleSchedule)
+	r.HandleFunc("/delete-schedule", handleDeleteSchedule)
 	r.HandleFunc("/measure/{kb}", handleMeasure)
 	r.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", http.FileServer(http.Dir("./assets"))))
 	log.Printf("Starting kaginawa server at port %s", port)
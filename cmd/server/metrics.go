@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+const contentTypeMetrics = "text/plain; version=0.0.4; charset=utf-8"
+
+// reportDurationBuckets defines upper bounds (seconds) of the report handler latency histogram.
+var reportDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var serverMetrics = newMetricsRegistry()
+
+// metricsRegistry holds counters of this server instance exposed by /metrics.
+type metricsRegistry struct {
+	mutex           sync.Mutex
+	reports         uint64
+	reportBuckets   []uint64 // Cumulative counts of reportDurationBuckets
+	reportCount     uint64
+	reportSum       float64
+	dbRequests      map[[2]string]uint64 // Keyed by backend and method
+	dbErrors        map[[2]string]uint64 // Keyed by backend and method
+	commandsByState map[[2]string]uint64 // Keyed by source and status
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		reportBuckets:   make([]uint64, len(reportDurationBuckets)),
+		dbRequests:      make(map[[2]string]uint64),
+		dbErrors:        make(map[[2]string]uint64),
+		commandsByState: make(map[[2]string]uint64),
+	}
+}
+
+// observeReport counts a stored report.
+func (m *metricsRegistry) observeReport() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.reports++
+}
+
+// observeReportDuration records latency of the report handler.
+func (m *metricsRegistry) observeReportDuration(d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	seconds := d.Seconds()
+	for i, bound := range reportDurationBuckets {
+		if seconds <= bound {
+			m.reportBuckets[i]++
+		}
+	}
+	m.reportCount++
+	m.reportSum += seconds
+}
+
+// observeDB counts a database method call of the backend.
+func (m *metricsRegistry) observeDB(backend, method string, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := [2]string{backend, method}
+	m.dbRequests[key]++
+	if err != nil {
+		m.dbErrors[key]++
+	}
+}
+
+// observeCommand counts a command execution.
+func (m *metricsRegistry) observeCommand(source string, exit commandExit) {
+	status := "success"
+	switch {
+	case exit.TimedOut:
+		status = "timeout"
+	case len(exit.Error) > 0:
+		status = "error"
+	case exit.ExitCode != 0:
+		status = "failure"
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.commandsByState[[2]string{source, status}]++
+}
+
+// write writes all counters in the Prometheus text format.
+func (m *metricsRegistry) write(buf *bytes.Buffer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	writeMetricHeader(buf, "kaginawa_reports_received_total", "counter", "Number of stored reports.")
+	fmt.Fprintf(buf, "kaginawa_reports_received_total %d\n", m.reports)
+
+	writeMetricHeader(buf, "kaginawa_report_duration_seconds", "histogram", "Latency of the report handler.")
+	for i, bound := range reportDurationBuckets {
+		fmt.Fprintf(buf, "kaginawa_report_duration_seconds_bucket{le=\"%s\"} %d\n", formatMetricValue(bound), m.reportBuckets[i])
+	}
+	fmt.Fprintf(buf, "kaginawa_report_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.reportCount)
+	fmt.Fprintf(buf, "kaginawa_report_duration_seconds_sum %s\n", formatMetricValue(m.reportSum))
+	fmt.Fprintf(buf, "kaginawa_report_duration_seconds_count %d\n", m.reportCount)
+
+	writeMetricHeader(buf, "kaginawa_db_requests_total", "counter", "Number of database method calls.")
+	for _, key := range sortedLabelPairs(m.dbRequests) {
+		fmt.Fprintf(buf, "kaginawa_db_requests_total{backend=%s,method=%s} %d\n",
+			quoteLabel(key[0]), quoteLabel(key[1]), m.dbRequests[key])
+	}
+	writeMetricHeader(buf, "kaginawa_db_errors_total", "counter", "Number of failed database method calls.")
+	for _, key := range sortedLabelPairs(m.dbErrors) {
+		fmt.Fprintf(buf, "kaginawa_db_errors_total{backend=%s,method=%s} %d\n",
+			quoteLabel(key[0]), quoteLabel(key[1]), m.dbErrors[key])
+	}
+
+	writeMetricHeader(buf, "kaginawa_command_executions_total", "counter", "Number of command executions on nodes.")
+	for _, key := range sortedLabelPairs(m.commandsByState) {
+		fmt.Fprintf(buf, "kaginawa_command_executions_total{source=%s,status=%s} %d\n",
+			quoteLabel(key[0]), quoteLabel(key[1]), m.commandsByState[key])
+	}
+}
+
+// observeDB wraps the database to count method calls and errors of the backend.
+func observeDB(db kaginawa.DB, backend string) kaginawa.DB {
+	return kaginawa.NewObservedDB(db, func(method string, err error) {
+		serverMetrics.observeDB(backend, method, err)
+	})
+}
+
+// writeRuntimeMetrics writes Go runtime metrics of this server instance.
+func writeRuntimeMetrics(buf *bytes.Buffer) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	writeMetricHeader(buf, "go_goroutines", "gauge", "Number of goroutines.")
+	fmt.Fprintf(buf, "go_goroutines %d\n", runtime.NumGoroutine())
+	writeMetricHeader(buf, "go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use.")
+	fmt.Fprintf(buf, "go_memstats_alloc_bytes %d\n", mem.Alloc)
+	writeMetricHeader(buf, "go_memstats_sys_bytes", "gauge", "Number of bytes obtained from system.")
+	fmt.Fprintf(buf, "go_memstats_sys_bytes %d\n", mem.Sys)
+	writeMetricHeader(buf, "process_start_time_seconds", "gauge", "Start time of the process since unix epoch.")
+	fmt.Fprintf(buf, "process_start_time_seconds %d\n", bootTime)
+}
+
+// writeFleetMetrics writes gauges of the nodes visible to the api key.
+func writeFleetMetrics(buf *bytes.Buffer, reports []kaginawa.Report, apiKey *kaginawa.APIKey, now time.Time) {
+	nodes := make(map[string]int)
+	online := make(map[string]int)
+	versions := make(map[string]int)
+	var disks []kaginawa.Report
+	for _, report := range reports {
+		if !apiKey.AllowsCustomID(report.CustomID) {
+			continue
+		}
+		nodes[report.CustomID]++
+		if liveness.online(report, now) {
+			online[report.CustomID]++
+		}
+		versions[report.AgentVersion]++
+		if report.DiskTotalBytes > 0 {
+			disks = append(disks, report)
+		}
+	}
+	writeMetricHeader(buf, "kaginawa_nodes", "gauge", "Number of nodes by custom ID.")
+	for _, customID := range sortedLabels(nodes) {
+		fmt.Fprintf(buf, "kaginawa_nodes{custom_id=%s} %d\n", quoteLabel(customID), nodes[customID])
+	}
+	writeMetricHeader(buf, "kaginawa_nodes_online", "gauge", "Number of online nodes by custom ID.")
+	for _, customID := range sortedLabels(nodes) {
+		fmt.Fprintf(buf, "kaginawa_nodes_online{custom_id=%s} %d\n", quoteLabel(customID), online[customID])
+	}
+	writeMetricHeader(buf, "kaginawa_agent_versions", "gauge", "Number of nodes by agent version.")
+	for _, version := range sortedLabels(versions) {
+		fmt.Fprintf(buf, "kaginawa_agent_versions{version=%s} %d\n", quoteLabel(version), versions[version])
+	}
+	writeMetricHeader(buf, "kaginawa_node_disk_usage_ratio", "gauge", "Disk usage of the node (0 to 1).")
+	sort.Slice(disks, func(i, j int) bool { return disks[i].ID < disks[j].ID })
+	for _, report := range disks {
+		fmt.Fprintf(buf, "kaginawa_node_disk_usage_ratio{node_id=%s,custom_id=%s,hostname=%s} %s\n",
+			quoteLabel(report.ID), quoteLabel(report.CustomID), quoteLabel(report.Hostname),
+			formatMetricValue(float64(report.DiskUsedBytes)/float64(report.DiskTotalBytes)))
+	}
+}
+
+func writeMetricHeader(buf *bytes.Buffer, name, kind, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// labelReplacer escapes label values of the Prometheus text format.
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(v string) string {
+	return `"` + labelReplacer.Replace(v) + `"`
+}
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedLabels(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedLabelPairs(m map[[2]string]uint64) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+// handleMetrics handles Prometheus scrape requests.
+// Fleet gauges are computed from cached reports, and limited to the custom IDs allowed for the api key.
+//
+// - Method: GET
+// - Client: API
+// - Access: Admin
+// - Response: Prometheus text format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	apiKey := validateAPIKey(r, kaginawa.ScopeMetricsRead)
+	if apiKey == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	reports, err := fleetCache.get()
+	if err != nil {
+		log.Printf("failed to list reports for metrics: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	serverMetrics.write(&buf)
+	writeRuntimeMetrics(&buf)
+	writeFleetMetrics(&buf, reports, apiKey, time.Now())
+	w.Header().Set("Content-Type", contentTypeMetrics)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Printf("failed to write body: %v", err)
+	}
+}
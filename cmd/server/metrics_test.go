@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+func TestHandleMetrics(t *testing.T) {
+	serverMetrics = newMetricsRegistry()
+	liveness = newLivenessTracker(defaultOfflineMissedReports)
+	defer func() {
+		serverMetrics = newMetricsRegistry()
+		liveness = newLivenessTracker(defaultOfflineMissedReports)
+	}()
+	db = observeDB(kaginawa.NewMemDB(), "memory")
+	invalidateListCaches()
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:    kaginawa.HashAPIKey(testAPIKey),
+		Label:  "prometheus",
+		Scopes: []string{kaginawa.ScopeMetricsRead},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Unix()
+	for _, report := range []kaginawa.Report{
+		{ID: "node1", CustomID: "site1", ServerTime: now, AgentVersion: "v1.0.0", Hostname: "pi\"1",
+			DiskTotalBytes: 200, DiskUsedBytes: 150},
+		{ID: "node2", CustomID: "site1", ServerTime: now - 3600, AgentVersion: "v1.0.0"},
+		{ID: "node3", CustomID: "site2", ServerTime: now, AgentVersion: "v1.1.0"},
+	} {
+		if err := db.PutReport(report); err != nil {
+			t.Fatal(err)
+		}
+	}
+	serverMetrics.observeReport()
+	serverMetrics.observeReportDuration(30 * time.Millisecond)
+	serverMetrics.observeDB("memory", "GetReportByID", errors.New("timeout"))
+	serverMetrics.observeCommand(commandSourceCommand, commandExit{ExitCode: 1})
+	serverMetrics.observeCommand(commandSourceCommand, commandExit{TimedOut: true})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/metrics", nil)
+	w := httptest.NewRecorder()
+	handleMetrics(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d without api key, got %d", http.StatusUnauthorized, w.Code)
+	}
+	req.Header.Set("Authorization", "token "+testAPIKey)
+	w = httptest.NewRecorder()
+	handleMetrics(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != contentTypeMetrics {
+		t.Fatalf("unexpected response %d: %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, expected := range []string{
+		"kaginawa_reports_received_total 1\n",
+		`kaginawa_report_duration_seconds_bucket{le="0.025"} 0` + "\n",
+		`kaginawa_report_duration_seconds_bucket{le="0.05"} 1` + "\n",
+		`kaginawa_report_duration_seconds_bucket{le="+Inf"} 1` + "\n",
+		"kaginawa_report_duration_seconds_count 1\n",
+		`kaginawa_db_requests_total{backend="memory",method="PutReport"} 3` + "\n",
+		`kaginawa_db_errors_total{backend="memory",method="GetReportByID"} 1` + "\n",
+		`kaginawa_command_executions_total{source="command",status="failure"} 1` + "\n",
+		`kaginawa_command_executions_total{source="command",status="timeout"} 1` + "\n",
+		`kaginawa_nodes{custom_id="site1"} 2` + "\n",
+		`kaginawa_nodes_online{custom_id="site1"} 1` + "\n",
+		`kaginawa_nodes_online{custom_id="site2"} 1` + "\n",
+		`kaginawa_agent_versions{version="v1.0.0"} 2` + "\n",
+		`kaginawa_node_disk_usage_ratio{node_id="node1",custom_id="site1",hostname="pi\"1"} 0.75` + "\n",
+		"# TYPE go_goroutines gauge\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %q in metrics:\n%s", expected, body)
+		}
+	}
+
+	// Fleet gauges are limited to allowed custom IDs
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:       kaginawa.HashAPIKey("site2-key"),
+		Label:     "site2",
+		Scopes:    []string{kaginawa.ScopeMetricsRead},
+		CustomIDs: []string{"site2"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "token site2-key")
+	w = httptest.NewRecorder()
+	handleMetrics(w, req)
+	if body := w.Body.String(); strings.Contains(body, `custom_id="site1"`) || !strings.Contains(body, `custom_id="site2"`) {
+		t.Errorf("expected site2 only, got:\n%s", body)
+	}
+	if body := w.Body.String(); !strings.Contains(body, `kaginawa_db_requests_total{backend="memory",method="ListReports"} 1`+"\n") {
+		t.Errorf("expected reports listed once for scrapes, got:\n%s", body)
+	}
+}
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+const (
+	notifyTimeout   = 10 * time.Second
+	eventHeader     = "X-Kaginawa-Event"
+	signatureHeader = "X-Kaginawa-Signature"
+)
+
+var notifyClient = &http.Client{Timeout: notifyTimeout}
+
+// notifier delivers node events to somewhere.
+type notifier interface {
+	notify(event kaginawa.NodeEvent) error
+}
+
+// logNotifier writes node events to the server log.
+type logNotifier struct{}
+
+func (logNotifier) notify(event kaginawa.NodeEvent) error {
+	log.Printf("EVENT %s", event.Message())
+	return nil
+}
+
+// webhookNotifier posts events as JSON. Payloads are signed by HMAC-SHA256 if the signing key is configured.
+type webhookNotifier struct {
+	url        string
+	signingKey string
+}
+
+func (n webhookNotifier) notify(event kaginawa.NodeEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set(eventHeader, event.Type)
+	if len(n.signingKey) > 0 {
+		header.Set(signatureHeader, signPayload(n.signingKey, body))
+	}
+	return postJSON(n.url, body, header)
+}
+
+// slackNotifier posts event messages to a Slack-compatible incoming webhook.
+type slackNotifier struct {
+	url string
+}
+
+func (n slackNotifier) notify(event kaginawa.NodeEvent) error {
+	body, err := json.Marshal(struct {
+		Text string `json:"text"`
+	}{"[kaginawa] " + event.Message()})
+	if err != nil {
+		return err
+	}
+	return postJSON(n.url, body, nil)
+}
+
+// emailNotifier sends event messages via SMTP. STARTTLS is used if the server supports it.
+type emailNotifier struct {
+	host     string
+	user     string
+	password string
+	from     string
+	to       []string
+}
+
+func (n emailNotifier) notify(event kaginawa.NodeEvent) error {
+	conn, err := net.DialTimeout("tcp", n.host, notifyTimeout)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(notifyTimeout)); err != nil {
+		safeClose(conn, "smtp connection")
+		return err
+	}
+	host, _, _ := net.SplitHostPort(n.host)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		safeClose(conn, "smtp connection")
+		return err
+	}
+	if err := n.send(client, host, event); err != nil {
+		safeClose(client, "smtp client")
+		return err
+	}
+	return client.Quit()
+}
+
+func (n emailNotifier) send(client *smtp.Client, host string, event kaginawa.NodeEvent) error {
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("failed to start tls: %w", err)
+		}
+	}
+	if len(n.user) > 0 {
+		if err := client.Auth(smtp.PlainAuth("", n.user, n.password, host)); err != nil {
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+	if err := client.Mail(n.from); err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("failed to add recipient %s: %w", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(n.message(event)); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+var (
+	// headerReplacer prevents header injection by node attributes such as custom id.
+	headerReplacer = strings.NewReplacer("\r", "", "\n", " ")
+	// bodyReplacer normalizes line endings of the body to CRLF.
+	bodyReplacer = strings.NewReplacer("\r\n", "\r\n", "\r", "", "\n", "\r\n")
+)
+
+func (n emailNotifier) message(event kaginawa.NodeEvent) []byte {
+	var buf bytes.Buffer
+	subject := headerReplacer.Replace(fmt.Sprintf("[kaginawa] %s: %s", event.Type, event.Name()))
+	fmt.Fprintf(&buf, "From: %s\r\n", n.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	buf.WriteString(bodyReplacer.Replace(event.Message()) + "\r\n")
+	return buf.Bytes()
+}
+
+// signPayload returns the signature header value of the payload.
+func signPayload(key string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// postJSON posts the body and checks the status code is 2xx.
+func postJSON(endpoint string, body []byte, header http.Header) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer safeClose(resp.Body, "notification response")
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// newNotifier creates a notifier of the database item.
+func newNotifier(config kaginawa.Notifier) (notifier, error) {
+	switch config.Type {
+	case kaginawa.NotifierWebhook:
+		return webhookNotifier{url: config.URL, signingKey: config.SigningKey}, nil
+	case kaginawa.NotifierSlack:
+		return slackNotifier{url: config.URL}, nil
+	case kaginawa.NotifierEmail:
+		return emailNotifier{
+			host:     config.SMTPHost,
+			user:     config.SMTPUser,
+			password: config.SMTPPassword,
+			from:     config.From,
+			to:       config.To,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier type: %s", config.Type)
+	}
+}
+
+// notifiers holds built-in destinations of node events. Notifiers stored in the database are added on dispatch.
+var notifiers = []notifier{logNotifier{}}
+
+// dispatchEvents delivers events to all notifiers. Delivery errors are logged only.
+func dispatchEvents(events []kaginawa.NodeEvent) {
+	if len(events) == 0 {
+		return
+	}
+	configs, err := notifierCache.get()
+	if err != nil {
+		log.Printf("failed to list notifiers: %v", err)
+	}
+	for _, event := range events {
+		for _, n := range notifiers {
+			if err := n.notify(event); err != nil {
+				log.Printf("failed to notify %s event of %s: %v", event.Type, event.NodeID, err)
+			}
+		}
+		for _, config := range configs {
+			if !config.Accepts(event) {
+				continue
+			}
+			n, err := newNotifier(config)
+			if err == nil {
+				err = n.notify(event)
+			}
+			if err != nil {
+				log.Printf("failed to notify %s event of %s to %s: %v", event.Type, event.NodeID, config.Name, err)
+			}
+		}
+	}
+}
+
+// notifierSummary defines the API representation of a notifier. Signing key and password are never returned.
+type notifierSummary struct {
+	Name            string   `json:"name"`
+	Type            string   `json:"type"`
+	URL             string   `json:"url,omitempty"`
+	HasSigningKey   bool     `json:"has_signing_key"`
+	SMTPHost        string   `json:"smtp_host,omitempty"`
+	SMTPUser        string   `json:"smtp_user,omitempty"`
+	HasSMTPPassword bool     `json:"has_smtp_password"`
+	From            string   `json:"from,omitempty"`
+	To              []string `json:"to"`
+	Events          []string `json:"events"`
+	CustomIDs       []string `json:"custom_ids"`
+	Enabled         bool     `json:"enabled"`
+	UpdatedBy       string   `json:"updated_by,omitempty"`
+	UpdatedAt       int64    `json:"updated_at,omitempty"`
+}
+
+func newNotifierSummary(n kaginawa.Notifier) notifierSummary {
+	summary := notifierSummary{
+		Name:            n.Name,
+		Type:            n.Type,
+		URL:             n.URL,
+		HasSigningKey:   len(n.SigningKey) > 0,
+		SMTPHost:        n.SMTPHost,
+		SMTPUser:        n.SMTPUser,
+		HasSMTPPassword: len(n.SMTPPassword) > 0,
+		From:            n.From,
+		To:              n.To,
+		Events:          n.Events,
+		CustomIDs:       n.CustomIDs,
+		Enabled:         n.Enabled,
+		UpdatedBy:       n.UpdatedBy,
+		UpdatedAt:       n.UpdatedAt,
+	}
+	for _, list := range []*[]string{&summary.To, &summary.Events, &summary.CustomIDs} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+	return summary
+}
+
+// handleNewNotifier handles notifier registration and update requests.
+// Empty signing key and SMTP password keep current ones.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleNewNotifier(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	session := getSession(r)
+	if !session.isLoggedIn() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	n := kaginawa.Notifier{
+		Name:         strings.TrimSpace(r.FormValue("name")),
+		Type:         r.FormValue("type"),
+		URL:          strings.TrimSpace(r.FormValue("url")),
+		SigningKey:   strings.TrimSpace(r.FormValue("signing-key")),
+		SMTPHost:     strings.TrimSpace(r.FormValue("smtp-host")),
+		SMTPUser:     strings.TrimSpace(r.FormValue("smtp-user")),
+		SMTPPassword: r.FormValue("smtp-password"),
+		From:         strings.TrimSpace(r.FormValue("from")),
+		To:           splitCommaList(r.FormValue("to")),
+		Events:       r.Form["event"],
+		CustomIDs:    splitCommaList(r.FormValue("custom-ids")),
+		Enabled:      r.FormValue("enabled") == "yes",
+		UpdatedBy:    session.email(),
+	}
+	if _, err := putNotifier(n); err != nil {
+		writeFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleDeleteNotifier handles notifier deletion requests.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleDeleteNotifier(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !getSession(r).isLoggedIn() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	name := r.FormValue("name")
+	if len(name) == 0 {
+		http.Error(w, "Name is empty", http.StatusBadRequest)
+		return
+	}
+	if err := db.DeleteNotifier(name); err != nil {
+		log.Printf("failed to delete notifier: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	notifierCache.invalidate()
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleTestNotifier handles sending a test event to the notifier. Disabled notifiers are also tested.
+//
+// - Method: POST
+// - Client: Browser, API
+// - Access: Admin
+// - Response: 303 redirect (browser) or 204 no content (API), 502 bad gateway if the delivery failed
+func handleTestNotifier(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	apiKey := validateAPIKey(r, kaginawa.ScopeNotifiersWrite)
+	if apiKey == nil && !getSession(r).isLoggedIn() {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	config, err := db.GetNotifier(mux.Vars(r)["name"])
+	if err != nil {
+		log.Printf("failed to get notifier: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if config == nil || !allowsCustomIDs(apiKey, config.CustomIDs) {
+		http.NotFound(w, r)
+		return
+	}
+	n, err := newNotifier(*config)
+	if err != nil {
+		writeFormError(w, err)
+		return
+	}
+	now := time.Now().UTC().Unix()
+	if err := n.notify(kaginawa.NodeEvent{Type: kaginawa.EventTest, LastSeen: now, Timestamp: now}); err != nil {
+		log.Printf("failed to test notifier %s: %v", config.Name, err)
+		http.Error(w, "Failed to notify: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	if apiKey == nil {
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleNotifiers handles list of notifiers requests.
+//
+// - Method: GET, HEAD
+// - Client: API
+// - Access: Admin
+// - Response: JSON
+func handleNotifiers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	apiKey := validateAPIKey(r, kaginawa.ScopeNotifiersRead)
+	if apiKey == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	configs, err := db.ListNotifiers()
+	if err != nil {
+		log.Printf("failed to list notifiers: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	summaries := make([]notifierSummary, 0, len(configs))
+	for _, config := range configs {
+		if allowsCustomIDs(apiKey, config.CustomIDs) {
+			summaries = append(summaries, newNotifierSummary(config))
+		}
+	}
+	writeJSON(w, summaries)
+}
+
+// handleNotifier handles single notifier requests. Empty signing key and SMTP password of PUT keep current ones.
+// Keys restricted to custom IDs can manage notifiers of events of the allowed custom IDs only.
+//
+// - Method: GET, HEAD, PUT or DELETE
+// - Client: API
+// - Access: Admin
+// - Response: JSON (GET, HEAD or PUT) or 204 no content (DELETE)
+func handleNotifier(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if len(name) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	scope := kaginawa.ScopeNotifiersWrite
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		scope = kaginawa.ScopeNotifiersRead
+	case http.MethodPut, http.MethodDelete:
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	apiKey := validateAPIKey(r, scope)
+	if apiKey == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	current, err := db.GetNotifier(name)
+	if err != nil {
+		log.Printf("failed to get notifier: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if current != nil && !allowsCustomIDs(apiKey, current.CustomIDs) {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var n kaginawa.Notifier
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if !allowsCustomIDs(apiKey, n.CustomIDs) {
+			http.Error(w, "Custom IDs must be allowed for the API key", http.StatusForbidden)
+			return
+		}
+		n.Name = name
+		n.UpdatedBy = apiKey.Label
+		stored, err := putNotifier(n)
+		if err != nil {
+			writeFormError(w, err)
+			return
+		}
+		writeJSON(w, newNotifierSummary(stored))
+		return
+	case http.MethodDelete:
+		if err := db.DeleteNotifier(name); err != nil {
+			log.Printf("failed to delete notifier: %v", err)
+			http.Error(w, "Database unavailable", http.StatusInternalServerError)
+			return
+		}
+		notifierCache.invalidate()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if current == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, newNotifierSummary(*current))
+}
+
+// putNotifier validates and puts the notifier. Empty signing key and SMTP password fall back to the current entry.
+// Returns the stored notifier.
+func putNotifier(n kaginawa.Notifier) (kaginawa.Notifier, error) {
+	if len(n.Name) == 0 {
+		return n, errors.New("name is empty")
+	}
+	switch n.Type {
+	case kaginawa.NotifierWebhook, kaginawa.NotifierSlack:
+		u, err := url.Parse(n.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return n, errors.New("invalid url")
+		}
+	case kaginawa.NotifierEmail:
+		if _, _, err := net.SplitHostPort(n.SMTPHost); err != nil {
+			return n, errors.New("invalid smtp host, expected host:port")
+		}
+		if len(n.From) == 0 || len(n.To) == 0 {
+			return n, errors.New("from and to are required")
+		}
+		for _, address := range append([]string{n.From}, n.To...) {
+			if strings.ContainsAny(address, "\r\n") {
+				return n, fmt.Errorf("invalid address: %s", address)
+			}
+		}
+	default:
+		return n, fmt.Errorf("unknown type: %s", n.Type)
+	}
+	for _, event := range n.Events {
+		if !validEventType(event) {
+			return n, fmt.Errorf("unknown event: %s", event)
+		}
+	}
+	if len(n.SigningKey) == 0 || len(n.SMTPPassword) == 0 {
+		current, err := db.GetNotifier(n.Name)
+		if err != nil {
+			return n, fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+		}
+		if current != nil {
+			if len(n.SigningKey) == 0 {
+				n.SigningKey = current.SigningKey
+			}
+			if len(n.SMTPPassword) == 0 {
+				n.SMTPPassword = current.SMTPPassword
+			}
+		}
+	}
+	n.UpdatedAt = time.Now().UTC().Unix()
+	if err := db.PutNotifier(n); err != nil {
+		return n, fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+	}
+	notifierCache.invalidate()
+	return n, nil
+}
+
+func validEventType(eventType string) bool {
+	for _, t := range kaginawa.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
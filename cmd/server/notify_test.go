@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+// testHookServer records requests posted to it.
+type testHookServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	status   int
+}
+
+func newTestHookServer(t *testing.T) *testHookServer {
+	t.Helper()
+	s := &testHookServer{status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		w.WriteHeader(s.status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testHookServer) received() ([]*http.Request, [][]byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests, s.bodies
+}
+
+// newTestSMTPServer starts a minimal SMTP server that accepts any messages and sends them to the channel.
+func newTestSMTPServer(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { safeClose(listener, "test smtp listener") })
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSMTP(conn, messages)
+		}
+	}()
+	return listener.Addr().String(), messages
+}
+
+func serveTestSMTP(conn net.Conn, messages chan<- string) {
+	defer safeClose(conn, "test smtp connection")
+	reader := bufio.NewReader(conn)
+	reply := func(s string) { _, _ = io.WriteString(conn, s+"\r\n") }
+	reply("220 localhost ESMTP test")
+	var data strings.Builder
+	inData := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if inData {
+			if line == ".\r\n" {
+				inData = false
+				messages <- data.String()
+				reply("250 OK")
+				continue
+			}
+			data.WriteString(line)
+			continue
+		}
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+			reply("250 OK")
+		case cmd == "DATA":
+			inData = true
+			reply("354 End data with <CR><LF>.<CR><LF>")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Not implemented")
+		}
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	hook := newTestHookServer(t)
+	event := kaginawa.NodeEvent{Type: kaginawa.EventOffline, NodeID: "node1", CustomID: "site1", Interval: 3}
+	if err := (webhookNotifier{url: hook.URL, signingKey: "secret"}).notify(event); err != nil {
+		t.Fatal(err)
+	}
+	requests, bodies := hook.received()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(requests))
+	}
+	if requests[0].Header.Get(eventHeader) != kaginawa.EventOffline {
+		t.Errorf("unexpected event header: %s", requests[0].Header.Get(eventHeader))
+	}
+	if signature := requests[0].Header.Get(signatureHeader); signature != signPayload("secret", bodies[0]) {
+		t.Errorf("unexpected signature: %s", signature)
+	}
+	var received kaginawa.NodeEvent
+	if err := json.Unmarshal(bodies[0], &received); err != nil {
+		t.Fatal(err)
+	}
+	if received.NodeID != "node1" || received.CustomID != "site1" {
+		t.Errorf("unexpected payload: %s", bodies[0])
+	}
+
+	hook.status = http.StatusInternalServerError
+	if err := (webhookNotifier{url: hook.URL}).notify(event); err == nil {
+		t.Error("expected error for status 500")
+	}
+	if requests, _ := hook.received(); requests[1].Header.Get(signatureHeader) != "" {
+		t.Error("expected unsigned payload without signing key")
+	}
+}
+
+func TestSlackNotifier(t *testing.T) {
+	hook := newTestHookServer(t)
+	event := kaginawa.NodeEvent{Type: kaginawa.EventReportError, NodeID: "node1", Errors: []string{"disk full"}}
+	if err := (slackNotifier{url: hook.URL}).notify(event); err != nil {
+		t.Fatal(err)
+	}
+	_, bodies := hook.received()
+	var payload struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(bodies[0], &payload); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(payload.Text, "[kaginawa] ") || !strings.Contains(payload.Text, "disk full") {
+		t.Errorf("unexpected text: %s", payload.Text)
+	}
+}
+
+func TestEmailNotifier(t *testing.T) {
+	addr, messages := newTestSMTPServer(t)
+	n := emailNotifier{host: addr, from: "kaginawa@example.com", to: []string{"ops@example.com", "dev@example.com"}}
+	event := kaginawa.NodeEvent{Type: kaginawa.EventNewNode, NodeID: "node1", CustomID: "site1\r\nBcc: evil@example.com"}
+	if err := n.notify(event); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case message := <-messages:
+		if !strings.Contains(message, "To: ops@example.com, dev@example.com\r\n") {
+			t.Errorf("expected recipients, got %q", message)
+		}
+		if header := strings.SplitN(message, "\r\n\r\n", 2)[0]; strings.Contains(header, "\r\nBcc:") {
+			t.Errorf("expected header injection prevented, got %q", message)
+		}
+		if !strings.Contains(message, "new node") {
+			t.Errorf("expected event message, got %q", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+func TestDispatchEvents(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	hook := newTestHookServer(t)
+	for _, n := range []kaginawa.Notifier{
+		{Name: "all", Type: kaginawa.NotifierWebhook, URL: hook.URL + "/all", Enabled: true},
+		{Name: "offline", Type: kaginawa.NotifierWebhook, URL: hook.URL + "/offline", Enabled: true,
+			Events: []string{kaginawa.EventOffline}},
+		{Name: "site2", Type: kaginawa.NotifierWebhook, URL: hook.URL + "/site2", Enabled: true,
+			CustomIDs: []string{"site2"}},
+		{Name: "disabled", Type: kaginawa.NotifierWebhook, URL: hook.URL + "/disabled"},
+		{Name: "broken", Type: kaginawa.NotifierWebhook, URL: "http://127.0.0.1:1", Enabled: true},
+	} {
+		if err := db.PutNotifier(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	invalidateListCaches()
+	defer invalidateListCaches()
+	dispatchEvents([]kaginawa.NodeEvent{
+		{Type: kaginawa.EventOffline, NodeID: "node1", CustomID: "site1"},
+		{Type: kaginawa.EventNewNode, NodeID: "node2", CustomID: "site2"},
+	})
+	var paths []string
+	requests, _ := hook.received()
+	for _, r := range requests {
+		paths = append(paths, r.URL.Path+":"+r.Header.Get(eventHeader))
+	}
+	expected := "/all:offline,/offline:offline,/all:new_node,/site2:new_node"
+	if strings.Join(paths, ",") != expected {
+		t.Errorf("expected %s, got %s", expected, strings.Join(paths, ","))
+	}
+}
+
+func TestHandleNotifier(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:    kaginawa.HashAPIKey(testAPIKey),
+		Label:  "notifier key",
+		Scopes: []string{kaginawa.ScopeNotifiersRead, kaginawa.ScopeNotifiersWrite},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	hook := newTestHookServer(t)
+	request := func(method, path, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://localhost:8080"+path, strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"name": "ops"})
+		req.Header.Set("Authorization", "token "+testAPIKey)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	for _, body := range []string{
+		`{"type":"unknown","url":"` + hook.URL + `"}`,
+		`{"type":"webhook","url":"ftp://example.com"}`,
+		`{"type":"email","smtp_host":"localhost","from":"a@example.com","to":["b@example.com"]}`,
+		`{"type":"email","smtp_host":"localhost:25","from":"a@example.com"}`,
+		`{"type":"slack","url":"` + hook.URL + `","events":["unknown"]}`,
+	} {
+		if w := request(http.MethodPut, "/notifiers/ops", body, handleNotifier); w.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, body, w.Code)
+		}
+	}
+	body := `{"type":"webhook","url":"` + hook.URL + `","signing_key":"secret","events":["offline"],"enabled":true}`
+	if w := request(http.MethodPut, "/notifiers/ops", body, handleNotifier); w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	// Empty signing key keeps the current one
+	body = `{"type":"webhook","url":"` + hook.URL + `","events":["offline","recovered"],"enabled":true}`
+	w := request(http.MethodPut, "/notifiers/ops", body, handleNotifier)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("expected signing key hidden, got %s", w.Body.String())
+	}
+	var summary notifierSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if !summary.HasSigningKey || summary.UpdatedBy != "notifier key" || len(summary.Events) != 2 {
+		t.Errorf("unexpected notifier: %+v", summary)
+	}
+
+	if w := request(http.MethodPost, "/notifiers/ops/test", "", handleTestNotifier); w.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	requests, bodies := hook.received()
+	if len(requests) != 1 || requests[0].Header.Get(eventHeader) != kaginawa.EventTest ||
+		requests[0].Header.Get(signatureHeader) != signPayload("secret", bodies[0]) {
+		t.Errorf("expected signed test event, got %d requests", len(requests))
+	}
+	hook.status = http.StatusNotFound
+	if w := request(http.MethodPost, "/notifiers/ops/test", "", handleTestNotifier); w.Code != http.StatusBadGateway {
+		t.Errorf("expected status %d, got %d", http.StatusBadGateway, w.Code)
+	}
+
+	if w := request(http.MethodGet, "/notifiers", "", handleNotifiers); !strings.Contains(w.Body.String(), `"name":"ops"`) {
+		t.Errorf("expected list of notifiers, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request(http.MethodDelete, "/notifiers/ops", "", handleNotifier); w.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if w := request(http.MethodGet, "/notifiers/ops", "", handleNotifier); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestHandleNotifier_restrictedKey(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:       kaginawa.HashAPIKey(testAPIKey),
+		Scopes:    []string{kaginawa.ScopeNotifiersRead, kaginawa.ScopeNotifiersWrite},
+		CustomIDs: []string{"site1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutNotifier(kaginawa.Notifier{Name: "all", Type: kaginawa.NotifierWebhook, URL: "http://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	request := func(method, name, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://localhost:8080/notifiers/"+name, strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"name": name})
+		req.Header.Set("Authorization", "token "+testAPIKey)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	if w := request(http.MethodPut, "tenant", `{"type":"webhook","url":"http://example.com"}`, handleNotifier); w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d without custom IDs, got %d", http.StatusForbidden, w.Code)
+	}
+	body := `{"type":"webhook","url":"http://example.com","custom_ids":["site1"]}`
+	if w := request(http.MethodPut, "tenant", body, handleNotifier); w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		if w := request(method, "all", body, handleNotifier); w.Code != http.StatusNotFound {
+			t.Errorf("expected status %d for %s of notifier of all nodes, got %d", http.StatusNotFound, method, w.Code)
+		}
+	}
+	if w := request(http.MethodPost, "all", "", handleTestNotifier); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for test of notifier of all nodes, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := request(http.MethodGet, "", "", handleNotifiers); strings.Contains(w.Body.String(), `"name":"all"`) {
+		t.Errorf("expected notifiers of allowed custom IDs only, got %s", w.Body.String())
+	}
+}
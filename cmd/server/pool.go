@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultRelayKeepAlive   = 30 * time.Second
+	defaultRelayIdleTimeout = 5 * time.Minute
+)
+
+var relayConns = newRelayPool(defaultRelayKeepAlive, defaultRelayIdleTimeout)
+
+// relayPool keeps an authenticated ssh connection per ssh server, shared by command executions.
+// Connections are checked by keepalive requests, and closed after idle timeout. Broken connections are redialed
+// on the next acquisition. Connections removed from the pool are closed after released by all users.
+type relayPool struct {
+	mutex       sync.Mutex
+	conns       map[string]*relayConn
+	keepAlive   time.Duration
+	idleTimeout time.Duration // zero disables pooling
+}
+
+// relayConn defines a pooled connection.
+type relayConn struct {
+	client   *ssh.Client
+	server   kaginawa.SSHServer // server entry used by the connection
+	refs     int
+	lastUsed time.Time
+	retired  bool // removed from the pool, closed when no longer referenced
+	closed   bool
+}
+
+func newRelayPool(keepAlive, idleTimeout time.Duration) *relayPool {
+	return &relayPool{conns: make(map[string]*relayConn), keepAlive: keepAlive, idleTimeout: idleTimeout}
+}
+
+// acquire returns a connection to the ssh server. Call the returned function to release the connection.
+func (p *relayPool) acquire(server kaginawa.SSHServer, config *ssh.ClientConfig) (*ssh.Client, func(), error) {
+	if p.idleTimeout <= 0 {
+		client, err := ssh.Dial("tcp", server.Addr(), config)
+		if err != nil {
+			return nil, nil, err
+		}
+		return client, func() { safeClose(client, "ssh server connection") }, nil
+	}
+	if client, release := p.reuse(server); client != nil {
+		return client, release, nil
+	}
+	// Dial without holding the lock, host key callbacks may refresh the server list.
+	client, err := ssh.Dial("tcp", server.Addr(), config)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.mutex.Lock()
+	if c, ok := p.conns[server.Host]; ok && !c.closed && sameRelay(c.server, server) {
+		release := p.refLocked(c)
+		p.mutex.Unlock()
+		safeClose(client, "duplicated ssh server connection") // dialed concurrently
+		return c.client, release, nil
+	} else if ok {
+		p.retireLocked(c)
+	}
+	c := &relayConn{client: client, server: server}
+	p.conns[server.Host] = c
+	release := p.refLocked(c)
+	p.mutex.Unlock()
+	go p.watch(c)
+	go p.keepAliveLoop(c)
+	return client, release, nil
+}
+
+// reuse returns the live connection of the ssh server. Returns nil if not available.
+func (p *relayPool) reuse(server kaginawa.SSHServer) (*ssh.Client, func()) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	c, ok := p.conns[server.Host]
+	if !ok {
+		return nil, nil
+	}
+	if c.closed || !sameRelay(c.server, server) {
+		p.retireLocked(c)
+		return nil, nil
+	}
+	return c.client, p.refLocked(c)
+}
+
+// refLocked references the connection. The returned function releases the reference only once.
+func (p *relayPool) refLocked(c *relayConn) func() {
+	c.refs++
+	c.lastUsed = time.Now()
+	var once sync.Once
+	return func() { once.Do(func() { p.release(c) }) }
+}
+
+func (p *relayPool) release(c *relayConn) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	c.refs--
+	c.lastUsed = time.Now()
+	if c.retired && c.refs == 0 {
+		p.closeLocked(c)
+	}
+}
+
+// discard removes the connection from the pool, e.g. when a channel could not be opened.
+// Other command executions sharing the connection keep using it until released.
+func (p *relayPool) discard(client *ssh.Client) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, c := range p.conns {
+		if c.client == client {
+			p.retireLocked(c)
+		}
+	}
+}
+
+// retain removes connections of removed or modified ssh servers.
+func (p *relayPool) retain(servers []kaginawa.SSHServer) {
+	entries := make(map[string]kaginawa.SSHServer, len(servers))
+	for _, server := range servers {
+		entries[server.Host] = server
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for host, c := range p.conns {
+		server, ok := entries[host]
+		if !ok || !sameRelay(c.server, server) || len(server.HostKey) == 0 {
+			p.retireLocked(c)
+		}
+	}
+}
+
+// retireLocked removes the connection from the pool, and closes it unless referenced.
+func (p *relayPool) retireLocked(c *relayConn) {
+	if p.conns[c.server.Host] == c {
+		delete(p.conns, c.server.Host)
+	}
+	c.retired = true
+	if c.refs == 0 {
+		p.closeLocked(c)
+	}
+}
+
+func (p *relayPool) closeLocked(c *relayConn) {
+	if p.conns[c.server.Host] == c {
+		delete(p.conns, c.server.Host)
+	}
+	if !c.closed {
+		c.closed = true
+		safeClose(c.client, "pooled ssh server connection")
+	}
+}
+
+// watch removes the connection from the pool when closed by the peer.
+func (p *relayPool) watch(c *relayConn) {
+	_ = c.client.Wait()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.conns[c.server.Host] == c {
+		delete(p.conns, c.server.Host)
+	}
+	c.closed = true
+}
+
+// keepAliveLoop sends keepalive requests, and closes the connection if unresponsive or idle.
+func (p *relayPool) keepAliveLoop(c *relayConn) {
+	ticker := time.NewTicker(p.keepAlive)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.mutex.Lock()
+		if !c.closed && c.refs == 0 && time.Since(c.lastUsed) >= p.idleTimeout {
+			p.closeLocked(c)
+		}
+		closed := c.closed
+		p.mutex.Unlock()
+		if closed {
+			return
+		}
+		if err := sendKeepAlive(c.client, p.keepAlive); err != nil {
+			log.Printf("keepalive failed on ssh server %s: %v", c.server.Host, err)
+			p.discard(c.client)
+			return
+		}
+	}
+}
+
+// sendKeepAlive sends a keepalive request. The connection is closed if no response within the timeout.
+func sendKeepAlive(client *ssh.Client, timeout time.Duration) error {
+	timer := time.AfterFunc(timeout, func() { _ = client.Close() })
+	defer timer.Stop()
+	if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		return fmt.Errorf("no response: %w", err)
+	}
+	return nil
+}
+
+// sameRelay reports whether the connection settings of the ssh servers are identical.
+// Trusting a host key on first use does not change the settings.
+func sameRelay(a, b kaginawa.SSHServer) bool {
+	if len(a.HostKey) > 0 && a.HostKey != b.HostKey {
+		return false
+	}
+	return a.Host == b.Host && a.Port == b.Port && a.User == b.User && a.Key == b.Key && a.Password == b.Password
+}
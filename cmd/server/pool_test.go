@@ -0,0 +1,181 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestRelayServer(t *testing.T) (kaginawa.SSHServer, *ssh.ClientConfig) {
+	t.Helper()
+	relay := newTestSSHServer(t)
+	server := kaginawa.SSHServer{Host: "127.0.0.1", Port: relay.port(), User: testSSHUser, Password: testSSHPassword}
+	config, err := createSSHConfig(testSSHUser, "", testSSHPassword, ssh.InsecureIgnoreHostKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, config
+}
+
+func (p *relayPool) size() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.conns)
+}
+
+func TestRelayPool_reuse(t *testing.T) {
+	server, config := newTestRelayServer(t)
+	pool := newRelayPool(time.Minute, time.Minute)
+	c1, release1, err := pool.acquire(server, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, release2, err := pool.acquire(server, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 != c2 {
+		t.Error("expected shared connection")
+	}
+	release1()
+	release1() // released only once
+	release2()
+	if pool.conns[server.Host].refs != 0 {
+		t.Errorf("expected 0 refs, got %d", pool.conns[server.Host].refs)
+	}
+	pool.retain(nil)
+	if pool.size() != 0 {
+		t.Errorf("expected empty pool, got %d connections", pool.size())
+	}
+}
+
+func TestRelayPool_retain(t *testing.T) {
+	server, config := newTestRelayServer(t)
+	pool := newRelayPool(time.Minute, time.Minute)
+	c1, release, err := pool.acquire(server, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	server.HostKey = "ssh-ed25519 AAAA" // trust on first use
+	pool.retain([]kaginawa.SSHServer{server})
+	if pool.size() != 1 {
+		t.Fatalf("expected connection kept, got %d connections", pool.size())
+	}
+	server.Password = "changed"
+	pool.retain([]kaginawa.SSHServer{server})
+	if pool.size() != 0 {
+		t.Fatalf("expected connection closed, got %d connections", pool.size())
+	}
+	c2, release, err := pool.acquire(server, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if c1 == c2 {
+		t.Error("expected new connection")
+	}
+}
+
+func TestRelayPool_reconnect(t *testing.T) {
+	server, config := newTestRelayServer(t)
+	pool := newRelayPool(time.Minute, time.Minute)
+	c1, release, err := pool.acquire(server, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	safeClose(c1, "test connection")
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.size() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c2, release, err := pool.acquire(server, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if c1 == c2 {
+		t.Error("expected new connection")
+	}
+	if _, _, err := c2.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		t.Errorf("expected live connection: %v", err)
+	}
+}
+
+func TestRelayPool_discard(t *testing.T) {
+	server, config := newTestRelayServer(t)
+	pool := newRelayPool(time.Minute, time.Minute)
+	c1, release1, err := pool.acquire(server, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, release2, err := pool.acquire(server, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release1()
+	pool.discard(c1)
+	if pool.size() != 0 {
+		t.Fatalf("expected discarded connection removed, got %d connections", pool.size())
+	}
+	if _, _, err := shared.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		t.Fatalf("expected connection kept for the other holder: %v", err)
+	}
+	c3, release3, err := pool.acquire(server, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release3()
+	if c3 == shared {
+		t.Error("expected new connection")
+	}
+	release2()
+	if _, _, err := shared.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+		t.Error("expected discarded connection closed after released")
+	}
+}
+
+func TestRelayPool_idleTimeout(t *testing.T) {
+	server, config := newTestRelayServer(t)
+	pool := newRelayPool(20*time.Millisecond, 50*time.Millisecond)
+	_, release, err := pool.acquire(server, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if pool.size() != 1 {
+		t.Fatal("expected connection in use kept")
+	}
+	release()
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.size() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pool.size() != 0 {
+		t.Error("expected idle connection closed")
+	}
+}
+
+func TestRelayPool_disabled(t *testing.T) {
+	server, config := newTestRelayServer(t)
+	pool := newRelayPool(time.Minute, 0)
+	c1, release1, err := pool.acquire(server, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release1()
+	c2, release2, err := pool.acquire(server, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release2()
+	if c1 == c2 {
+		t.Error("expected dedicated connections")
+	}
+	if pool.size() != 0 {
+		t.Errorf("expected empty pool, got %d connections", pool.size())
+	}
+}
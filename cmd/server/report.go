@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"io"
 	"log"
-	"math/rand"
 	"net"
 	"net/http"
 	"strings"
@@ -14,6 +13,24 @@ import (
 	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
 )
 
+const relayLoadsTTL = 15 * time.Minute
+
+var (
+	relaySelector kaginawa.RelaySelector = kaginawa.ChainSelector{}
+	relayLoads                           = kaginawa.NewRelayLoads(relayLoadsTTL)
+)
+
+// usesLeastLoaded returns true if the selector counts loads of ssh servers.
+func usesLeastLoaded(selector kaginawa.RelaySelector) bool {
+	chain, _ := selector.(kaginawa.ChainSelector)
+	for _, s := range chain {
+		if _, ok := s.(kaginawa.LeastLoadedSelector); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // reply defines all reply message attributes
 type reply struct {
 	SSHServerHost string `json:"ssh_host,omitempty"`
@@ -34,7 +51,10 @@ func handleReport(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
 	}
-	if !validateAPIKey(r, false) {
+	begin := time.Now()
+	defer func() { serverMetrics.observeReportDuration(time.Since(begin)) }()
+	apiKey := validateAPIKey(r, kaginawa.ScopeReportWrite)
+	if apiKey == nil {
 		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
@@ -60,8 +80,12 @@ func handleReport(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Response unmarshal error", http.StatusBadRequest)
 		return
 	}
+	if !apiKey.AllowsCustomID(report.CustomID) {
+		http.Error(w, "Custom ID not allowed", http.StatusForbidden)
+		return
+	}
 	report.ServerTime = time.Now().UTC().Unix()
-	report.APIKey = extractAPIKey(r)
+	report.APIKey = apiKey.Key
 	log.Printf("REPORT from %s %s %d", report.ID, report.CustomID, report.SSHRemotePort)
 
 	// Pick global IP
@@ -79,21 +103,43 @@ func handleReport(w http.ResponseWriter, r *http.Request) {
 		report.GlobalHost = report.GlobalIP
 	}
 
+	previous, lookupErr := db.GetReportByID(report.ID)
+	if lookupErr != nil {
+		log.Printf("failed to get Report (id=%s): %v", report.ID, lookupErr)
+	}
+
 	if err := db.PutReport(report); err != nil {
 		log.Printf("failed to put Report (id=%s): %v", report.ID, err)
 		http.Error(w, "Failed to put database", http.StatusInternalServerError)
 		return
 	}
+	serverMetrics.observeReport()
+	reportStream.publish(report)
+
+	// Raise events and evaluate alert rules in background
+	var events []kaginawa.NodeEvent
+	if previous == nil && lookupErr == nil {
+		events = append(events, kaginawa.NewReportEvent(kaginawa.EventNewNode, report))
+	}
+	if errorsChanged(previous, report) {
+		events = append(events, kaginawa.NewReportEvent(kaginawa.EventReportError, report))
+	}
+	go func() {
+		dispatchEvents(append(events, evaluateAlerts(report)...))
+	}()
+
+	// Queue deliveries to report hooks
+	deliverReport(report)
 
 	var msg reply
-	if len(kaginawa.SSHServers) > 0 {
-		i := rand.Int() % len(kaginawa.SSHServers)
+	if server := relaySelector.Select(relayHealths.filter(kaginawa.SSHServers()), report); server != nil {
+		relayLoads.Assign(report.ID, server.Host)
 		msg = reply{
-			SSHServerHost: kaginawa.SSHServers[i].Host,
-			SSHServerPort: kaginawa.SSHServers[i].Port,
-			SSHServerUser: kaginawa.SSHServers[i].User,
-			SSHKey:        kaginawa.SSHServers[i].Key,
-			SSHPassword:   kaginawa.SSHServers[i].Password,
+			SSHServerHost: server.Host,
+			SSHServerPort: server.Port,
+			SSHServerUser: server.User,
+			SSHKey:        server.Key,
+			SSHPassword:   server.Password,
 		}
 	}
 	rawReply, err := json.Marshal(msg)
@@ -117,6 +163,29 @@ func handleReport(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// errorsChanged checks the report has errors and the set of them differs from the previous report.
+// Repeated reports with the same errors are not raised again.
+func errorsChanged(previous *kaginawa.Report, report kaginawa.Report) bool {
+	if len(report.Errors) == 0 {
+		return false
+	}
+	if previous == nil {
+		return true
+	}
+	known := make(map[string]struct{}, len(previous.Errors))
+	for _, e := range previous.Errors {
+		known[e] = struct{}{}
+	}
+	current := make(map[string]struct{}, len(report.Errors))
+	for _, e := range report.Errors {
+		if _, ok := known[e]; !ok {
+			return true
+		}
+		current[e] = struct{}{}
+	}
+	return len(current) != len(known)
+}
+
 func reverseLookup(globalIP string) (string, error) {
 	if globalIP == "[::1]" {
 		return "", nil
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+func TestErrorsChanged(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		previous *kaginawa.Report
+		errors   []string
+		expected bool
+	}{
+		{"no errors", &kaginawa.Report{}, nil, false},
+		{"new node", nil, []string{"disk full"}, true},
+		{"none to some", &kaginawa.Report{}, []string{"disk full"}, true},
+		{"same errors", &kaginawa.Report{Errors: []string{"a", "b"}}, []string{"b", "a"}, false},
+		{"added error", &kaginawa.Report{Errors: []string{"a"}}, []string{"a", "b"}, true},
+		{"removed error", &kaginawa.Report{Errors: []string{"a", "b"}}, []string{"a"}, true},
+		{"resolved", &kaginawa.Report{Errors: []string{"a"}}, nil, false},
+	} {
+		if actual := errorsChanged(tc.previous, kaginawa.Report{Errors: tc.errors}); actual != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, actual)
+		}
+	}
+}
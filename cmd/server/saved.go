@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+// savedParamPrefix defines prefix of form parameter names holding parameters of saved commands.
+const savedParamPrefix = "param-"
+
+// savedCommandSummary defines the API representation of a saved command.
+type savedCommandSummary struct {
+	kaginawa.SavedCommand
+	Params []string `json:"params"`
+}
+
+func newSavedCommandSummary(command kaginawa.SavedCommand) savedCommandSummary {
+	params := command.Params()
+	if params == nil {
+		params = []string{}
+	}
+	return savedCommandSummary{command, params}
+}
+
+// renderSavedCommand fills the command of the form by the saved command and "param-<name>" form values.
+func renderSavedCommand(r *http.Request, form commandForm, name string) (commandForm, error) {
+	saved, err := db.GetSavedCommand(name)
+	if err != nil {
+		return form, fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+	}
+	if saved == nil {
+		return form, fmt.Errorf("saved command not found: %s", name)
+	}
+	form.saved = saved
+	values := make(map[string]string)
+	for _, param := range saved.Params() {
+		if v, ok := r.Form[savedParamPrefix+param]; ok && len(v) > 0 {
+			values[param] = v[0]
+		}
+	}
+	form.command, err = saved.Render(values)
+	if err != nil {
+		return form, err
+	}
+	return form, nil
+}
+
+// handleNewSavedCommand handles saved command registration and update requests.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleNewSavedCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	session := getSession(r)
+	if !session.isLoggedIn() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	command := kaginawa.SavedCommand{
+		Name:        strings.TrimSpace(r.FormValue("name")),
+		Description: strings.TrimSpace(r.FormValue("description")),
+		Command:     strings.TrimSpace(r.FormValue("command")),
+		Scopes:      r.Form["scope"],
+		UpdatedBy:   session.email(),
+	}
+	if err := putSavedCommand(command); err != nil {
+		writeFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleDeleteSavedCommand handles saved command deletion requests.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleDeleteSavedCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !getSession(r).isLoggedIn() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	name := r.FormValue("name")
+	if len(name) == 0 {
+		http.Error(w, "Name is empty", http.StatusBadRequest)
+		return
+	}
+	if err := db.DeleteSavedCommand(name); err != nil {
+		log.Printf("failed to delete saved command: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleSavedCommands handles list of saved commands requests.
+//
+// - Method: GET, HEAD
+// - Client: API
+// - Access: Admin
+// - Response: JSON
+func handleSavedCommands(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if validateAPIKey(r, kaginawa.ScopeCommandsRead) == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	commands, err := db.ListSavedCommands()
+	if err != nil {
+		log.Printf("failed to list saved commands: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	summaries := make([]savedCommandSummary, 0, len(commands))
+	for _, command := range commands {
+		summaries = append(summaries, newSavedCommandSummary(command))
+	}
+	writeJSON(w, summaries)
+}
+
+// handleSavedCommand handles single saved command requests.
+//
+// - Method: GET, HEAD, PUT or DELETE
+// - Client: API
+// - Access: Admin
+// - Response: JSON (GET, HEAD or PUT) or 204 no content (DELETE)
+func handleSavedCommand(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if len(name) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	scope := kaginawa.ScopeCommandsWrite
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		scope = kaginawa.ScopeCommandsRead
+	case http.MethodPut, http.MethodDelete:
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	apiKey := validateAPIKey(r, scope)
+	if apiKey == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		// Saved commands are executable by the scopes listed on them, so saving one requires command:exec
+		if !apiKey.HasScope(kaginawa.ScopeCommandExec) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		var command kaginawa.SavedCommand
+		if err := json.NewDecoder(r.Body).Decode(&command); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		command.Name = name
+		command.UpdatedBy = apiKey.Label
+		if err := putSavedCommand(command); err != nil {
+			writeFormError(w, err)
+			return
+		}
+		saved, err := db.GetSavedCommand(name)
+		if err != nil || saved == nil {
+			log.Printf("failed to get saved command: %v", err)
+			http.Error(w, "Database unavailable", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, newSavedCommandSummary(*saved))
+		return
+	case http.MethodDelete:
+		if err := db.DeleteSavedCommand(name); err != nil {
+			log.Printf("failed to delete saved command: %v", err)
+			http.Error(w, "Database unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	command, err := db.GetSavedCommand(name)
+	if err != nil {
+		log.Printf("failed to get saved command: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if command == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, newSavedCommandSummary(*command))
+}
+
+// putSavedCommand validates and puts the saved command.
+func putSavedCommand(command kaginawa.SavedCommand) error {
+	if len(command.Name) == 0 {
+		return errors.New("name is empty")
+	}
+	if len(command.Command) == 0 {
+		return errors.New("command is empty")
+	}
+	if err := command.Validate(); err != nil {
+		return err
+	}
+	for _, scope := range command.Scopes {
+		if !validScope(scope) {
+			return fmt.Errorf("unknown scope: %s", scope)
+		}
+	}
+	command.UpdatedAt = time.Now().UTC().Unix()
+	if err := db.PutSavedCommand(command); err != nil {
+		return fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+const testLimitedAPIKey = "limited-key"
+
+func TestHandleCommand_saved(t *testing.T) {
+	setupTestRelay(t)
+	sessionStore = sessions.NewCookieStore([]byte("test"))
+	if err := db.PutSavedCommand(kaginawa.SavedCommand{
+		Name:    "greet",
+		Command: "echo {{msg}}",
+		Scopes:  []string{kaginawa.ScopeNodesRead},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutSavedCommand(kaginawa.SavedCommand{Name: "private", Command: "echo private"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:    kaginawa.HashAPIKey(testLimitedAPIKey),
+		Label:  "limited key",
+		Scopes: []string{kaginawa.ScopeNodesRead},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	credentials := url.Values{"user": {testSSHUser}, "password": {testSSHPassword}}
+	tests := []struct {
+		key    string
+		form   url.Values
+		status int
+		body   string
+	}{
+		{testAPIKey, url.Values{"saved": {"greet"}, "param-msg": {"hi; exit 1"}}, http.StatusOK, "'hi; exit 1'\n"},
+		{testAPIKey, url.Values{"saved": {"greet"}}, http.StatusBadRequest, "parameter required: msg\n"},
+		{testAPIKey, url.Values{"saved": {"unknown"}}, http.StatusBadRequest, "saved command not found: unknown\n"},
+		{testLimitedAPIKey, url.Values{"saved": {"greet"}, "param-msg": {"hello"}}, http.StatusOK, "'hello'\n"},
+		{testLimitedAPIKey, url.Values{"saved": {"private"}}, http.StatusForbidden, ""},
+		{testLimitedAPIKey, url.Values{"command": {"echo hello"}}, http.StatusForbidden, ""},
+		{"unknown", url.Values{"saved": {"greet"}, "param-msg": {"hello"}}, http.StatusForbidden, ""},
+	}
+	for _, test := range tests {
+		for k, v := range credentials {
+			test.form[k] = v
+		}
+		req := newTestCommandRequest("/nodes/node1/command", test.form)
+		req.Header.Set("Authorization", "token "+test.key)
+		w := httptest.NewRecorder()
+		handleCommand(w, req)
+		if w.Code != test.status {
+			t.Errorf("expected status %d for %v, got %d: %s", test.status, test.form, w.Code, w.Body.String())
+			continue
+		}
+		if len(test.body) > 0 && w.Body.String() != test.body {
+			t.Errorf("expected %q for %v, got %q", test.body, test.form, w.Body.String())
+		}
+	}
+	logs, err := db.ListCommandLogs("node1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) == 0 || logs[0].Saved != "greet" || logs[0].Actor != "limited key" {
+		t.Errorf("expected saved command recorded, got %+v", logs)
+	}
+}
+
+func TestHandleSavedCommand(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:    kaginawa.HashAPIKey(testAPIKey),
+		Label:  "library key",
+		Scopes: []string{kaginawa.ScopeCommandsRead, kaginawa.ScopeCommandsWrite, kaginawa.ScopeCommandExec},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:    kaginawa.HashAPIKey(testLimitedAPIKey),
+		Label:  "writer key",
+		Scopes: []string{kaginawa.ScopeCommandsRead, kaginawa.ScopeCommandsWrite},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	requestBy := func(key, method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://localhost:8080/saved-commands/status", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"name": "status"})
+		req.Header.Set("Authorization", "token "+key)
+		w := httptest.NewRecorder()
+		handleSavedCommand(w, req)
+		return w
+	}
+	request := func(method, body string) *httptest.ResponseRecorder {
+		return requestBy(testAPIKey, method, body)
+	}
+
+	if w := requestBy(testLimitedAPIKey, http.MethodPut, `{"command":"id","scopes":["commands:write"]}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d without command:exec, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	if w := request(http.MethodPut, `{"command":"systemctl status {{service}}","scopes":["unknown"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for unknown scope, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := request(http.MethodPut, `{"command":"echo \"{{msg}}\""}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for quoted placeholder, got %d", http.StatusBadRequest, w.Code)
+	}
+	w := request(http.MethodPut, `{"command":"systemctl status {{service}}","scopes":["nodes:read"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var summary savedCommandSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Name != "status" || summary.UpdatedBy != "library key" || summary.UpdatedAt == 0 ||
+		len(summary.Params) != 1 || summary.Params[0] != "service" {
+		t.Errorf("unexpected saved command: %+v", summary)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/saved-commands", nil)
+	req.Header.Set("Authorization", "token "+testAPIKey)
+	list := httptest.NewRecorder()
+	handleSavedCommands(list, req)
+	if list.Code != http.StatusOK || !strings.Contains(list.Body.String(), `"name":"status"`) {
+		t.Errorf("expected list of saved commands, got %d: %s", list.Code, list.Body.String())
+	}
+
+	if w := request(http.MethodDelete, ""); w.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if w := request(http.MethodGet, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
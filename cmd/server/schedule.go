@@ -0,0 +1,698 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+const (
+	scheduleRunsLimit        = 20
+	defaultScheduleRunsLimit = 100
+	maxScheduleOutputBytes   = 4096
+)
+
+// scheduleSelectorKeys defines accepted keys of node selectors of schedules.
+var scheduleSelectorKeys = map[string]bool{
+	"custom-id":   true,
+	"hostname":    true,
+	"global-addr": true,
+	"local-addr":  true,
+	"version":     true,
+	"minutes":     true,
+}
+
+// scheduleSummary defines the API representation of a schedule.
+type scheduleSummary struct {
+	kaginawa.Schedule
+	NextRunAt int64 `json:"next_run_at,omitempty"` // Next run time (UTC), omitted if disabled
+}
+
+func newScheduleSummary(schedule kaginawa.Schedule) scheduleSummary {
+	summary := scheduleSummary{Schedule: schedule}
+	if spec, err := kaginawa.ParseCron(schedule.Spec); err == nil && schedule.Enabled {
+		if next := spec.Next(time.Now().UTC()); !next.IsZero() {
+			summary.NextRunAt = next.Unix()
+		}
+	}
+	return summary
+}
+
+// startScheduler runs due schedules at the beginning of every minute.
+func startScheduler() {
+	go func() {
+		for {
+			now := time.Now().UTC()
+			time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+			runDueSchedules(time.Now().UTC().Truncate(time.Minute))
+		}
+	}()
+}
+
+// runDueSchedules starts enabled schedules matching the minute. Schedules already started at the minute are skipped.
+func runDueSchedules(minute time.Time) {
+	schedules, err := db.ListSchedules()
+	if err != nil {
+		log.Printf("failed to list schedules: %v", err)
+		return
+	}
+	for _, schedule := range schedules {
+		if !schedule.Enabled || schedule.LastRunAt >= minute.Unix() {
+			continue
+		}
+		spec, err := kaginawa.ParseCron(schedule.Spec)
+		if err != nil {
+			log.Printf("skipping schedule %s: %v", schedule.Name, err)
+			continue
+		}
+		if !spec.Match(minute) {
+			continue
+		}
+		claimed, err := claimSchedule(schedule, minute)
+		if err != nil {
+			log.Printf("failed to claim schedule %s: %v", schedule.Name, err)
+			continue
+		}
+		if claimed == nil || !claimed.Enabled {
+			continue // started by other instances, deleted or disabled
+		}
+		if _, err := startSchedule(*claimed); err != nil {
+			log.Printf("failed to start schedule %s: %v", schedule.Name, err)
+		}
+	}
+}
+
+// claimSchedule updates the last run time of the schedule, unless other instances have already done it.
+// Returns the current schedule, or nil if not claimed.
+func claimSchedule(schedule kaginawa.Schedule, now time.Time) (*kaginawa.Schedule, error) {
+	claimed, err := db.ClaimScheduleRun(schedule.Name, schedule.LastRunAt, now.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+	}
+	if !claimed {
+		return nil, nil
+	}
+	current, err := db.GetSchedule(schedule.Name) // may be edited after listed
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+	}
+	return current, nil
+}
+
+// startSchedule starts a command job over the nodes selected by the schedule claimed by claimSchedule.
+// The result is stored as a run of the schedule after the job finished.
+func startSchedule(schedule kaginawa.Schedule) (*commandJob, error) {
+	values, err := url.ParseQuery(schedule.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector: %w", err)
+	}
+	selector, err := parseNodeSelector(values)
+	if err != nil {
+		return nil, err
+	}
+	reports, err := selector.selectReports(kaginawa.ListViewAttributes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ID < reports[j].ID })
+	form := commandForm{
+		profile: schedule.Profile,
+		command: schedule.Command,
+		timeout: time.Duration(defaultTimeoutSec) * time.Second,
+	}
+	if schedule.Timeout > 0 {
+		form.timeout = time.Duration(schedule.Timeout) * time.Second
+	}
+	concurrency := defaultJobConcurrency
+	if schedule.Concurrency > 0 {
+		concurrency = schedule.Concurrency
+	}
+	job, err := newCommandJob(reports, form, concurrency)
+	if err != nil {
+		return nil, err
+	}
+	commandJobs.add(job)
+	snapshot := commandJobs.get(job.ID)
+	log.Printf("SCHEDULE %s started job %s on %d nodes: %s", schedule.Name, job.ID, job.Total, job.Command)
+	go func() {
+		runCommandJob(job.ID, reports, form, concurrency, "schedule "+schedule.Name, commandSourceSchedule)
+		if finished := commandJobs.get(job.ID); finished != nil {
+			if err := db.PutScheduleRun(newScheduleRun(schedule.Name, finished)); err != nil {
+				log.Printf("failed to put run of schedule %s: %v", schedule.Name, err)
+			}
+		}
+	}()
+	return snapshot, nil
+}
+
+// newScheduleRun converts the finished command job to a run of the schedule. Outputs are truncated.
+func newScheduleRun(name string, job *commandJob) kaginawa.ScheduleRun {
+	run := kaginawa.ScheduleRun{
+		ID:         job.ID,
+		Schedule:   name,
+		Command:    job.Command,
+		Total:      job.Total,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+		Results:    make([]kaginawa.ScheduleResult, len(job.Results)),
+	}
+	for i, r := range job.Results {
+		result := kaginawa.ScheduleResult{
+			NodeID:   r.ID,
+			CustomID: r.CustomID,
+			Hostname: r.Hostname,
+			ExitCode: -1,
+			Error:    r.Error,
+		}
+		if r.Result != nil {
+			result.ExitCode = r.Result.ExitCode
+			result.Output = truncateOutput(r.Result.Stdout+r.Result.Stderr, maxScheduleOutputBytes)
+			result.Error = r.Result.Error
+			result.Duration = r.Result.DurationMS
+			result.TimedOut = r.Result.TimedOut
+		}
+		if r.Status == jobStatusDone && result.ExitCode == 0 && !result.TimedOut {
+			run.Succeeded++
+		} else {
+			run.Failed++
+		}
+		run.Results[i] = result
+	}
+	return run
+}
+
+// scheduleVisibleTo reports whether the api key is able to read the schedule. Keys restricted to custom IDs can read
+// schedules selecting nodes by an allowed custom ID only. Nil key means a logged-in browser session.
+func scheduleVisibleTo(schedule kaginawa.Schedule, apiKey *kaginawa.APIKey) bool {
+	if apiKey == nil || len(apiKey.CustomIDs) == 0 {
+		return true
+	}
+	values, err := url.ParseQuery(schedule.Selector)
+	if err != nil {
+		return false
+	}
+	selector, err := parseNodeSelector(values)
+	if err != nil || len(selector.hostname+selector.globalAddr+selector.localAddr+selector.version) > 0 {
+		return false // other attributes may select nodes of any custom IDs
+	}
+	return len(selector.customID) > 0 && apiKey.AllowsCustomID(selector.customID)
+}
+
+// visibleScheduleRun returns a copy of the run with results and counts of the nodes allowed for the api key.
+// Returns nil if no nodes of the run are allowed.
+func visibleScheduleRun(run kaginawa.ScheduleRun, apiKey *kaginawa.APIKey) *kaginawa.ScheduleRun {
+	if apiKey == nil || len(apiKey.CustomIDs) == 0 {
+		return &run
+	}
+	visible := run
+	visible.Results = []kaginawa.ScheduleResult{}
+	visible.Succeeded = 0
+	visible.Failed = 0
+	for _, result := range run.Results {
+		if !allowsNode(apiKey, &kaginawa.Report{ID: result.NodeID, CustomID: result.CustomID}) {
+			continue
+		}
+		visible.Results = append(visible.Results, result)
+		if result.ExitCode == 0 && !result.TimedOut {
+			visible.Succeeded++
+		} else {
+			visible.Failed++
+		}
+	}
+	if len(visible.Results) == 0 && len(run.Results) > 0 {
+		return nil
+	}
+	visible.Total = len(visible.Results)
+	return &visible
+}
+
+func truncateOutput(output string, max int) string {
+	if len(output) <= max {
+		return output
+	}
+	return output[:max] + "\n[truncated]"
+}
+
+// handleSchedules handles list of schedules requests.
+// API clients can request a list of schedules by "Accept: application/json" header.
+//
+// - Method: GET or HEAD
+// - Client: Browser or API
+// - Access: Admin
+// - Response: HTML or JSON
+func handleSchedules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	api := r.Header.Get("Accept") == contentTypeJSON
+	var apiKey *kaginawa.APIKey
+	if api {
+		if apiKey = validateAPIKey(r, kaginawa.ScopeSchedulesRead); apiKey == nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	} else if !getSession(r).isLoggedIn() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	schedules, err := db.ListSchedules()
+	if err != nil {
+		log.Printf("failed to list schedules: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	summaries := make([]scheduleSummary, 0, len(schedules))
+	for _, schedule := range schedules {
+		if scheduleVisibleTo(schedule, apiKey) {
+			summaries = append(summaries, newScheduleSummary(schedule))
+		}
+	}
+	if api {
+		writeJSON(w, summaries)
+		return
+	}
+	profiles, err := db.ListCredentialProfiles()
+	if err != nil {
+		log.Printf("failed to list credential profiles: %v", err)
+	}
+	execTemplate(w, "schedules", struct {
+		Meta      meta
+		Schedules []scheduleSummary
+		Profiles  []kaginawa.CredentialProfile
+	}{
+		newMeta(r, "Schedules"),
+		summaries,
+		profiles,
+	})
+}
+
+// handleSchedule handles single schedule requests. Browsers get the schedule with history of runs.
+//
+// - Method: GET, HEAD, PUT or DELETE
+// - Client: Browser (GET or HEAD) or API
+// - Access: Admin
+// - Response: HTML (browser), JSON (GET, HEAD or PUT) or 204 no content (DELETE)
+func handleSchedule(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if len(name) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	scope := kaginawa.ScopeSchedulesWrite
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if r.Header.Get("Accept") != contentTypeJSON {
+			handleScheduleWeb(w, r, name)
+			return
+		}
+		scope = kaginawa.ScopeSchedulesRead
+	case http.MethodPut, http.MethodDelete:
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	apiKey := validateAPIKey(r, scope)
+	if apiKey == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		if len(apiKey.CustomIDs) > 0 {
+			http.Error(w, "API keys restricted to custom IDs cannot manage schedules", http.StatusForbidden)
+			return
+		}
+		if !apiKey.HasScope(kaginawa.ScopeCommandExec) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		var schedule kaginawa.Schedule
+		if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		schedule.Name = name
+		schedule.UpdatedBy = commandActor(apiKey, r)
+		stored, err := putSchedule(schedule)
+		if err != nil {
+			writeFormError(w, err)
+			return
+		}
+		writeJSON(w, newScheduleSummary(stored))
+		return
+	case http.MethodDelete:
+		if len(apiKey.CustomIDs) > 0 {
+			http.Error(w, "API keys restricted to custom IDs cannot manage schedules", http.StatusForbidden)
+			return
+		}
+		if err := db.DeleteSchedule(name); err != nil {
+			log.Printf("failed to delete schedule: %v", err)
+			http.Error(w, "Database unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	schedule, err := db.GetSchedule(name)
+	if err != nil {
+		log.Printf("failed to get schedule: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if schedule == nil || !scheduleVisibleTo(*schedule, apiKey) {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, newScheduleSummary(*schedule))
+}
+
+func handleScheduleWeb(w http.ResponseWriter, r *http.Request, name string) {
+	if !getSession(r).isLoggedIn() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	schedule, err := db.GetSchedule(name)
+	if err != nil {
+		log.Printf("failed to get schedule: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if schedule == nil {
+		http.NotFound(w, r)
+		return
+	}
+	runs, err := db.ListScheduleRuns(name, scheduleRunsLimit)
+	if err != nil {
+		log.Printf("failed to list runs of schedule %s: %v", name, err)
+	}
+	execTemplate(w, "schedule", struct {
+		Meta     meta
+		Schedule scheduleSummary
+		Runs     []kaginawa.ScheduleRun
+	}{
+		newMeta(r, "Schedule"),
+		newScheduleSummary(*schedule),
+		runs,
+	})
+}
+
+// handleScheduleRuns handles list of runs of the schedule requests.
+// Results are limited to the nodes allowed for the api key.
+//
+// - Method: GET
+// - Client: Browser or API
+// - Access: Admin
+// - Response: JSON
+func handleScheduleRuns(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if len(name) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	apiKey := validateAPIKey(r, kaginawa.ScopeSchedulesRead)
+	if apiKey == nil && !getSession(r).isLoggedIn() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	limit := defaultScheduleRunsLimit
+	if v := r.URL.Query().Get("limit"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit value", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	runs, err := db.ListScheduleRuns(name, limit)
+	if err != nil {
+		log.Printf("failed to list schedule runs: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	visible := []kaginawa.ScheduleRun{}
+	for _, run := range runs {
+		if v := visibleScheduleRun(run, apiKey); v != nil {
+			visible = append(visible, *v)
+		}
+	}
+	writeJSON(w, visible)
+}
+
+// handleRunSchedule handles run the schedule immediately. Progress is available at /command-jobs/:id.
+//
+// - Method: POST
+// - Client: Browser or API
+// - Access: Admin
+// - Response: 303 redirect (browser) or JSON (API)
+func handleRunSchedule(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if len(name) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	apiKey := validateAPIKey(r, kaginawa.ScopeSchedulesWrite)
+	if apiKey == nil && !getSession(r).isLoggedIn() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if apiKey != nil && len(apiKey.CustomIDs) > 0 {
+		http.Error(w, "API keys restricted to custom IDs cannot manage schedules", http.StatusForbidden)
+		return
+	}
+	if apiKey != nil && !apiKey.HasScope(kaginawa.ScopeCommandExec) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	schedule, err := db.GetSchedule(name)
+	if err != nil {
+		log.Printf("failed to get schedule: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if schedule == nil {
+		http.NotFound(w, r)
+		return
+	}
+	now := time.Now().UTC()
+	claimed, err := claimSchedule(*schedule, now)
+	if err != nil {
+		writeFormError(w, err)
+		return
+	}
+	if claimed == nil {
+		http.Error(w, "Schedule has been started by others, retry later", http.StatusConflict)
+		return
+	}
+	job, err := startSchedule(*claimed)
+	if err != nil {
+		writeFormError(w, err)
+		return
+	}
+	if apiKey == nil {
+		http.Redirect(w, r, "/schedules/"+url.PathEscape(name), http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Location", "/command-jobs/"+job.ID)
+	w.Header().Set("Content-Type", contentTypeJSON) // writeJSON cannot set headers after WriteHeader
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, job)
+}
+
+// handleNewSchedule handles schedule registration and update requests.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleNewSchedule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	session := getSession(r)
+	if !session.isLoggedIn() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	schedule := kaginawa.Schedule{
+		Name:      strings.TrimSpace(r.FormValue("name")),
+		Spec:      strings.TrimSpace(r.FormValue("spec")),
+		Command:   strings.TrimSpace(r.FormValue("command")),
+		Selector:  strings.TrimSpace(r.FormValue("selector")),
+		Profile:   strings.TrimSpace(r.FormValue("profile")),
+		Enabled:   r.FormValue("enabled") == "yes",
+		UpdatedBy: session.email(),
+	}
+	for _, field := range []struct {
+		name  string
+		value *int
+	}{{"timeout", &schedule.Timeout}, {"concurrency", &schedule.Concurrency}} {
+		if v := strings.TrimSpace(r.FormValue(field.name)); len(v) > 0 {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "Invalid "+field.name+" value", http.StatusBadRequest)
+				return
+			}
+			*field.value = n
+		}
+	}
+	if _, err := putSchedule(schedule); err != nil {
+		writeFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/schedules", http.StatusSeeOther)
+}
+
+// handleToggleSchedule handles enable or disable the schedule.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleToggleSchedule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !getSession(r).isLoggedIn() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	schedule, err := db.GetSchedule(r.FormValue("name"))
+	if err != nil {
+		log.Printf("failed to get schedule: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if schedule == nil {
+		http.NotFound(w, r)
+		return
+	}
+	schedule.Enabled = r.FormValue("enabled") == "yes"
+	if err := db.PutSchedule(*schedule); err != nil {
+		log.Printf("failed to put schedule: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/schedules", http.StatusSeeOther)
+}
+
+// handleDeleteSchedule handles schedule deletion requests. Runs are preserved.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !getSession(r).isLoggedIn() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	name := r.FormValue("name")
+	if len(name) == 0 {
+		http.Error(w, "Name is empty", http.StatusBadRequest)
+		return
+	}
+	if err := db.DeleteSchedule(name); err != nil {
+		log.Printf("failed to delete schedule: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/schedules", http.StatusSeeOther)
+}
+
+// putSchedule validates and puts the schedule. Last run time is kept from the current entry.
+// Returns the stored schedule.
+func putSchedule(schedule kaginawa.Schedule) (kaginawa.Schedule, error) {
+	if len(schedule.Name) == 0 {
+		return schedule, errors.New("name is empty")
+	}
+	if _, err := kaginawa.ParseCron(schedule.Spec); err != nil {
+		return schedule, err
+	}
+	if len(schedule.Command) == 0 {
+		return schedule, errors.New("command is empty")
+	}
+	values, err := url.ParseQuery(schedule.Selector)
+	if err != nil {
+		return schedule, fmt.Errorf("invalid selector: %v", err)
+	}
+	for key := range values {
+		if !scheduleSelectorKeys[key] {
+			return schedule, fmt.Errorf("unknown selector: %s", key)
+		}
+	}
+	selector, err := parseNodeSelector(values)
+	if err != nil {
+		return schedule, err
+	}
+	if selector.empty() {
+		return schedule, errors.New("node selector required")
+	}
+	if schedule.Timeout < 0 {
+		return schedule, errors.New("invalid timeout value")
+	}
+	if schedule.Concurrency < 0 || schedule.Concurrency > maxJobConcurrency {
+		return schedule, errors.New("invalid concurrency value")
+	}
+	if len(schedule.Profile) > 0 {
+		profile, err := db.GetCredentialProfile(schedule.Profile)
+		if err != nil {
+			return schedule, fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+		}
+		if profile == nil {
+			return schedule, fmt.Errorf("credential profile not found: %s", schedule.Profile)
+		}
+	}
+	current, err := db.GetSchedule(schedule.Name)
+	if err != nil {
+		return schedule, fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+	}
+	schedule.LastRunAt = 0
+	if current != nil {
+		schedule.LastRunAt = current.LastRunAt
+	}
+	schedule.UpdatedAt = time.Now().UTC().Unix()
+	if err := db.PutSchedule(schedule); err != nil {
+		return schedule, fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+	}
+	return schedule, nil
+}
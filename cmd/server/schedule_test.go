@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+// setupTestSchedules prepares the test relay with node1 of custom ID "site1" and its assigned credential profile.
+func setupTestSchedules(t *testing.T) {
+	t.Helper()
+	setupTestRelay(t)
+	report, err := db.GetReportByID("node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report.CustomID = "site1"
+	if err := db.PutReport(*report); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutCredentialProfile(kaginawa.CredentialProfile{
+		Name:      "site1",
+		User:      testSSHUser,
+		Password:  testSSHPassword,
+		CustomIDs: []string{"site1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitScheduleRuns waits until the number of runs of the schedule reaches n.
+func waitScheduleRuns(t *testing.T, name string, n int) []kaginawa.ScheduleRun {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		runs, err := db.ListScheduleRuns(name, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(runs) >= n || time.Now().After(deadline) {
+			return runs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunDueSchedules(t *testing.T) {
+	setupTestSchedules(t)
+	for _, schedule := range []kaginawa.Schedule{
+		{Name: "hello", Spec: "*/5 * * * *", Command: "echo hello", Selector: "custom-id=site1", Enabled: true},
+		{Name: "failure", Spec: "*/5 * * * *", Command: "exit 3", Selector: "custom-id=site1", Enabled: true},
+		{Name: "disabled", Spec: "* * * * *", Command: "echo disabled", Selector: "custom-id=site1"},
+	} {
+		if err := db.PutSchedule(schedule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	minute := time.Date(2026, 1, 1, 3, 10, 0, 0, time.UTC)
+	runDueSchedules(minute)
+	runs := waitScheduleRuns(t, "hello", 1)
+	if len(runs) != 1 {
+		t.Fatalf("expected 1 run, got %d", len(runs))
+	}
+	run := runs[0]
+	if run.Total != 1 || run.Succeeded != 1 || run.Failed != 0 || run.FinishedAt == 0 {
+		t.Errorf("unexpected run: %+v", run)
+	}
+	if len(run.Results) != 1 || run.Results[0].NodeID != "node1" || run.Results[0].Output != "hello\n" {
+		t.Errorf("unexpected results: %+v", run.Results)
+	}
+	failures := waitScheduleRuns(t, "failure", 1)
+	if len(failures) != 1 || failures[0].Failed != 1 || failures[0].Results[0].ExitCode != 3 {
+		t.Errorf("expected failed run, got %+v", failures)
+	}
+	schedule, err := db.GetSchedule("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schedule.LastRunAt != minute.Unix() {
+		t.Errorf("expected last run at %d, got %d", minute.Unix(), schedule.LastRunAt)
+	}
+
+	runDueSchedules(minute)                      // already started
+	runDueSchedules(minute.Add(time.Minute))     // not matched
+	runDueSchedules(minute.Add(5 * time.Minute)) // matched
+	if runs := waitScheduleRuns(t, "hello", 2); len(runs) != 2 {
+		t.Errorf("expected 2 runs, got %d", len(runs))
+	}
+	waitScheduleRuns(t, "failure", 2) // finish background runs before the next test
+	if runs, _ := db.ListScheduleRuns("disabled", 0); len(runs) != 0 {
+		t.Errorf("expected disabled schedule skipped, got %d runs", len(runs))
+	}
+	logs, err := db.ListCommandLogs("node1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Source != commandSourceSchedule || logs[0].Profile != "site1" {
+		t.Errorf("expected scheduled execution recorded, got %+v", logs)
+	}
+}
+
+func TestHandleSchedule(t *testing.T) {
+	setupTestSchedules(t)
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:    kaginawa.HashAPIKey(testAPIKey),
+		Label:  "schedule key",
+		Scopes: []string{kaginawa.ScopeSchedulesRead, kaginawa.ScopeSchedulesWrite, kaginawa.ScopeCommandExec},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	request := func(method, path, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://localhost:8080"+path, strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"name": "cleanup"})
+		req.Header.Set("Authorization", "token "+testAPIKey)
+		req.Header.Set("Accept", contentTypeJSON)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	for _, body := range []string{
+		`{"spec":"0 25 * * *","command":"echo hello","selector":"custom-id=site1"}`,
+		`{"spec":"@daily","command":"","selector":"custom-id=site1"}`,
+		`{"spec":"@daily","command":"echo hello","selector":""}`,
+		`{"spec":"@daily","command":"echo hello","selector":"unknown=1"}`,
+		`{"spec":"@daily","command":"echo hello","selector":"custom-id=site1","profile":"unknown"}`,
+	} {
+		if w := request(http.MethodPut, "/schedules/cleanup", body, handleSchedule); w.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, body, w.Code)
+		}
+	}
+	body := `{"spec":"0 3 * * *","command":"echo hello","selector":"custom-id=site1","enabled":true}`
+	w := request(http.MethodPut, "/schedules/cleanup", body, handleSchedule)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var summary scheduleSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.UpdatedBy != "schedule key" || summary.NextRunAt == 0 || time.Unix(summary.NextRunAt, 0).UTC().Hour() != 3 {
+		t.Errorf("unexpected schedule: %+v", summary)
+	}
+
+	w = request(http.MethodPost, "/schedules/cleanup/run", "", handleRunSchedule)
+	if w.Code != http.StatusAccepted || !strings.HasPrefix(w.Header().Get("Location"), "/command-jobs/") {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, w.Code, w.Body.String())
+	}
+	waitScheduleRuns(t, "cleanup", 1)
+	w = request(http.MethodGet, "/schedules/cleanup/runs", "", handleScheduleRuns)
+	var runs []kaginawa.ScheduleRun
+	if err := json.Unmarshal(w.Body.Bytes(), &runs); err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Succeeded != 1 {
+		t.Errorf("expected a succeeded run, got %s", w.Body.String())
+	}
+
+	w = request(http.MethodGet, "/schedules", "", handleSchedules)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"name":"cleanup"`) {
+		t.Errorf("expected list of schedules, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request(http.MethodDelete, "/schedules/cleanup", "", handleSchedule); w.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if w := request(http.MethodGet, "/schedules/cleanup", "", handleSchedule); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestClaimSchedule(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	if err := db.PutSchedule(kaginawa.Schedule{Name: "hello", Spec: "@daily", Command: "echo hello"}); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := db.GetSchedule("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutSchedule(kaginawa.Schedule{Name: "hello", Spec: "@daily", Command: "echo edited"}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	claimed, err := claimSchedule(*stale, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed == nil || claimed.Command != "echo edited" || claimed.LastRunAt != now.Unix() {
+		t.Errorf("expected latest schedule claimed, got %+v", claimed)
+	}
+	if claimed, err := claimSchedule(*stale, now); err != nil || claimed != nil {
+		t.Errorf("expected claimed by others, got %+v, %v", claimed, err)
+	}
+}
+
+func TestScheduleVisibleTo(t *testing.T) {
+	limited := &kaginawa.APIKey{CustomIDs: []string{"site1"}}
+	for _, tc := range []struct {
+		selector string
+		apiKey   *kaginawa.APIKey
+		expected bool
+	}{
+		{"hostname=web", nil, true},
+		{"hostname=web", &kaginawa.APIKey{}, true},
+		{"custom-id=site1", limited, true},
+		{"custom-id=site2", limited, false},
+		{"custom-id=site1&hostname=web", limited, false},
+		{"hostname=web", limited, false},
+	} {
+		schedule := kaginawa.Schedule{Name: "hello", Selector: tc.selector}
+		if actual := scheduleVisibleTo(schedule, tc.apiKey); actual != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.selector, tc.expected, actual)
+		}
+	}
+}
+
+func TestVisibleScheduleRun(t *testing.T) {
+	run := kaginawa.ScheduleRun{
+		ID:        "1",
+		Schedule:  "hello",
+		Total:     3,
+		Succeeded: 2,
+		Failed:    1,
+		Results: []kaginawa.ScheduleResult{
+			{NodeID: "node1", CustomID: "site1"},
+			{NodeID: "node2", CustomID: "site2"},
+			{NodeID: "node3", CustomID: "site1", ExitCode: 1},
+		},
+	}
+	if visible := visibleScheduleRun(run, nil); visible == nil || visible.Total != 3 || len(visible.Results) != 3 {
+		t.Errorf("expected all results for browser sessions, got %+v", visible)
+	}
+	visible := visibleScheduleRun(run, &kaginawa.APIKey{CustomIDs: []string{"site1"}})
+	if visible == nil || visible.Total != 2 || visible.Succeeded != 1 || visible.Failed != 1 || len(visible.Results) != 2 {
+		t.Errorf("expected results of site1, got %+v", visible)
+	}
+	if visible := visibleScheduleRun(run, &kaginawa.APIKey{CustomIDs: []string{"site3"}}); visible != nil {
+		t.Errorf("expected invisible, got %+v", visible)
+	}
+}
+
+func TestHandleSchedule_restrictedKey(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:       kaginawa.HashAPIKey(testAPIKey),
+		Scopes:    []string{kaginawa.ScopeSchedulesWrite},
+		CustomIDs: []string{"site1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	body := `{"spec":"@daily","command":"echo hello","selector":"custom-id=site2"}`
+	req := httptest.NewRequest(http.MethodPut, "http://localhost:8080/schedules/cleanup", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"name": "cleanup"})
+	req.Header.Set("Authorization", "token "+testAPIKey)
+	w := httptest.NewRecorder()
+	handleSchedule(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+
+	if err := db.PutSchedule(kaginawa.Schedule{Name: "cleanup", Spec: "@daily", Command: "df"}); err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest(http.MethodDelete, "http://localhost:8080/schedules/cleanup", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "cleanup"})
+	req.Header.Set("Authorization", "token "+testAPIKey)
+	w = httptest.NewRecorder()
+	handleSchedule(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+	if schedule, err := db.GetSchedule("cleanup"); err != nil || schedule == nil {
+		t.Errorf("expected schedule not deleted, got %v, %v", schedule, err)
+	}
+}
+
+func TestHandleSchedule_withoutCommandExec(t *testing.T) {
+	setupTestSchedules(t)
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:    kaginawa.HashAPIKey(testAPIKey),
+		Scopes: []string{kaginawa.ScopeSchedulesWrite},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutSchedule(kaginawa.Schedule{Name: "cleanup", Spec: "@daily", Command: "df", Selector: "custom-id=site1"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		method  string
+		path    string
+		body    string
+		handler http.HandlerFunc
+	}{
+		{http.MethodPut, "/schedules/cleanup", `{"spec":"@daily","command":"id","selector":"custom-id=site1"}`, handleSchedule},
+		{http.MethodPost, "/schedules/cleanup/run", "", handleRunSchedule},
+	} {
+		req := httptest.NewRequest(test.method, "http://localhost:8080"+test.path, strings.NewReader(test.body))
+		req = mux.SetURLVars(req, map[string]string{"name": "cleanup"})
+		req.Header.Set("Authorization", "token "+testAPIKey)
+		w := httptest.NewRecorder()
+		test.handler(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected status %d for %s %s, got %d", http.StatusUnauthorized, test.method, test.path, w.Code)
+		}
+	}
+	if schedule, err := db.GetSchedule("cleanup"); err != nil || schedule == nil || schedule.Command != "df" || schedule.LastRunAt != 0 {
+		t.Errorf("expected schedule neither updated nor run, got %+v, %v", schedule, err)
+	}
+}
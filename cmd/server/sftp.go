@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+const (
+	defaultMaxTransferMB = 100
+	uploadMemoryBytes    = 8 << 20 // larger files are buffered on temporary files
+	uploadFormOverhead   = 1 << 20 // allowance of multipart headers and credential fields
+)
+
+var (
+	maxUploadBytes   int64 = defaultMaxTransferMB << 20
+	maxDownloadBytes int64 = defaultMaxTransferMB << 20
+)
+
+// handleUpload handles upload a file to the node via sftp.
+//
+// - Method: POST (multipart/form-data)
+// - Client: Browser or API
+// - Access: Admin
+// - Response: Text
+func handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	auth := authorizeNodeRequest(w, r) // before buffering the body
+	if auth == nil {
+		return
+	}
+	if auth.limitedKey != nil {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden) // saved commands only
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes+uploadFormOverhead)
+	if err := r.ParseMultipartForm(uploadMemoryBytes); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("File too large (max %d bytes)", maxUploadBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		log.Printf("failed to parse multipart form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		if err := r.MultipartForm.RemoveAll(); err != nil {
+			log.Printf("failed to remove temporary files: %v", err)
+		}
+	}()
+	req := parseAuthorizedNodeRequest(w, r, auth, parseCredentialForm)
+	if req == nil {
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "File required", http.StatusBadRequest)
+		return
+	}
+	defer safeClose(file, "upload file")
+	if header.Size > maxUploadBytes {
+		http.Error(w, fmt.Sprintf("File too large (max %d bytes)", maxUploadBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	dest := strings.TrimSpace(r.FormValue("path"))
+	if len(dest) == 0 {
+		http.Error(w, "Path required", http.StatusBadRequest)
+		return
+	}
+	if strings.HasSuffix(dest, "/") {
+		dest += path.Base(header.Filename)
+	}
+	req.command = "upload " + dest
+
+	begin := time.Now()
+	var written int64
+	err = withSFTP(req, func(client *sftp.Client) error {
+		remote, err := client.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", dest, err)
+		}
+		defer safeClose(remote, "remote file")
+		written, err = io.Copy(remote, file)
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", dest, err)
+		}
+		return nil
+	})
+	recordCommand(req, commandSourceUpload, newCommandExit(err), time.Since(begin), int(written))
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	message := fmt.Sprintf("Uploaded %d bytes to %s\n", written, dest)
+	if req.browser {
+		handleNodeWeb(w, r, req.id, req.typedUser(), req.password, req.profile, message)
+		return
+	}
+	w.Header().Add("Content-Type", contentTypeText)
+	if _, err := w.Write([]byte(message)); err != nil {
+		log.Printf("failed to write body: %v", err)
+	}
+}
+
+// handleDownload handles download a file from the node via sftp.
+//
+// - Method: POST
+// - Client: Browser or API
+// - Access: Admin
+// - Response: File
+func handleDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	req := parseNodeRequest(w, r, parseCredentialForm)
+	if req == nil {
+		return
+	}
+	src := strings.TrimSpace(r.FormValue("path"))
+	if len(src) == 0 {
+		http.Error(w, "Path required", http.StatusBadRequest)
+		return
+	}
+	req.command = "download " + src
+
+	begin := time.Now()
+	var written int64
+	err := withSFTP(req, func(client *sftp.Client) error {
+		info, err := client.Stat(src)
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", src, err)
+		}
+		if !info.Mode().IsRegular() {
+			return &transferError{http.StatusBadRequest, fmt.Sprintf("Not a regular file: %s", src)}
+		}
+		if info.Size() > maxDownloadBytes {
+			return &transferError{http.StatusRequestEntityTooLarge, fmt.Sprintf("File too large (max %d bytes)", maxDownloadBytes)}
+		}
+		remote, err := client.Open(src)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", src, err)
+		}
+		defer safeClose(remote, "remote file")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(src)))
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+		written, err = io.Copy(w, io.LimitReader(remote, info.Size()))
+		if err != nil {
+			return &transferError{0, fmt.Sprintf("failed to send %s: %v", src, err)} // headers are already sent
+		}
+		if written != info.Size() {
+			return &transferError{0, fmt.Sprintf("failed to send %s: read %d of %d bytes", src, written, info.Size())}
+		}
+		return nil
+	})
+	recordCommand(req, commandSourceDownload, newCommandExit(err), time.Since(begin), int(written))
+	var transferErr *transferError
+	if errors.As(err, &transferErr) {
+		if transferErr.status == 0 {
+			log.Print(transferErr.message) // truncated transfer
+			return
+		}
+		http.Error(w, transferErr.message, transferErr.status)
+		return
+	}
+	if err != nil {
+		writeCommandError(w, err)
+	}
+}
+
+// transferError defines a file transfer error responded with the status. Zero status means the response is already sent.
+type transferError struct {
+	status  int
+	message string
+}
+
+func (e *transferError) Error() string {
+	return e.message
+}
+
+// withSFTP opens a sftp session to the node through the ssh server. Connections are closed after timeout.
+func withSFTP(req *commandRequest, fn func(client *sftp.Client) error) error {
+	client, closeFn, err := connectTargetWithRetry(req.server, req.report, req.serverConfig, req.targetConfig)
+	if err != nil {
+		return err
+	}
+	timer := time.AfterFunc(req.timeout, closeFn)
+	defer func() {
+		if timer.Stop() {
+			closeFn()
+		}
+	}()
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to start sftp session: %w", err)
+	}
+	defer safeClose(sftpClient, "sftp session")
+	return fn(sftpClient)
+}
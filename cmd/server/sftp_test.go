@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+func newTestUploadRequest(t *testing.T, fields map[string]string, content []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for k, v := range fields {
+		if err := writer.WriteField(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	part, err := writer.CreateFormFile("file", "hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "http://localhost:8080/nodes/node1/upload", &body)
+	req = mux.SetURLVars(req, map[string]string{"id": "node1"})
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "token "+testAPIKey)
+	return req
+}
+
+func TestHandleUploadAndDownload(t *testing.T) {
+	setupTestRelay(t)
+	dir := t.TempDir()
+	fields := map[string]string{"user": testSSHUser, "password": testSSHPassword, "path": dir + "/"}
+	w := httptest.NewRecorder()
+	handleUpload(w, newTestUploadRequest(t, fields, []byte("hello sftp")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	uploaded, err := os.ReadFile(filepath.Join(dir, "hello.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(uploaded) != "hello sftp" {
+		t.Errorf("expected uploaded content, got %q", uploaded)
+	}
+
+	form := url.Values{"user": {testSSHUser}, "password": {testSSHPassword}, "path": {dir + "/hello.txt"}}
+	w = httptest.NewRecorder()
+	handleDownload(w, newTestCommandRequest("/nodes/node1/download", form))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w.Body.String() != "hello sftp" {
+		t.Errorf("expected downloaded content, got %q", w.Body.String())
+	}
+	if !strings.Contains(w.Header().Get("Content-Disposition"), `filename="hello.txt"`) {
+		t.Errorf("unexpected content disposition: %s", w.Header().Get("Content-Disposition"))
+	}
+
+	logs, err := db.ListCommandLogs("node1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 || logs[0].Source != commandSourceDownload || logs[1].Source != commandSourceUpload {
+		t.Errorf("expected upload and download logs, got %+v", logs)
+	}
+}
+
+func TestHandleUpload_tooLarge(t *testing.T) {
+	defer func(n int64) { maxUploadBytes = n }(maxUploadBytes)
+	maxUploadBytes = 4
+	setupTestRelay(t)
+	fields := map[string]string{"user": testSSHUser, "password": testSSHPassword, "path": t.TempDir() + "/a.txt"}
+	w := httptest.NewRecorder()
+	handleUpload(w, newTestUploadRequest(t, fields, []byte("hello sftp")))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %d, got %d: %s", http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	}
+}
+
+func TestHandleDownload_tooLarge(t *testing.T) {
+	defer func(n int64) { maxDownloadBytes = n }(maxDownloadBytes)
+	maxDownloadBytes = 4
+	setupTestRelay(t)
+	file := filepath.Join(t.TempDir(), "large.txt")
+	if err := os.WriteFile(file, []byte("hello sftp"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"user": {testSSHUser}, "password": {testSSHPassword}, "path": {file}}
+	w := httptest.NewRecorder()
+	handleDownload(w, newTestCommandRequest("/nodes/node1/download", form))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %d, got %d: %s", http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	}
+}
+
+// unreadBody fails the test if the request body is read.
+type unreadBody struct{ t *testing.T }
+
+func (b unreadBody) Read([]byte) (int, error) {
+	b.t.Error("request body read before authentication")
+	return 0, errors.New("unexpected read")
+}
+
+func TestHandleUpload_unauthorized(t *testing.T) {
+	setupTestRelay(t)
+	sessionStore = sessions.NewCookieStore([]byte("test"))
+	if err := db.PutAPIKey(kaginawa.APIKey{Key: kaginawa.HashAPIKey("read-key"), Scopes: []string{kaginawa.ScopeNodesRead}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"", "unknown", "read-key"} {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost:8080/nodes/node1/upload", unreadBody{t})
+		req = mux.SetURLVars(req, map[string]string{"id": "node1"})
+		req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+		if len(key) > 0 {
+			req.Header.Set("Authorization", "token "+key)
+		}
+		w := httptest.NewRecorder()
+		handleUpload(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected status %d for key %q, got %d", http.StatusForbidden, key, w.Code)
+		}
+	}
+}
+
+// brokenResponseWriter fails to write bodies, like disconnected clients.
+type brokenResponseWriter struct{ *httptest.ResponseRecorder }
+
+func (w brokenResponseWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestHandleDownload_truncated(t *testing.T) {
+	setupTestRelay(t)
+	file := filepath.Join(t.TempDir(), "hello.txt")
+	if err := os.WriteFile(file, []byte("hello sftp"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"user": {testSSHUser}, "password": {testSSHPassword}, "path": {file}}
+	handleDownload(brokenResponseWriter{httptest.NewRecorder()}, newTestCommandRequest("/nodes/node1/download", form))
+	logs, err := db.ListCommandLogs("node1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].ExitCode != -1 || !strings.Contains(logs[0].Error, "connection reset") {
+		t.Errorf("expected failed download recorded, got %+v", logs)
+	}
+}
+
+// shrinkingResponseWriter truncates the file when headers are set, like files shrunk by others during downloads.
+type shrinkingResponseWriter struct {
+	*httptest.ResponseRecorder
+	file string
+	once *sync.Once
+}
+
+func (w shrinkingResponseWriter) Header() http.Header {
+	w.once.Do(func() {
+		if err := os.Truncate(w.file, 5); err != nil {
+			panic(err)
+		}
+	})
+	return w.ResponseRecorder.Header()
+}
+
+func TestHandleDownload_shortRead(t *testing.T) {
+	setupTestRelay(t)
+	file := filepath.Join(t.TempDir(), "hello.txt")
+	if err := os.WriteFile(file, []byte("hello sftp"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"user": {testSSHUser}, "password": {testSSHPassword}, "path": {file}}
+	w := shrinkingResponseWriter{httptest.NewRecorder(), file, &sync.Once{}}
+	handleDownload(w, newTestCommandRequest("/nodes/node1/download", form))
+	logs, err := db.ListCommandLogs("node1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].ExitCode != -1 || !strings.Contains(logs[0].Error, "read 5 of 10 bytes") {
+		t.Errorf("expected short download recorded as failure, got %+v", logs)
+	}
+}
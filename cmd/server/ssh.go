@@ -1,14 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"html"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/mux"
 	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
@@ -20,73 +25,170 @@ const (
 	eofRetries        = 3
 )
 
-var errEOF = errors.New("EOF")
+var (
+	errEOF                 = errors.New("EOF")
+	errCommandTimeout      = errors.New("timeout")
+	errRelayUnavailable    = errors.New("ssh server is currently unavailable")
+	errRelayMisconfigured  = errors.New("ssh server configuration error")
+	errDatabaseUnavailable = errors.New("database unavailable")
+)
 
 type commandResponse struct {
 	data []byte
 	err  error
 }
 
+// commandRequest defines resolved parameters of a command execution request.
+type commandRequest struct {
+	id           string
+	actor        string // API key label or user email
+	user         string
+	password     string // empty if the credential profile is used
+	profile      string // name of the credential profile used
+	command      string
+	saved        string // name of the saved command
+	timeout      time.Duration
+	browser      bool
+	report       *kaginawa.Report
+	server       kaginawa.SSHServer
+	serverConfig *ssh.ClientConfig
+	targetConfig *ssh.ClientConfig
+}
+
+// typedUser returns the user name entered by the client. Users of credential profiles are not returned.
+func (req *commandRequest) typedUser() string {
+	if len(req.profile) > 0 {
+		return ""
+	}
+	return req.user
+}
+
+// commandResult defines the JSON response of a command execution.
+type commandResult struct {
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	ExitCode   int    `json:"exit_code"`
+	DurationMS int64  `json:"duration_ms"`
+	TimedOut   bool   `json:"timed_out"`
+	Error      string `json:"error,omitempty"`
+}
+
 // handleCommand handles execute a command via ssh.
+// API clients can request a commandResult by "Accept: application/json" header.
 //
-// - Method: HEAD
+// - Method: POST
 // - Client: Browser or API
 // - Access: Admin
-// - Response: Text
+// - Response: Text or JSON
 func handleCommand(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
 	}
-	id := mux.Vars(r)["id"]
-	if len(id) == 0 {
-		http.NotFound(w, r)
+	req := parseCommandRequest(w, r)
+	if req == nil {
 		return
 	}
-
-	// Validate API key or session
-	browser := false
-	if !validateAPIKey(r, true) {
-		if !getSession(r).isLoggedIn() {
-			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	if !req.browser && r.Header.Get("Accept") == contentTypeJSON {
+		result, err := execResult(req)
+		if err != nil {
+			recordCommand(req, commandSourceCommand, newCommandExit(err), 0, 0)
+			writeCommandError(w, err)
 			return
 		}
-		browser = true
+		recordCommandResult(req, commandSourceCommand, result)
+		writeJSON(w, result)
+		return
+	}
+	begin := time.Now()
+	resp, err := execWithTimeout(req.server, req.report, req.serverConfig, req.targetConfig, req.command, req.timeout)
+	exit := newCommandExit(err)
+	if errors.Is(err, errCommandTimeout) {
+		exit = commandExit{ExitCode: -1, TimedOut: true, Error: err.Error()}
+	}
+	recordCommand(req, commandSourceCommand, exit, time.Since(begin), len(resp))
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	if req.browser {
+		handleNodeWeb(w, r, req.id, req.typedUser(), req.password, req.profile, string(resp))
+	} else {
+		w.Header().Add("Content-Type", "text/plain")
+		if _, err := w.Write(resp); err != nil {
+			log.Printf("failed to write body: %v", err)
+		}
+	}
+}
+
+// parseCommandRequest validates the command request and resolves the target node and ssh server.
+// Returns nil after writing an error response if the request is not acceptable.
+func parseCommandRequest(w http.ResponseWriter, r *http.Request) *commandRequest {
+	return parseNodeRequest(w, r, parseCommandForm)
+}
+
+// nodeRequestAuth defines the authenticated client of a request to the node.
+type nodeRequestAuth struct {
+	apiKey     *kaginawa.APIKey // key with command:exec scope
+	limitedKey *kaginawa.APIKey // key without command:exec scope, only allowed to invoke saved commands
+	browser    bool
+}
+
+// authorizeNodeRequest validates the API key or session of the request to the node without reading the body.
+// Returns nil after writing an error response if the client is not authenticated.
+func authorizeNodeRequest(w http.ResponseWriter, r *http.Request) *nodeRequestAuth {
+	if apiKey := validateAPIKey(r, kaginawa.ScopeCommandExec); apiKey != nil {
+		return &nodeRequestAuth{apiKey: apiKey}
+	}
+	if getSession(r).isLoggedIn() {
+		return &nodeRequestAuth{browser: true}
+	}
+	if limitedKey := lookupAPIKey(r); limitedKey != nil {
+		if limitedKey = currentAPIKey(limitedKey); limitedKey != nil {
+			return &nodeRequestAuth{limitedKey: limitedKey}
+		}
+	}
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	return nil
+}
+
+// parseNodeRequest validates the request to the node using the form parser, and resolves the node and ssh server.
+// Returns nil after writing an error response if the request is not acceptable.
+func parseNodeRequest(w http.ResponseWriter, r *http.Request, parse func(*http.Request) (commandForm, error)) *commandRequest {
+	auth := authorizeNodeRequest(w, r)
+	if auth == nil {
+		return nil
+	}
+	return parseAuthorizedNodeRequest(w, r, auth, parse)
+}
+
+// parseAuthorizedNodeRequest is the same as parseNodeRequest for the request authorized by authorizeNodeRequest.
+func parseAuthorizedNodeRequest(w http.ResponseWriter, r *http.Request, auth *nodeRequestAuth,
+	parse func(*http.Request) (commandForm, error)) *commandRequest {
+	id := mux.Vars(r)["id"]
+	if len(id) == 0 {
+		http.NotFound(w, r)
+		return nil
 	}
+	apiKey, limitedKey, browser := auth.apiKey, auth.limitedKey, auth.browser
 
 	// Target information
 	if err := r.ParseForm(); err != nil {
 		log.Printf("failed to parse form: %v", err)
 		http.Error(w, "Invalid form", http.StatusBadRequest)
-		return
-	}
-	user := strings.TrimSpace(r.FormValue("user"))
-	password := strings.TrimSpace(r.FormValue("password"))
-	key := strings.TrimSpace(r.FormValue("key"))
-	command := strings.TrimSpace(r.FormValue("command"))
-	timeoutSec := strings.TrimSpace(r.FormValue("timeout"))
-	if len(user) == 0 {
-		http.Error(w, "User name required", http.StatusBadRequest)
-		return
-	}
-	if len(command) == 0 {
-		http.Error(w, "Command required", http.StatusBadRequest)
-		return
+		return nil
 	}
-	timeout := time.Duration(defaultTimeoutSec) * time.Second
-	if len(timeoutSec) > 0 {
-		n, err := strconv.Atoi(timeoutSec)
-		if err != nil || n < 1 {
-			http.Error(w, "Invalid timeout value", http.StatusBadRequest)
-			return
+	form, err := parse(r)
+	if limitedKey != nil {
+		if form.saved == nil || !form.saved.AllowedBy(*limitedKey) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return nil
 		}
-		timeout = time.Duration(n) * time.Second
+		apiKey = limitedKey
 	}
-	targetConfig, err := createSSHConfig(user, key, password)
 	if err != nil {
-		log.Printf("failed to parse key: %v", err)
-		http.Error(w, "Invalid ssh key", http.StatusBadRequest)
-		return
+		writeFormError(w, err)
+		return nil
 	}
 
 	// Get record
@@ -94,19 +196,178 @@ func handleCommand(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.Printf("failed to get report %s: %v", id, err)
 		http.Error(w, "Database unavailable", http.StatusInternalServerError)
-		return
+		return nil
+	}
+	if report == nil || !allowsNode(apiKey, report) {
+		http.NotFound(w, r)
+		return nil
 	}
 	if report.SSHRemotePort < 1 {
 		http.Error(w, "SSH not connected", http.StatusServiceUnavailable)
-		return
+		return nil
+	}
+	form, err = resolveCredentials(form, report)
+	if err != nil {
+		writeFormError(w, err)
+		return nil
+	}
+	targetHostKeyCallback, err := nodeHostKeyCallback(report.ID)
+	if err != nil {
+		log.Printf("failed to get known host %s: %v", report.ID, err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return nil
+	}
+	targetConfig, err := createSSHConfig(form.user, form.key, form.password, targetHostKeyCallback)
+	if err != nil {
+		log.Printf("failed to parse key: %v", err)
+		http.Error(w, "Invalid ssh key", http.StatusBadRequest)
+		return nil
+	}
+
+	server, serverConfig := resolveRelay(w, report)
+	if serverConfig == nil {
+		return nil
+	}
+	return &commandRequest{
+		id:           id,
+		actor:        commandActor(apiKey, r),
+		user:         form.user,
+		password:     form.typedPassword(),
+		profile:      form.profile,
+		command:      form.command,
+		saved:        form.savedName(),
+		timeout:      form.timeout,
+		browser:      browser,
+		report:       report,
+		server:       server,
+		serverConfig: serverConfig,
+		targetConfig: targetConfig,
+	}
+}
+
+// commandForm defines form parameters of a command execution.
+type commandForm struct {
+	user     string
+	password string
+	key      string
+	profile  string // name of the credential profile, credentials are filled by resolveCredentials
+	command  string
+	saved    *kaginawa.SavedCommand // the saved command rendered to the command
+	timeout  time.Duration
+}
+
+// savedName returns name of the saved command, or empty if the command is entered by the client.
+func (f commandForm) savedName() string {
+	if f.saved == nil {
+		return ""
+	}
+	return f.saved.Name
+}
+
+// typedPassword returns the password entered by the client. Passwords of credential profiles are not returned.
+func (f commandForm) typedPassword() string {
+	if len(f.profile) > 0 {
+		return ""
+	}
+	return f.password
+}
+
+// parseCommandForm parses form parameters of a parsed form. Returns an error message for the client if invalid.
+func parseCommandForm(r *http.Request) (commandForm, error) {
+	form, err := parseCredentialForm(r)
+	if err != nil {
+		return form, err
 	}
+	if name := strings.TrimSpace(r.FormValue("saved")); len(name) > 0 {
+		return renderSavedCommand(r, form, name)
+	}
+	form.command = strings.TrimSpace(r.FormValue("command"))
+	if len(form.command) == 0 {
+		return form, errors.New("command required")
+	}
+	return form, nil
+}
+
+// parseCredentialForm parses ssh credentials and timeout of a parsed form.
+// The user name may be omitted if a credential profile is selected or assigned to the node.
+func parseCredentialForm(r *http.Request) (commandForm, error) {
+	form := commandForm{
+		user:     strings.TrimSpace(r.FormValue("user")),
+		password: strings.TrimSpace(r.FormValue("password")),
+		key:      strings.TrimSpace(r.FormValue("key")),
+		profile:  strings.TrimSpace(r.FormValue("profile")),
+		timeout:  time.Duration(defaultTimeoutSec) * time.Second,
+	}
+	if timeoutSec := strings.TrimSpace(r.FormValue("timeout")); len(timeoutSec) > 0 {
+		n, err := strconv.Atoi(timeoutSec)
+		if err != nil || n < 1 {
+			return form, errors.New("invalid timeout value")
+		}
+		form.timeout = time.Duration(n) * time.Second
+	}
+	return form, nil
+}
+
+// newCommandRequest creates a command request of the node without writing responses.
+func newCommandRequest(report *kaginawa.Report, form commandForm) (*commandRequest, error) {
+	if report.SSHRemotePort < 1 {
+		return nil, errors.New("ssh not connected")
+	}
+	form, err := resolveCredentials(form, report)
+	if err != nil {
+		return nil, err
+	}
+	targetHostKeyCallback, err := nodeHostKeyCallback(report.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get known host %s: %w", report.ID, err)
+	}
+	targetConfig, err := createSSHConfig(form.user, form.key, form.password, targetHostKeyCallback)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh key: %w", err)
+	}
+	server, serverConfig, err := lookupRelay(report)
+	if err != nil {
+		return nil, err
+	}
+	return &commandRequest{
+		id:           report.ID,
+		user:         form.user,
+		password:     form.typedPassword(),
+		profile:      form.profile,
+		command:      form.command,
+		saved:        form.savedName(),
+		timeout:      form.timeout,
+		report:       report,
+		server:       server,
+		serverConfig: serverConfig,
+		targetConfig: targetConfig,
+	}, nil
+}
 
-	// Get ssh server information
+// resolveRelay finds the ssh server that the node is connected to, and creates its client config.
+// Returns nil config after writing an error response if the ssh server is not available.
+func resolveRelay(w http.ResponseWriter, report *kaginawa.Report) (kaginawa.SSHServer, *ssh.ClientConfig) {
+	server, serverConfig, err := lookupRelay(report)
+	if err != nil {
+		log.Print(err)
+		switch {
+		case errors.Is(err, errRelayUnavailable):
+			http.Error(w, "SSH server unavailable", http.StatusServiceUnavailable)
+		case errors.Is(err, errRelayMisconfigured):
+			http.Error(w, "SSH server configuration error", http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		}
+		return server, nil
+	}
+	return server, serverConfig
+}
+
+// lookupRelay finds the ssh server that the node is connected to, and creates its client config.
+func lookupRelay(report *kaginawa.Report) (kaginawa.SSHServer, *ssh.ClientConfig, error) {
 	servers, err := db.ListSSHServers()
 	if err != nil {
-		log.Printf("failed to list ssh servers: %v", err)
-		http.Error(w, "Database unavailable", http.StatusInternalServerError)
-		return
+		return kaginawa.SSHServer{}, nil, fmt.Errorf("failed to list ssh servers: %w", err)
 	}
 	var server kaginawa.SSHServer
 	for _, s := range servers {
@@ -115,52 +376,46 @@ func handleCommand(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	if len(server.Host) == 0 {
-		log.Printf("server %s is currently unavailable", report.SSHServerHost)
-		http.Error(w, "SSH server unavailable", http.StatusServiceUnavailable)
-		return
+		return server, nil, fmt.Errorf("%w: %s", errRelayUnavailable, report.SSHServerHost)
 	}
-
-	serverConfig, err := createSSHConfig(server.User, server.Key, server.Password)
+	serverConfig, err := createSSHConfig(server.User, server.Key, server.Password, serverHostKeyCallback(server))
 	if err != nil {
-		log.Printf("failed to parse server key: %v", err)
-		http.Error(w, "SSH server configuration error", http.StatusServiceUnavailable)
+		return server, nil, fmt.Errorf("%w: failed to parse key of %s: %v", errRelayMisconfigured, server.Host, err)
+	}
+	return server, serverConfig, nil
+}
+
+// writeFormError writes an error response of invalid form parameters, or database errors while resolving them.
+func writeFormError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errDatabaseUnavailable) {
+		log.Print(err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
 		return
 	}
-	var resp []byte
-	eofCount := 0
-	for {
-		resp, err = execWithTimeout(server, report, serverConfig, targetConfig, command, timeout)
-		if err != nil {
-			if errors.Is(err, errEOF) {
-				eofCount++
-				if eofCount >= eofRetries {
-					log.Printf("EOF occurred %d times", eofCount)
-					http.Error(w, fmt.Sprintf("EOF occurred %d times", eofCount), http.StatusServiceUnavailable)
-					return
-				}
-				continue
-			}
-			log.Print(err)
-			http.Error(w, err.Error(), http.StatusServiceUnavailable)
-			return
-		}
-		break
+	http.Error(w, html.EscapeString(err.Error()), http.StatusBadRequest)
+}
+
+// writeCommandError writes an error response of the command execution.
+func writeCommandError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errEOF) {
+		log.Printf("EOF occurred %d times", eofRetries)
+		http.Error(w, fmt.Sprintf("EOF occurred %d times", eofRetries), http.StatusServiceUnavailable)
+		return
 	}
-	if browser {
-		handleNodeWeb(w, r, id, user, password, string(resp))
-	} else {
-		w.Header().Add("Content-Type", "text/plain")
-		if _, err := w.Write(resp); err != nil {
-			log.Printf("failed to write body: %v", err)
-		}
+	log.Print(err)
+	var changed *hostKeyChangedError
+	if errors.As(err, &changed) {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
 	}
+	http.Error(w, err.Error(), http.StatusServiceUnavailable)
 }
 
-func createSSHConfig(user, key, password string) (*ssh.ClientConfig, error) {
+func createSSHConfig(user, key, password string, hostKeyCallback ssh.HostKeyCallback) (*ssh.ClientConfig, error) {
 	config := ssh.ClientConfig{
 		User:            user,
 		Auth:            make([]ssh.AuthMethod, 0),
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: hostKeyCallback,
 	}
 	if len(key) > 0 {
 		parsed, err := ssh.ParsePrivateKey([]byte(key))
@@ -178,51 +433,300 @@ func createSSHConfig(user, key, password string) (*ssh.ClientConfig, error) {
 func execWithTimeout(s kaginawa.SSHServer, r *kaginawa.Report, sc, tc *ssh.ClientConfig, cmd string, timeout time.Duration) ([]byte, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	ch := make(chan commandResponse, 1)
-	go func() { ch <- exec(s, r, sc, tc, cmd) }()
-	select {
-	case wrapped := <-ch:
-		if wrapped.err != nil {
-			return nil, wrapped.err
+	wrapped := exec(ctx, s, r, sc, tc, cmd)
+	if wrapped.err != nil {
+		return nil, wrapped.err
+	}
+	return wrapped.data, nil
+}
+
+// exec executes a command with combined output. The command is killed when the context is done, so that the session
+// and the pooled relay connection are released.
+func exec(ctx context.Context, s kaginawa.SSHServer, r *kaginawa.Report, sc, tc *ssh.ClientConfig, cmd string) commandResponse {
+	client, closeFn, err := connectTargetWithRetry(s, r, sc, tc)
+	if err != nil {
+		return commandResponse{err: err}
+	}
+	defer closeFn()
+	if ctx.Err() != nil {
+		return commandResponse{err: errCommandTimeout}
+	}
+
+	// Exec command
+	session, err := client.NewSession()
+	if err != nil {
+		return commandResponse{err: fmt.Errorf("failed to create ssh session: %w", err)}
+	}
+	defer func() { _ = session.Close() }() // returns io.EOF if already closed
+	done := make(chan commandResponse, 1)
+	go func() {
+		output, err := session.CombinedOutput(cmd)
+		if err != nil {
+			done <- commandResponse{err: fmt.Errorf("failed to submit ssh command: %w", err)}
+			return
 		}
-		return wrapped.data, nil
+		done <- commandResponse{data: output}
+	}()
+	select {
+	case resp := <-done:
+		return resp
 	case <-ctx.Done():
-		return nil, errors.New("timeout")
+		if err := session.Signal(ssh.SIGKILL); err != nil {
+			log.Printf("failed to kill command: %v", err)
+		}
+		_ = session.Close() // unblock output copying
+		<-done
+		return commandResponse{err: errCommandTimeout}
+	}
+}
+
+// execResult executes a command with separated stdout and stderr.
+// Non-zero exit codes and timeouts are reported in the result with the output captured so far.
+func execResult(req *commandRequest) (*commandResult, error) {
+	client, closeFn, err := connectTargetWithRetry(req.server, req.report, req.serverConfig, req.targetConfig)
+	if err != nil {
+		return nil, err
 	}
+	defer closeFn()
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ssh session: %w", err)
+	}
+	defer func() { _ = session.Close() }() // returns io.EOF if already closed
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	begin := time.Now()
+	if err := session.Start(req.command); err != nil {
+		return nil, fmt.Errorf("failed to submit ssh command: %w", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+	var exit commandExit
+	select {
+	case err := <-done:
+		exit = newCommandExit(err)
+	case <-time.After(req.timeout):
+		if err := session.Signal(ssh.SIGKILL); err != nil {
+			log.Printf("failed to kill command: %v", err)
+		}
+		_ = session.Close() // unblock output copying
+		<-done
+		exit = commandExit{ExitCode: -1, TimedOut: true, Error: "timeout"}
+	}
+	return &commandResult{
+		Stdout:     stdout.String(),
+		Stderr:     stderr.String(),
+		ExitCode:   exit.ExitCode,
+		DurationMS: time.Since(begin).Milliseconds(),
+		TimedOut:   exit.TimedOut,
+		Error:      exit.Error,
+	}, nil
 }
 
-func exec(s kaginawa.SSHServer, r *kaginawa.Report, sc, tc *ssh.ClientConfig, cmd string) commandResponse {
+// connectTargetWithRetry connects to the target node, and retries if EOF occurred.
+func connectTargetWithRetry(s kaginawa.SSHServer, r *kaginawa.Report, sc, tc *ssh.ClientConfig) (*ssh.Client, func(), error) {
+	for i := 1; ; i++ {
+		client, closeFn, err := connectTarget(s, r, sc, tc)
+		if errors.Is(err, errEOF) && i < eofRetries {
+			continue
+		}
+		return client, closeFn, err
+	}
+}
+
+// connectTarget connects to the target node through the pooled connection of the ssh server.
+// Call returned function to close the target connection.
+func connectTarget(s kaginawa.SSHServer, r *kaginawa.Report, sc, tc *ssh.ClientConfig) (*ssh.Client, func(), error) {
 	// Connect to the ssh server
-	conn, err := ssh.Dial("tcp", s.Addr(), sc)
+	conn, release, err := relayConns.acquire(s, sc)
 	if err != nil {
-		return commandResponse{err: fmt.Errorf("failed to connect remote ssh server %s: %w", s.Host, err)}
+		return nil, nil, fmt.Errorf("failed to connect remote ssh server %s: %w", s.Host, err)
 	}
-	defer safeClose(conn, "ssh server connection")
 
 	// Make a TCP connection from ssh server to target node
 	targetAddr := fmt.Sprintf("%s:%d", "localhost", r.SSHRemotePort)
 	target, err := conn.Dial("tcp", targetAddr)
+	var rejected *ssh.OpenChannelError
+	if err != nil && !errors.As(err, &rejected) {
+		// The pooled connection is broken, retry with a new connection
+		release()
+		relayConns.discard(conn)
+		conn, release, err = relayConns.acquire(s, sc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect remote ssh server %s: %w", s.Host, err)
+		}
+		target, err = conn.Dial("tcp", targetAddr)
+	}
 	if err != nil {
-		return commandResponse{err: fmt.Errorf("failed to connect target %s: %w", r.ID, err)}
+		release()
+		return nil, nil, fmt.Errorf("failed to connect target %s: %w", r.ID, err)
 	}
 	c, nc, req, err := ssh.NewClientConn(target, targetAddr, tc)
 	if err != nil {
+		release()
 		if strings.HasSuffix(err.Error(), "EOF") {
-			return commandResponse{err: errEOF}
+			return nil, nil, errEOF
 		}
-		return commandResponse{err: fmt.Errorf("failed to open target ssh connection %s: %w", r.ID, err)}
+		return nil, nil, fmt.Errorf("failed to open target ssh connection %s: %w", r.ID, err)
 	}
 	client := ssh.NewClient(c, nc, req)
-	defer safeClose(client, "ssh target connection")
+	return client, func() {
+		safeClose(client, "ssh target connection")
+		release()
+	}, nil
+}
 
-	// Exec command
+// commandExit defines the final event of a streamed command.
+type commandExit struct {
+	ExitCode int    `json:"exit_code"`
+	TimedOut bool   `json:"timed_out,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// handleCommandStream handles execute a command via ssh and streams output as server-sent events.
+// Each chunk is sent as a "stdout" or "stderr" event with a JSON string, followed by an "exit" event.
+//
+// - Method: POST
+// - Client: Browser or API
+// - Access: Admin
+// - Response: Server-sent events
+func handleCommandStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	req := parseCommandRequest(w, r)
+	if req == nil {
+		return
+	}
+	begin := time.Now()
+	client, closeFn, err := connectTargetWithRetry(req.server, req.report, req.serverConfig, req.targetConfig)
+	if err != nil {
+		recordCommand(req, commandSourceStream, newCommandExit(err), time.Since(begin), 0)
+		writeCommandError(w, err)
+		return
+	}
+	defer closeFn()
 	session, err := client.NewSession()
 	if err != nil {
-		return commandResponse{err: fmt.Errorf("failed to create ssh session: %w", err)}
+		err = fmt.Errorf("failed to create ssh session: %w", err)
+		recordCommand(req, commandSourceStream, newCommandExit(err), time.Since(begin), 0)
+		writeCommandError(w, err)
+		return
+	}
+	defer func() { _ = session.Close() }() // returns io.EOF if already closed
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		err = fmt.Errorf("failed to open stdout: %w", err)
+		recordCommand(req, commandSourceStream, newCommandExit(err), time.Since(begin), 0)
+		writeCommandError(w, err)
+		return
 	}
-	output, err := session.CombinedOutput(cmd)
+	stderr, err := session.StderrPipe()
 	if err != nil {
-		return commandResponse{err: fmt.Errorf("failed to submit ssh command: %w", err)}
+		err = fmt.Errorf("failed to open stderr: %w", err)
+		recordCommand(req, commandSourceStream, newCommandExit(err), time.Since(begin), 0)
+		writeCommandError(w, err)
+		return
+	}
+	events, err := newSSEWriter(w)
+	if err != nil {
+		recordCommand(req, commandSourceStream, newCommandExit(err), time.Since(begin), 0)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := session.Start(req.command); err != nil {
+		exit := commandExit{ExitCode: -1, Error: err.Error()}
+		recordCommand(req, commandSourceStream, exit, time.Since(begin), 0)
+		sendEvent(events, "exit", exit)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), req.timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	var stdoutSize, stderrSize int
+	wg.Add(2)
+	go streamOutput(&wg, events, "stdout", stdout, &stdoutSize)
+	go streamOutput(&wg, events, "stderr", stderr, &stderrSize)
+	done := make(chan error, 1)
+	go func() {
+		wg.Wait()
+		done <- session.Wait()
+	}()
+	var exit commandExit
+	select {
+	case err := <-done:
+		exit = newCommandExit(err)
+	case <-ctx.Done():
+		if err := session.Signal(ssh.SIGKILL); err != nil {
+			log.Printf("failed to kill command: %v", err)
+		}
+		_ = session.Close() // unblock readers
+		<-done
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			exit = commandExit{ExitCode: -1, Error: "disconnected"}
+			recordCommand(req, commandSourceStream, exit, time.Since(begin), stdoutSize+stderrSize)
+			return
+		}
+		exit = commandExit{ExitCode: -1, TimedOut: true, Error: "timeout"}
+	}
+	recordCommand(req, commandSourceStream, exit, time.Since(begin), stdoutSize+stderrSize)
+	sendEvent(events, "exit", exit)
+}
+
+// streamOutput sends chunks of the reader as events. Incomplete UTF-8 sequences are carried to the next chunk.
+// Number of read bytes is added to size.
+func streamOutput(wg *sync.WaitGroup, events *sseWriter, name string, reader io.Reader, size *int) {
+	defer wg.Done()
+	buf := make([]byte, 4096)
+	var pending []byte
+	for {
+		n, err := reader.Read(buf)
+		*size += n
+		if n > 0 {
+			chunk := append(pending, buf[:n]...)
+			cut := validUTF8Prefix(chunk)
+			pending = append([]byte(nil), chunk[cut:]...)
+			if cut > 0 {
+				sendEvent(events, name, string(chunk[:cut]))
+			}
+		}
+		if err != nil {
+			if len(pending) > 0 {
+				sendEvent(events, name, string(pending))
+			}
+			return
+		}
+	}
+}
+
+// validUTF8Prefix returns length of the chunk excluding an incomplete UTF-8 sequence at the end.
+func validUTF8Prefix(chunk []byte) int {
+	for i := len(chunk) - 1; i >= 0 && i >= len(chunk)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(chunk[i]) {
+			if !utf8.FullRune(chunk[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(chunk)
+}
+
+func newCommandExit(err error) commandExit {
+	if err == nil {
+		return commandExit{}
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return commandExit{ExitCode: exitErr.ExitStatus()}
+	}
+	return commandExit{ExitCode: -1, Error: err.Error()}
+}
+
+func sendEvent(events *sseWriter, event string, v interface{}) {
+	if err := events.send(event, v); err != nil {
+		log.Printf("failed to send %s event: %v", event, err)
 	}
-	return commandResponse{data: output}
 }
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	testSSHUser     = "kaginawa"
+	testSSHPassword = "secret"
+)
+
+// testSSHServer is an in-process ssh server acting as both a relay (direct-tcpip) and a target node (exec).
+// Supported commands: "echo <text>", "stderr <text>", "exit <code>" and "sleep <sec>".
+// Shell echoes input back until "exit" is entered.
+type testSSHServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+	mutex    sync.Mutex
+	window   [2]uint32 // cols and rows of the last window-change request
+}
+
+func newTestSSHServer(t *testing.T) *testSSHServer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == testSSHUser && string(pass) == testSSHPassword {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", c.User())
+		},
+	}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSSHServer{listener: listener, config: config}
+	go s.serve()
+	t.Cleanup(func() { safeClose(listener, "test ssh listener") })
+	return s
+}
+
+func (s *testSSHServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *testSSHServer) handleConn(conn net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for ch := range chans {
+		switch ch.ChannelType() {
+		case "session":
+			go s.handleSession(ch)
+		case "direct-tcpip":
+			go s.handleDirectTCPIP(ch)
+		default:
+			_ = ch.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+func (s *testSSHServer) handleDirectTCPIP(newCh ssh.NewChannel) {
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newCh.ExtraData(), &payload); err != nil {
+		_ = newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	target, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		_ = newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		safeClose(target, "target")
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		_, _ = io.Copy(target, ch)
+		safeClose(target, "target")
+	}()
+	_, _ = io.Copy(ch, target)
+	_ = ch.Close()
+}
+
+func (s *testSSHServer) handleSession(newCh ssh.NewChannel) {
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		return
+	}
+	defer func() { _ = ch.Close() }()
+	for req := range reqs {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			code := runTestCommand(ch, payload.Command)
+			status := make([]byte, 4)
+			binary.BigEndian.PutUint32(status, uint32(code))
+			_, _ = ch.SendRequest("exit-status", false, status)
+			return
+		case "shell":
+			_ = req.Reply(true, nil)
+			go runTestShell(ch)
+		case "subsystem":
+			var payload struct{ Name string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil || payload.Name != "sftp" {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			go func() {
+				server, err := sftp.NewServer(ch)
+				if err != nil {
+					return
+				}
+				_ = server.Serve()
+				_ = ch.Close()
+			}()
+		case "window-change":
+			var payload struct{ Cols, Rows, Width, Height uint32 }
+			if err := ssh.Unmarshal(req.Payload, &payload); err == nil {
+				s.mutex.Lock()
+				s.window = [2]uint32{payload.Cols, payload.Rows}
+				s.mutex.Unlock()
+			}
+		default:
+			if req.WantReply {
+				_ = req.Reply(req.Type == "pty-req", nil)
+			}
+		}
+	}
+}
+
+func (s *testSSHServer) windowSize() [2]uint32 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.window
+}
+
+func runTestShell(ch ssh.Channel) {
+	buf := make([]byte, 1024)
+	var line []byte
+	for {
+		n, err := ch.Read(buf)
+		if err != nil {
+			return
+		}
+		_, _ = ch.Write(buf[:n])
+		line = append(line, buf[:n]...)
+		if strings.Contains(string(line), "exit\r") {
+			_, _ = ch.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
+			_ = ch.Close()
+			return
+		}
+	}
+}
+
+func runTestCommand(ch ssh.Channel, command string) int {
+	name, arg, _ := strings.Cut(command, " ")
+	switch name {
+	case "echo":
+		_, _ = fmt.Fprintln(ch, arg)
+	case "stderr":
+		_, _ = fmt.Fprintln(ch.Stderr(), arg)
+	case "exit":
+		code, _ := strconv.Atoi(arg)
+		return code
+	case "sleep":
+		sec, _ := strconv.Atoi(arg)
+		time.Sleep(time.Duration(sec) * time.Second)
+	default:
+		_, _ = fmt.Fprintf(ch.Stderr(), "%s: command not found\n", name)
+		return 127
+	}
+	return 0
+}
+
+// setupTestRelay registers a relay server and a connected node to the database.
+func setupTestRelay(t *testing.T) (relay, target *testSSHServer) {
+	t.Helper()
+	relay = newTestSSHServer(t)
+	target = newTestSSHServer(t)
+	db = kaginawa.NewMemDB()
+	if err := db.PutSSHServer(kaginawa.SSHServer{
+		Host:     "127.0.0.1",
+		Port:     relay.port(),
+		User:     testSSHUser,
+		Password: testSSHPassword,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutReport(kaginawa.Report{
+		ID:            "node1",
+		SSHServerHost: "127.0.0.1",
+		SSHRemotePort: target.port(),
+		ServerTime:    time.Now().Unix(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:    kaginawa.HashAPIKey(testAPIKey),
+		Label:  "command key",
+		Scopes: []string{kaginawa.ScopeCommandExec},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return relay, target
+}
+
+func newTestCommandRequest(path string, form url.Values) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "http://localhost:8080"+path, strings.NewReader(form.Encode()))
+	req = mux.SetURLVars(req, map[string]string{"id": "node1"})
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "token "+testAPIKey)
+	return req
+}
+
+func TestHandleCommand(t *testing.T) {
+	setupTestRelay(t)
+	form := url.Values{"user": {testSSHUser}, "password": {testSSHPassword}, "command": {"echo hello"}}
+	w := httptest.NewRecorder()
+	handleCommand(w, newTestCommandRequest("/nodes/node1/command", form))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w.Body.String() != "hello\n" {
+		t.Errorf("expected hello, got %q", w.Body.String())
+	}
+	known, err := db.GetKnownHost("node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if known == nil {
+		t.Error("expected host key of the node trusted")
+	}
+}
+
+func TestHandleCommand_timeout(t *testing.T) {
+	relay, _ := setupTestRelay(t)
+	form := url.Values{"user": {testSSHUser}, "password": {testSSHPassword}, "command": {"sleep 3"}, "timeout": {"1"}}
+	w := httptest.NewRecorder()
+	handleCommand(w, newTestCommandRequest("/nodes/node1/command", form))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusServiceUnavailable, w.Code, w.Body.String())
+	}
+	relayConns.mutex.Lock()
+	defer relayConns.mutex.Unlock()
+	if conn, ok := relayConns.conns["127.0.0.1"]; ok && conn.refs != 0 {
+		t.Errorf("expected relay connection of port %d released, got %d refs", relay.port(), conn.refs)
+	}
+}
+
+func TestHandleCommand_json(t *testing.T) {
+	setupTestRelay(t)
+	tests := []struct {
+		command  string
+		expected commandResult
+	}{
+		{"echo hello", commandResult{Stdout: "hello\n"}},
+		{"stderr oops", commandResult{Stderr: "oops\n"}},
+		{"exit 3", commandResult{ExitCode: 3}},
+		{"sleep 3", commandResult{ExitCode: -1, TimedOut: true, Error: "timeout"}},
+	}
+	for _, test := range tests {
+		form := url.Values{
+			"user":     {testSSHUser},
+			"password": {testSSHPassword},
+			"command":  {test.command},
+			"timeout":  {"1"},
+		}
+		req := newTestCommandRequest("/nodes/node1/command", form)
+		req.Header.Set("Accept", contentTypeJSON)
+		w := httptest.NewRecorder()
+		handleCommand(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if w.Header().Get("Content-Type") != contentTypeJSON {
+			t.Errorf("expected content type %s, got %s", contentTypeJSON, w.Header().Get("Content-Type"))
+		}
+		var result commandResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if test.expected.TimedOut && result.DurationMS < 1000 {
+			t.Errorf("%s: expected duration over timeout, got %d ms", test.command, result.DurationMS)
+		}
+		result.DurationMS = 0
+		if result != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.command, test.expected, result)
+		}
+	}
+}
+
+func TestHandleCommandStream(t *testing.T) {
+	setupTestRelay(t)
+	tests := []struct {
+		command  string
+		expected []string
+	}{
+		{"echo hello", []string{"event: stdout\ndata: \"hello\\n\"", "event: exit\ndata: {\"exit_code\":0}"}},
+		{"stderr oops", []string{"event: stderr\ndata: \"oops\\n\"", "event: exit\ndata: {\"exit_code\":0}"}},
+		{"exit 3", []string{"event: exit\ndata: {\"exit_code\":3}"}},
+		{"sleep 3", []string{"event: exit\ndata: {\"exit_code\":-1,\"timed_out\":true,\"error\":\"timeout\"}"}},
+	}
+	for _, test := range tests {
+		form := url.Values{
+			"user":     {testSSHUser},
+			"password": {testSSHPassword},
+			"command":  {test.command},
+			"timeout":  {"1"},
+		}
+		w := httptest.NewRecorder()
+		handleCommandStream(w, newTestCommandRequest("/nodes/node1/command/stream", form))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if w.Header().Get("Content-Type") != contentTypeEventStream {
+			t.Errorf("expected content type %s, got %s", contentTypeEventStream, w.Header().Get("Content-Type"))
+		}
+		for _, e := range test.expected {
+			if !strings.Contains(w.Body.String(), e) {
+				t.Errorf("%s: expected %q in %q", test.command, e, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestHandleCommandStream_disconnected(t *testing.T) {
+	setupTestRelay(t)
+	form := url.Values{"user": {testSSHUser}, "password": {testSSHPassword}, "command": {"sleep 3"}, "timeout": {"60"}}
+	req := newTestCommandRequest("/nodes/node1/command/stream", form)
+	ctx, cancel := context.WithCancel(req.Context())
+	time.AfterFunc(500*time.Millisecond, cancel) // client disconnects
+	w := httptest.NewRecorder()
+	handleCommandStream(w, req.WithContext(ctx))
+	if strings.Contains(w.Body.String(), "event: exit") {
+		t.Errorf("expected no exit event to the disconnected client, got %q", w.Body.String())
+	}
+	logs, err := db.ListCommandLogs("node1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].TimedOut || logs[0].Error != "disconnected" {
+		t.Errorf("expected disconnected command log, got %+v", logs)
+	}
+}
+
+func TestValidUTF8Prefix(t *testing.T) {
+	s := []byte("あい")
+	tests := []struct {
+		in       []byte
+		expected int
+	}{
+		{s, len(s)},
+		{s[:4], 3},
+		{s[:5], 3},
+		{[]byte("abc"), 3},
+		{nil, 0},
+	}
+	for _, test := range tests {
+		if got := validUTF8Prefix(test.in); got != test.expected {
+			t.Errorf("expected validUTF8Prefix(%q) = %d, got %d", test.in, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+const (
+	reportStreamBuffer   = 16               // reports buffered for each subscriber
+	reportStreamInterval = 30 * time.Second // interval of keep-alive comments
+)
+
+var reportStream = newReportBroker()
+
+// reportBroker publishes reports accepted by this instance to subscribers of the report stream.
+type reportBroker struct {
+	mutex       sync.Mutex
+	subscribers map[*reportSubscriber]struct{}
+}
+
+// reportSubscriber defines a client of the report stream.
+type reportSubscriber struct {
+	customID string
+	nodeID   string
+	apiKey   *kaginawa.APIKey // nil for browser sessions
+	reports  chan kaginawa.Report
+}
+
+func newReportBroker() *reportBroker {
+	return &reportBroker{subscribers: make(map[*reportSubscriber]struct{})}
+}
+
+// subscribe registers the subscriber.
+func (b *reportBroker) subscribe(s *reportSubscriber) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.subscribers[s] = struct{}{}
+}
+
+// unsubscribe removes the subscriber.
+func (b *reportBroker) unsubscribe(s *reportSubscriber) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.subscribers, s)
+}
+
+// publish sends the report to all accepting subscribers without blocking.
+// Reports are dropped for subscribers whose buffers are full.
+func (b *reportBroker) publish(report kaginawa.Report) {
+	report.APIKey = "" // never expose hash of the api key
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for s := range b.subscribers {
+		if !s.accepts(report) {
+			continue
+		}
+		select {
+		case s.reports <- report:
+		default:
+			log.Printf("report stream buffer is full, dropped report of %s", report.ID)
+		}
+	}
+}
+
+// accepts checks the report matches filters and the api key of the subscriber.
+func (s *reportSubscriber) accepts(report kaginawa.Report) bool {
+	if len(s.customID) > 0 && report.CustomID != s.customID {
+		return false
+	}
+	if len(s.nodeID) > 0 && report.ID != s.nodeID {
+		return false
+	}
+	return allowsNode(s.apiKey, &report)
+}
+
+// handleReportStream handles subscriptions of reports received by this instance as server-sent events.
+// Each report is sent as a "report" event, filtered by optional "custom-id" and "node-id" parameters.
+//
+// - Method: GET
+// - Client: Browser or API
+// - Access: Admin
+// - Response: Server-sent events
+func handleReportStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	apiKey := validateAPIKey(r, kaginawa.ScopeNodesRead)
+	if apiKey == nil && !getSession(r).isLoggedIn() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	subscriber := &reportSubscriber{
+		customID: r.URL.Query().Get("custom-id"),
+		nodeID:   r.URL.Query().Get("node-id"),
+		apiKey:   apiKey,
+		reports:  make(chan kaginawa.Report, reportStreamBuffer),
+	}
+	events, err := newSSEWriter(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reportStream.subscribe(subscriber)
+	defer reportStream.unsubscribe(subscriber)
+	ticker := time.NewTicker(reportStreamInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case report := <-subscriber.reports:
+			if err := events.send("report", report); err != nil {
+				return // disconnected
+			}
+		case <-ticker.C:
+			if err := events.ping(); err != nil {
+				return // disconnected
+			}
+		}
+	}
+}
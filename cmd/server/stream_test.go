@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+func TestReportSubscriber_Accepts(t *testing.T) {
+	limited := &kaginawa.APIKey{CustomIDs: []string{"site1"}}
+	report := kaginawa.Report{ID: "node1", CustomID: "site1"}
+	for _, tc := range []struct {
+		name       string
+		subscriber reportSubscriber
+		expected   bool
+	}{
+		{"no filters", reportSubscriber{}, true},
+		{"custom id", reportSubscriber{customID: "site1"}, true},
+		{"other custom id", reportSubscriber{customID: "site2"}, false},
+		{"node id", reportSubscriber{nodeID: "node1"}, true},
+		{"other node id", reportSubscriber{nodeID: "node2"}, false},
+		{"allowed key", reportSubscriber{apiKey: limited}, true},
+		{"disallowed key", reportSubscriber{apiKey: &kaginawa.APIKey{CustomIDs: []string{"site2"}}}, false},
+	} {
+		if actual := tc.subscriber.accepts(report); actual != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+func TestHandleReportStream(t *testing.T) {
+	reportStream = newReportBroker()
+	defer func() { reportStream = newReportBroker() }()
+	sessionStore = sessions.NewCookieStore([]byte("test"))
+	db = kaginawa.NewMemDB()
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:       kaginawa.HashAPIKey(testAPIKey),
+		Label:     "dashboard",
+		Scopes:    []string{kaginawa.ScopeNodesRead},
+		CustomIDs: []string{"site1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handleReportStream))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	safeClose(resp.Body, "stream body")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected status %d without api key, got %d", http.StatusForbidden, resp.StatusCode)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"?custom-id=site1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "token "+testAPIKey)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(resp.Body, "stream body")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != contentTypeEventStream {
+		t.Fatalf("unexpected response %d: %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	for i := 0; ; i++ {
+		reportStream.mutex.Lock()
+		n := len(reportStream.subscribers)
+		reportStream.mutex.Unlock()
+		if n > 0 {
+			break
+		}
+		if i > 100 {
+			t.Fatal("subscriber not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	reportStream.publish(kaginawa.Report{ID: "node2", CustomID: "site2"}) // not allowed for the key
+	reportStream.publish(kaginawa.Report{ID: "node1", CustomID: "site1", Sequence: 3, APIKey: "hash"})
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "event: report\n" {
+		t.Fatalf("unexpected event line %q", line)
+	}
+	line, err = reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received kaginawa.Report
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &received); err != nil {
+		t.Fatal(err)
+	}
+	if received.ID != "node1" || received.Sequence != 3 {
+		t.Errorf("unexpected report %+v", received)
+	}
+	if len(received.APIKey) > 0 {
+		t.Errorf("api key hash exposed: %s", received.APIKey)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	terminalAuthTimeout     = 30 * time.Second
+	terminalReadLimit       = 64 * 1024
+	defaultTerminalCols     = 80
+	defaultTerminalRows     = 24
+	defaultTerminalIdle     = 15 * time.Minute
+	defaultTerminalDuration = 2 * time.Hour
+)
+
+var (
+	terminalIdleTimeout = defaultTerminalIdle
+	terminalMaxDuration = defaultTerminalDuration
+	terminalUpgrader    = websocket.Upgrader{} // rejects cross-origin requests by default
+)
+
+// terminalMessage defines a control message of the terminal socket.
+//
+// Client to server: "auth" (first message), "input" and "resize". Server to client: "error" and "exit".
+// Terminal output is sent as binary messages.
+type terminalMessage struct {
+	Type     string `json:"type"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	Key      string `json:"key,omitempty"`
+	Profile  string `json:"profile,omitempty"`
+	Data     string `json:"data,omitempty"`
+	Cols     int    `json:"cols,omitempty"`
+	Rows     int    `json:"rows,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+// terminalConn serializes writes to the websocket connection.
+type terminalConn struct {
+	conn    *websocket.Conn
+	mutex   sync.Mutex
+	written int // bytes of terminal output
+}
+
+// Write implements io.Writer for terminal output.
+func (c *terminalConn) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	c.written += len(p)
+	return len(p), nil
+}
+
+func (c *terminalConn) sendControl(msg terminalMessage) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.conn.WriteJSON(msg); err != nil {
+		log.Printf("failed to send terminal %s message: %v", msg.Type, err)
+	}
+}
+
+// handleTerminal handles web terminal page requests.
+//
+// - Method: GET
+// - Client: Browser
+// - Access: Admin
+// - Response: HTML
+func handleTerminal(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if len(id) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !getSession(r).isLoggedIn() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	report, err := db.GetReportByID(id)
+	if err != nil {
+		log.Printf("failed to get report %s: %v", id, err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if report == nil {
+		http.NotFound(w, r)
+		return
+	}
+	profiles, err := db.ListCredentialProfiles()
+	if err != nil {
+		log.Printf("failed to list credential profiles: %v", err)
+	}
+	assigned := ""
+	if p := kaginawa.FindAssignedProfile(profiles, *report); p != nil {
+		assigned = p.Name
+	}
+	execTemplate(w, "terminal", struct {
+		Meta            meta
+		Report          kaginawa.Report
+		IdleMinutes     int
+		Profiles        []kaginawa.CredentialProfile
+		AssignedProfile string
+	}{
+		newMeta(r, "Terminal"),
+		*report,
+		int(terminalIdleTimeout.Minutes()),
+		profiles,
+		assigned,
+	})
+}
+
+// handleTerminalSocket handles interactive shell sessions over WebSocket.
+//
+// - Method: GET (WebSocket)
+// - Client: Browser
+// - Access: Admin
+// - Response: WebSocket
+func handleTerminalSocket(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if len(id) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	session := getSession(r)
+	if !session.isLoggedIn() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	report, err := db.GetReportByID(id)
+	if err != nil {
+		log.Printf("failed to get report %s: %v", id, err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if report == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if report.SSHRemotePort < 1 {
+		http.Error(w, "SSH not connected", http.StatusServiceUnavailable)
+		return
+	}
+	server, serverConfig := resolveRelay(w, report)
+	if serverConfig == nil {
+		return
+	}
+	ws, err := terminalUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("failed to upgrade terminal connection: %v", err)
+		return // upgrader writes error response
+	}
+	defer safeClose(ws, "terminal socket")
+	ws.SetReadLimit(terminalReadLimit)
+	conn := &terminalConn{conn: ws}
+
+	// Authenticate to the node
+	var auth terminalMessage
+	if err := ws.SetReadDeadline(time.Now().Add(terminalAuthTimeout)); err != nil {
+		log.Printf("failed to set read deadline: %v", err)
+		return
+	}
+	if err := ws.ReadJSON(&auth); err != nil || auth.Type != "auth" {
+		conn.sendControl(terminalMessage{Type: "error", Message: "authentication message required"})
+		return
+	}
+	form, err := resolveCredentials(commandForm{
+		user:     auth.User,
+		password: auth.Password,
+		key:      auth.Key,
+		profile:  auth.Profile,
+	}, report)
+	if errors.Is(err, errDatabaseUnavailable) {
+		log.Print(err)
+		conn.sendControl(terminalMessage{Type: "error", Message: "Database unavailable"})
+		return
+	}
+	if err != nil {
+		conn.sendControl(terminalMessage{Type: "error", Message: err.Error()})
+		return
+	}
+	targetHostKeyCallback, err := nodeHostKeyCallback(report.ID)
+	if err != nil {
+		log.Printf("failed to get known host %s: %v", report.ID, err)
+		conn.sendControl(terminalMessage{Type: "error", Message: "Database unavailable"})
+		return
+	}
+	targetConfig, err := createSSHConfig(form.user, form.key, form.password, targetHostKeyCallback)
+	if err != nil {
+		conn.sendControl(terminalMessage{Type: "error", Message: "Invalid ssh key"})
+		return
+	}
+	req := &commandRequest{
+		id:      report.ID,
+		actor:   session.email(),
+		user:    form.user,
+		profile: form.profile,
+		command: "open terminal",
+	}
+	client, closeFn, err := connectTargetWithRetry(server, report, serverConfig, targetConfig)
+	recordCommand(req, commandSourceTerminal, newCommandExit(err), 0, 0)
+	if err != nil {
+		log.Print(err)
+		conn.sendControl(terminalMessage{Type: "error", Message: err.Error()})
+		return
+	}
+	defer closeFn()
+
+	// Start shell
+	shell, err := client.NewSession()
+	if err != nil {
+		conn.sendControl(terminalMessage{Type: "error", Message: "failed to create ssh session: " + err.Error()})
+		return
+	}
+	defer func() { _ = shell.Close() }() // returns io.EOF if already closed
+	cols, rows := terminalSize(auth.Cols, auth.Rows)
+	if err := shell.RequestPty("xterm-256color", rows, cols, ssh.TerminalModes{ssh.ECHO: 1}); err != nil {
+		conn.sendControl(terminalMessage{Type: "error", Message: "failed to request pty: " + err.Error()})
+		return
+	}
+	stdin, err := shell.StdinPipe()
+	if err != nil {
+		conn.sendControl(terminalMessage{Type: "error", Message: "failed to open stdin: " + err.Error()})
+		return
+	}
+	shell.Stdout = conn
+	shell.Stderr = conn
+	if err := shell.Shell(); err != nil {
+		conn.sendControl(terminalMessage{Type: "error", Message: "failed to start shell: " + err.Error()})
+		return
+	}
+	begin := time.Now()
+	log.Printf("TERMINAL opened by %s <%s> on %s (%s) as %s", session.name(), session.email(), report.ID,
+		report.CustomID, form.user)
+	defer func() {
+		log.Printf("TERMINAL closed by %s <%s> on %s (%s) as %s after %s", session.name(), session.email(),
+			report.ID, report.CustomID, form.user, time.Since(begin).Round(time.Second))
+	}()
+
+	// Relay input until the shell exits, the client disconnects or timeouts
+	exited := make(chan error, 1)
+	go func() { exited <- shell.Wait() }()
+	disconnected := make(chan error, 1)
+	go func() {
+		for {
+			var msg terminalMessage
+			if err := ws.SetReadDeadline(time.Now().Add(terminalIdleTimeout)); err != nil {
+				disconnected <- err
+				return
+			}
+			if err := ws.ReadJSON(&msg); err != nil {
+				disconnected <- err
+				return
+			}
+			switch msg.Type {
+			case "input":
+				if _, err := stdin.Write([]byte(msg.Data)); err != nil {
+					disconnected <- err
+					return
+				}
+			case "resize":
+				cols, rows := terminalSize(msg.Cols, msg.Rows)
+				if err := shell.WindowChange(rows, cols); err != nil {
+					log.Printf("failed to change window size: %v", err)
+				}
+			}
+		}
+	}()
+	timer := time.NewTimer(terminalMaxDuration)
+	defer timer.Stop()
+	var exit commandExit
+	select {
+	case err := <-exited:
+		exit = newCommandExit(err)
+		conn.sendControl(terminalMessage{Type: "exit", Message: exit.Error})
+	case err := <-disconnected:
+		exit = commandExit{ExitCode: -1, Error: "disconnected"}
+		if isTimeout(err) {
+			exit = commandExit{ExitCode: -1, TimedOut: true, Error: "idle timeout"}
+			conn.sendControl(terminalMessage{Type: "exit", Message: exit.Error})
+		}
+		_ = shell.Close()
+		<-exited
+	case <-timer.C:
+		exit = commandExit{ExitCode: -1, TimedOut: true, Error: "session timeout"}
+		conn.sendControl(terminalMessage{Type: "exit", Message: exit.Error})
+		_ = shell.Close()
+		<-exited
+	}
+	req.command = "close terminal"
+	conn.mutex.Lock()
+	written := conn.written
+	conn.mutex.Unlock()
+	recordCommand(req, commandSourceTerminal, exit, time.Since(begin), written)
+}
+
+// terminalSize returns valid size of the terminal.
+func terminalSize(cols, rows int) (int, int) {
+	if cols < 1 || cols > 1000 {
+		cols = defaultTerminalCols
+	}
+	if rows < 1 || rows > 1000 {
+		rows = defaultTerminalRows
+	}
+	return cols, rows
+}
+
+func isTimeout(err error) bool {
+	var t interface{ Timeout() bool }
+	return errors.As(err, &t) && t.Timeout()
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
+)
+
+func newTestTerminal(t *testing.T) *websocket.Conn {
+	t.Helper()
+	r := mux.NewRouter()
+	r.HandleFunc("/nodes/{id}/terminal/socket", handleTerminalSocket)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/nodes/node1/terminal/socket"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { safeClose(conn, "test terminal") })
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// waitTerminalLogs waits until n command logs of node1 are recorded. Returns logs newest first.
+func waitTerminalLogs(t *testing.T, n int) []kaginawa.CommandLog {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		logs, err := db.ListCommandLogs("node1", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(logs) >= n || time.Now().After(deadline) {
+			return logs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHandleTerminalSocket(t *testing.T) {
+	_, target := setupTestRelay(t)
+	sessionStore = testSessionStore{}
+	conn := newTestTerminal(t)
+	if err := conn.WriteJSON(terminalMessage{Type: "auth", User: testSSHUser, Password: testSSHPassword}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteJSON(terminalMessage{Type: "resize", Cols: 120, Rows: 40}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteJSON(terminalMessage{Type: "input", Data: "hello\r"}); err != nil {
+		t.Fatal(err)
+	}
+	var output string
+	for !strings.Contains(output, "hello") {
+		kind, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("failed to read output: %v (got %q)", err, output)
+		}
+		if kind == websocket.BinaryMessage {
+			output += string(data)
+		}
+	}
+	if size := target.windowSize(); size != [2]uint32{120, 40} {
+		t.Errorf("expected window size 120x40, got %v", size)
+	}
+	if err := conn.WriteJSON(terminalMessage{Type: "input", Data: "exit\r"}); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		kind, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("expected exit message, got %v", err)
+		}
+		if kind == websocket.TextMessage && strings.Contains(string(data), `"type":"exit"`) {
+			break
+		}
+	}
+	logs := waitTerminalLogs(t, 2)
+	if len(logs) != 2 {
+		t.Fatalf("expected open and close of the terminal recorded, got %+v", logs)
+	}
+	closed, opened := logs[0], logs[1]
+	if opened.Command != "open terminal" || opened.Source != commandSourceTerminal || opened.Actor != "test@example.com" ||
+		opened.User != testSSHUser || len(opened.Error) > 0 {
+		t.Errorf("unexpected open log: %+v", opened)
+	}
+	if closed.Command != "close terminal" || closed.ExitCode != 0 || closed.OutputBytes == 0 {
+		t.Errorf("unexpected close log: %+v", closed)
+	}
+}
+
+func TestHandleTerminalSocket_authFailure(t *testing.T) {
+	setupTestRelay(t)
+	sessionStore = testSessionStore{}
+	conn := newTestTerminal(t)
+	if err := conn.WriteJSON(terminalMessage{Type: "auth", User: testSSHUser, Password: "wrong"}); err != nil {
+		t.Fatal(err)
+	}
+	var msg terminalMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "error" || !strings.Contains(msg.Message, "unable to authenticate") {
+		t.Errorf("expected authentication error, got %+v", msg)
+	}
+	if logs := waitTerminalLogs(t, 1); len(logs) != 1 || logs[0].Command != "open terminal" || logs[0].ExitCode != -1 {
+		t.Errorf("expected failed open recorded, got %+v", logs)
+	}
+}
+
+func TestHandleTerminalSocket_idleTimeout(t *testing.T) {
+	defer func(d time.Duration) { terminalIdleTimeout = d }(terminalIdleTimeout)
+	terminalIdleTimeout = 100 * time.Millisecond
+	setupTestRelay(t)
+	sessionStore = testSessionStore{}
+	conn := newTestTerminal(t)
+	if err := conn.WriteJSON(terminalMessage{Type: "auth", User: testSSHUser, Password: testSSHPassword}); err != nil {
+		t.Fatal(err)
+	}
+	var msg terminalMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "exit" || msg.Message != "idle timeout" {
+		t.Errorf("expected idle timeout, got %+v", msg)
+	}
+	if logs := waitTerminalLogs(t, 2); len(logs) != 2 || !logs[0].TimedOut {
+		t.Errorf("expected timed out close recorded, got %+v", logs)
+	}
+}
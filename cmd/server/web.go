@@ -2,11 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime"
 	"strconv"
@@ -36,6 +38,19 @@ var (
 		"t_fresh": func(ts int64, min int) bool {
 			return time.Unix(ts, 0).After(time.Now().Add(-time.Duration(min) * time.Minute))
 		},
+		// fingerprint of authorized_keys formatted key
+		"fingerprint": fingerprint,
+		// join strings
+		"join": strings.Join,
+		// membership check of strings
+		"contains": func(list []string, s string) bool {
+			for _, v := range list {
+				if v == s {
+					return true
+				}
+			}
+			return false
+		},
 		// human-readable byte size
 		"b_fmt": func(bytes interface{}) string {
 			var b uint64
@@ -113,6 +128,20 @@ func initTemplate(dir string) {
 	}
 }
 
+// writeJSON writes the value as a JSON response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("failed to marshal response: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", contentTypeJSON)
+	if _, err := w.Write(body); err != nil {
+		log.Printf("failed to write body: %v", err)
+	}
+}
+
 func remoteIP(r *http.Request) string {
 	if header := r.Header.Get("CF-Connecting-IP"); len(header) > 0 {
 		return header
@@ -297,63 +326,23 @@ func handleNodesWeb(w http.ResponseWriter, r *http.Request) {
 	page := page(r)
 	limit := limit(r)
 	offset := (page - 1) * limit
-	customID := r.URL.Query().Get("custom-id")
-	hostname := r.URL.Query().Get("hostname")
-	globalAddr := r.URL.Query().Get("global-addr")
-	localAddr := r.URL.Query().Get("local-addr")
-	version := r.URL.Query().Get("version")
-	minutesStr := r.URL.Query().Get("minutes")
-	minutes := 0
-	var err error
-	if len(minutesStr) > 0 {
-		minutes, err = strconv.Atoi(minutesStr)
-		if err != nil {
-			http.Error(w, "Invalid parameter: minutes = "+html.EscapeString(minutesStr), http.StatusBadRequest)
-			return
-		}
+	selector, err := parseNodeSelector(r.URL.Query())
+	if err != nil {
+		http.Error(w, html.EscapeString(err.Error()), http.StatusBadRequest)
+		return
 	}
-	filtered := minutes > 0
+	filtered := selector.minutes > 0
 	var reports []kaginawa.Report
 	var count int
-	if len(customID+hostname+globalAddr+localAddr+version) == 0 {
-		reports, count, err = db.CountAndListReports(offset, limit, minutes, kaginawa.ListViewAttributes)
-		if err != nil {
-			log.Printf("failed to list reports: %v", err)
-			http.Error(w, "Database unavailable", http.StatusInternalServerError)
-			return
-		}
-	} else if len(customID) > 0 && len(hostname+globalAddr+localAddr+version) == 0 {
-		matches, err := db.ListReportsByCustomID(customID, minutes, kaginawa.ListViewAttributes)
+	if selector.empty() {
+		reports, count, err = db.CountAndListReports(offset, limit, selector.minutes, kaginawa.ListViewAttributes)
 		if err != nil {
 			log.Printf("failed to list reports: %v", err)
 			http.Error(w, "Database unavailable", http.StatusInternalServerError)
 			return
 		}
-		count = len(matches)
-		reports = kaginawa.SubReports(matches, offset, limit)
-		filtered = true
 	} else {
-		if !strings.HasPrefix(version, "v") {
-			version = "v" + version
-		}
-		matches, err := kaginawa.MatchReports(db, minutes, kaginawa.ListViewAttributes, func(r kaginawa.Report) bool {
-			if len(customID) > 0 && r.CustomID == customID {
-				return true
-			}
-			if len(hostname) > 0 && r.Hostname == hostname {
-				return true
-			}
-			if len(globalAddr) > 0 && (r.GlobalIP == globalAddr || r.GlobalHost == globalAddr) {
-				return true
-			}
-			if len(localAddr) > 0 && (r.LocalIPv4 == localAddr || r.LocalIPv6 == localAddr) {
-				return true
-			}
-			if len(version) > 0 && r.AgentVersion == version {
-				return true
-			}
-			return false
-		})
+		matches, err := selector.selectReports(kaginawa.ListViewAttributes)
 		if err != nil {
 			log.Printf("failed to list reports: %v", err)
 			http.Error(w, "Database unavailable", http.StatusInternalServerError)
@@ -376,8 +365,76 @@ func handleNodesWeb(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// nodeSelector defines filters of nodes. Nodes matching any of the specified attributes are selected.
+type nodeSelector struct {
+	customID   string
+	hostname   string
+	globalAddr string
+	localAddr  string
+	version    string
+	minutes    int
+}
+
+// parseNodeSelector parses filter parameters: custom-id, hostname, global-addr, local-addr, version and minutes.
+func parseNodeSelector(values url.Values) (nodeSelector, error) {
+	selector := nodeSelector{
+		customID:   values.Get("custom-id"),
+		hostname:   values.Get("hostname"),
+		globalAddr: values.Get("global-addr"),
+		localAddr:  values.Get("local-addr"),
+		version:    values.Get("version"),
+	}
+	if len(selector.version) > 0 && !strings.HasPrefix(selector.version, "v") {
+		selector.version = "v" + selector.version
+	}
+	if minutes := values.Get("minutes"); len(minutes) > 0 {
+		n, err := strconv.Atoi(minutes)
+		if err != nil {
+			return selector, fmt.Errorf("invalid parameter: minutes = %s", minutes)
+		}
+		selector.minutes = n
+	}
+	return selector, nil
+}
+
+// empty returns true if no attribute filters are specified.
+func (s nodeSelector) empty() bool {
+	return len(s.customID+s.hostname+s.globalAddr+s.localAddr+s.version) == 0
+}
+
+func (s nodeSelector) match(r kaginawa.Report) bool {
+	if len(s.customID) > 0 && r.CustomID == s.customID {
+		return true
+	}
+	if len(s.hostname) > 0 && r.Hostname == s.hostname {
+		return true
+	}
+	if len(s.globalAddr) > 0 && (r.GlobalIP == s.globalAddr || r.GlobalHost == s.globalAddr) {
+		return true
+	}
+	if len(s.localAddr) > 0 && (r.LocalIPv4 == s.localAddr || r.LocalIPv6 == s.localAddr) {
+		return true
+	}
+	if len(s.version) > 0 && r.AgentVersion == s.version {
+		return true
+	}
+	return false
+}
+
+// selectReports queries all reports matching the selector. All reports are selected if the selector is empty.
+func (s nodeSelector) selectReports(projection kaginawa.Projection) ([]kaginawa.Report, error) {
+	if s.empty() {
+		return db.ListReports(0, 0, s.minutes, projection)
+	}
+	if len(s.customID) > 0 && len(s.hostname+s.globalAddr+s.localAddr+s.version) == 0 {
+		return db.ListReportsByCustomID(s.customID, s.minutes, projection)
+	}
+	return kaginawa.MatchReports(db, s.minutes, projection, s.match)
+}
+
 func handleNodesAPI(w http.ResponseWriter, r *http.Request) {
-	if !validateAPIKey(r, true) {
+	apiKey := validateAPIKey(r, kaginawa.ScopeNodesRead)
+	if apiKey == nil {
 		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
@@ -422,6 +479,15 @@ func handleNodesAPI(w http.ResponseWriter, r *http.Request) {
 		}
 		reports = records
 	}
+	if len(apiKey.CustomIDs) > 0 {
+		var allowed []kaginawa.Report
+		for _, report := range reports {
+			if apiKey.AllowsCustomID(report.CustomID) {
+				allowed = append(allowed, report)
+			}
+		}
+		reports = allowed
+	}
 	if reports == nil {
 		reports = []kaginawa.Report{}
 	}
@@ -455,11 +521,11 @@ func handleNode(w http.ResponseWriter, r *http.Request) {
 	if r.Header.Get("Accept") == contentTypeJSON {
 		handleNodeAPI(w, r, id)
 	} else {
-		handleNodeWeb(w, r, id, "", "", "")
+		handleNodeWeb(w, r, id, "", "", "", "")
 	}
 }
 
-func handleNodeWeb(w http.ResponseWriter, r *http.Request, id, user, password, response string) {
+func handleNodeWeb(w http.ResponseWriter, r *http.Request, id, user, password, profile, response string) {
 	if !getSession(r).isLoggedIn() {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
@@ -470,28 +536,59 @@ func handleNodeWeb(w http.ResponseWriter, r *http.Request, id, user, password, r
 		http.Error(w, "Database unavailable", http.StatusInternalServerError)
 		return
 	}
+	var knownHost *kaginawa.KnownHost
+	if !knownHostsDisabled {
+		knownHost, err = db.GetKnownHost(id)
+		if err != nil {
+			log.Printf("failed to get known host %s: %v", id, err)
+		}
+	}
+	commandLogs, err := db.ListCommandLogs(id, nodeCommandLogsLimit)
+	if err != nil {
+		log.Printf("failed to list command logs %s: %v", id, err)
+	}
+	profiles, err := db.ListCredentialProfiles()
+	if err != nil {
+		log.Printf("failed to list credential profiles: %v", err)
+	}
+	assigned := ""
+	if p := kaginawa.FindAssignedProfile(profiles, *rep); p != nil {
+		assigned = p.Name
+	}
+	savedCommands, err := db.ListSavedCommands()
+	if err != nil {
+		log.Printf("failed to list saved commands: %v", err)
+	}
 	execTemplate(w, "node", struct {
-		Meta     meta
-		Report   kaginawa.Report
-		User     string
-		Password string
-		Response string
+		Meta            meta
+		Report          kaginawa.Report
+		User            string
+		Password        string
+		Profile         string
+		Profiles        []kaginawa.CredentialProfile
+		AssignedProfile string
+		SavedCommands   []kaginawa.SavedCommand
+		Response        string
+		KnownHost       *kaginawa.KnownHost
+		CommandLogs     []kaginawa.CommandLog
 	}{
 		newMeta(r, "Node Detail"),
 		*rep,
 		user,
 		password,
+		profile,
+		profiles,
+		assigned,
+		savedCommands,
 		response,
+		knownHost,
+		commandLogs,
 	})
 }
 
 func handleNodeAPI(w http.ResponseWriter, r *http.Request, id string) {
-	apiKey := strings.Replace(r.Header.Get("Authorization"), "token ", "", 1)
-	if len(apiKey) == 0 {
-		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-		return
-	}
-	if !validateAPIKey(r, true) {
+	apiKey := validateAPIKey(r, kaginawa.ScopeNodesRead)
+	if apiKey == nil {
 		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
@@ -501,7 +598,7 @@ func handleNodeAPI(w http.ResponseWriter, r *http.Request, id string) {
 		http.Error(w, "Database unavailable", http.StatusInternalServerError)
 		return
 	}
-	if record == nil {
+	if record == nil || !allowsNode(apiKey, record) {
 		http.NotFound(w, r)
 		return
 	}
@@ -531,6 +628,10 @@ func handleAdmin(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
+	handleAdminWeb(w, r, "")
+}
+
+func handleAdminWeb(w http.ResponseWriter, r *http.Request, newKey string) {
 	keys, err := db.ListAPIKeys()
 	if err != nil {
 		log.Printf("failed to list api keys: %v", err)
@@ -543,14 +644,94 @@ func handleAdmin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Database unavailable", http.StatusInternalServerError)
 		return
 	}
+	profiles, err := db.ListCredentialProfiles()
+	if err != nil {
+		log.Printf("failed to list credential profiles: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	savedCommands, err := db.ListSavedCommands()
+	if err != nil {
+		log.Printf("failed to list saved commands: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	notifierConfigs, err := db.ListNotifiers()
+	if err != nil {
+		log.Printf("failed to list notifiers: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	alertRules, err := db.ListAlertRules()
+	if err != nil {
+		log.Printf("failed to list alert rules: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	alerts, err := db.ListAlerts(true)
+	if err != nil {
+		log.Printf("failed to list alerts: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	reportHooks, err := db.ListReportHooks()
+	if err != nil {
+		log.Printf("failed to list report hooks: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	keyNames := make(map[string]string)
+	for _, key := range keys {
+		keyNames[key.Key] = fmt.Sprintf("%s (%s…)", key.Label, key.Prefix)
+	}
+	deadLetters := make(map[string]int)
+	for _, hook := range reportHooks {
+		count, err := db.CountDeadLetters(hook.Name)
+		if err != nil {
+			log.Printf("failed to count dead letters: %v", err)
+			http.Error(w, "Database unavailable", http.StatusInternalServerError)
+			return
+		}
+		deadLetters[hook.Name] = count
+	}
 	execTemplate(w, "admin", struct {
-		Meta       meta
-		APIKeys    []kaginawa.APIKey
-		SSHServers []kaginawa.SSHServer
+		Meta           meta
+		APIKeys        []kaginawa.APIKey
+		NewKey         string
+		Scopes         []string
+		SSHServers     []kaginawa.SSHServer
+		Healths        map[string]relayHealth
+		Profiles       []kaginawa.CredentialProfile
+		Commands       []kaginawa.SavedCommand
+		Notifiers      []kaginawa.Notifier
+		NotifierTypes  []string
+		EventTypes     []string
+		AlertRules     []kaginawa.AlertRule
+		Alerts         []kaginawa.Alert
+		AlertMetrics   []string
+		AlertOperators []string
+		ReportHooks    []kaginawa.ReportHook
+		APIKeyNames    map[string]string
+		DeadLetters    map[string]int
 	}{
 		newMeta(r, "Admin"),
 		keys,
+		newKey,
+		kaginawa.Scopes,
 		servers,
+		relayHealths.byHost(),
+		profiles,
+		savedCommands,
+		notifierConfigs,
+		kaginawa.NotifierTypes,
+		kaginawa.EventTypes,
+		alertRules,
+		alerts,
+		kaginawa.AlertMetrics,
+		kaginawa.AlertOperators,
+		reportHooks,
+		keyNames,
+		deadLetters,
 	})
 }
 
@@ -574,36 +755,22 @@ func handleInstallScript(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
-	keys, err := db.ListAPIKeys()
-	if err != nil {
-		log.Printf("failed to list api keys: %v", err)
-		http.Error(w, "Database unavailable", http.StatusInternalServerError)
-		return
-	}
-	var nonAdminKeys []kaginawa.APIKey
-	for _, v := range keys {
-		if !v.Admin {
-			nonAdminKeys = append(nonAdminKeys, v)
-		}
-	}
 	execTemplate(w, "install-script", struct {
 		Meta     meta
 		Arch     string
 		CustomID string
-		APIKeys  []kaginawa.APIKey
 		APIKey   string
 		Server   string
 	}{
 		newMeta(r, "Install Script Generator"),
 		r.FormValue("arch"),
 		strings.TrimSpace(r.FormValue("cid")),
-		nonAdminKeys,
-		r.FormValue("key"),
+		strings.TrimSpace(r.FormValue("key")),
 		strings.Split(os.Getenv("SELF_URL"), "//")[1],
 	})
 }
 
-// handleNewAPIKey handles API key creation requests.
+// handleNewAPIKey handles registration requests of an existing API key.
 //
 // - Method: POST
 // - Client: Browser
@@ -624,13 +791,16 @@ func handleNewAPIKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	k := strings.TrimSpace(r.FormValue("key"))
-	l := strings.TrimSpace(r.FormValue("label"))
-	a := r.FormValue("admin") == "yes"
 	if len(k) == 0 {
 		http.Error(w, "Key is empty", http.StatusBadRequest)
 		return
 	}
-	if err := db.PutAPIKey(kaginawa.APIKey{Key: k, Label: l, Admin: a}); err != nil {
+	apiKey, err := parseAPIKeyForm(r, k)
+	if err != nil {
+		http.Error(w, html.EscapeString(err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err := db.PutAPIKey(apiKey); err != nil {
 		log.Printf("failed to put api key: %v", err)
 		http.Error(w, "Database unavailable", http.StatusInternalServerError)
 		return
@@ -638,12 +808,116 @@ func handleNewAPIKey(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
 
-// handleGenerateKey handles API key generation requests.
+func parseAPIKeyForm(r *http.Request, raw string) (kaginawa.APIKey, error) {
+	apiKey := kaginawa.NewAPIKey(raw)
+	apiKey.Label = strings.TrimSpace(r.FormValue("label"))
+	apiKey.Scopes = r.Form["scope"]
+	if len(apiKey.Scopes) == 0 {
+		return apiKey, errors.New("scope is empty")
+	}
+	for _, scope := range apiKey.Scopes {
+		if !validScope(scope) {
+			return apiKey, fmt.Errorf("unknown scope: %s", scope)
+		}
+	}
+	apiKey.CustomIDs = splitCommaList(r.FormValue("custom-ids"))
+	if e := strings.TrimSpace(r.FormValue("expires")); len(e) > 0 {
+		date, err := time.Parse("2006-01-02", e)
+		if err != nil {
+			return apiKey, fmt.Errorf("%s is not a date", e)
+		}
+		apiKey.ExpiresAt = date.AddDate(0, 0, 1).Unix() // available until the end of the day (UTC)
+	}
+	return apiKey, nil
+}
+
+func validScope(scope string) bool {
+	for _, s := range kaginawa.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// handleDisableAPIKey handles API key revocation and re-activation requests.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleDisableAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !getSession(r).isLoggedIn() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	apiKey, err := db.GetAPIKey(r.FormValue("key"))
+	if err != nil {
+		log.Printf("failed to get api key: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if apiKey == nil {
+		http.NotFound(w, r)
+		return
+	}
+	apiKey.Disabled = r.FormValue("disabled") == "yes"
+	if err := db.PutAPIKey(*apiKey); err != nil {
+		log.Printf("failed to put api key: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleDeleteAPIKey handles API key deletion requests.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !getSession(r).isLoggedIn() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	k := r.FormValue("key")
+	if len(k) == 0 {
+		http.Error(w, "Key is empty", http.StatusBadRequest)
+		return
+	}
+	if err := db.DeleteAPIKey(k); err != nil {
+		log.Printf("failed to delete api key: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleGenerateKey handles API key generation requests. The generated key is shown only once.
 //
 // - Method: POST
 // - Client: Browser
 // - Access: Admin
-// - Response: Plain text
+// - Response: HTML
 func handleGenerateKey(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
@@ -653,15 +927,28 @@ func handleGenerateKey(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 		return
 	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
 	id, err := ksuid.NewRandom()
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
-	w.Header().Add("Content-Type", contentTypeText)
-	if _, err := w.Write([]byte(id.String())); err != nil {
-		log.Printf("failed to write body: %v", err)
+	apiKey, err := parseAPIKeyForm(r, id.String())
+	if err != nil {
+		http.Error(w, html.EscapeString(err.Error()), http.StatusBadRequest)
+		return
 	}
+	if err := db.PutAPIKey(apiKey); err != nil {
+		log.Printf("failed to put api key: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	handleAdminWeb(w, r, id.String())
 }
 
 // handleNewSSHServer handles SSH server registration requests.
@@ -684,53 +971,222 @@ func handleNewSSHServer(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid form", http.StatusBadRequest)
 		return
 	}
+	server, err := parseSSHServerForm(r, nil)
+	if err != nil {
+		http.Error(w, html.EscapeString(err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err := db.PutSSHServer(server); err != nil {
+		log.Printf("failed to put ssh server: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	refreshSSHServers()
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleEditSSHServer handles SSH server update requests. Empty key and password keep current ones.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleEditSSHServer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !getSession(r).isLoggedIn() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	current, err := db.GetSSHServerByHost(r.FormValue("original"))
+	if err != nil {
+		log.Printf("failed to get ssh server: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	if current == nil {
+		http.NotFound(w, r)
+		return
+	}
+	server, err := parseSSHServerForm(r, current)
+	if err != nil {
+		http.Error(w, html.EscapeString(err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err := replaceSSHServer(current.Host, server); err != nil {
+		log.Printf("failed to replace ssh server: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleDeleteSSHServer handles SSH server deletion requests.
+//
+// - Method: POST
+// - Client: Browser
+// - Access: Admin
+// - Response: 303 redirect
+func handleDeleteSSHServer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !getSession(r).isLoggedIn() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("failed to parse form: %v", err)
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	h := r.FormValue("host")
+	if len(h) == 0 {
+		http.Error(w, "Host is empty", http.StatusBadRequest)
+		return
+	}
+	if err := db.DeleteSSHServer(h); err != nil {
+		log.Printf("failed to delete ssh server: %v", err)
+		http.Error(w, "Database unavailable", http.StatusInternalServerError)
+		return
+	}
+	refreshSSHServers()
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// parseSSHServerForm parses ssh server form values. Empty key and password fall back to the current entry if given.
+func parseSSHServerForm(r *http.Request, current *kaginawa.SSHServer) (kaginawa.SSHServer, error) {
 	h := strings.TrimSpace(r.FormValue("host"))
 	p := strings.TrimSpace(r.FormValue("port"))
 	u := strings.TrimSpace(r.FormValue("user"))
 	k := strings.TrimSpace(r.FormValue("key"))
 	pw := strings.TrimSpace(r.FormValue("password"))
-	if len(h) == 0 {
-		http.Error(w, "Host is empty", http.StatusBadRequest)
-		return
+	if current != nil && len(k) == 0 && len(pw) == 0 {
+		k = current.Key
+		pw = current.Password
+	}
+	server := kaginawa.SSHServer{Host: h, User: u, Key: k, Password: pw}
+	if current != nil && current.Host == h {
+		server.HostKey = current.HostKey
 	}
 	port, err := strconv.Atoi(p)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("%s is not a port number", html.EscapeString(p)), http.StatusBadRequest)
+		return server, fmt.Errorf("%s is not a port number", p)
+	}
+	server.Port = port
+	if wt := strings.TrimSpace(r.FormValue("weight")); len(wt) > 0 {
+		weight, err := strconv.Atoi(wt)
+		if err != nil || weight < 0 {
+			return server, fmt.Errorf("%s is not a weight", wt)
+		}
+		server.Weight = weight
+	}
+	if err := validateSSHServer(server); err != nil {
+		return server, err
+	}
+	return server, nil
+}
+
+// validateSSHServer validates required attributes of the ssh server.
+func validateSSHServer(server kaginawa.SSHServer) error {
+	if len(server.Host) == 0 {
+		return errors.New("host is empty")
+	}
+	if server.Port < 1 || server.Port > 65535 {
+		return fmt.Errorf("%d is not a port number", server.Port)
+	}
+	if len(server.User) == 0 {
+		return errors.New("user is empty")
+	}
+	if server.Weight < 0 {
+		return errors.New("weight is negative")
+	}
+	if len(server.Key) == 0 && len(server.Password) == 0 {
+		return errors.New("key or password is empty")
+	}
+	return nil
+}
+
+// replaceSSHServer puts the server and deletes the old entry if the host is changed.
+func replaceSSHServer(oldHost string, server kaginawa.SSHServer) error {
+	defer refreshSSHServers()
+	if err := db.PutSSHServer(server); err != nil {
+		return err
+	}
+	if oldHost != server.Host {
+		return db.DeleteSSHServer(oldHost)
+	}
+	return nil
+}
+
+// refreshSSHServers reloads the ssh server cache used for report replies.
+func refreshSSHServers() {
+	servers, err := db.ListSSHServers()
+	if err != nil {
+		log.Printf("failed to refresh ssh servers: %v", err)
 		return
 	}
-	if len(u) == 0 {
-		http.Error(w, "User is empty", http.StatusBadRequest)
+	kaginawa.SetSSHServers(servers)
+	relayConns.retain(servers)
+}
+
+// handleSSHServers handles list of SSH servers requests.
+//
+// - Method: GET, HEAD
+// - Client: API
+// - Access: Admin
+// - Response: JSON
+func handleSSHServers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
 	}
-	if len(k) == 0 && len(p) == 0 {
-		http.Error(w, "Key or password is empty", http.StatusBadRequest)
+	if validateAPIKey(r, kaginawa.ScopeServersRead) == nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
-	if err := db.PutSSHServer(kaginawa.SSHServer{Host: h, Port: port, User: u, Key: k, Password: pw}); err != nil {
-		log.Printf("failed to put ssh server: %v", err)
+	servers, err := db.ListSSHServers()
+	if err != nil {
+		log.Printf("failed to list ssh servers: %v", err)
 		http.Error(w, "Database unavailable", http.StatusInternalServerError)
 		return
 	}
-	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	if servers == nil {
+		servers = []kaginawa.SSHServer{}
+	}
+	writeJSON(w, servers)
 }
 
 // handleSSHServer handles single SSH server requests.
 //
-// - Method: GET, HEAD
+// - Method: GET, HEAD, PUT or DELETE
 // - Client: API
 // - Access: Admin
-// - Response: JSON
+// - Response: JSON (GET, HEAD or PUT) or 204 no content (DELETE)
 func handleSSHServer(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 	if len(id) == 0 {
 		http.NotFound(w, r)
 		return
 	}
-	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+	scope := kaginawa.ScopeServersWrite
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		scope = kaginawa.ScopeServersRead
+	case http.MethodPut, http.MethodDelete:
+	default:
 		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
 	}
-	if !validateAPIKey(r, true) {
+	if validateAPIKey(r, scope) == nil {
 		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
@@ -740,19 +1196,50 @@ func handleSSHServer(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Database unavailable", http.StatusInternalServerError)
 		return
 	}
+	switch r.Method {
+	case http.MethodPut:
+		var updated kaginawa.SSHServer
+		if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if len(updated.Host) == 0 {
+			updated.Host = id
+		}
+		if server != nil && len(updated.Key) == 0 && len(updated.Password) == 0 {
+			updated.Key = server.Key
+			updated.Password = server.Password
+		}
+		if server != nil && len(updated.HostKey) == 0 && updated.Host == server.Host {
+			updated.HostKey = server.HostKey
+		}
+		if err := validateSSHServer(updated); err != nil {
+			http.Error(w, html.EscapeString(err.Error()), http.StatusBadRequest)
+			return
+		}
+		if err := replaceSSHServer(id, updated); err != nil {
+			log.Printf("failed to replace ssh server: %v", err)
+			http.Error(w, "Database unavailable", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, updated)
+		return
+	}
 	if server == nil {
 		http.NotFound(w, r)
 		return
 	}
-	body, err := json.Marshal(server)
-	if err != nil {
-		log.Printf("failed to marshal response: %v", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-	}
-	w.Header().Add("Content-Type", contentTypeJSON)
-	if _, err := w.Write(body); err != nil {
-		log.Printf("failed to write body: %v", err)
+	if r.Method == http.MethodDelete {
+		if err := db.DeleteSSHServer(id); err != nil {
+			log.Printf("failed to delete ssh server: %v", err)
+			http.Error(w, "Database unavailable", http.StatusInternalServerError)
+			return
+		}
+		refreshSSHServers()
+		w.WriteHeader(http.StatusNoContent)
+		return
 	}
+	writeJSON(w, server)
 }
 
 // handleHistories handles list of histories for specified node.
@@ -771,11 +1258,23 @@ func handleHistories(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
 	}
-	if !validateAPIKey(r, true) {
+	apiKey := validateAPIKey(r, kaginawa.ScopeHistoriesRead)
+	if apiKey == nil {
 		if !getSession(r).isLoggedIn() {
 			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 			return
 		}
+	} else if len(apiKey.CustomIDs) > 0 {
+		report, err := db.GetReportByID(id)
+		if err != nil {
+			log.Printf("failed to get report: %v", err)
+			http.Error(w, "Database unavailable", http.StatusInternalServerError)
+			return
+		}
+		if report == nil || !allowsNode(apiKey, report) {
+			http.NotFound(w, r)
+			return
+		}
 	}
 	end := time.Now()
 	endParam := r.URL.Query().Get("end")
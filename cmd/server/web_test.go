@@ -5,8 +5,12 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+
 	"github.com/kaginawa/kaginawa-server/internal/kaginawa"
 )
 
@@ -19,6 +23,24 @@ var (
 	}
 )
 
+// testSessionStore provides sessions of a logged-in user for testing.
+type testSessionStore struct{}
+
+func (s testSessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return s.New(r, name)
+}
+
+func (s testSessionStore) New(_ *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	session.Values["guest"] = false
+	session.Values["profile"] = map[string]interface{}{"name": "Test User", "email": "test@example.com"}
+	return session, nil
+}
+
+func (s testSessionStore) Save(*http.Request, http.ResponseWriter, *sessions.Session) error {
+	return nil
+}
+
 func TestHandleNodes_ok(t *testing.T) {
 	initTemplate("../../template")
 
@@ -27,7 +49,7 @@ func TestHandleNodes_ok(t *testing.T) {
 	if err := db.PutReport(testReport); err != nil {
 		t.Fatalf("failed to put test data: %v", err)
 	}
-	if err := db.PutAPIKey(kaginawa.APIKey{Key: testAPIKey, Label: "admin key", Admin: true}); err != nil {
+	if err := db.PutAPIKey(kaginawa.APIKey{Key: kaginawa.HashAPIKey(testAPIKey), Label: "admin key", Admin: true}); err != nil {
 		t.Fatalf("failed to put test key: %v", err)
 	}
 
@@ -64,7 +86,7 @@ func TestHandleNodes_limitedAccess(t *testing.T) {
 
 	// Prepare database
 	db = kaginawa.NewMemDB()
-	if err := db.PutAPIKey(kaginawa.APIKey{Key: testAPIKey, Label: "Test API Key"}); err != nil {
+	if err := db.PutAPIKey(kaginawa.APIKey{Key: kaginawa.HashAPIKey(testAPIKey), Label: "Test API Key"}); err != nil {
 		t.Fatalf("failed to put test key: %v", err)
 	}
 
@@ -83,3 +105,112 @@ func TestHandleNodes_limitedAccess(t *testing.T) {
 		t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, resp.StatusCode)
 	}
 }
+
+func TestHandleNodes_restrictedCustomID(t *testing.T) {
+	initTemplate("../../template")
+
+	// Prepare database
+	db = kaginawa.NewMemDB()
+	for _, report := range []kaginawa.Report{{ID: "A", CustomID: "dev1"}, {ID: "B", CustomID: "dev2"}} {
+		if err := db.PutReport(report); err != nil {
+			t.Fatalf("failed to put test data: %v", err)
+		}
+	}
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:       kaginawa.HashAPIKey(testAPIKey),
+		Label:     "restricted key",
+		Scopes:    []string{kaginawa.ScopeNodesRead},
+		CustomIDs: []string{"dev1"},
+	}); err != nil {
+		t.Fatalf("failed to put test key: %v", err)
+	}
+
+	// Build request
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/nodes", nil)
+	req.Header.Set("Accept", contentTypeJSON)
+	req.Header.Set("Authorization", "token "+testAPIKey)
+	w := httptest.NewRecorder()
+
+	// Execute
+	handleNodes(w, req)
+	resp := w.Result()
+
+	// Validate
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	defer safeClose(resp.Body, "body")
+	var result []kaginawa.Report
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(result) != 1 || result[0].ID != "A" {
+		t.Errorf("expected only node A, got %v", result)
+	}
+}
+
+func TestHandleSSHServer_putAndDelete(t *testing.T) {
+	// Prepare database
+	db = kaginawa.NewMemDB()
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:    kaginawa.HashAPIKey(testAPIKey),
+		Label:  "servers key",
+		Scopes: []string{kaginawa.ScopeServersRead, kaginawa.ScopeServersWrite},
+	}); err != nil {
+		t.Fatalf("failed to put test key: %v", err)
+	}
+	if err := db.PutSSHServer(kaginawa.SSHServer{Host: "old.example.com", Port: 22, User: "u", Password: "p"}); err != nil {
+		t.Fatalf("failed to put test server: %v", err)
+	}
+	refreshSSHServers()
+
+	// Rename host and keep current password
+	body := `{"host":"new.example.com","port":2222,"user":"u"}`
+	req := httptest.NewRequest(http.MethodPut, "http://localhost:8080/servers/old.example.com", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "old.example.com"})
+	req.Header.Set("Authorization", "token "+testAPIKey)
+	w := httptest.NewRecorder()
+	handleSSHServer(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	servers := kaginawa.SSHServers()
+	if len(servers) != 1 || servers[0].Host != "new.example.com" {
+		t.Fatalf("expected cache refreshed, got %v", servers)
+	}
+	if servers[0].Port != 2222 || servers[0].Password != "p" {
+		t.Errorf("expected updated port and kept password, got %v", servers[0])
+	}
+
+	// Delete
+	req = httptest.NewRequest(http.MethodDelete, "http://localhost:8080/servers/new.example.com", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "new.example.com"})
+	req.Header.Set("Authorization", "token "+testAPIKey)
+	w = httptest.NewRecorder()
+	handleSSHServer(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if servers := kaginawa.SSHServers(); len(servers) != 0 {
+		t.Errorf("expected empty cache, got %v", servers)
+	}
+}
+
+func TestHandleSSHServer_readOnlyKey(t *testing.T) {
+	db = kaginawa.NewMemDB()
+	if err := db.PutAPIKey(kaginawa.APIKey{
+		Key:    kaginawa.HashAPIKey(testAPIKey),
+		Label:  "read only key",
+		Scopes: []string{kaginawa.ScopeServersRead},
+	}); err != nil {
+		t.Fatalf("failed to put test key: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodDelete, "http://localhost:8080/servers/example.com", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "example.com"})
+	req.Header.Set("Authorization", "token "+testAPIKey)
+	w := httptest.NewRecorder()
+	handleSSHServer(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
@@ -0,0 +1,144 @@
+package kaginawa
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Metrics of alert rules.
+const (
+	MetricDiskUsage    = "disk_usage"    // Disk usage percentage
+	MetricRTT          = "rtt"           // Round trip time millis
+	MetricUploadKBPS   = "upload_kbps"   // Upload throughput kbps
+	MetricDownloadKBPS = "download_kbps" // Download throughput kbps
+	MetricErrors       = "errors"        // Number of errors
+)
+
+// AlertMetrics defines list of all metrics of alert rules.
+var AlertMetrics = []string{MetricDiskUsage, MetricRTT, MetricUploadKBPS, MetricDownloadKBPS, MetricErrors}
+
+// AlertOperators defines list of all comparison operators of alert rules.
+var AlertOperators = []string{">", ">=", "<", "<="}
+
+// AlertRule defines database item of a threshold rule evaluated on every received report.
+type AlertRule struct {
+	Name        string   `json:"name" bson:"name"`
+	Metric      string   `json:"metric" bson:"metric"`                     // One of the AlertMetrics
+	Operator    string   `json:"operator" bson:"operator"`                 // One of the AlertOperators
+	Threshold   float64  `json:"threshold" bson:"threshold"`               // Compared with the metric value
+	Consecutive int      `json:"consecutive,omitempty" bson:"consecutive"` // Number of reports until firing (1 if zero)
+	CustomIDs   []string `json:"custom_ids,omitempty" bson:"custom_ids"`   // Evaluated custom IDs (all if empty)
+	Enabled     bool     `json:"enabled" bson:"enabled"`                   // Disabled rules are not evaluated
+	UpdatedBy   string   `json:"updated_by,omitempty" bson:"updated_by"`   // API key label or user email
+	UpdatedAt   int64    `json:"updated_at,omitempty" bson:"updated_at"`   // Last updated time (UTC)
+}
+
+// Alert defines database item of the alert state of a rule of a node.
+type Alert struct {
+	Rule       string  `json:"rule" bson:"rule"`                         // Alert rule name
+	NodeID     string  `json:"node_id" bson:"node_id"`                   // Node ID (MAC address)
+	CustomID   string  `json:"custom_id,omitempty" bson:"custom_id"`     // Custom ID of the latest report
+	Hostname   string  `json:"hostname,omitempty" bson:"hostname"`       // Hostname of the latest report
+	Count      int     `json:"count" bson:"count"`                       // Number of consecutive exceeded reports
+	Firing     bool    `json:"firing" bson:"firing"`                     // Firing until the value goes back
+	Value      float64 `json:"value" bson:"value"`                       // Metric value of the latest report
+	FiredAt    int64   `json:"fired_at,omitempty" bson:"fired_at"`       // Last fired time (UTC)
+	ResolvedAt int64   `json:"resolved_at,omitempty" bson:"resolved_at"` // Last resolved time (UTC)
+	UpdatedAt  int64   `json:"updated_at" bson:"updated_at"`             // Last evaluated time (UTC)
+}
+
+// Validate checks metric, operator and consecutive count of the rule.
+func (r AlertRule) Validate() error {
+	if !containsString(AlertMetrics, r.Metric) {
+		return fmt.Errorf("unknown metric: %s", r.Metric)
+	}
+	if !containsString(AlertOperators, r.Operator) {
+		return fmt.Errorf("unknown operator: %s", r.Operator)
+	}
+	if r.Consecutive < 0 {
+		return fmt.Errorf("invalid consecutive count: %d", r.Consecutive)
+	}
+	return nil
+}
+
+// AppliesTo reports whether the rule is evaluated on the report.
+func (r AlertRule) AppliesTo(report Report) bool {
+	return r.Enabled && containsOrEmpty(r.CustomIDs, report.CustomID)
+}
+
+// Value extracts the metric value of the report. Returns false if the report has no measurement of the metric.
+func (r AlertRule) Value(report Report) (float64, bool) {
+	switch r.Metric {
+	case MetricDiskUsage:
+		if report.DiskTotalBytes == 0 {
+			return 0, false
+		}
+		usage := float64(report.DiskUsedBytes) / float64(report.DiskTotalBytes) * 100
+		return math.Round(usage*10) / 10, true
+	case MetricRTT:
+		return float64(report.RTTMills), report.RTTMills > 0
+	case MetricUploadKBPS:
+		return float64(report.UploadKBPS), report.UploadKBPS > 0
+	case MetricDownloadKBPS:
+		return float64(report.DownloadKBPS), report.DownloadKBPS > 0
+	case MetricErrors:
+		return float64(len(report.Errors)), true
+	default:
+		return 0, false
+	}
+}
+
+// Exceeds reports whether the value meets the condition of the rule.
+func (r AlertRule) Exceeds(value float64) bool {
+	switch r.Operator {
+	case ">":
+		return value > r.Threshold
+	case ">=":
+		return value >= r.Threshold
+	case "<":
+		return value < r.Threshold
+	case "<=":
+		return value <= r.Threshold
+	default:
+		return false
+	}
+}
+
+// RequiredCount returns number of consecutive exceeded reports until firing.
+func (r AlertRule) RequiredCount() int {
+	if r.Consecutive < 1 {
+		return 1
+	}
+	return r.Consecutive
+}
+
+// Condition formats the condition of the rule, e.g. "disk_usage > 90".
+func (r AlertRule) Condition() string {
+	return fmt.Sprintf("%s %s %s", r.Metric, r.Operator, strconv.FormatFloat(r.Threshold, 'f', -1, 64))
+}
+
+// Evaluate updates the state by the metric value of the report.
+// Returns EventAlertFiring or EventAlertResolved if the state is changed, otherwise empty.
+func (a *Alert) Evaluate(rule AlertRule, report Report, value float64, now int64) string {
+	a.CustomID = report.CustomID
+	a.Hostname = report.Hostname
+	a.Value = value
+	a.UpdatedAt = now
+	if rule.Exceeds(value) {
+		a.Count++
+		if !a.Firing && a.Count >= rule.RequiredCount() {
+			a.Firing = true
+			a.FiredAt = now
+			return EventAlertFiring
+		}
+		return ""
+	}
+	a.Count = 0
+	if a.Firing {
+		a.Firing = false
+		a.ResolvedAt = now
+		return EventAlertResolved
+	}
+	return ""
+}
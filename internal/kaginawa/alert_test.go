@@ -0,0 +1,72 @@
+package kaginawa
+
+import "testing"
+
+func TestAlertRule_Value(t *testing.T) {
+	report := Report{DiskTotalBytes: 3000, DiskUsedBytes: 2000, RTTMills: 120, Errors: []string{"e1", "e2"}}
+	tests := []struct {
+		metric   string
+		value    float64
+		measured bool
+	}{
+		{MetricDiskUsage, 66.7, true},
+		{MetricRTT, 120, true},
+		{MetricUploadKBPS, 0, false},
+		{MetricErrors, 2, true},
+		{"unknown", 0, false},
+	}
+	for _, test := range tests {
+		value, measured := AlertRule{Metric: test.metric}.Value(report)
+		if value != test.value || measured != test.measured {
+			t.Errorf("%s: expected %v (%v), got %v (%v)", test.metric, test.value, test.measured, value, measured)
+		}
+	}
+	if _, measured := (AlertRule{Metric: MetricDiskUsage}).Value(Report{}); measured {
+		t.Error("expected disk usage unavailable without disk total")
+	}
+}
+
+func TestAlertRule_Validate(t *testing.T) {
+	valid := AlertRule{Metric: MetricRTT, Operator: ">=", Threshold: 500}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, rule := range []AlertRule{
+		{Metric: "cpu", Operator: ">"},
+		{Metric: MetricRTT, Operator: "=="},
+		{Metric: MetricRTT, Operator: ">", Consecutive: -1},
+	} {
+		if err := rule.Validate(); err == nil {
+			t.Errorf("expected error for %+v", rule)
+		}
+	}
+	if c := (AlertRule{Metric: MetricDiskUsage, Operator: ">", Threshold: 90.5}).Condition(); c != "disk_usage > 90.5" {
+		t.Errorf("unexpected condition: %s", c)
+	}
+}
+
+func TestAlert_Evaluate(t *testing.T) {
+	rule := AlertRule{Name: "disk", Metric: MetricDiskUsage, Operator: ">", Threshold: 90, Consecutive: 3}
+	var alert Alert
+	for i, test := range []struct {
+		value    float64
+		expected string
+	}{
+		{95, ""},
+		{95, ""},
+		{80, ""}, // resets count
+		{95, ""},
+		{95, ""},
+		{95, EventAlertFiring},
+		{96, ""}, // deduplicated
+		{90, EventAlertResolved},
+		{85, ""},
+	} {
+		if actual := alert.Evaluate(rule, Report{}, test.value, int64(i)); actual != test.expected {
+			t.Errorf("#%d: expected %q, got %q", i, test.expected, actual)
+		}
+	}
+	if alert.Firing || alert.FiredAt != 5 || alert.ResolvedAt != 7 {
+		t.Errorf("unexpected state: %+v", alert)
+	}
+}
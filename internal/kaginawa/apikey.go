@@ -0,0 +1,222 @@
+package kaginawa
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// apiKeyCacheTTL defines lifetime of cached api keys. Other processes keep accepting disabled or deleted keys
+	// for unprivileged scopes until this duration passes, so keep it short. Expiry is checked on every validation
+	// regardless of the cache.
+	apiKeyCacheTTL = 10 * time.Second
+	// apiKeyHashPrefix marks hashed api keys. Keys without the prefix are stored in plaintext (legacy).
+	apiKeyHashPrefix = "hmac-sha256:"
+	// maxAPIKeyPrefixLength defines maximum length of the raw key prefix kept for display.
+	maxAPIKeyPrefixLength = 6
+)
+
+// APIKeySalt is a deployment-wide salt for hashing api keys. Changing it invalidates all registered keys.
+var APIKeySalt string
+
+// Scopes of api keys.
+const (
+	// ScopeReportWrite allows to submit reports (used by kaginawa agents).
+	ScopeReportWrite = "report:write"
+	// ScopeNodesRead allows to read newest reports of nodes.
+	ScopeNodesRead = "nodes:read"
+	// ScopeHistoriesRead allows to read report histories of nodes.
+	ScopeHistoriesRead = "histories:read"
+	// ScopeCommandExec allows to execute commands on nodes.
+	ScopeCommandExec = "command:exec"
+	// ScopeCommandsRead allows to read command execution logs and saved commands.
+	ScopeCommandsRead = "commands:read"
+	// ScopeCommandsWrite allows to create, update and delete saved commands.
+	ScopeCommandsWrite = "commands:write"
+	// ScopeServersRead allows to read ssh server entries including credentials.
+	ScopeServersRead = "servers:read"
+	// ScopeServersWrite allows to create, update and delete ssh server entries.
+	ScopeServersWrite = "servers:write"
+	// ScopeCredentialsRead allows to read credential profiles excluding keys and passwords.
+	ScopeCredentialsRead = "credentials:read"
+	// ScopeCredentialsWrite allows to create, update and delete credential profiles.
+	ScopeCredentialsWrite = "credentials:write"
+	// ScopeSchedulesRead allows to read scheduled commands and their runs.
+	ScopeSchedulesRead = "schedules:read"
+	// ScopeSchedulesWrite allows to create, update, delete and run scheduled commands.
+	ScopeSchedulesWrite = "schedules:write"
+	// ScopeNotifiersRead allows to read notifiers excluding signing keys and passwords.
+	ScopeNotifiersRead = "notifiers:read"
+	// ScopeNotifiersWrite allows to create, update, delete and test notifiers.
+	ScopeNotifiersWrite = "notifiers:write"
+	// ScopeAlertsRead allows to read alert rules and alert states.
+	ScopeAlertsRead = "alerts:read"
+	// ScopeAlertsWrite allows to create, update and delete alert rules.
+	ScopeAlertsWrite = "alerts:write"
+	// ScopeHooksRead allows to read report hooks and their dead letters.
+	ScopeHooksRead = "hooks:read"
+	// ScopeHooksWrite allows to create, update and delete report hooks, and to redeliver dead letters.
+	ScopeHooksWrite = "hooks:write"
+	// ScopeMetricsRead allows to scrape Prometheus metrics of the server and nodes.
+	ScopeMetricsRead = "metrics:read"
+)
+
+// Scopes defines list of all available scopes.
+var Scopes = []string{
+	ScopeReportWrite,
+	ScopeNodesRead,
+	ScopeHistoriesRead,
+	ScopeCommandExec,
+	ScopeCommandsRead,
+	ScopeCommandsWrite,
+	ScopeServersRead,
+	ScopeServersWrite,
+	ScopeCredentialsRead,
+	ScopeCredentialsWrite,
+	ScopeSchedulesRead,
+	ScopeSchedulesWrite,
+	ScopeNotifiersRead,
+	ScopeNotifiersWrite,
+	ScopeAlertsRead,
+	ScopeAlertsWrite,
+	ScopeHooksRead,
+	ScopeHooksWrite,
+	ScopeMetricsRead,
+}
+
+// APIKey defines database item of an api key.
+type APIKey struct {
+	Key       string   `bson:"key"`    // Salted hash of the raw key
+	Prefix    string   `bson:"prefix"` // Leading characters of the raw key for display
+	Label     string   `bson:"label"`
+	Admin     bool     `bson:"admin"`      // Legacy flag granting all scopes
+	Scopes    []string `bson:"scopes"`     // Granted scopes (empty means report:write only)
+	CustomIDs []string `bson:"custom_ids"` // Accessible custom IDs (empty means all)
+	Disabled  bool     `bson:"disabled"`   // Revoked by administrator
+	ExpiresAt int64    `bson:"expires_at"` // Expiration time as UTC unix timestamp (0 means never)
+}
+
+// NewAPIKey creates an api key entry from the raw key. The raw key itself is not kept.
+func NewAPIKey(raw string) APIKey {
+	n := len(raw) / 4
+	if n > maxAPIKeyPrefixLength {
+		n = maxAPIKeyPrefixLength
+	}
+	return APIKey{Key: HashAPIKey(raw), Prefix: raw[:n]}
+}
+
+// HashAPIKey calculates salted hash of the raw key.
+func HashAPIKey(raw string) string {
+	mac := hmac.New(sha256.New, []byte(APIKeySalt))
+	mac.Write([]byte(raw))
+	return apiKeyHashPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsHashed checks the key is stored as a hash or not.
+func (k APIKey) IsHashed() bool {
+	return strings.HasPrefix(k.Key, apiKeyHashPrefix)
+}
+
+// IsExpired checks the key has been expired or not.
+func (k APIKey) IsExpired() bool {
+	return k.ExpiresAt > 0 && k.ExpiresAt <= time.Now().UTC().Unix()
+}
+
+// IsActive checks the key is neither disabled nor expired.
+func (k APIKey) IsActive() bool {
+	return !k.Disabled && !k.IsExpired()
+}
+
+// HasScope checks the key is granted specified scope or not.
+func (k APIKey) HasScope(scope string) bool {
+	if k.Admin {
+		return true
+	}
+	if len(k.Scopes) == 0 {
+		return scope == ScopeReportWrite
+	}
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsCustomID checks the key is able to access nodes of specified custom ID or not.
+func (k APIKey) AllowsCustomID(customID string) bool {
+	if len(k.CustomIDs) == 0 {
+		return true
+	}
+	for _, c := range k.CustomIDs {
+		if c == customID {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrateAPIKeys replaces all plaintext api keys with hashed ones, including the keys recorded in reports and histories.
+// Returns number of migrated keys.
+func MigrateAPIKeys(db DB) (int, error) {
+	keys, err := db.ListAPIKeys()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	migrated := 0
+	for _, legacy := range keys {
+		if legacy.IsHashed() {
+			continue
+		}
+		hashed := NewAPIKey(legacy.Key)
+		hashed.Label = legacy.Label
+		hashed.Admin = legacy.Admin
+		hashed.Scopes = legacy.Scopes
+		hashed.CustomIDs = legacy.CustomIDs
+		hashed.Disabled = legacy.Disabled
+		hashed.ExpiresAt = legacy.ExpiresAt
+		if err := db.PutAPIKey(hashed); err != nil {
+			return migrated, fmt.Errorf("failed to put hashed api key %s: %w", hashed.Prefix, err)
+		}
+		if err := db.ReplaceReportAPIKey(legacy.Key, hashed.Key); err != nil {
+			return migrated, fmt.Errorf("failed to replace api key %s of reports: %w", hashed.Prefix, err)
+		}
+		if err := db.DeleteAPIKey(legacy.Key); err != nil {
+			return migrated, fmt.Errorf("failed to delete plaintext api key %s: %w", hashed.Prefix, err)
+		}
+		log.Printf("api key %s... (%s) migrated to hashed form", hashed.Prefix, hashed.Label)
+		migrated++
+	}
+	return migrated, nil
+}
+
+type cachedAPIKey struct {
+	apiKey APIKey
+	time   time.Time
+}
+
+func loadCachedAPIKey(key string) (APIKey, bool) {
+	v, ok := KnownAPIKeys.Load(key)
+	if !ok {
+		return APIKey{}, false
+	}
+	cached := v.(cachedAPIKey)
+	if time.Since(cached.time) > apiKeyCacheTTL {
+		KnownAPIKeys.Delete(key)
+		return APIKey{}, false
+	}
+	return cached.apiKey, true
+}
+
+func storeCachedAPIKey(apiKey APIKey) {
+	KnownAPIKeys.Store(apiKey.Key, cachedAPIKey{apiKey, time.Now()})
+}
+
+func forgetCachedAPIKey(key string) {
+	KnownAPIKeys.Delete(key)
+}
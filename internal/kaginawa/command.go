@@ -0,0 +1,109 @@
+package kaginawa
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// savedCommandParam matches parameter placeholders of saved commands, e.g. "{{service}}".
+var savedCommandParam = regexp.MustCompile(`{{\s*([A-Za-z_][A-Za-z0-9_]*)\s*}}`)
+
+// SavedCommand defines database item of a named command of the command library.
+type SavedCommand struct {
+	Name        string   `json:"name" bson:"name"`
+	Description string   `json:"description,omitempty" bson:"description"`
+	Command     string   `json:"command" bson:"command"`                 // Command with "{{param}}" placeholders
+	Scopes      []string `json:"scopes,omitempty" bson:"scopes"`         // API key scopes allowed to invoke besides command:exec
+	UpdatedBy   string   `json:"updated_by,omitempty" bson:"updated_by"` // API key label or user email
+	UpdatedAt   int64    `json:"updated_at,omitempty" bson:"updated_at"` // Last updated time (UTC)
+}
+
+// Params returns names of the parameters in order of appearance, without duplicates.
+func (c SavedCommand) Params() []string {
+	var params []string
+	seen := make(map[string]bool)
+	for _, match := range savedCommandParam.FindAllStringSubmatch(c.Command, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			params = append(params, match[1])
+		}
+	}
+	return params
+}
+
+// Validate reports an error if a placeholder is not a bare shell word. Values are single quoted on rendering, which
+// does not protect placeholders inside quotes, escaped by a backslash or inside here-documents.
+func (c SavedCommand) Validate() error {
+	quoted := shellQuotedPositions(c.Command)
+	for _, loc := range savedCommandParam.FindAllStringSubmatchIndex(c.Command, -1) {
+		if quoted[loc[0]] {
+			return fmt.Errorf("placeholder must not be quoted: %s", c.Command[loc[2]:loc[3]])
+		}
+	}
+	return nil
+}
+
+// Render replaces placeholders by the parameter values. Values are quoted as single shell words. Returns an error if a
+// parameter is missing or the command is not valid.
+func (c SavedCommand) Render(values map[string]string) (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+	for _, param := range c.Params() {
+		if _, ok := values[param]; !ok {
+			return "", fmt.Errorf("parameter required: %s", param)
+		}
+	}
+	return savedCommandParam.ReplaceAllStringFunc(c.Command, func(placeholder string) string {
+		return shellQuote(values[savedCommandParam.FindStringSubmatch(placeholder)[1]])
+	}), nil
+}
+
+// AllowedBy reports whether the api key without command:exec scope is allowed to invoke the command.
+func (c SavedCommand) AllowedBy(key APIKey) bool {
+	for _, scope := range c.Scopes {
+		if key.HasScope(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// shellQuotedPositions reports for each byte of the command whether it is inside single quotes, double quotes,
+// backquotes or here-documents, or escaped by a backslash.
+func shellQuotedPositions(command string) []bool {
+	quoted := make([]bool, len(command))
+	var quote byte
+	heredoc := false
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		quoted[i] = quote != 0 || heredoc
+		switch {
+		case heredoc:
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			}
+		case c == '\\':
+			if i+1 < len(command) {
+				i++
+				quoted[i] = true
+			}
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '<' && i+1 < len(command) && command[i+1] == '<':
+			heredoc = true
+		}
+	}
+	return quoted
+}
+
+// shellQuote quotes the value as a single word of POSIX shells.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,79 @@
+package kaginawa
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSavedCommand_Params(t *testing.T) {
+	command := SavedCommand{Command: "journalctl -u {{service}} -n {{ lines }} | grep {{service}}"}
+	expected := []string{"service", "lines"}
+	if params := command.Params(); !reflect.DeepEqual(params, expected) {
+		t.Errorf("expected %v, got %v", expected, params)
+	}
+	if params := (SavedCommand{Command: "uptime"}).Params(); len(params) != 0 {
+		t.Errorf("expected no params, got %v", params)
+	}
+}
+
+func TestSavedCommand_Render(t *testing.T) {
+	command := SavedCommand{Command: "journalctl -u {{service}} -n {{lines}}"}
+	tests := []struct {
+		values   map[string]string
+		expected string
+	}{
+		{map[string]string{"service": "nginx", "lines": "10"}, "journalctl -u 'nginx' -n '10'"},
+		{map[string]string{"service": "x; rm -rf /", "lines": ""}, "journalctl -u 'x; rm -rf /' -n ''"},
+		{map[string]string{"service": "it's", "lines": "$(id)"}, `journalctl -u 'it'\''s' -n '$(id)'`},
+	}
+	for _, test := range tests {
+		rendered, err := command.Render(test.values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rendered != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, rendered)
+		}
+	}
+	if _, err := command.Render(map[string]string{"service": "nginx"}); err == nil {
+		t.Error("expected error for missing parameter")
+	}
+	quoted := SavedCommand{Command: `echo "{{msg}}"`}
+	if rendered, err := quoted.Render(map[string]string{"msg": "$(id)"}); err == nil {
+		t.Errorf("expected error for quoted placeholder, got %s", rendered)
+	}
+}
+
+func TestSavedCommand_Validate(t *testing.T) {
+	tests := []struct {
+		command string
+		valid   bool
+	}{
+		{"journalctl -u {{service}} -n {{lines}}", true},
+		{"echo {{msg}}'s' \\\\{{msg}} \"a\"{{msg}} `uptime` $({{msg}})", true},
+		{`echo "{{msg}}"`, false},
+		{`echo "a \" {{msg}}"`, false},
+		{`echo '{{msg}}'`, false},
+		{"echo `{{msg}}`", false},
+		{`echo \{{msg}}`, false},
+		{"cat <<EOF\n{{msg}}\nEOF", false},
+	}
+	for _, test := range tests {
+		if err := (SavedCommand{Command: test.command}).Validate(); (err == nil) != test.valid {
+			t.Errorf("expected valid=%v for %s, got %v", test.valid, test.command, err)
+		}
+	}
+}
+
+func TestSavedCommand_AllowedBy(t *testing.T) {
+	command := SavedCommand{Scopes: []string{ScopeNodesRead}}
+	if !command.AllowedBy(APIKey{Scopes: []string{ScopeNodesRead}}) {
+		t.Error("expected allowed by nodes:read")
+	}
+	if command.AllowedBy(APIKey{Scopes: []string{ScopeHistoriesRead}}) {
+		t.Error("expected not allowed by histories:read")
+	}
+	if (SavedCommand{}).AllowedBy(APIKey{Scopes: []string{ScopeNodesRead}}) {
+		t.Error("expected not allowed without scopes")
+	}
+}
@@ -3,18 +3,30 @@ package kaginawa
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
 	// KnownAPIKeys caches known api keys on memory.
 	KnownAPIKeys sync.Map
-	// KnownAdminAPIKeys caches known admin api keys on memory.
-	KnownAdminAPIKeys sync.Map
-	// SSHServers caches list of ssh servers on memory.
-	SSHServers []SSHServer
+	// sshServers caches list of ssh servers on memory.
+	sshServers atomic.Pointer[[]SSHServer]
 )
 
+// SSHServers returns the cached list of ssh servers. Callers must not modify the returned slice.
+func SSHServers() []SSHServer {
+	if servers := sshServers.Load(); servers != nil {
+		return *servers
+	}
+	return nil
+}
+
+// SetSSHServers replaces the cached list of ssh servers.
+func SetSSHServers(servers []SSHServer) {
+	sshServers.Store(&servers)
+}
+
 // Projection defines parameter patterns of projection attributes
 type Projection int
 
@@ -31,20 +43,24 @@ const (
 
 // DB implements database operations.
 type DB interface {
-	// ValidateAPIKey validates API key. Results are ok, label and error.
-	ValidateAPIKey(key string) (bool, string, error)
-	// ValidateAdminAPIKey validates API key for admin privilege only. Results are ok, label and error.
-	ValidateAdminAPIKey(key string) (bool, string, error)
+	// ValidateAPIKey validates raw API key. Returns (nil, nil) if not found, disabled or expired.
+	ValidateAPIKey(raw string) (*APIKey, error)
 	// ListAPIKeys scans all api keys.
 	ListAPIKeys() ([]APIKey, error)
+	// GetAPIKey queries an api key by hashed key regardless of the status. Returns (nil, nil) if not found.
+	GetAPIKey(key string) (*APIKey, error)
 	// PutAPIKey puts an api key.
 	PutAPIKey(apiKey APIKey) error
+	// DeleteAPIKey deletes an api key by hashed key.
+	DeleteAPIKey(key string) error
 	// ListSSHServers scans all ssh servers.
 	ListSSHServers() ([]SSHServer, error)
 	// GetSSHServerByHost queries a server by host.
 	GetSSHServerByHost(host string) (*SSHServer, error)
 	// PutSSHServer puts ssh server entry.
 	PutSSHServer(server SSHServer) error
+	// DeleteSSHServer deletes a ssh server entry by host.
+	DeleteSSHServer(host string) error
 	// PutReport puts a report.
 	PutReport(report Report) error
 	// CountReports counts number of reports.
@@ -59,6 +75,8 @@ type DB interface {
 	ListReportsByCustomID(customID string, minutes int, projection Projection) ([]Report, error)
 	// DeleteReport deletes a report. Histories are preserved.
 	DeleteReport(id string) error
+	// ReplaceReportAPIKey replaces the api key of reports and histories, e.g. a plaintext key with its hash.
+	ReplaceReportAPIKey(from, to string) error
 	// ListHistory queries list of history.
 	ListHistory(id string, begin time.Time, end time.Time, projection Projection) ([]Report, error)
 	// GetUserSession gets a user session.
@@ -67,25 +85,194 @@ type DB interface {
 	PutUserSession(session UserSession) error
 	// DeleteUserSession deletes a user session.
 	DeleteUserSession(id string) error
+	// GetKnownHost gets a trusted host key of the node.
+	GetKnownHost(id string) (*KnownHost, error)
+	// PutKnownHost puts a trusted host key of the node.
+	PutKnownHost(host KnownHost) error
+	// DeleteKnownHost deletes a trusted host key of the node.
+	DeleteKnownHost(id string) error
+	// PutCommandLog puts a command execution log.
+	PutCommandLog(entry CommandLog) error
+	// ListCommandLogs queries command execution logs of the node, newest first. Zero limit means unlimited.
+	ListCommandLogs(nodeID string, limit int) ([]CommandLog, error)
+	// ListCredentialProfiles scans all credential profiles.
+	ListCredentialProfiles() ([]CredentialProfile, error)
+	// GetCredentialProfile queries a credential profile by name. Returns (nil, nil) if not found.
+	GetCredentialProfile(name string) (*CredentialProfile, error)
+	// PutCredentialProfile puts a credential profile.
+	PutCredentialProfile(profile CredentialProfile) error
+	// DeleteCredentialProfile deletes a credential profile by name.
+	DeleteCredentialProfile(name string) error
+	// ListSavedCommands scans all saved commands.
+	ListSavedCommands() ([]SavedCommand, error)
+	// GetSavedCommand queries a saved command by name. Returns (nil, nil) if not found.
+	GetSavedCommand(name string) (*SavedCommand, error)
+	// PutSavedCommand puts a saved command.
+	PutSavedCommand(command SavedCommand) error
+	// DeleteSavedCommand deletes a saved command by name.
+	DeleteSavedCommand(name string) error
+	// ListSchedules scans all schedules.
+	ListSchedules() ([]Schedule, error)
+	// GetSchedule queries a schedule by name. Returns (nil, nil) if not found.
+	GetSchedule(name string) (*Schedule, error)
+	// PutSchedule puts a schedule. The last run time of an existing schedule is kept so that concurrent edits do not
+	// undo ClaimScheduleRun.
+	PutSchedule(schedule Schedule) error
+	// DeleteSchedule deletes a schedule by name. Runs are preserved.
+	DeleteSchedule(name string) error
+	// ClaimScheduleRun sets the last run time of the schedule to at only if it is still last, so that only one of
+	// concurrent server instances runs the schedule. Returns false if the schedule is claimed by others or deleted.
+	ClaimScheduleRun(name string, last, at int64) (bool, error)
+	// ClaimNodeEvent records the liveness event of the node only if not recorded yet, so that only one of concurrent
+	// server instances delivers the event. Since is the server time of the last report before the node went offline.
+	ClaimNodeEvent(nodeID, eventType string, since int64) (bool, error)
+	// PutScheduleRun puts a result of a scheduled run.
+	PutScheduleRun(run ScheduleRun) error
+	// ListScheduleRuns queries runs of the schedule, newest first. Zero limit means unlimited.
+	ListScheduleRuns(schedule string, limit int) ([]ScheduleRun, error)
+	// ListNotifiers scans all notifiers.
+	ListNotifiers() ([]Notifier, error)
+	// GetNotifier queries a notifier by name. Returns (nil, nil) if not found.
+	GetNotifier(name string) (*Notifier, error)
+	// PutNotifier puts a notifier.
+	PutNotifier(notifier Notifier) error
+	// DeleteNotifier deletes a notifier by name.
+	DeleteNotifier(name string) error
+	// ListAlertRules scans all alert rules.
+	ListAlertRules() ([]AlertRule, error)
+	// GetAlertRule queries an alert rule by name. Returns (nil, nil) if not found.
+	GetAlertRule(name string) (*AlertRule, error)
+	// PutAlertRule puts an alert rule.
+	PutAlertRule(rule AlertRule) error
+	// DeleteAlertRule deletes an alert rule by name with its alert states.
+	DeleteAlertRule(name string) error
+	// ListAlerts scans alert states sorted by rule and node ID.
+	ListAlerts(firingOnly bool) ([]Alert, error)
+	// GetAlert queries the alert state of the rule of the node. Returns (nil, nil) if not found.
+	GetAlert(rule, nodeID string) (*Alert, error)
+	// PutAlert puts an alert state.
+	PutAlert(alert Alert) error
+	// DeleteAlert deletes the alert state of the rule of the node.
+	DeleteAlert(rule, nodeID string) error
+	// ListReportHooks scans all report hooks.
+	ListReportHooks() ([]ReportHook, error)
+	// GetReportHook queries a report hook by name. Returns (nil, nil) if not found.
+	GetReportHook(name string) (*ReportHook, error)
+	// PutReportHook puts a report hook.
+	PutReportHook(hook ReportHook) error
+	// DeleteReportHook deletes a report hook by name. Dead letters are preserved.
+	DeleteReportHook(name string) error
+	// PutDeadLetter puts an undelivered report of the hook.
+	PutDeadLetter(letter DeadLetter) error
+	// ListDeadLetters queries dead letters of the hook, newest first. Zero limit means unlimited.
+	ListDeadLetters(hook string, limit int) ([]DeadLetter, error)
+	// CountDeadLetters counts dead letters of the hook.
+	CountDeadLetters(hook string) (int, error)
+	// TrimDeadLetters deletes dead letters of the hook except the newest keep letters.
+	TrimDeadLetters(hook string, keep int) error
+	// GetDeadLetter queries a dead letter of the hook by ID. Returns (nil, nil) if not found.
+	GetDeadLetter(hook, id string) (*DeadLetter, error)
+	// DeleteDeadLetter deletes a dead letter of the hook by ID.
+	DeleteDeadLetter(hook, id string) error
+}
+
+// KnownHost defines database item of trusted host key of a node.
+type KnownHost struct {
+	ID        string `json:"id" bson:"id"`               // Node ID (MAC address)
+	Key       string `json:"key" bson:"key"`             // Host key (authorized_keys format)
+	Timestamp int64  `json:"timestamp" bson:"timestamp"` // First seen time (UTC)
+}
+
+// CommandLog defines database item of a command execution audit log.
+type CommandLog struct {
+	ID          string `json:"id" bson:"id"`                     // Time-ordered unique ID (KSUID)
+	NodeID      string `json:"node_id" bson:"node_id"`           // Node ID (MAC address)
+	Actor       string `json:"actor" bson:"actor"`               // API key label or user email
+	Source      string `json:"source" bson:"source"`             // Executed via: command, stream, job, schedule, upload, download or forward
+	User        string `json:"user" bson:"user"`                 // SSH user name
+	Profile     string `json:"profile,omitempty" bson:"profile"` // Credential profile name
+	Saved       string `json:"saved,omitempty" bson:"saved"`     // Saved command name
+	Command     string `json:"command" bson:"command"`           // Executed command
+	ExitCode    int    `json:"exit_code" bson:"exit_code"`       // Exit code (-1 if unknown)
+	TimedOut    bool   `json:"timed_out" bson:"timed_out"`       // Killed by timeout
+	Error       string `json:"error,omitempty" bson:"error"`     // Error message
+	DurationMS  int64  `json:"duration_ms" bson:"duration_ms"`   // Execution time in milliseconds
+	OutputBytes int    `json:"output_bytes" bson:"output_bytes"` // Size of stdout and stderr
+	Timestamp   int64  `json:"timestamp" bson:"timestamp"`       // Executed time (UTC)
 }
 
-// APIKey defines database item of an api key.
-type APIKey struct {
-	Key   string `bson:"key"`
-	Label string `bson:"label"`
-	Admin bool   `bson:"admin"`
+// CredentialProfile defines database item of named ssh credentials of target nodes.
+type CredentialProfile struct {
+	Name      string    `json:"name" bson:"name"`
+	User      string    `json:"user" bson:"user"`
+	Key       string    `json:"key,omitempty" bson:"key"`
+	Password  string    `json:"password,omitempty" bson:"password"`
+	CustomIDs []string  `json:"custom_ids,omitempty" bson:"custom_ids"`            // Assigned custom IDs
+	NodeIDs   []string  `json:"node_ids,omitempty" bson:"node_ids"`                // Assigned node IDs (MAC addresses)
+	Secret    *Envelope `json:"-" bson:"secret,omitempty" dynamodbav:",omitempty"` // Encrypted key and password
+}
+
+// AssignedTo reports whether the profile is assigned to the node or its custom id.
+func (p CredentialProfile) AssignedTo(report Report) bool {
+	for _, id := range p.NodeIDs {
+		if id == report.ID {
+			return true
+		}
+	}
+	if len(report.CustomID) == 0 {
+		return false
+	}
+	for _, id := range p.CustomIDs {
+		if id == report.CustomID {
+			return true
+		}
+	}
+	return false
+}
+
+// UsableFor reports whether the profile can be selected for the node explicitly.
+// Profiles assigned to neither node ids nor custom ids can be used for any nodes.
+func (p CredentialProfile) UsableFor(report Report) bool {
+	return (len(p.NodeIDs) == 0 && len(p.CustomIDs) == 0) || p.AssignedTo(report)
+}
+
+// FindAssignedProfile finds the credential profile assigned to the node. Assignments by node id take precedence
+// over custom id. Returns nil if not assigned.
+func FindAssignedProfile(profiles []CredentialProfile, report Report) *CredentialProfile {
+	var byCustomID *CredentialProfile
+	for i, p := range profiles {
+		for _, id := range p.NodeIDs {
+			if id == report.ID {
+				return &profiles[i]
+			}
+		}
+		if byCustomID == nil && p.AssignedTo(report) {
+			byCustomID = &profiles[i]
+		}
+	}
+	return byCustomID
 }
 
 // SSHServer defines database item of ssh server.
 type SSHServer struct {
-	Host     string `json:"host" bson:"host"`
-	Port     int    `json:"port" bson:"port"`
-	User     string `json:"user" bson:"user"`
-	Key      string `json:"key,omitempty" bson:"key"`
-	Password string `json:"password,omitempty" bson:"password"`
+	Host     string    `json:"host" bson:"host"`
+	Port     int       `json:"port" bson:"port"`
+	User     string    `json:"user" bson:"user"`
+	Key      string    `json:"key,omitempty" bson:"key"`
+	Password string    `json:"password,omitempty" bson:"password"`
+	Weight   int       `json:"weight,omitempty" bson:"weight"`                    // Weight for weighted relay selection
+	HostKey  string    `json:"host_key,omitempty" bson:"host_key"`                // Trusted host key (authorized_keys format)
+	Secret   *Envelope `json:"-" bson:"secret,omitempty" dynamodbav:",omitempty"` // Encrypted key and password
 }
 
 // Addr formats address by host:port.
 func (s SSHServer) Addr() string {
 	return fmt.Sprintf("%s:%d", s.Host, s.Port)
 }
+
+func (s SSHServer) weight() int {
+	if s.Weight < 1 {
+		return 1
+	}
+	return s.Weight
+}
@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
@@ -27,6 +29,18 @@ type DynamoDB struct {
 	nodesTable      string
 	logsTable       string
 	sessionsTable   string
+	hostsTable      string
+	commandsTable   string
+	profilesTable   string
+	savedTable      string
+	schedulesTable  string
+	runsTable       string
+	notifiersTable  string
+	rulesTable      string
+	alertsTable     string
+	hooksTable      string
+	lettersTable    string
+	eventsTable     string
 	customIDIndex   string
 	logsTTLDays     int
 	sessionsTTLDays int
@@ -55,6 +69,18 @@ func NewDynamoDB() (*DynamoDB, error) {
 	db.logsTable = os.Getenv("DYNAMO_LOGS")
 	db.sessionsTable = os.Getenv("DYNAMO_SESSIONS")
 	db.customIDIndex = os.Getenv("DYNAMO_CUSTOM_IDS")
+	db.hostsTable = os.Getenv("DYNAMO_KNOWN_HOSTS")
+	db.commandsTable = os.Getenv("DYNAMO_COMMAND_LOGS")
+	db.profilesTable = os.Getenv("DYNAMO_CREDENTIAL_PROFILES")
+	db.savedTable = os.Getenv("DYNAMO_SAVED_COMMANDS")
+	db.schedulesTable = os.Getenv("DYNAMO_SCHEDULES")
+	db.runsTable = os.Getenv("DYNAMO_SCHEDULE_RUNS")
+	db.notifiersTable = os.Getenv("DYNAMO_NOTIFIERS")
+	db.rulesTable = os.Getenv("DYNAMO_ALERT_RULES")
+	db.alertsTable = os.Getenv("DYNAMO_ALERTS")
+	db.hooksTable = os.Getenv("DYNAMO_REPORT_HOOKS")
+	db.lettersTable = os.Getenv("DYNAMO_DEAD_LETTERS")
+	db.eventsTable = os.Getenv("DYNAMO_NODE_EVENTS")
 	if len(db.keysTable) == 0 {
 		return nil, errors.New("missing env var: DYNAMO_KEYS")
 	}
@@ -91,32 +117,12 @@ func NewDynamoDB() (*DynamoDB, error) {
 }
 
 // ValidateAPIKey implements same signature of the DB interface.
-func (db *DynamoDB) ValidateAPIKey(key string) (bool, string, error) {
-	if v, ok := KnownAPIKeys.Load(key); ok {
-		return ok, v.(string), nil
-	}
-	apiKey, err := db.findAPIKey(key)
-	if err != nil {
-		return false, "", err
-	}
-	KnownAPIKeys.Store(apiKey.Key, apiKey.Label)
-	return true, apiKey.Label, nil
-}
-
-// ValidateAdminAPIKey implements same signature of the DB interface.
-func (db *DynamoDB) ValidateAdminAPIKey(key string) (bool, string, error) {
-	if v, ok := KnownAdminAPIKeys.Load(key); ok {
-		return ok, v.(string), nil
-	}
-	apiKey, err := db.findAPIKey(key)
-	if err != nil {
-		return false, "", err
-	}
-	if !apiKey.Admin {
-		return false, "", nil
+func (db *DynamoDB) ValidateAPIKey(raw string) (*APIKey, error) {
+	apiKey, err := db.findAPIKey(HashAPIKey(raw))
+	if err != nil || apiKey == nil || !apiKey.IsActive() {
+		return nil, err
 	}
-	KnownAdminAPIKeys.Store(apiKey.Key, apiKey.Label)
-	return true, apiKey.Label, nil
+	return apiKey, nil
 }
 
 // ListAPIKeys implements same signature of the DB interface.
@@ -132,10 +138,7 @@ func (db *DynamoDB) ListAPIKeys() ([]APIKey, error) {
 				continue
 			}
 			records = append(records, record)
-			KnownAPIKeys.Store(record.Key, record.Label) // update cache
-			if record.Admin {
-				KnownAdminAPIKeys.Store(record.Key, record.Label) // update cache
-			}
+			storeCachedAPIKey(record) // update cache
 		}
 		return !lastPage
 	}); err != nil {
@@ -144,19 +147,56 @@ func (db *DynamoDB) ListAPIKeys() ([]APIKey, error) {
 	return records, nil
 }
 
+// GetAPIKey implements same signature of the DB interface.
+func (db *DynamoDB) GetAPIKey(key string) (*APIKey, error) {
+	hash, err := db.encoder.Encode(struct{ Key string }{key})
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %v", err)
+	}
+	item, err := db.instance.GetItem(&dynamodb.GetItemInput{TableName: &db.keysTable, Key: hash.M})
+	if err != nil {
+		return nil, err
+	}
+	if item.Item == nil {
+		return nil, nil
+	}
+	var apiKey APIKey
+	if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item.Item}, &apiKey); err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
 // PutAPIKey implements same signature of the DB interface.
 func (db *DynamoDB) PutAPIKey(apiKey APIKey) error {
 	item, err := db.encoder.Encode(apiKey)
 	if err != nil {
 		return fmt.Errorf("failed to marshal: %w", err)
 	}
-	_, err = db.instance.PutItem(&dynamodb.PutItemInput{TableName: &db.keysTable, Item: item.M})
-	return err
+	if _, err = db.instance.PutItem(&dynamodb.PutItemInput{TableName: &db.keysTable, Item: item.M}); err != nil {
+		return err
+	}
+	forgetCachedAPIKey(apiKey.Key)
+	return nil
+}
+
+// DeleteAPIKey implements same signature of the DB interface.
+func (db *DynamoDB) DeleteAPIKey(key string) error {
+	hash, err := db.encoder.Encode(struct{ Key string }{key})
+	if err != nil {
+		return fmt.Errorf("invalid key: %v", err)
+	}
+	if _, err = db.instance.DeleteItem(&dynamodb.DeleteItemInput{TableName: &db.keysTable, Key: hash.M}); err != nil {
+		return err
+	}
+	forgetCachedAPIKey(key)
+	return nil
 }
 
 // ListSSHServers implements same signature of the DB interface.
 func (db *DynamoDB) ListSSHServers() ([]SSHServer, error) {
 	var records []SSHServer
+	var openErr error
 	if err := db.instance.ScanPages(&dynamodb.ScanInput{
 		TableName: &db.serversTable,
 	}, func(output *dynamodb.ScanOutput, lastPage bool) bool {
@@ -166,13 +206,21 @@ func (db *DynamoDB) ListSSHServers() ([]SSHServer, error) {
 				log.Printf("skipping error item: %v", err)
 				continue
 			}
-			records = append(records, record)
+			server, err := openSecrets(record)
+			if err != nil {
+				openErr = err
+				return false
+			}
+			records = append(records, server)
 		}
 		return !lastPage
 	}); err != nil {
 		return nil, err
 	}
-	SSHServers = records // update cache
+	if openErr != nil {
+		return nil, openErr
+	}
+	SetSSHServers(records) // update cache
 	return records, nil
 }
 
@@ -193,12 +241,20 @@ func (db *DynamoDB) GetSSHServerByHost(host string) (*SSHServer, error) {
 	if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item.Item}, &server); err != nil {
 		return nil, err
 	}
-	return &server, nil
+	opened, err := openSecrets(server)
+	if err != nil {
+		return nil, err
+	}
+	return &opened, nil
 }
 
 // PutSSHServer implements same signature of the DB interface.
 func (db *DynamoDB) PutSSHServer(server SSHServer) error {
-	item, err := db.encoder.Encode(server)
+	sealed, err := sealSecrets(server)
+	if err != nil {
+		return err
+	}
+	item, err := db.encoder.Encode(sealed)
 	if err != nil {
 		return fmt.Errorf("failed to marshal: %w", err)
 	}
@@ -206,6 +262,16 @@ func (db *DynamoDB) PutSSHServer(server SSHServer) error {
 	return err
 }
 
+// DeleteSSHServer implements same signature of the DB interface.
+func (db *DynamoDB) DeleteSSHServer(host string) error {
+	key, err := db.encoder.Encode(struct{ Host string }{host})
+	if err != nil {
+		return fmt.Errorf("invalid host: %v", err)
+	}
+	_, err = db.instance.DeleteItem(&dynamodb.DeleteItemInput{TableName: &db.serversTable, Key: key.M})
+	return err
+}
+
 // PutReport implements same signature of the DB interface.
 func (db *DynamoDB) PutReport(report Report) error {
 	if len(report.CustomID) == 0 {
@@ -359,6 +425,54 @@ func (db *DynamoDB) DeleteReport(id string) error {
 	return err
 }
 
+// ReplaceReportAPIKey implements same signature of the DB interface.
+// Scans the tables of nodes and logs, so it is meant for one-time migrations.
+func (db *DynamoDB) ReplaceReportAPIKey(from, to string) error {
+	tables := map[string][]string{db.nodesTable: {"ID"}, db.logsTable: {"ID", "ServerTime"}}
+	for table, keyNames := range tables {
+		projection := expression.NamesList(expression.Name(keyNames[0]))
+		for _, name := range keyNames[1:] {
+			projection = projection.AddNames(expression.Name(name))
+		}
+		expr, err := expression.NewBuilder().
+			WithFilter(expression.Name("APIKey").Equal(expression.Value(from))).
+			WithProjection(projection).
+			Build()
+		if err != nil {
+			return fmt.Errorf("failed to build expression: %w", err)
+		}
+		var keys []map[string]*dynamodb.AttributeValue
+		if err := db.instance.ScanPages(&dynamodb.ScanInput{
+			TableName:                 aws.String(table),
+			FilterExpression:          expr.Filter(),
+			ProjectionExpression:      expr.Projection(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		}, func(output *dynamodb.ScanOutput, lastPage bool) bool {
+			keys = append(keys, output.Items...)
+			return !lastPage
+		}); err != nil {
+			return err
+		}
+		update, err := expression.NewBuilder().WithUpdate(expression.Set(expression.Name("APIKey"), expression.Value(to))).Build()
+		if err != nil {
+			return fmt.Errorf("failed to build expression: %w", err)
+		}
+		for _, key := range keys {
+			if _, err := db.instance.UpdateItem(&dynamodb.UpdateItemInput{
+				TableName:                 aws.String(table),
+				Key:                       key,
+				UpdateExpression:          update.Update(),
+				ExpressionAttributeNames:  update.Names(),
+				ExpressionAttributeValues: update.Values(),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // ListHistory implements same signature of the DB interface.
 func (db *DynamoDB) ListHistory(id string, begin time.Time, end time.Time, projection Projection) ([]Report, error) {
 	keyCond := expression.Key("ID").Equal(expression.Value(id)).And(
@@ -418,74 +532,208 @@ func (db *DynamoDB) DeleteUserSession(id string) error {
 	return err
 }
 
-// SessionTTLSeconds calculates session TTL seconds.
-func (db *DynamoDB) SessionTTLSeconds() int {
-	return db.sessionsTTLDays * 24 * 60 * 60
+// GetKnownHost implements same signature of the DB interface.
+func (db *DynamoDB) GetKnownHost(id string) (*KnownHost, error) {
+	if err := requireTable(db.hostsTable, "DYNAMO_KNOWN_HOSTS"); err != nil {
+		return nil, err
+	}
+	hash, err := db.encoder.Encode(struct{ ID string }{id})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id: %v", err)
+	}
+	item, err := db.instance.GetItem(&dynamodb.GetItemInput{TableName: &db.hostsTable, Key: hash.M})
+	if err != nil {
+		return nil, err
+	}
+	if item.Item == nil {
+		return nil, nil
+	}
+	var host KnownHost
+	if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item.Item}, &host); err != nil {
+		return nil, err
+	}
+	return &host, nil
 }
 
-func (db *DynamoDB) applyProjection(builder expression.Builder, projection Projection) expression.Builder {
-	switch projection {
-	case IDAttributes:
-		return builder.WithProjection(expression.NamesList(
-			expression.Name("ID"),
-			expression.Name("CustomID"),
-			expression.Name("ServerTime"),
-			expression.Name("Success"),
-		))
-	case ListViewAttributes:
-		return builder.WithProjection(expression.NamesList(
-			expression.Name("ID"),
-			expression.Name("CustomID"),
-			expression.Name("Hostname"),
-			expression.Name("ServerTime"),
-			expression.Name("SSHServerHost"),
-			expression.Name("SSHRemotePort"),
-			expression.Name("GlobalIP"),
-			expression.Name("GlobalHost"),
-			expression.Name("LocalIPv4"),
-			expression.Name("LocalIPv6"),
-			expression.Name("Sequence"),
-			expression.Name("AgentVersion"),
-			expression.Name("Success"),
-			expression.Name("Errors"),
-		))
-	case MeasurementAttributes:
-		return builder.WithProjection(expression.NamesList(
-			expression.Name("ID"),
-			expression.Name("CustomID"),
-			expression.Name("Hostname"),
-			expression.Name("ServerTime"),
-			expression.Name("Sequence"),
-			expression.Name("RTTMills"),
-			expression.Name("UploadKBPS"),
-			expression.Name("DownloadKBPS"),
-			expression.Name("Success"),
-		))
+// PutKnownHost implements same signature of the DB interface.
+func (db *DynamoDB) PutKnownHost(host KnownHost) error {
+	if err := requireTable(db.hostsTable, "DYNAMO_KNOWN_HOSTS"); err != nil {
+		return err
 	}
-	return builder
+	item, err := db.encoder.Encode(host)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	_, err = db.instance.PutItem(&dynamodb.PutItemInput{TableName: &db.hostsTable, Item: item.M})
+	return err
 }
 
-func (db *DynamoDB) findAPIKey(key string) (APIKey, error) {
-	hash, err := db.encoder.Encode(struct{ Key string }{key})
+// DeleteKnownHost implements same signature of the DB interface.
+func (db *DynamoDB) DeleteKnownHost(id string) error {
+	if err := requireTable(db.hostsTable, "DYNAMO_KNOWN_HOSTS"); err != nil {
+		return err
+	}
+	hash, err := db.encoder.Encode(struct{ ID string }{id})
 	if err != nil {
-		return APIKey{}, fmt.Errorf("invalid key: %v", err)
+		return fmt.Errorf("invalid id: %v", err)
 	}
-	item, err := db.instance.GetItem(&dynamodb.GetItemInput{TableName: &db.keysTable, Key: hash.M})
-	if err != nil || item == nil {
-		return APIKey{}, err
+	_, err = db.instance.DeleteItem(&dynamodb.DeleteItemInput{TableName: &db.hostsTable, Key: hash.M})
+	return err
+}
+
+// PutCommandLog implements same signature of the DB interface.
+func (db *DynamoDB) PutCommandLog(entry CommandLog) error {
+	if err := requireTable(db.commandsTable, "DYNAMO_COMMAND_LOGS"); err != nil {
+		return err
 	}
-	var apiKey APIKey
-	if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item.Item}, &apiKey); err != nil {
-		return APIKey{}, err
+	item, err := db.encoder.Encode(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
 	}
-	return apiKey, nil
+	_, err = db.instance.PutItem(&dynamodb.PutItemInput{TableName: &db.commandsTable, Item: item.M})
+	return err
 }
 
-func (db *DynamoDB) queryReports(query *dynamodb.QueryInput) ([]Report, error) {
-	var records []Report
-	if err := db.instance.QueryPages(query, func(output *dynamodb.QueryOutput, lastPage bool) bool {
+// ListCommandLogs implements same signature of the DB interface.
+func (db *DynamoDB) ListCommandLogs(nodeID string, limit int) ([]CommandLog, error) {
+	if err := requireTable(db.commandsTable, "DYNAMO_COMMAND_LOGS"); err != nil {
+		return nil, err
+	}
+	expr, err := expression.NewBuilder().WithKeyCondition(expression.Key("NodeID").Equal(expression.Value(nodeID))).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+	var records []CommandLog
+	if err := db.instance.QueryPages(&dynamodb.QueryInput{
+		TableName:                 &db.commandsTable,
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ScanIndexForward:          aws.Bool(false),
+	}, func(output *dynamodb.QueryOutput, lastPage bool) bool {
 		for _, item := range output.Items {
-			var record Report
+			if limit > 0 && len(records) >= limit {
+				return false
+			}
+			var record CommandLog
+			if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item}, &record); err != nil {
+				log.Printf("skipping error item: %v", err)
+				continue
+			}
+			records = append(records, record)
+		}
+		return !lastPage && (limit == 0 || len(records) < limit)
+	}); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// ListCredentialProfiles implements same signature of the DB interface.
+// Returns no profiles if the table is not configured.
+func (db *DynamoDB) ListCredentialProfiles() ([]CredentialProfile, error) {
+	if len(db.profilesTable) == 0 {
+		return nil, nil
+	}
+	var records []CredentialProfile
+	var openErr error
+	if err := db.instance.ScanPages(&dynamodb.ScanInput{
+		TableName: &db.profilesTable,
+	}, func(output *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range output.Items {
+			var record CredentialProfile
+			if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item}, &record); err != nil {
+				log.Printf("skipping error item: %v", err)
+				continue
+			}
+			profile, err := openSecrets(record)
+			if err != nil {
+				openErr = err
+				return false
+			}
+			records = append(records, profile)
+		}
+		return !lastPage
+	}); err != nil {
+		return nil, err
+	}
+	if openErr != nil {
+		return nil, openErr
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	return records, nil
+}
+
+// GetCredentialProfile implements same signature of the DB interface.
+// Returns (nil, nil) if the table is not configured.
+func (db *DynamoDB) GetCredentialProfile(name string) (*CredentialProfile, error) {
+	if len(db.profilesTable) == 0 {
+		return nil, nil
+	}
+	hash, err := db.encoder.Encode(struct{ Name string }{name})
+	if err != nil {
+		return nil, fmt.Errorf("invalid name: %v", err)
+	}
+	item, err := db.instance.GetItem(&dynamodb.GetItemInput{TableName: &db.profilesTable, Key: hash.M})
+	if err != nil {
+		return nil, err
+	}
+	if item.Item == nil {
+		return nil, nil
+	}
+	var profile CredentialProfile
+	if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item.Item}, &profile); err != nil {
+		return nil, err
+	}
+	opened, err := openSecrets(profile)
+	if err != nil {
+		return nil, err
+	}
+	return &opened, nil
+}
+
+// PutCredentialProfile implements same signature of the DB interface.
+func (db *DynamoDB) PutCredentialProfile(profile CredentialProfile) error {
+	if err := requireTable(db.profilesTable, "DYNAMO_CREDENTIAL_PROFILES"); err != nil {
+		return err
+	}
+	sealed, err := sealSecrets(profile)
+	if err != nil {
+		return err
+	}
+	item, err := db.encoder.Encode(sealed)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	_, err = db.instance.PutItem(&dynamodb.PutItemInput{TableName: &db.profilesTable, Item: item.M})
+	return err
+}
+
+// DeleteCredentialProfile implements same signature of the DB interface.
+func (db *DynamoDB) DeleteCredentialProfile(name string) error {
+	if err := requireTable(db.profilesTable, "DYNAMO_CREDENTIAL_PROFILES"); err != nil {
+		return err
+	}
+	hash, err := db.encoder.Encode(struct{ Name string }{name})
+	if err != nil {
+		return fmt.Errorf("invalid name: %v", err)
+	}
+	_, err = db.instance.DeleteItem(&dynamodb.DeleteItemInput{TableName: &db.profilesTable, Key: hash.M})
+	return err
+}
+
+// ListSavedCommands implements same signature of the DB interface.
+// Returns no commands if the table is not configured.
+func (db *DynamoDB) ListSavedCommands() ([]SavedCommand, error) {
+	if len(db.savedTable) == 0 {
+		return nil, nil
+	}
+	var records []SavedCommand
+	if err := db.instance.ScanPages(&dynamodb.ScanInput{
+		TableName: &db.savedTable,
+	}, func(output *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range output.Items {
+			var record SavedCommand
 			if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item}, &record); err != nil {
 				log.Printf("skipping error item: %v", err)
 				continue
@@ -496,5 +744,888 @@ func (db *DynamoDB) queryReports(query *dynamodb.QueryInput) ([]Report, error) {
 	}); err != nil {
 		return nil, err
 	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
 	return records, nil
 }
+
+// GetSavedCommand implements same signature of the DB interface.
+func (db *DynamoDB) GetSavedCommand(name string) (*SavedCommand, error) {
+	if err := requireTable(db.savedTable, "DYNAMO_SAVED_COMMANDS"); err != nil {
+		return nil, err
+	}
+	hash, err := db.encoder.Encode(struct{ Name string }{name})
+	if err != nil {
+		return nil, fmt.Errorf("invalid name: %v", err)
+	}
+	item, err := db.instance.GetItem(&dynamodb.GetItemInput{TableName: &db.savedTable, Key: hash.M})
+	if err != nil {
+		return nil, err
+	}
+	if item.Item == nil {
+		return nil, nil
+	}
+	var command SavedCommand
+	if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item.Item}, &command); err != nil {
+		return nil, err
+	}
+	return &command, nil
+}
+
+// PutSavedCommand implements same signature of the DB interface.
+func (db *DynamoDB) PutSavedCommand(command SavedCommand) error {
+	if err := requireTable(db.savedTable, "DYNAMO_SAVED_COMMANDS"); err != nil {
+		return err
+	}
+	item, err := db.encoder.Encode(command)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	_, err = db.instance.PutItem(&dynamodb.PutItemInput{TableName: &db.savedTable, Item: item.M})
+	return err
+}
+
+// DeleteSavedCommand implements same signature of the DB interface.
+func (db *DynamoDB) DeleteSavedCommand(name string) error {
+	if err := requireTable(db.savedTable, "DYNAMO_SAVED_COMMANDS"); err != nil {
+		return err
+	}
+	hash, err := db.encoder.Encode(struct{ Name string }{name})
+	if err != nil {
+		return fmt.Errorf("invalid name: %v", err)
+	}
+	_, err = db.instance.DeleteItem(&dynamodb.DeleteItemInput{TableName: &db.savedTable, Key: hash.M})
+	return err
+}
+
+// ListSchedules implements same signature of the DB interface.
+// Returns no schedules if the table is not configured.
+func (db *DynamoDB) ListSchedules() ([]Schedule, error) {
+	if len(db.schedulesTable) == 0 {
+		return nil, nil
+	}
+	var records []Schedule
+	if err := db.instance.ScanPages(&dynamodb.ScanInput{
+		TableName: &db.schedulesTable,
+	}, func(output *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range output.Items {
+			var record Schedule
+			if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item}, &record); err != nil {
+				log.Printf("skipping error item: %v", err)
+				continue
+			}
+			records = append(records, record)
+		}
+		return !lastPage
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	return records, nil
+}
+
+// GetSchedule implements same signature of the DB interface.
+func (db *DynamoDB) GetSchedule(name string) (*Schedule, error) {
+	if err := requireTable(db.schedulesTable, "DYNAMO_SCHEDULES"); err != nil {
+		return nil, err
+	}
+	hash, err := db.encoder.Encode(struct{ Name string }{name})
+	if err != nil {
+		return nil, fmt.Errorf("invalid name: %v", err)
+	}
+	item, err := db.instance.GetItem(&dynamodb.GetItemInput{TableName: &db.schedulesTable, Key: hash.M})
+	if err != nil {
+		return nil, err
+	}
+	if item.Item == nil {
+		return nil, nil
+	}
+	var schedule Schedule
+	if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item.Item}, &schedule); err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// PutSchedule implements same signature of the DB interface.
+func (db *DynamoDB) PutSchedule(schedule Schedule) error {
+	if err := requireTable(db.schedulesTable, "DYNAMO_SCHEDULES"); err != nil {
+		return err
+	}
+	item, err := db.encoder.Encode(schedule)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	hash, err := db.encoder.Encode(struct{ Name string }{schedule.Name})
+	if err != nil {
+		return fmt.Errorf("invalid name: %v", err)
+	}
+	update := expression.Set(expression.Name("LastRunAt"),
+		expression.Name("LastRunAt").IfNotExists(expression.Value(schedule.LastRunAt)))
+	for name, value := range item.M {
+		if name != "Name" && name != "LastRunAt" {
+			update = update.Set(expression.Name(name), expression.Value(value))
+		}
+	}
+	expr, err := expression.NewBuilder().WithUpdate(update).Build()
+	if err != nil {
+		return fmt.Errorf("failed to build expression: %w", err)
+	}
+	_, err = db.instance.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 &db.schedulesTable,
+		Key:                       hash.M,
+		UpdateExpression:          expr.Update(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	return err
+}
+
+// DeleteSchedule implements same signature of the DB interface.
+func (db *DynamoDB) DeleteSchedule(name string) error {
+	if err := requireTable(db.schedulesTable, "DYNAMO_SCHEDULES"); err != nil {
+		return err
+	}
+	hash, err := db.encoder.Encode(struct{ Name string }{name})
+	if err != nil {
+		return fmt.Errorf("invalid name: %v", err)
+	}
+	_, err = db.instance.DeleteItem(&dynamodb.DeleteItemInput{TableName: &db.schedulesTable, Key: hash.M})
+	return err
+}
+
+// ClaimScheduleRun implements same signature of the DB interface.
+func (db *DynamoDB) ClaimScheduleRun(name string, last, at int64) (bool, error) {
+	if err := requireTable(db.schedulesTable, "DYNAMO_SCHEDULES"); err != nil {
+		return false, err
+	}
+	hash, err := db.encoder.Encode(struct{ Name string }{name})
+	if err != nil {
+		return false, fmt.Errorf("invalid name: %v", err)
+	}
+	cond := expression.AttributeExists(expression.Name("Name")).
+		And(expression.Name("LastRunAt").Equal(expression.Value(last)))
+	expr, err := expression.NewBuilder().
+		WithCondition(cond).
+		WithUpdate(expression.Set(expression.Name("LastRunAt"), expression.Value(at))).
+		Build()
+	if err != nil {
+		return false, fmt.Errorf("failed to build expression: %w", err)
+	}
+	_, err = db.instance.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 &db.schedulesTable,
+		Key:                       hash.M,
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ClaimNodeEvent implements same signature of the DB interface.
+// Claims every event if the table is not configured, e.g. running a single instance.
+func (db *DynamoDB) ClaimNodeEvent(nodeID, eventType string, since int64) (bool, error) {
+	if len(db.eventsTable) == 0 {
+		return true, nil
+	}
+	item, err := db.encoder.Encode(struct{ NodeID, Event string }{nodeID, nodeEventKey(eventType, since)})
+	if err != nil {
+		return false, fmt.Errorf("invalid event: %v", err)
+	}
+	expr, err := expression.NewBuilder().WithCondition(expression.AttributeNotExists(expression.Name("NodeID"))).Build()
+	if err != nil {
+		return false, fmt.Errorf("failed to build expression: %w", err)
+	}
+	_, err = db.instance.PutItem(&dynamodb.PutItemInput{
+		TableName:                &db.eventsTable,
+		Item:                     item.M,
+		ConditionExpression:      expr.Condition(),
+		ExpressionAttributeNames: expr.Names(),
+	})
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutScheduleRun implements same signature of the DB interface.
+func (db *DynamoDB) PutScheduleRun(run ScheduleRun) error {
+	if err := requireTable(db.runsTable, "DYNAMO_SCHEDULE_RUNS"); err != nil {
+		return err
+	}
+	item, err := db.encoder.Encode(run)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	_, err = db.instance.PutItem(&dynamodb.PutItemInput{TableName: &db.runsTable, Item: item.M})
+	return err
+}
+
+// ListScheduleRuns implements same signature of the DB interface.
+func (db *DynamoDB) ListScheduleRuns(schedule string, limit int) ([]ScheduleRun, error) {
+	if err := requireTable(db.runsTable, "DYNAMO_SCHEDULE_RUNS"); err != nil {
+		return nil, err
+	}
+	expr, err := expression.NewBuilder().WithKeyCondition(expression.Key("Schedule").Equal(expression.Value(schedule))).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+	var records []ScheduleRun
+	if err := db.instance.QueryPages(&dynamodb.QueryInput{
+		TableName:                 &db.runsTable,
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ScanIndexForward:          aws.Bool(false),
+	}, func(output *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range output.Items {
+			if limit > 0 && len(records) >= limit {
+				return false
+			}
+			var record ScheduleRun
+			if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item}, &record); err != nil {
+				log.Printf("skipping error item: %v", err)
+				continue
+			}
+			records = append(records, record)
+		}
+		return !lastPage && (limit == 0 || len(records) < limit)
+	}); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// HasKnownHosts reports whether the table of trusted host keys of nodes is configured.
+func (db *DynamoDB) HasKnownHosts() bool {
+	return len(db.hostsTable) > 0
+}
+
+// SessionTTLSeconds calculates session TTL seconds.
+func (db *DynamoDB) SessionTTLSeconds() int {
+	return db.sessionsTTLDays * 24 * 60 * 60
+}
+
+func (db *DynamoDB) applyProjection(builder expression.Builder, projection Projection) expression.Builder {
+	switch projection {
+	case IDAttributes:
+		return builder.WithProjection(expression.NamesList(
+			expression.Name("ID"),
+			expression.Name("CustomID"),
+			expression.Name("ServerTime"),
+			expression.Name("Success"),
+		))
+	case ListViewAttributes:
+		return builder.WithProjection(expression.NamesList(
+			expression.Name("ID"),
+			expression.Name("CustomID"),
+			expression.Name("Hostname"),
+			expression.Name("ServerTime"),
+			expression.Name("Trigger"),
+			expression.Name("SSHServerHost"),
+			expression.Name("SSHRemotePort"),
+			expression.Name("GlobalIP"),
+			expression.Name("GlobalHost"),
+			expression.Name("LocalIPv4"),
+			expression.Name("LocalIPv6"),
+			expression.Name("Sequence"),
+			expression.Name("AgentVersion"),
+			expression.Name("Success"),
+			expression.Name("Errors"),
+			expression.Name("DiskTotalBytes"),
+			expression.Name("DiskUsedBytes"),
+		))
+	case MeasurementAttributes:
+		return builder.WithProjection(expression.NamesList(
+			expression.Name("ID"),
+			expression.Name("CustomID"),
+			expression.Name("Hostname"),
+			expression.Name("ServerTime"),
+			expression.Name("Sequence"),
+			expression.Name("RTTMills"),
+			expression.Name("UploadKBPS"),
+			expression.Name("DownloadKBPS"),
+			expression.Name("Success"),
+		))
+	}
+	return builder
+}
+
+func (db *DynamoDB) findAPIKey(key string) (*APIKey, error) {
+	if apiKey, ok := loadCachedAPIKey(key); ok {
+		return &apiKey, nil
+	}
+	apiKey, err := db.GetAPIKey(key)
+	if err != nil || apiKey == nil {
+		return nil, err
+	}
+	storeCachedAPIKey(*apiKey)
+	return apiKey, nil
+}
+
+func (db *DynamoDB) queryReports(query *dynamodb.QueryInput) ([]Report, error) {
+	var records []Report
+	if err := db.instance.QueryPages(query, func(output *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range output.Items {
+			var record Report
+			if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item}, &record); err != nil {
+				log.Printf("skipping error item: %v", err)
+				continue
+			}
+			records = append(records, record)
+		}
+		return !lastPage
+	}); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// requireTable checks an optional table is configured.
+func requireTable(table, envName string) error {
+	if len(table) == 0 {
+		return fmt.Errorf("missing env var: %s", envName)
+	}
+	return nil
+}
+
+// ListNotifiers implements same signature of the DB interface.
+// Returns no notifiers if the table is not configured.
+func (db *DynamoDB) ListNotifiers() ([]Notifier, error) {
+	if len(db.notifiersTable) == 0 {
+		return nil, nil
+	}
+	var records []Notifier
+	var openErr error
+	if err := db.instance.ScanPages(&dynamodb.ScanInput{
+		TableName: &db.notifiersTable,
+	}, func(output *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range output.Items {
+			var record Notifier
+			if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item}, &record); err != nil {
+				log.Printf("skipping error item: %v", err)
+				continue
+			}
+			notifier, err := openSecrets(record)
+			if err != nil {
+				openErr = err
+				return false
+			}
+			records = append(records, notifier)
+		}
+		return !lastPage
+	}); err != nil {
+		return nil, err
+	}
+	if openErr != nil {
+		return nil, openErr
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	return records, nil
+}
+
+// GetNotifier implements same signature of the DB interface.
+func (db *DynamoDB) GetNotifier(name string) (*Notifier, error) {
+	if err := requireTable(db.notifiersTable, "DYNAMO_NOTIFIERS"); err != nil {
+		return nil, err
+	}
+	hash, err := db.encoder.Encode(struct{ Name string }{name})
+	if err != nil {
+		return nil, fmt.Errorf("invalid name: %v", err)
+	}
+	item, err := db.instance.GetItem(&dynamodb.GetItemInput{TableName: &db.notifiersTable, Key: hash.M})
+	if err != nil {
+		return nil, err
+	}
+	if item.Item == nil {
+		return nil, nil
+	}
+	var notifier Notifier
+	if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item.Item}, &notifier); err != nil {
+		return nil, err
+	}
+	opened, err := openSecrets(notifier)
+	if err != nil {
+		return nil, err
+	}
+	return &opened, nil
+}
+
+// PutNotifier implements same signature of the DB interface.
+func (db *DynamoDB) PutNotifier(notifier Notifier) error {
+	if err := requireTable(db.notifiersTable, "DYNAMO_NOTIFIERS"); err != nil {
+		return err
+	}
+	sealed, err := sealSecrets(notifier)
+	if err != nil {
+		return err
+	}
+	item, err := db.encoder.Encode(sealed)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	_, err = db.instance.PutItem(&dynamodb.PutItemInput{TableName: &db.notifiersTable, Item: item.M})
+	return err
+}
+
+// DeleteNotifier implements same signature of the DB interface.
+func (db *DynamoDB) DeleteNotifier(name string) error {
+	if err := requireTable(db.notifiersTable, "DYNAMO_NOTIFIERS"); err != nil {
+		return err
+	}
+	hash, err := db.encoder.Encode(struct{ Name string }{name})
+	if err != nil {
+		return fmt.Errorf("invalid name: %v", err)
+	}
+	_, err = db.instance.DeleteItem(&dynamodb.DeleteItemInput{TableName: &db.notifiersTable, Key: hash.M})
+	return err
+}
+
+// ListAlertRules implements same signature of the DB interface.
+// Returns no rules if the table is not configured.
+func (db *DynamoDB) ListAlertRules() ([]AlertRule, error) {
+	if len(db.rulesTable) == 0 {
+		return nil, nil
+	}
+	var records []AlertRule
+	if err := db.instance.ScanPages(&dynamodb.ScanInput{
+		TableName: &db.rulesTable,
+	}, func(output *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range output.Items {
+			var record AlertRule
+			if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item}, &record); err != nil {
+				log.Printf("skipping error item: %v", err)
+				continue
+			}
+			records = append(records, record)
+		}
+		return !lastPage
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	return records, nil
+}
+
+// GetAlertRule implements same signature of the DB interface.
+func (db *DynamoDB) GetAlertRule(name string) (*AlertRule, error) {
+	if err := requireTable(db.rulesTable, "DYNAMO_ALERT_RULES"); err != nil {
+		return nil, err
+	}
+	hash, err := db.encoder.Encode(struct{ Name string }{name})
+	if err != nil {
+		return nil, fmt.Errorf("invalid name: %v", err)
+	}
+	item, err := db.instance.GetItem(&dynamodb.GetItemInput{TableName: &db.rulesTable, Key: hash.M})
+	if err != nil {
+		return nil, err
+	}
+	if item.Item == nil {
+		return nil, nil
+	}
+	var rule AlertRule
+	if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item.Item}, &rule); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// PutAlertRule implements same signature of the DB interface.
+func (db *DynamoDB) PutAlertRule(rule AlertRule) error {
+	if err := requireTable(db.rulesTable, "DYNAMO_ALERT_RULES"); err != nil {
+		return err
+	}
+	item, err := db.encoder.Encode(rule)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	_, err = db.instance.PutItem(&dynamodb.PutItemInput{TableName: &db.rulesTable, Item: item.M})
+	return err
+}
+
+// DeleteAlertRule implements same signature of the DB interface.
+func (db *DynamoDB) DeleteAlertRule(name string) error {
+	if err := requireTable(db.rulesTable, "DYNAMO_ALERT_RULES"); err != nil {
+		return err
+	}
+	hash, err := db.encoder.Encode(struct{ Name string }{name})
+	if err != nil {
+		return fmt.Errorf("invalid name: %v", err)
+	}
+	if _, err := db.instance.DeleteItem(&dynamodb.DeleteItemInput{TableName: &db.rulesTable, Key: hash.M}); err != nil {
+		return err
+	}
+	if len(db.alertsTable) == 0 {
+		return nil
+	}
+	expr, err := expression.NewBuilder().WithKeyCondition(expression.Key("Rule").Equal(expression.Value(name))).Build()
+	if err != nil {
+		return fmt.Errorf("failed to build expression: %w", err)
+	}
+	var keys []map[string]*dynamodb.AttributeValue
+	if err := db.instance.QueryPages(&dynamodb.QueryInput{
+		TableName:                 &db.alertsTable,
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}, func(output *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range output.Items {
+			keys = append(keys, map[string]*dynamodb.AttributeValue{"Rule": item["Rule"], "NodeID": item["NodeID"]})
+		}
+		return !lastPage
+	}); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, err := db.instance.DeleteItem(&dynamodb.DeleteItemInput{TableName: &db.alertsTable, Key: key}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListAlerts implements same signature of the DB interface.
+// Returns no alerts if the table is not configured.
+func (db *DynamoDB) ListAlerts(firingOnly bool) ([]Alert, error) {
+	if len(db.alertsTable) == 0 {
+		return nil, nil
+	}
+	input := &dynamodb.ScanInput{TableName: &db.alertsTable}
+	if firingOnly {
+		expr, err := expression.NewBuilder().WithFilter(expression.Name("Firing").Equal(expression.Value(true))).Build()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build expression: %w", err)
+		}
+		input.FilterExpression = expr.Filter()
+		input.ExpressionAttributeNames = expr.Names()
+		input.ExpressionAttributeValues = expr.Values()
+	}
+	records := make([]Alert, 0)
+	if err := db.instance.ScanPages(input, func(output *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range output.Items {
+			var record Alert
+			if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item}, &record); err != nil {
+				log.Printf("skipping error item: %v", err)
+				continue
+			}
+			records = append(records, record)
+		}
+		return !lastPage
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Rule != records[j].Rule {
+			return records[i].Rule < records[j].Rule
+		}
+		return records[i].NodeID < records[j].NodeID
+	})
+	return records, nil
+}
+
+// GetAlert implements same signature of the DB interface.
+func (db *DynamoDB) GetAlert(rule, nodeID string) (*Alert, error) {
+	if err := requireTable(db.alertsTable, "DYNAMO_ALERTS"); err != nil {
+		return nil, err
+	}
+	key, err := db.encoder.Encode(struct{ Rule, NodeID string }{rule, nodeID})
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %v", err)
+	}
+	item, err := db.instance.GetItem(&dynamodb.GetItemInput{TableName: &db.alertsTable, Key: key.M})
+	if err != nil {
+		return nil, err
+	}
+	if item.Item == nil {
+		return nil, nil
+	}
+	var alert Alert
+	if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item.Item}, &alert); err != nil {
+		return nil, err
+	}
+	return &alert, nil
+}
+
+// PutAlert implements same signature of the DB interface.
+func (db *DynamoDB) PutAlert(alert Alert) error {
+	if err := requireTable(db.alertsTable, "DYNAMO_ALERTS"); err != nil {
+		return err
+	}
+	item, err := db.encoder.Encode(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	_, err = db.instance.PutItem(&dynamodb.PutItemInput{TableName: &db.alertsTable, Item: item.M})
+	return err
+}
+
+// DeleteAlert implements same signature of the DB interface.
+func (db *DynamoDB) DeleteAlert(rule, nodeID string) error {
+	if err := requireTable(db.alertsTable, "DYNAMO_ALERTS"); err != nil {
+		return err
+	}
+	key, err := db.encoder.Encode(struct{ Rule, NodeID string }{rule, nodeID})
+	if err != nil {
+		return fmt.Errorf("invalid key: %v", err)
+	}
+	_, err = db.instance.DeleteItem(&dynamodb.DeleteItemInput{TableName: &db.alertsTable, Key: key.M})
+	return err
+}
+
+// ListReportHooks implements same signature of the DB interface.
+// Returns no hooks if the table is not configured.
+func (db *DynamoDB) ListReportHooks() ([]ReportHook, error) {
+	if len(db.hooksTable) == 0 {
+		return nil, nil
+	}
+	var records []ReportHook
+	var openErr error
+	if err := db.instance.ScanPages(&dynamodb.ScanInput{
+		TableName: &db.hooksTable,
+	}, func(output *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range output.Items {
+			var record ReportHook
+			if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item}, &record); err != nil {
+				log.Printf("skipping error item: %v", err)
+				continue
+			}
+			hook, err := openSecrets(record)
+			if err != nil {
+				openErr = err
+				return false
+			}
+			records = append(records, hook)
+		}
+		return !lastPage
+	}); err != nil {
+		return nil, err
+	}
+	if openErr != nil {
+		return nil, openErr
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	return records, nil
+}
+
+// GetReportHook implements same signature of the DB interface.
+func (db *DynamoDB) GetReportHook(name string) (*ReportHook, error) {
+	if err := requireTable(db.hooksTable, "DYNAMO_REPORT_HOOKS"); err != nil {
+		return nil, err
+	}
+	hash, err := db.encoder.Encode(struct{ Name string }{name})
+	if err != nil {
+		return nil, fmt.Errorf("invalid name: %v", err)
+	}
+	item, err := db.instance.GetItem(&dynamodb.GetItemInput{TableName: &db.hooksTable, Key: hash.M})
+	if err != nil {
+		return nil, err
+	}
+	if item.Item == nil {
+		return nil, nil
+	}
+	var hook ReportHook
+	if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item.Item}, &hook); err != nil {
+		return nil, err
+	}
+	opened, err := openSecrets(hook)
+	if err != nil {
+		return nil, err
+	}
+	return &opened, nil
+}
+
+// PutReportHook implements same signature of the DB interface.
+func (db *DynamoDB) PutReportHook(hook ReportHook) error {
+	if err := requireTable(db.hooksTable, "DYNAMO_REPORT_HOOKS"); err != nil {
+		return err
+	}
+	sealed, err := sealSecrets(hook)
+	if err != nil {
+		return err
+	}
+	item, err := db.encoder.Encode(sealed)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	_, err = db.instance.PutItem(&dynamodb.PutItemInput{TableName: &db.hooksTable, Item: item.M})
+	return err
+}
+
+// DeleteReportHook implements same signature of the DB interface.
+func (db *DynamoDB) DeleteReportHook(name string) error {
+	if err := requireTable(db.hooksTable, "DYNAMO_REPORT_HOOKS"); err != nil {
+		return err
+	}
+	hash, err := db.encoder.Encode(struct{ Name string }{name})
+	if err != nil {
+		return fmt.Errorf("invalid name: %v", err)
+	}
+	_, err = db.instance.DeleteItem(&dynamodb.DeleteItemInput{TableName: &db.hooksTable, Key: hash.M})
+	return err
+}
+
+// PutDeadLetter implements same signature of the DB interface.
+func (db *DynamoDB) PutDeadLetter(letter DeadLetter) error {
+	if err := requireTable(db.lettersTable, "DYNAMO_DEAD_LETTERS"); err != nil {
+		return err
+	}
+	item, err := db.encoder.Encode(letter)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	_, err = db.instance.PutItem(&dynamodb.PutItemInput{TableName: &db.lettersTable, Item: item.M})
+	return err
+}
+
+// ListDeadLetters implements same signature of the DB interface.
+// Returns no dead letters if the table is not configured.
+func (db *DynamoDB) ListDeadLetters(hook string, limit int) ([]DeadLetter, error) {
+	if len(db.lettersTable) == 0 {
+		return nil, nil
+	}
+	expr, err := expression.NewBuilder().WithKeyCondition(expression.Key("Hook").Equal(expression.Value(hook))).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build expression: %w", err)
+	}
+	var records []DeadLetter
+	if err := db.instance.QueryPages(&dynamodb.QueryInput{
+		TableName:                 &db.lettersTable,
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ScanIndexForward:          aws.Bool(false),
+	}, func(output *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range output.Items {
+			if limit > 0 && len(records) >= limit {
+				return false
+			}
+			var record DeadLetter
+			if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item}, &record); err != nil {
+				log.Printf("skipping error item: %v", err)
+				continue
+			}
+			records = append(records, record)
+		}
+		return !lastPage && (limit == 0 || len(records) < limit)
+	}); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// CountDeadLetters implements same signature of the DB interface.
+// Returns zero if the table is not configured.
+func (db *DynamoDB) CountDeadLetters(hook string) (int, error) {
+	if len(db.lettersTable) == 0 {
+		return 0, nil
+	}
+	expr, err := expression.NewBuilder().WithKeyCondition(expression.Key("Hook").Equal(expression.Value(hook))).Build()
+	if err != nil {
+		return 0, fmt.Errorf("failed to build expression: %w", err)
+	}
+	var count int
+	err = db.instance.QueryPages(&dynamodb.QueryInput{
+		TableName:                 &db.lettersTable,
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		Select:                    aws.String(dynamodb.SelectCount),
+	}, func(output *dynamodb.QueryOutput, lastPage bool) bool {
+		count += int(*output.Count)
+		return !lastPage
+	})
+	return count, err
+}
+
+// TrimDeadLetters implements same signature of the DB interface.
+func (db *DynamoDB) TrimDeadLetters(hook string, keep int) error {
+	if len(db.lettersTable) == 0 {
+		return nil
+	}
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(expression.Key("Hook").Equal(expression.Value(hook))).
+		WithProjection(expression.NamesList(expression.Name("ID"))).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build expression: %w", err)
+	}
+	var ids []string
+	var count int
+	if err := db.instance.QueryPages(&dynamodb.QueryInput{
+		TableName:                 &db.lettersTable,
+		KeyConditionExpression:    expr.KeyCondition(),
+		ProjectionExpression:      expr.Projection(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ScanIndexForward:          aws.Bool(false),
+	}, func(output *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range output.Items {
+			count++
+			if count <= keep {
+				continue
+			}
+			var record DeadLetter
+			if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item}, &record); err != nil {
+				log.Printf("skipping error item: %v", err)
+				continue
+			}
+			ids = append(ids, record.ID)
+		}
+		return !lastPage
+	}); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := db.DeleteDeadLetter(hook, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDeadLetter implements same signature of the DB interface.
+func (db *DynamoDB) GetDeadLetter(hook, id string) (*DeadLetter, error) {
+	if err := requireTable(db.lettersTable, "DYNAMO_DEAD_LETTERS"); err != nil {
+		return nil, err
+	}
+	key, err := db.encoder.Encode(struct{ Hook, ID string }{hook, id})
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %v", err)
+	}
+	item, err := db.instance.GetItem(&dynamodb.GetItemInput{TableName: &db.lettersTable, Key: key.M})
+	if err != nil {
+		return nil, err
+	}
+	if item.Item == nil {
+		return nil, nil
+	}
+	var letter DeadLetter
+	if err := db.decoder.Decode(&dynamodb.AttributeValue{M: item.Item}, &letter); err != nil {
+		return nil, err
+	}
+	return &letter, nil
+}
+
+// DeleteDeadLetter implements same signature of the DB interface.
+func (db *DynamoDB) DeleteDeadLetter(hook, id string) error {
+	if err := requireTable(db.lettersTable, "DYNAMO_DEAD_LETTERS"); err != nil {
+		return err
+	}
+	key, err := db.encoder.Encode(struct{ Hook, ID string }{hook, id})
+	if err != nil {
+		return fmt.Errorf("invalid key: %v", err)
+	}
+	_, err = db.instance.DeleteItem(&dynamodb.DeleteItemInput{TableName: &db.lettersTable, Key: key.M})
+	return err
+}
@@ -1,6 +1,7 @@
 package kaginawa
 
 import (
+	"sort"
 	"sync"
 	"time"
 )
@@ -12,49 +13,64 @@ type MemDB struct {
 	nodes         map[string]Report
 	sessions      map[string]UserSession
 	logs          []Report
+	knownHosts    map[string]KnownHost
+	commandLogs   []CommandLog
+	profiles      map[string]CredentialProfile
+	savedCommands map[string]SavedCommand
+	schedules     map[string]Schedule
+	scheduleRuns  []ScheduleRun
+	notifiers     map[string]Notifier
+	alertRules    map[string]AlertRule
+	alerts        map[[2]string]Alert
+	reportHooks   map[string]ReportHook
+	deadLetters   map[[2]string]DeadLetter
+	nodeEvents    map[[2]string]bool
 	keysMutex     sync.RWMutex
 	serversMutex  sync.RWMutex
 	nodesMutex    sync.RWMutex
 	logsMutex     sync.RWMutex
 	sessionsMutex sync.RWMutex
+	hostsMutex    sync.RWMutex
+	commandsMutex sync.RWMutex
+	profilesMutex sync.RWMutex
+	savedMutex    sync.RWMutex
+	schedMutex    sync.RWMutex
+	notifyMutex   sync.RWMutex
+	alertsMutex   sync.RWMutex
+	hooksMutex    sync.RWMutex
+	eventsMutex   sync.Mutex
 }
 
 // NewMemDB will create in-memory DB instance that implements DB interface.
 func NewMemDB() *MemDB {
 	return &MemDB{
-		keys:     make(map[string]APIKey),
-		servers:  make(map[string]SSHServer),
-		nodes:    make(map[string]Report),
-		logs:     make([]Report, 0),
-		sessions: make(map[string]UserSession),
+		keys:          make(map[string]APIKey),
+		servers:       make(map[string]SSHServer),
+		nodes:         make(map[string]Report),
+		logs:          make([]Report, 0),
+		sessions:      make(map[string]UserSession),
+		knownHosts:    make(map[string]KnownHost),
+		profiles:      make(map[string]CredentialProfile),
+		savedCommands: make(map[string]SavedCommand),
+		schedules:     make(map[string]Schedule),
+		notifiers:     make(map[string]Notifier),
+		alertRules:    make(map[string]AlertRule),
+		alerts:        make(map[[2]string]Alert),
+		reportHooks:   make(map[string]ReportHook),
+		deadLetters:   make(map[[2]string]DeadLetter),
+		nodeEvents:    make(map[[2]string]bool),
 	}
 }
 
 // ValidateAPIKey implements same signature of the DB interface.
-func (db *MemDB) ValidateAPIKey(key string) (bool, string, error) {
+func (db *MemDB) ValidateAPIKey(raw string) (*APIKey, error) {
 	db.keysMutex.RLock()
 	defer db.keysMutex.RUnlock()
-	for _, v := range db.keys {
-		if v.Key == key {
-			return true, v.Label, nil
-		}
-	}
-	return false, "", nil
-}
-
-// ValidateAdminAPIKey implements same signature of the DB interface.
-func (db *MemDB) ValidateAdminAPIKey(key string) (bool, string, error) {
-	db.keysMutex.RLock()
-	defer db.keysMutex.RUnlock()
-	for _, v := range db.keys {
-		if !v.Admin {
-			continue
-		}
-		if v.Key == key {
-			return true, v.Label, nil
-		}
+	v, ok := db.keys[HashAPIKey(raw)]
+	if !ok || !v.IsActive() {
+		return nil, nil
 	}
-	return false, "", nil
+	return &v, nil
 }
 
 // ListAPIKeys implements same signature of the DB interface.
@@ -68,6 +84,17 @@ func (db *MemDB) ListAPIKeys() ([]APIKey, error) {
 	return slice, nil
 }
 
+// GetAPIKey implements same signature of the DB interface.
+func (db *MemDB) GetAPIKey(key string) (*APIKey, error) {
+	db.keysMutex.RLock()
+	defer db.keysMutex.RUnlock()
+	v, ok := db.keys[key]
+	if !ok {
+		return nil, nil
+	}
+	return &v, nil
+}
+
 // PutAPIKey implements same signature of the DB interface.
 func (db *MemDB) PutAPIKey(apiKey APIKey) error {
 	db.keysMutex.Lock()
@@ -76,13 +103,25 @@ func (db *MemDB) PutAPIKey(apiKey APIKey) error {
 	return nil
 }
 
+// DeleteAPIKey implements same signature of the DB interface.
+func (db *MemDB) DeleteAPIKey(key string) error {
+	db.keysMutex.Lock()
+	defer db.keysMutex.Unlock()
+	delete(db.keys, key)
+	return nil
+}
+
 // ListSSHServers implements same signature of the DB interface.
 func (db *MemDB) ListSSHServers() ([]SSHServer, error) {
 	db.serversMutex.RLock()
 	defer db.serversMutex.RUnlock()
 	slice := make([]SSHServer, 0, len(db.servers))
 	for _, v := range db.servers {
-		slice = append(slice, v)
+		server, err := openSecrets(v)
+		if err != nil {
+			return nil, err
+		}
+		slice = append(slice, server)
 	}
 	return slice, nil
 }
@@ -93,7 +132,11 @@ func (db *MemDB) GetSSHServerByHost(host string) (*SSHServer, error) {
 	defer db.serversMutex.RUnlock()
 	for k, v := range db.servers {
 		if k == host {
-			return &v, nil
+			server, err := openSecrets(v)
+			if err != nil {
+				return nil, err
+			}
+			return &server, nil
 		}
 	}
 	return nil, nil
@@ -101,9 +144,21 @@ func (db *MemDB) GetSSHServerByHost(host string) (*SSHServer, error) {
 
 // PutSSHServer implements same signature of the DB interface.
 func (db *MemDB) PutSSHServer(server SSHServer) error {
+	sealed, err := sealSecrets(server)
+	if err != nil {
+		return err
+	}
+	db.serversMutex.Lock()
+	defer db.serversMutex.Unlock()
+	db.servers[server.Host] = sealed
+	return nil
+}
+
+// DeleteSSHServer implements same signature of the DB interface.
+func (db *MemDB) DeleteSSHServer(host string) error {
 	db.serversMutex.Lock()
 	defer db.serversMutex.Unlock()
-	db.servers[server.Host] = server
+	delete(db.servers, host)
 	return nil
 }
 
@@ -195,6 +250,26 @@ func (db *MemDB) DeleteReport(id string) error {
 	return nil
 }
 
+// ReplaceReportAPIKey implements same signature of the DB interface.
+func (db *MemDB) ReplaceReportAPIKey(from, to string) error {
+	db.nodesMutex.Lock()
+	db.logsMutex.Lock()
+	defer db.nodesMutex.Unlock()
+	defer db.logsMutex.Unlock()
+	for id, report := range db.nodes {
+		if report.APIKey == from {
+			report.APIKey = to
+			db.nodes[id] = report
+		}
+	}
+	for i := range db.logs {
+		if db.logs[i].APIKey == from {
+			db.logs[i].APIKey = to
+		}
+	}
+	return nil
+}
+
 // ListHistory implements same signature of the DB interface.
 func (db *MemDB) ListHistory(id string, begin time.Time, end time.Time, _ Projection) ([]Report, error) {
 	db.nodesMutex.RLock()
@@ -235,3 +310,497 @@ func (db *MemDB) DeleteUserSession(id string) error {
 	delete(db.sessions, id)
 	return nil
 }
+
+// GetKnownHost implements same signature of the DB interface.
+func (db *MemDB) GetKnownHost(id string) (*KnownHost, error) {
+	db.hostsMutex.RLock()
+	defer db.hostsMutex.RUnlock()
+	v, ok := db.knownHosts[id]
+	if !ok {
+		return nil, nil
+	}
+	return &v, nil
+}
+
+// PutKnownHost implements same signature of the DB interface.
+func (db *MemDB) PutKnownHost(host KnownHost) error {
+	db.hostsMutex.Lock()
+	defer db.hostsMutex.Unlock()
+	db.knownHosts[host.ID] = host
+	return nil
+}
+
+// DeleteKnownHost implements same signature of the DB interface.
+func (db *MemDB) DeleteKnownHost(id string) error {
+	db.hostsMutex.Lock()
+	defer db.hostsMutex.Unlock()
+	delete(db.knownHosts, id)
+	return nil
+}
+
+// PutCommandLog implements same signature of the DB interface.
+func (db *MemDB) PutCommandLog(entry CommandLog) error {
+	db.commandsMutex.Lock()
+	defer db.commandsMutex.Unlock()
+	db.commandLogs = append(db.commandLogs, entry)
+	return nil
+}
+
+// ListCommandLogs implements same signature of the DB interface.
+func (db *MemDB) ListCommandLogs(nodeID string, limit int) ([]CommandLog, error) {
+	db.commandsMutex.RLock()
+	defer db.commandsMutex.RUnlock()
+	var slice []CommandLog
+	for i := len(db.commandLogs) - 1; i >= 0; i-- {
+		if limit > 0 && len(slice) >= limit {
+			break
+		}
+		if db.commandLogs[i].NodeID == nodeID {
+			slice = append(slice, db.commandLogs[i])
+		}
+	}
+	return slice, nil
+}
+
+// ListCredentialProfiles implements same signature of the DB interface.
+func (db *MemDB) ListCredentialProfiles() ([]CredentialProfile, error) {
+	db.profilesMutex.RLock()
+	defer db.profilesMutex.RUnlock()
+	slice := make([]CredentialProfile, 0, len(db.profiles))
+	for _, v := range db.profiles {
+		profile, err := openSecrets(v)
+		if err != nil {
+			return nil, err
+		}
+		slice = append(slice, profile)
+	}
+	sort.Slice(slice, func(i, j int) bool { return slice[i].Name < slice[j].Name })
+	return slice, nil
+}
+
+// GetCredentialProfile implements same signature of the DB interface.
+func (db *MemDB) GetCredentialProfile(name string) (*CredentialProfile, error) {
+	db.profilesMutex.RLock()
+	defer db.profilesMutex.RUnlock()
+	v, ok := db.profiles[name]
+	if !ok {
+		return nil, nil
+	}
+	profile, err := openSecrets(v)
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// PutCredentialProfile implements same signature of the DB interface.
+func (db *MemDB) PutCredentialProfile(profile CredentialProfile) error {
+	sealed, err := sealSecrets(profile)
+	if err != nil {
+		return err
+	}
+	db.profilesMutex.Lock()
+	defer db.profilesMutex.Unlock()
+	db.profiles[profile.Name] = sealed
+	return nil
+}
+
+// DeleteCredentialProfile implements same signature of the DB interface.
+func (db *MemDB) DeleteCredentialProfile(name string) error {
+	db.profilesMutex.Lock()
+	defer db.profilesMutex.Unlock()
+	delete(db.profiles, name)
+	return nil
+}
+
+// ListSavedCommands implements same signature of the DB interface.
+func (db *MemDB) ListSavedCommands() ([]SavedCommand, error) {
+	db.savedMutex.RLock()
+	defer db.savedMutex.RUnlock()
+	slice := make([]SavedCommand, 0, len(db.savedCommands))
+	for _, v := range db.savedCommands {
+		slice = append(slice, v)
+	}
+	sort.Slice(slice, func(i, j int) bool { return slice[i].Name < slice[j].Name })
+	return slice, nil
+}
+
+// GetSavedCommand implements same signature of the DB interface.
+func (db *MemDB) GetSavedCommand(name string) (*SavedCommand, error) {
+	db.savedMutex.RLock()
+	defer db.savedMutex.RUnlock()
+	if v, ok := db.savedCommands[name]; ok {
+		return &v, nil
+	}
+	return nil, nil
+}
+
+// PutSavedCommand implements same signature of the DB interface.
+func (db *MemDB) PutSavedCommand(command SavedCommand) error {
+	db.savedMutex.Lock()
+	defer db.savedMutex.Unlock()
+	db.savedCommands[command.Name] = command
+	return nil
+}
+
+// DeleteSavedCommand implements same signature of the DB interface.
+func (db *MemDB) DeleteSavedCommand(name string) error {
+	db.savedMutex.Lock()
+	defer db.savedMutex.Unlock()
+	delete(db.savedCommands, name)
+	return nil
+}
+
+// ListSchedules implements same signature of the DB interface.
+func (db *MemDB) ListSchedules() ([]Schedule, error) {
+	db.schedMutex.RLock()
+	defer db.schedMutex.RUnlock()
+	slice := make([]Schedule, 0, len(db.schedules))
+	for _, v := range db.schedules {
+		slice = append(slice, v)
+	}
+	sort.Slice(slice, func(i, j int) bool { return slice[i].Name < slice[j].Name })
+	return slice, nil
+}
+
+// GetSchedule implements same signature of the DB interface.
+func (db *MemDB) GetSchedule(name string) (*Schedule, error) {
+	db.schedMutex.RLock()
+	defer db.schedMutex.RUnlock()
+	if v, ok := db.schedules[name]; ok {
+		return &v, nil
+	}
+	return nil, nil
+}
+
+// PutSchedule implements same signature of the DB interface.
+func (db *MemDB) PutSchedule(schedule Schedule) error {
+	db.schedMutex.Lock()
+	defer db.schedMutex.Unlock()
+	if current, ok := db.schedules[schedule.Name]; ok {
+		schedule.LastRunAt = current.LastRunAt
+	}
+	db.schedules[schedule.Name] = schedule
+	return nil
+}
+
+// DeleteSchedule implements same signature of the DB interface.
+func (db *MemDB) DeleteSchedule(name string) error {
+	db.schedMutex.Lock()
+	defer db.schedMutex.Unlock()
+	delete(db.schedules, name)
+	return nil
+}
+
+// ClaimScheduleRun implements same signature of the DB interface.
+func (db *MemDB) ClaimScheduleRun(name string, last, at int64) (bool, error) {
+	db.schedMutex.Lock()
+	defer db.schedMutex.Unlock()
+	schedule, ok := db.schedules[name]
+	if !ok || schedule.LastRunAt != last {
+		return false, nil
+	}
+	schedule.LastRunAt = at
+	db.schedules[name] = schedule
+	return true, nil
+}
+
+// ClaimNodeEvent implements same signature of the DB interface.
+func (db *MemDB) ClaimNodeEvent(nodeID, eventType string, since int64) (bool, error) {
+	db.eventsMutex.Lock()
+	defer db.eventsMutex.Unlock()
+	key := [2]string{nodeID, nodeEventKey(eventType, since)}
+	if db.nodeEvents[key] {
+		return false, nil
+	}
+	db.nodeEvents[key] = true
+	return true, nil
+}
+
+// PutScheduleRun implements same signature of the DB interface.
+func (db *MemDB) PutScheduleRun(run ScheduleRun) error {
+	db.schedMutex.Lock()
+	defer db.schedMutex.Unlock()
+	db.scheduleRuns = append(db.scheduleRuns, run)
+	return nil
+}
+
+// ListScheduleRuns implements same signature of the DB interface.
+func (db *MemDB) ListScheduleRuns(schedule string, limit int) ([]ScheduleRun, error) {
+	db.schedMutex.RLock()
+	defer db.schedMutex.RUnlock()
+	var slice []ScheduleRun
+	for i := len(db.scheduleRuns) - 1; i >= 0; i-- {
+		if limit > 0 && len(slice) >= limit {
+			break
+		}
+		if db.scheduleRuns[i].Schedule == schedule {
+			slice = append(slice, db.scheduleRuns[i])
+		}
+	}
+	return slice, nil
+}
+
+// ListNotifiers implements same signature of the DB interface.
+func (db *MemDB) ListNotifiers() ([]Notifier, error) {
+	db.notifyMutex.RLock()
+	defer db.notifyMutex.RUnlock()
+	slice := make([]Notifier, 0, len(db.notifiers))
+	for _, v := range db.notifiers {
+		notifier, err := openSecrets(v)
+		if err != nil {
+			return nil, err
+		}
+		slice = append(slice, notifier)
+	}
+	sort.Slice(slice, func(i, j int) bool { return slice[i].Name < slice[j].Name })
+	return slice, nil
+}
+
+// GetNotifier implements same signature of the DB interface.
+func (db *MemDB) GetNotifier(name string) (*Notifier, error) {
+	db.notifyMutex.RLock()
+	defer db.notifyMutex.RUnlock()
+	v, ok := db.notifiers[name]
+	if !ok {
+		return nil, nil
+	}
+	notifier, err := openSecrets(v)
+	if err != nil {
+		return nil, err
+	}
+	return &notifier, nil
+}
+
+// PutNotifier implements same signature of the DB interface.
+func (db *MemDB) PutNotifier(notifier Notifier) error {
+	sealed, err := sealSecrets(notifier)
+	if err != nil {
+		return err
+	}
+	db.notifyMutex.Lock()
+	defer db.notifyMutex.Unlock()
+	db.notifiers[notifier.Name] = sealed
+	return nil
+}
+
+// DeleteNotifier implements same signature of the DB interface.
+func (db *MemDB) DeleteNotifier(name string) error {
+	db.notifyMutex.Lock()
+	defer db.notifyMutex.Unlock()
+	delete(db.notifiers, name)
+	return nil
+}
+
+// ListAlertRules implements same signature of the DB interface.
+func (db *MemDB) ListAlertRules() ([]AlertRule, error) {
+	db.alertsMutex.RLock()
+	defer db.alertsMutex.RUnlock()
+	slice := make([]AlertRule, 0, len(db.alertRules))
+	for _, v := range db.alertRules {
+		slice = append(slice, v)
+	}
+	sort.Slice(slice, func(i, j int) bool { return slice[i].Name < slice[j].Name })
+	return slice, nil
+}
+
+// GetAlertRule implements same signature of the DB interface.
+func (db *MemDB) GetAlertRule(name string) (*AlertRule, error) {
+	db.alertsMutex.RLock()
+	defer db.alertsMutex.RUnlock()
+	v, ok := db.alertRules[name]
+	if !ok {
+		return nil, nil
+	}
+	return &v, nil
+}
+
+// PutAlertRule implements same signature of the DB interface.
+func (db *MemDB) PutAlertRule(rule AlertRule) error {
+	db.alertsMutex.Lock()
+	defer db.alertsMutex.Unlock()
+	db.alertRules[rule.Name] = rule
+	return nil
+}
+
+// DeleteAlertRule implements same signature of the DB interface.
+func (db *MemDB) DeleteAlertRule(name string) error {
+	db.alertsMutex.Lock()
+	defer db.alertsMutex.Unlock()
+	delete(db.alertRules, name)
+	for k := range db.alerts {
+		if k[0] == name {
+			delete(db.alerts, k)
+		}
+	}
+	return nil
+}
+
+// ListAlerts implements same signature of the DB interface.
+func (db *MemDB) ListAlerts(firingOnly bool) ([]Alert, error) {
+	db.alertsMutex.RLock()
+	defer db.alertsMutex.RUnlock()
+	slice := make([]Alert, 0)
+	for _, v := range db.alerts {
+		if firingOnly && !v.Firing {
+			continue
+		}
+		slice = append(slice, v)
+	}
+	sort.Slice(slice, func(i, j int) bool {
+		if slice[i].Rule != slice[j].Rule {
+			return slice[i].Rule < slice[j].Rule
+		}
+		return slice[i].NodeID < slice[j].NodeID
+	})
+	return slice, nil
+}
+
+// GetAlert implements same signature of the DB interface.
+func (db *MemDB) GetAlert(rule, nodeID string) (*Alert, error) {
+	db.alertsMutex.RLock()
+	defer db.alertsMutex.RUnlock()
+	v, ok := db.alerts[[2]string{rule, nodeID}]
+	if !ok {
+		return nil, nil
+	}
+	return &v, nil
+}
+
+// PutAlert implements same signature of the DB interface.
+func (db *MemDB) PutAlert(alert Alert) error {
+	db.alertsMutex.Lock()
+	defer db.alertsMutex.Unlock()
+	db.alerts[[2]string{alert.Rule, alert.NodeID}] = alert
+	return nil
+}
+
+// DeleteAlert implements same signature of the DB interface.
+func (db *MemDB) DeleteAlert(rule, nodeID string) error {
+	db.alertsMutex.Lock()
+	defer db.alertsMutex.Unlock()
+	delete(db.alerts, [2]string{rule, nodeID})
+	return nil
+}
+
+// ListReportHooks implements same signature of the DB interface.
+func (db *MemDB) ListReportHooks() ([]ReportHook, error) {
+	db.hooksMutex.RLock()
+	defer db.hooksMutex.RUnlock()
+	slice := make([]ReportHook, 0, len(db.reportHooks))
+	for _, v := range db.reportHooks {
+		hook, err := openSecrets(v)
+		if err != nil {
+			return nil, err
+		}
+		slice = append(slice, hook)
+	}
+	sort.Slice(slice, func(i, j int) bool { return slice[i].Name < slice[j].Name })
+	return slice, nil
+}
+
+// GetReportHook implements same signature of the DB interface.
+func (db *MemDB) GetReportHook(name string) (*ReportHook, error) {
+	db.hooksMutex.RLock()
+	defer db.hooksMutex.RUnlock()
+	v, ok := db.reportHooks[name]
+	if !ok {
+		return nil, nil
+	}
+	hook, err := openSecrets(v)
+	if err != nil {
+		return nil, err
+	}
+	return &hook, nil
+}
+
+// PutReportHook implements same signature of the DB interface.
+func (db *MemDB) PutReportHook(hook ReportHook) error {
+	sealed, err := sealSecrets(hook)
+	if err != nil {
+		return err
+	}
+	db.hooksMutex.Lock()
+	defer db.hooksMutex.Unlock()
+	db.reportHooks[hook.Name] = sealed
+	return nil
+}
+
+// DeleteReportHook implements same signature of the DB interface.
+func (db *MemDB) DeleteReportHook(name string) error {
+	db.hooksMutex.Lock()
+	defer db.hooksMutex.Unlock()
+	delete(db.reportHooks, name)
+	return nil
+}
+
+// PutDeadLetter implements same signature of the DB interface.
+func (db *MemDB) PutDeadLetter(letter DeadLetter) error {
+	db.hooksMutex.Lock()
+	defer db.hooksMutex.Unlock()
+	db.deadLetters[[2]string{letter.Hook, letter.ID}] = letter
+	return nil
+}
+
+// ListDeadLetters implements same signature of the DB interface.
+func (db *MemDB) ListDeadLetters(hook string, limit int) ([]DeadLetter, error) {
+	db.hooksMutex.RLock()
+	defer db.hooksMutex.RUnlock()
+	slice := make([]DeadLetter, 0)
+	for _, v := range db.deadLetters {
+		if v.Hook == hook {
+			slice = append(slice, v)
+		}
+	}
+	sort.Slice(slice, func(i, j int) bool { return slice[i].ID > slice[j].ID })
+	if limit > 0 && len(slice) > limit {
+		slice = slice[:limit]
+	}
+	return slice, nil
+}
+
+// CountDeadLetters implements same signature of the DB interface.
+func (db *MemDB) CountDeadLetters(hook string) (int, error) {
+	db.hooksMutex.RLock()
+	defer db.hooksMutex.RUnlock()
+	count := 0
+	for k := range db.deadLetters {
+		if k[0] == hook {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// TrimDeadLetters implements same signature of the DB interface.
+func (db *MemDB) TrimDeadLetters(hook string, keep int) error {
+	letters, err := db.ListDeadLetters(hook, 0)
+	if err != nil || len(letters) <= keep {
+		return err
+	}
+	db.hooksMutex.Lock()
+	defer db.hooksMutex.Unlock()
+	for _, letter := range letters[keep:] {
+		delete(db.deadLetters, [2]string{hook, letter.ID})
+	}
+	return nil
+}
+
+// GetDeadLetter implements same signature of the DB interface.
+func (db *MemDB) GetDeadLetter(hook, id string) (*DeadLetter, error) {
+	db.hooksMutex.RLock()
+	defer db.hooksMutex.RUnlock()
+	v, ok := db.deadLetters[[2]string{hook, id}]
+	if !ok {
+		return nil, nil
+	}
+	return &v, nil
+}
+
+// DeleteDeadLetter implements same signature of the DB interface.
+func (db *MemDB) DeleteDeadLetter(hook, id string) error {
+	db.hooksMutex.Lock()
+	defer db.hooksMutex.Unlock()
+	delete(db.deadLetters, [2]string{hook, id})
+	return nil
+}
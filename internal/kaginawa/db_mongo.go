@@ -14,16 +14,29 @@ import (
 )
 
 const (
-	keyCollection     = "keys"
-	serverCollection  = "servers"
-	nodeCollection    = "nodes"
-	logCollection     = "logs"
-	sessionCollection = "sessions"
+	keyCollection      = "keys"
+	serverCollection   = "servers"
+	nodeCollection     = "nodes"
+	logCollection      = "logs"
+	sessionCollection  = "sessions"
+	hostCollection     = "known_hosts"
+	commandCollection  = "command_logs"
+	profileCollection  = "credential_profiles"
+	savedCollection    = "saved_commands"
+	scheduleCollection = "schedules"
+	runCollection      = "schedule_runs"
+	notifierCollection = "notifiers"
+	ruleCollection     = "alert_rules"
+	alertCollection    = "alerts"
+	hookCollection     = "report_hooks"
+	letterCollection   = "dead_letters"
+	eventCollection    = "node_events"
 )
 
 var (
-	t      = true
-	upsert = &options.ReplaceOptions{Upsert: &t}
+	t            = true
+	upsert       = &options.ReplaceOptions{Upsert: &t}
+	updateUpsert = &options.UpdateOptions{Upsert: &t}
 )
 
 // MongoDB implements DB interface.
@@ -45,53 +58,12 @@ func NewMongoDB(endpoint string) (*MongoDB, error) {
 }
 
 // ValidateAPIKey implements same signature of the DB interface.
-func (db *MongoDB) ValidateAPIKey(key string) (bool, string, error) {
-	// Check cache first
-	if v, ok := KnownAPIKeys.Load(key); ok {
-		return ok, v.(string), nil
-	}
-
-	// Retrieve from database
-	result := db.instance.Collection(keyCollection).FindOne(context.Background(), bson.M{"key": key})
-	if result.Err() != nil {
-		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
-			return false, "", nil
-		}
-		return false, "", result.Err()
-	}
-	var apiKey APIKey
-	if err := result.Decode(&apiKey); err != nil {
-		return false, "", result.Err()
+func (db *MongoDB) ValidateAPIKey(raw string) (*APIKey, error) {
+	apiKey, err := db.findAPIKey(HashAPIKey(raw))
+	if err != nil || apiKey == nil || !apiKey.IsActive() {
+		return nil, err
 	}
-
-	// Cache and return
-	KnownAPIKeys.Store(apiKey.Key, apiKey.Label)
-	return true, apiKey.Label, nil
-}
-
-// ValidateAdminAPIKey implements same signature of the DB interface.
-func (db *MongoDB) ValidateAdminAPIKey(key string) (bool, string, error) {
-	// Check cache first
-	if v, ok := KnownAdminAPIKeys.Load(key); ok {
-		return ok, v.(string), nil
-	}
-
-	// Retrieve from database
-	result := db.instance.Collection(keyCollection).FindOne(context.Background(), bson.M{"key": key, "admin": true})
-	if result.Err() != nil {
-		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
-			return false, "", nil
-		}
-		return false, "", result.Err()
-	}
-	var apiKey APIKey
-	if err := result.Decode(&apiKey); err != nil {
-		return false, "", result.Err()
-	}
-
-	// Cache and return
-	KnownAdminAPIKeys.Store(apiKey.Key, apiKey.Label)
-	return true, apiKey.Label, nil
+	return apiKey, nil
 }
 
 // ListAPIKeys implements same signature of the DB interface.
@@ -108,14 +80,27 @@ func (db *MongoDB) ListAPIKeys() ([]APIKey, error) {
 			return nil, err
 		}
 		apiKeys = append(apiKeys, result)
-		KnownAPIKeys.Store(result.Key, result.Label) // update cache
-		if result.Admin {
-			KnownAdminAPIKeys.Store(result.Key, result.Label) // update cache
-		}
+		storeCachedAPIKey(result) // update cache
 	}
 	return apiKeys, nil
 }
 
+// GetAPIKey implements same signature of the DB interface.
+func (db *MongoDB) GetAPIKey(key string) (*APIKey, error) {
+	result := db.instance.Collection(keyCollection).FindOne(context.Background(), bson.M{"key": key})
+	if result.Err() != nil {
+		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, result.Err()
+	}
+	var apiKey APIKey
+	if err := result.Decode(&apiKey); err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
 // PutAPIKey implements same signature of the DB interface.
 func (db *MongoDB) PutAPIKey(apiKey APIKey) error {
 	raw, err := bson.Marshal(apiKey)
@@ -126,6 +111,16 @@ func (db *MongoDB) PutAPIKey(apiKey APIKey) error {
 	if _, err := db.instance.Collection(keyCollection).ReplaceOne(context.Background(), key, raw, upsert); err != nil {
 		return err
 	}
+	forgetCachedAPIKey(apiKey.Key)
+	return nil
+}
+
+// DeleteAPIKey implements same signature of the DB interface.
+func (db *MongoDB) DeleteAPIKey(key string) error {
+	if _, err := db.instance.Collection(keyCollection).DeleteOne(context.Background(), bson.M{"key": key}); err != nil {
+		return err
+	}
+	forgetCachedAPIKey(key)
 	return nil
 }
 
@@ -142,9 +137,13 @@ func (db *MongoDB) ListSSHServers() ([]SSHServer, error) {
 		if err := cur.Decode(&result); err != nil {
 			return nil, err
 		}
-		servers = append(servers, result)
+		server, err := openSecrets(result)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
 	}
-	SSHServers = servers // update cache
+	SetSSHServers(servers) // update cache
 	return servers, nil
 }
 
@@ -161,22 +160,36 @@ func (db *MongoDB) GetSSHServerByHost(host string) (*SSHServer, error) {
 	if err := result.Decode(&server); err != nil {
 		return nil, err
 	}
-	return &server, nil
+	opened, err := openSecrets(server)
+	if err != nil {
+		return nil, err
+	}
+	return &opened, nil
 }
 
 // PutSSHServer implements same signature of the DB interface.
 func (db *MongoDB) PutSSHServer(server SSHServer) error {
-	raw, err := bson.Marshal(server)
+	sealed, err := sealSecrets(server)
+	if err != nil {
+		return err
+	}
+	raw, err := bson.Marshal(sealed)
 	if err != nil {
 		return fmt.Errorf("failed to marshal: %w", err)
 	}
-	key := bson.M{"host": server.Host, "port": server.Port}
+	key := bson.M{"host": server.Host}
 	if _, err := db.instance.Collection(serverCollection).ReplaceOne(context.Background(), key, raw, upsert); err != nil {
 		return err
 	}
 	return nil
 }
 
+// DeleteSSHServer implements same signature of the DB interface.
+func (db *MongoDB) DeleteSSHServer(host string) error {
+	_, err := db.instance.Collection(serverCollection).DeleteOne(context.Background(), bson.M{"host": host})
+	return err
+}
+
 // PutReport implements same signature of the DB interface.
 func (db *MongoDB) PutReport(report Report) error {
 	raw, err := bson.Marshal(report)
@@ -268,6 +281,18 @@ func (db *MongoDB) DeleteReport(id string) error {
 	return err
 }
 
+// ReplaceReportAPIKey implements same signature of the DB interface.
+func (db *MongoDB) ReplaceReportAPIKey(from, to string) error {
+	filter := bson.M{"api_key": from}
+	update := bson.M{"$set": bson.M{"api_key": to}}
+	for _, collection := range []string{nodeCollection, logCollection} {
+		if _, err := db.instance.Collection(collection).UpdateMany(context.Background(), filter, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ListHistory implements same signature of the DB interface.
 func (db *MongoDB) ListHistory(id string, begin time.Time, end time.Time, projection Projection) ([]Report, error) {
 	opts := db.applyProjection(&options.FindOptions{Sort: bson.M{"server_time": 1}}, projection)
@@ -314,43 +339,352 @@ func (db *MongoDB) DeleteUserSession(id string) error {
 	return err
 }
 
+// GetKnownHost implements same signature of the DB interface.
+func (db *MongoDB) GetKnownHost(id string) (*KnownHost, error) {
+	result := db.instance.Collection(hostCollection).FindOne(context.Background(), bson.M{"id": id})
+	if result.Err() != nil {
+		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, result.Err()
+	}
+	var host KnownHost
+	if err := result.Decode(&host); err != nil {
+		return nil, err
+	}
+	return &host, nil
+}
+
+// PutKnownHost implements same signature of the DB interface.
+func (db *MongoDB) PutKnownHost(host KnownHost) error {
+	raw, err := bson.Marshal(host)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	key := bson.M{"id": host.ID}
+	_, err = db.instance.Collection(hostCollection).ReplaceOne(context.Background(), key, raw, upsert)
+	return err
+}
+
+// DeleteKnownHost implements same signature of the DB interface.
+func (db *MongoDB) DeleteKnownHost(id string) error {
+	_, err := db.instance.Collection(hostCollection).DeleteOne(context.Background(), bson.M{"id": id})
+	return err
+}
+
+// PutCommandLog implements same signature of the DB interface.
+func (db *MongoDB) PutCommandLog(entry CommandLog) error {
+	_, err := db.instance.Collection(commandCollection).InsertOne(context.Background(), entry)
+	return err
+}
+
+// ListCommandLogs implements same signature of the DB interface.
+func (db *MongoDB) ListCommandLogs(nodeID string, limit int) ([]CommandLog, error) {
+	opts := &options.FindOptions{Sort: bson.M{"id": -1}}
+	if limit > 0 {
+		opts.Limit = int64p(limit)
+	}
+	cur, err := db.instance.Collection(commandCollection).Find(context.Background(), bson.M{"node_id": nodeID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.safeClose(cur)
+	logs := make([]CommandLog, 0)
+	for cur.Next(context.Background()) {
+		var result CommandLog
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+		logs = append(logs, result)
+	}
+	return logs, nil
+}
+
+// ListCredentialProfiles implements same signature of the DB interface.
+func (db *MongoDB) ListCredentialProfiles() ([]CredentialProfile, error) {
+	opts := options.Find().SetSort(bson.M{"name": 1})
+	cur, err := db.instance.Collection(profileCollection).Find(context.Background(), bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.safeClose(cur)
+	var profiles []CredentialProfile
+	for cur.Next(context.Background()) {
+		var result CredentialProfile
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+		profile, err := openSecrets(result)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// GetCredentialProfile implements same signature of the DB interface.
+func (db *MongoDB) GetCredentialProfile(name string) (*CredentialProfile, error) {
+	result := db.instance.Collection(profileCollection).FindOne(context.Background(), bson.M{"name": name})
+	if result.Err() != nil {
+		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, result.Err()
+	}
+	var profile CredentialProfile
+	if err := result.Decode(&profile); err != nil {
+		return nil, err
+	}
+	opened, err := openSecrets(profile)
+	if err != nil {
+		return nil, err
+	}
+	return &opened, nil
+}
+
+// PutCredentialProfile implements same signature of the DB interface.
+func (db *MongoDB) PutCredentialProfile(profile CredentialProfile) error {
+	sealed, err := sealSecrets(profile)
+	if err != nil {
+		return err
+	}
+	raw, err := bson.Marshal(sealed)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	key := bson.M{"name": profile.Name}
+	_, err = db.instance.Collection(profileCollection).ReplaceOne(context.Background(), key, raw, upsert)
+	return err
+}
+
+// DeleteCredentialProfile implements same signature of the DB interface.
+func (db *MongoDB) DeleteCredentialProfile(name string) error {
+	_, err := db.instance.Collection(profileCollection).DeleteOne(context.Background(), bson.M{"name": name})
+	return err
+}
+
+// ListSavedCommands implements same signature of the DB interface.
+func (db *MongoDB) ListSavedCommands() ([]SavedCommand, error) {
+	opts := options.Find().SetSort(bson.M{"name": 1})
+	cur, err := db.instance.Collection(savedCollection).Find(context.Background(), bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.safeClose(cur)
+	var commands []SavedCommand
+	for cur.Next(context.Background()) {
+		var command SavedCommand
+		if err := cur.Decode(&command); err != nil {
+			return nil, err
+		}
+		commands = append(commands, command)
+	}
+	return commands, nil
+}
+
+// GetSavedCommand implements same signature of the DB interface.
+func (db *MongoDB) GetSavedCommand(name string) (*SavedCommand, error) {
+	result := db.instance.Collection(savedCollection).FindOne(context.Background(), bson.M{"name": name})
+	if result.Err() != nil {
+		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, result.Err()
+	}
+	var command SavedCommand
+	if err := result.Decode(&command); err != nil {
+		return nil, err
+	}
+	return &command, nil
+}
+
+// PutSavedCommand implements same signature of the DB interface.
+func (db *MongoDB) PutSavedCommand(command SavedCommand) error {
+	raw, err := bson.Marshal(command)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	key := bson.M{"name": command.Name}
+	_, err = db.instance.Collection(savedCollection).ReplaceOne(context.Background(), key, raw, upsert)
+	return err
+}
+
+// DeleteSavedCommand implements same signature of the DB interface.
+func (db *MongoDB) DeleteSavedCommand(name string) error {
+	_, err := db.instance.Collection(savedCollection).DeleteOne(context.Background(), bson.M{"name": name})
+	return err
+}
+
+// ListSchedules implements same signature of the DB interface.
+func (db *MongoDB) ListSchedules() ([]Schedule, error) {
+	opts := options.Find().SetSort(bson.M{"name": 1})
+	cur, err := db.instance.Collection(scheduleCollection).Find(context.Background(), bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.safeClose(cur)
+	var schedules []Schedule
+	for cur.Next(context.Background()) {
+		var schedule Schedule
+		if err := cur.Decode(&schedule); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
+
+// GetSchedule implements same signature of the DB interface.
+func (db *MongoDB) GetSchedule(name string) (*Schedule, error) {
+	result := db.instance.Collection(scheduleCollection).FindOne(context.Background(), bson.M{"name": name})
+	if result.Err() != nil {
+		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, result.Err()
+	}
+	var schedule Schedule
+	if err := result.Decode(&schedule); err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// PutSchedule implements same signature of the DB interface.
+func (db *MongoDB) PutSchedule(schedule Schedule) error {
+	raw, err := bson.Marshal(schedule)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	var fields bson.M
+	if err := bson.Unmarshal(raw, &fields); err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	delete(fields, "_id")
+	delete(fields, "last_run_at")
+	update := bson.M{"$set": fields, "$setOnInsert": bson.M{"last_run_at": schedule.LastRunAt}}
+	key := bson.M{"name": schedule.Name}
+	_, err = db.instance.Collection(scheduleCollection).UpdateOne(context.Background(), key, update, updateUpsert)
+	return err
+}
+
+// DeleteSchedule implements same signature of the DB interface.
+func (db *MongoDB) DeleteSchedule(name string) error {
+	_, err := db.instance.Collection(scheduleCollection).DeleteOne(context.Background(), bson.M{"name": name})
+	return err
+}
+
+// ClaimScheduleRun implements same signature of the DB interface.
+func (db *MongoDB) ClaimScheduleRun(name string, last, at int64) (bool, error) {
+	filter := bson.M{"name": name, "last_run_at": last}
+	update := bson.M{"$set": bson.M{"last_run_at": at}}
+	result, err := db.instance.Collection(scheduleCollection).UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// ClaimNodeEvent implements same signature of the DB interface.
+func (db *MongoDB) ClaimNodeEvent(nodeID, eventType string, since int64) (bool, error) {
+	key := nodeEventKey(eventType, since)
+	claim := bson.M{"_id": nodeID + "/" + key, "node_id": nodeID, "event": key}
+	_, err := db.instance.Collection(eventCollection).InsertOne(context.Background(), claim)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutScheduleRun implements same signature of the DB interface.
+func (db *MongoDB) PutScheduleRun(run ScheduleRun) error {
+	_, err := db.instance.Collection(runCollection).InsertOne(context.Background(), run)
+	return err
+}
+
+// ListScheduleRuns implements same signature of the DB interface.
+func (db *MongoDB) ListScheduleRuns(schedule string, limit int) ([]ScheduleRun, error) {
+	opts := &options.FindOptions{Sort: bson.M{"id": -1}}
+	if limit > 0 {
+		opts.Limit = int64p(limit)
+	}
+	cur, err := db.instance.Collection(runCollection).Find(context.Background(), bson.M{"schedule": schedule}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.safeClose(cur)
+	runs := make([]ScheduleRun, 0)
+	for cur.Next(context.Background()) {
+		var result ScheduleRun
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+		runs = append(runs, result)
+	}
+	return runs, nil
+}
+
+func (db *MongoDB) findAPIKey(key string) (*APIKey, error) {
+	// Check cache first
+	if apiKey, ok := loadCachedAPIKey(key); ok {
+		return &apiKey, nil
+	}
+
+	// Retrieve from database
+	apiKey, err := db.GetAPIKey(key)
+	if err != nil || apiKey == nil {
+		return nil, err
+	}
+
+	// Cache and return
+	storeCachedAPIKey(*apiKey)
+	return apiKey, nil
+}
+
 func (db *MongoDB) applyProjection(opts *options.FindOptions, projection Projection) *options.FindOptions {
 	switch projection {
 	case IDAttributes:
 		opts.Projection = bson.D{
-			{"id", 1},
-			{"custom_id", 1},
-			{"server_time", 1},
-			{"success", 1},
+			{Key: "id", Value: 1},
+			{Key: "custom_id", Value: 1},
+			{Key: "server_time", Value: 1},
+			{Key: "success", Value: 1},
 		}
 	case ListViewAttributes:
 		opts.Projection = bson.D{
-			{"id", 1},
-			{"custom_id", 1},
-			{"hostname", 1},
-			{"server_time", 1},
-			{"ssh_server_host", 1},
-			{"ssh_remote_port", 1},
-			{"ip_global", 1},
-			{"host_global", 1},
-			{"ip4_local", 1},
-			{"ip6_local", 1},
-			{"seq", 1},
-			{"agent_version", 1},
-			{"success", 1},
-			{"errors", 1},
+			{Key: "id", Value: 1},
+			{Key: "custom_id", Value: 1},
+			{Key: "hostname", Value: 1},
+			{Key: "server_time", Value: 1},
+			{Key: "trigger", Value: 1},
+			{Key: "ssh_server_host", Value: 1},
+			{Key: "ssh_remote_port", Value: 1},
+			{Key: "ip_global", Value: 1},
+			{Key: "host_global", Value: 1},
+			{Key: "ip4_local", Value: 1},
+			{Key: "ip6_local", Value: 1},
+			{Key: "seq", Value: 1},
+			{Key: "agent_version", Value: 1},
+			{Key: "success", Value: 1},
+			{Key: "errors", Value: 1},
+			{Key: "disk_total_bytes", Value: 1},
+			{Key: "disk_used_bytes", Value: 1},
 		}
 	case MeasurementAttributes:
 		opts.Projection = bson.D{
-			{"id", 1},
-			{"custom_id", 1},
-			{"hostname", 1},
-			{"server_time", 1},
-			{"seq", 1},
-			{"rtt_ms", 1},
-			{"upload_bps", 1},
-			{"download_bps", 1},
-			{"success", 1},
+			{Key: "id", Value: 1},
+			{Key: "custom_id", Value: 1},
+			{Key: "hostname", Value: 1},
+			{Key: "server_time", Value: 1},
+			{Key: "seq", Value: 1},
+			{Key: "rtt_ms", Value: 1},
+			{Key: "upload_bps", Value: 1},
+			{Key: "download_bps", Value: 1},
+			{Key: "success", Value: 1},
 		}
 	}
 	return opts
@@ -378,3 +712,331 @@ func int64p(n int) *int64 {
 	n64 := int64(n)
 	return &n64
 }
+
+// ListNotifiers implements same signature of the DB interface.
+func (db *MongoDB) ListNotifiers() ([]Notifier, error) {
+	opts := options.Find().SetSort(bson.M{"name": 1})
+	cur, err := db.instance.Collection(notifierCollection).Find(context.Background(), bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.safeClose(cur)
+	var notifiers []Notifier
+	for cur.Next(context.Background()) {
+		var result Notifier
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+		notifier, err := openSecrets(result)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	return notifiers, nil
+}
+
+// GetNotifier implements same signature of the DB interface.
+func (db *MongoDB) GetNotifier(name string) (*Notifier, error) {
+	result := db.instance.Collection(notifierCollection).FindOne(context.Background(), bson.M{"name": name})
+	if result.Err() != nil {
+		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, result.Err()
+	}
+	var notifier Notifier
+	if err := result.Decode(&notifier); err != nil {
+		return nil, err
+	}
+	opened, err := openSecrets(notifier)
+	if err != nil {
+		return nil, err
+	}
+	return &opened, nil
+}
+
+// PutNotifier implements same signature of the DB interface.
+func (db *MongoDB) PutNotifier(notifier Notifier) error {
+	sealed, err := sealSecrets(notifier)
+	if err != nil {
+		return err
+	}
+	raw, err := bson.Marshal(sealed)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	key := bson.M{"name": notifier.Name}
+	_, err = db.instance.Collection(notifierCollection).ReplaceOne(context.Background(), key, raw, upsert)
+	return err
+}
+
+// DeleteNotifier implements same signature of the DB interface.
+func (db *MongoDB) DeleteNotifier(name string) error {
+	_, err := db.instance.Collection(notifierCollection).DeleteOne(context.Background(), bson.M{"name": name})
+	return err
+}
+
+// ListAlertRules implements same signature of the DB interface.
+func (db *MongoDB) ListAlertRules() ([]AlertRule, error) {
+	opts := options.Find().SetSort(bson.M{"name": 1})
+	cur, err := db.instance.Collection(ruleCollection).Find(context.Background(), bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.safeClose(cur)
+	var rules []AlertRule
+	for cur.Next(context.Background()) {
+		var result AlertRule
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+		rules = append(rules, result)
+	}
+	return rules, nil
+}
+
+// GetAlertRule implements same signature of the DB interface.
+func (db *MongoDB) GetAlertRule(name string) (*AlertRule, error) {
+	result := db.instance.Collection(ruleCollection).FindOne(context.Background(), bson.M{"name": name})
+	if result.Err() != nil {
+		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, result.Err()
+	}
+	var rule AlertRule
+	if err := result.Decode(&rule); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// PutAlertRule implements same signature of the DB interface.
+func (db *MongoDB) PutAlertRule(rule AlertRule) error {
+	raw, err := bson.Marshal(rule)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	key := bson.M{"name": rule.Name}
+	_, err = db.instance.Collection(ruleCollection).ReplaceOne(context.Background(), key, raw, upsert)
+	return err
+}
+
+// DeleteAlertRule implements same signature of the DB interface.
+func (db *MongoDB) DeleteAlertRule(name string) error {
+	if _, err := db.instance.Collection(ruleCollection).DeleteOne(context.Background(), bson.M{"name": name}); err != nil {
+		return err
+	}
+	_, err := db.instance.Collection(alertCollection).DeleteMany(context.Background(), bson.M{"rule": name})
+	return err
+}
+
+// ListAlerts implements same signature of the DB interface.
+func (db *MongoDB) ListAlerts(firingOnly bool) ([]Alert, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "rule", Value: 1}, {Key: "node_id", Value: 1}})
+	filter := bson.M{}
+	if firingOnly {
+		filter["firing"] = true
+	}
+	cur, err := db.instance.Collection(alertCollection).Find(context.Background(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.safeClose(cur)
+	alerts := make([]Alert, 0)
+	for cur.Next(context.Background()) {
+		var result Alert
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, result)
+	}
+	return alerts, nil
+}
+
+// GetAlert implements same signature of the DB interface.
+func (db *MongoDB) GetAlert(rule, nodeID string) (*Alert, error) {
+	filter := bson.M{"rule": rule, "node_id": nodeID}
+	result := db.instance.Collection(alertCollection).FindOne(context.Background(), filter)
+	if result.Err() != nil {
+		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, result.Err()
+	}
+	var alert Alert
+	if err := result.Decode(&alert); err != nil {
+		return nil, err
+	}
+	return &alert, nil
+}
+
+// PutAlert implements same signature of the DB interface.
+func (db *MongoDB) PutAlert(alert Alert) error {
+	raw, err := bson.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	key := bson.M{"rule": alert.Rule, "node_id": alert.NodeID}
+	_, err = db.instance.Collection(alertCollection).ReplaceOne(context.Background(), key, raw, upsert)
+	return err
+}
+
+// DeleteAlert implements same signature of the DB interface.
+func (db *MongoDB) DeleteAlert(rule, nodeID string) error {
+	key := bson.M{"rule": rule, "node_id": nodeID}
+	_, err := db.instance.Collection(alertCollection).DeleteOne(context.Background(), key)
+	return err
+}
+
+// ListReportHooks implements same signature of the DB interface.
+func (db *MongoDB) ListReportHooks() ([]ReportHook, error) {
+	opts := options.Find().SetSort(bson.M{"name": 1})
+	cur, err := db.instance.Collection(hookCollection).Find(context.Background(), bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.safeClose(cur)
+	var hooks []ReportHook
+	for cur.Next(context.Background()) {
+		var result ReportHook
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+		hook, err := openSecrets(result)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// GetReportHook implements same signature of the DB interface.
+func (db *MongoDB) GetReportHook(name string) (*ReportHook, error) {
+	result := db.instance.Collection(hookCollection).FindOne(context.Background(), bson.M{"name": name})
+	if result.Err() != nil {
+		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, result.Err()
+	}
+	var hook ReportHook
+	if err := result.Decode(&hook); err != nil {
+		return nil, err
+	}
+	opened, err := openSecrets(hook)
+	if err != nil {
+		return nil, err
+	}
+	return &opened, nil
+}
+
+// PutReportHook implements same signature of the DB interface.
+func (db *MongoDB) PutReportHook(hook ReportHook) error {
+	sealed, err := sealSecrets(hook)
+	if err != nil {
+		return err
+	}
+	raw, err := bson.Marshal(sealed)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	key := bson.M{"name": hook.Name}
+	_, err = db.instance.Collection(hookCollection).ReplaceOne(context.Background(), key, raw, upsert)
+	return err
+}
+
+// DeleteReportHook implements same signature of the DB interface.
+func (db *MongoDB) DeleteReportHook(name string) error {
+	_, err := db.instance.Collection(hookCollection).DeleteOne(context.Background(), bson.M{"name": name})
+	return err
+}
+
+// PutDeadLetter implements same signature of the DB interface.
+func (db *MongoDB) PutDeadLetter(letter DeadLetter) error {
+	raw, err := bson.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	key := bson.M{"hook": letter.Hook, "id": letter.ID}
+	_, err = db.instance.Collection(letterCollection).ReplaceOne(context.Background(), key, raw, upsert)
+	return err
+}
+
+// ListDeadLetters implements same signature of the DB interface.
+func (db *MongoDB) ListDeadLetters(hook string, limit int) ([]DeadLetter, error) {
+	opts := &options.FindOptions{Sort: bson.M{"id": -1}}
+	if limit > 0 {
+		opts.Limit = int64p(limit)
+	}
+	cur, err := db.instance.Collection(letterCollection).Find(context.Background(), bson.M{"hook": hook}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.safeClose(cur)
+	letters := make([]DeadLetter, 0)
+	for cur.Next(context.Background()) {
+		var result DeadLetter
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+		letters = append(letters, result)
+	}
+	return letters, nil
+}
+
+// CountDeadLetters implements same signature of the DB interface.
+func (db *MongoDB) CountDeadLetters(hook string) (int, error) {
+	count, err := db.instance.Collection(letterCollection).CountDocuments(context.Background(), bson.M{"hook": hook})
+	return int(count), err
+}
+
+// TrimDeadLetters implements same signature of the DB interface.
+func (db *MongoDB) TrimDeadLetters(hook string, keep int) error {
+	opts := options.Find().SetSort(bson.M{"id": -1}).SetSkip(int64(keep)).SetProjection(bson.M{"id": 1})
+	cur, err := db.instance.Collection(letterCollection).Find(context.Background(), bson.M{"hook": hook}, opts)
+	if err != nil {
+		return err
+	}
+	defer db.safeClose(cur)
+	var ids []string
+	for cur.Next(context.Background()) {
+		var result DeadLetter
+		if err := cur.Decode(&result); err != nil {
+			return err
+		}
+		ids = append(ids, result.ID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	filter := bson.M{"hook": hook, "id": bson.M{"$in": ids}}
+	_, err = db.instance.Collection(letterCollection).DeleteMany(context.Background(), filter)
+	return err
+}
+
+// GetDeadLetter implements same signature of the DB interface.
+func (db *MongoDB) GetDeadLetter(hook, id string) (*DeadLetter, error) {
+	filter := bson.M{"hook": hook, "id": id}
+	result := db.instance.Collection(letterCollection).FindOne(context.Background(), filter)
+	if result.Err() != nil {
+		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, result.Err()
+	}
+	var letter DeadLetter
+	if err := result.Decode(&letter); err != nil {
+		return nil, err
+	}
+	return &letter, nil
+}
+
+// DeleteDeadLetter implements same signature of the DB interface.
+func (db *MongoDB) DeleteDeadLetter(hook, id string) error {
+	filter := bson.M{"hook": hook, "id": id}
+	_, err := db.instance.Collection(letterCollection).DeleteOne(context.Background(), filter)
+	return err
+}
@@ -0,0 +1,29 @@
+package kaginawa
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMongoDB_applyProjection(t *testing.T) {
+	db := &MongoDB{}
+	opts := db.applyProjection(&options.FindOptions{}, IDAttributes)
+	projection, ok := opts.Projection.(bson.D)
+	if !ok {
+		t.Fatalf("expected ordered projection, got %T", opts.Projection)
+	}
+	expected := []string{"id", "custom_id", "server_time", "success"}
+	if len(projection) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, projection)
+	}
+	for i, key := range expected {
+		if projection[i].Key != key || projection[i].Value != 1 {
+			t.Errorf("expected %s at %d, got %v", key, i, projection[i])
+		}
+	}
+	if opts := db.applyProjection(&options.FindOptions{}, AllAttributes); opts.Projection != nil {
+		t.Errorf("expected no projection for all attributes, got %v", opts.Projection)
+	}
+}
@@ -0,0 +1,462 @@
+package kaginawa
+
+import "time"
+
+// ObservedDB wraps a DB and reports the result of every method call to the observer.
+type ObservedDB struct {
+	db      DB
+	observe func(method string, err error)
+}
+
+// NewObservedDB creates a DB that calls the observer after every method call of the db.
+func NewObservedDB(db DB, observe func(method string, err error)) *ObservedDB {
+	return &ObservedDB{db: db, observe: observe}
+}
+
+// ValidateAPIKey implements same signature of the DB interface.
+func (o *ObservedDB) ValidateAPIKey(raw string) (*APIKey, error) {
+	v, err := o.db.ValidateAPIKey(raw)
+	o.observe("ValidateAPIKey", err)
+	return v, err
+}
+
+// ListAPIKeys implements same signature of the DB interface.
+func (o *ObservedDB) ListAPIKeys() ([]APIKey, error) {
+	v, err := o.db.ListAPIKeys()
+	o.observe("ListAPIKeys", err)
+	return v, err
+}
+
+// GetAPIKey implements same signature of the DB interface.
+func (o *ObservedDB) GetAPIKey(key string) (*APIKey, error) {
+	v, err := o.db.GetAPIKey(key)
+	o.observe("GetAPIKey", err)
+	return v, err
+}
+
+// PutAPIKey implements same signature of the DB interface.
+func (o *ObservedDB) PutAPIKey(apiKey APIKey) error {
+	err := o.db.PutAPIKey(apiKey)
+	o.observe("PutAPIKey", err)
+	return err
+}
+
+// DeleteAPIKey implements same signature of the DB interface.
+func (o *ObservedDB) DeleteAPIKey(key string) error {
+	err := o.db.DeleteAPIKey(key)
+	o.observe("DeleteAPIKey", err)
+	return err
+}
+
+// ListSSHServers implements same signature of the DB interface.
+func (o *ObservedDB) ListSSHServers() ([]SSHServer, error) {
+	v, err := o.db.ListSSHServers()
+	o.observe("ListSSHServers", err)
+	return v, err
+}
+
+// GetSSHServerByHost implements same signature of the DB interface.
+func (o *ObservedDB) GetSSHServerByHost(host string) (*SSHServer, error) {
+	v, err := o.db.GetSSHServerByHost(host)
+	o.observe("GetSSHServerByHost", err)
+	return v, err
+}
+
+// PutSSHServer implements same signature of the DB interface.
+func (o *ObservedDB) PutSSHServer(server SSHServer) error {
+	err := o.db.PutSSHServer(server)
+	o.observe("PutSSHServer", err)
+	return err
+}
+
+// DeleteSSHServer implements same signature of the DB interface.
+func (o *ObservedDB) DeleteSSHServer(host string) error {
+	err := o.db.DeleteSSHServer(host)
+	o.observe("DeleteSSHServer", err)
+	return err
+}
+
+// PutReport implements same signature of the DB interface.
+func (o *ObservedDB) PutReport(report Report) error {
+	err := o.db.PutReport(report)
+	o.observe("PutReport", err)
+	return err
+}
+
+// CountReports implements same signature of the DB interface.
+func (o *ObservedDB) CountReports() (int, error) {
+	v, err := o.db.CountReports()
+	o.observe("CountReports", err)
+	return v, err
+}
+
+// ListReports implements same signature of the DB interface.
+func (o *ObservedDB) ListReports(skip, limit, minutes int, projection Projection) ([]Report, error) {
+	v, err := o.db.ListReports(skip, limit, minutes, projection)
+	o.observe("ListReports", err)
+	return v, err
+}
+
+// CountAndListReports implements same signature of the DB interface.
+func (o *ObservedDB) CountAndListReports(skip, limit, minutes int, projection Projection) ([]Report, int, error) {
+	v, n, err := o.db.CountAndListReports(skip, limit, minutes, projection)
+	o.observe("CountAndListReports", err)
+	return v, n, err
+}
+
+// GetReportByID implements same signature of the DB interface.
+func (o *ObservedDB) GetReportByID(id string) (*Report, error) {
+	v, err := o.db.GetReportByID(id)
+	o.observe("GetReportByID", err)
+	return v, err
+}
+
+// ListReportsByCustomID implements same signature of the DB interface.
+func (o *ObservedDB) ListReportsByCustomID(customID string, minutes int, projection Projection) ([]Report, error) {
+	v, err := o.db.ListReportsByCustomID(customID, minutes, projection)
+	o.observe("ListReportsByCustomID", err)
+	return v, err
+}
+
+// DeleteReport implements same signature of the DB interface.
+func (o *ObservedDB) DeleteReport(id string) error {
+	err := o.db.DeleteReport(id)
+	o.observe("DeleteReport", err)
+	return err
+}
+
+// ReplaceReportAPIKey implements same signature of the DB interface.
+func (o *ObservedDB) ReplaceReportAPIKey(from, to string) error {
+	err := o.db.ReplaceReportAPIKey(from, to)
+	o.observe("ReplaceReportAPIKey", err)
+	return err
+}
+
+// ListHistory implements same signature of the DB interface.
+func (o *ObservedDB) ListHistory(id string, begin time.Time, end time.Time, projection Projection) ([]Report, error) {
+	v, err := o.db.ListHistory(id, begin, end, projection)
+	o.observe("ListHistory", err)
+	return v, err
+}
+
+// GetUserSession implements same signature of the DB interface.
+func (o *ObservedDB) GetUserSession(id string) (*UserSession, error) {
+	v, err := o.db.GetUserSession(id)
+	o.observe("GetUserSession", err)
+	return v, err
+}
+
+// PutUserSession implements same signature of the DB interface.
+func (o *ObservedDB) PutUserSession(session UserSession) error {
+	err := o.db.PutUserSession(session)
+	o.observe("PutUserSession", err)
+	return err
+}
+
+// DeleteUserSession implements same signature of the DB interface.
+func (o *ObservedDB) DeleteUserSession(id string) error {
+	err := o.db.DeleteUserSession(id)
+	o.observe("DeleteUserSession", err)
+	return err
+}
+
+// GetKnownHost implements same signature of the DB interface.
+func (o *ObservedDB) GetKnownHost(id string) (*KnownHost, error) {
+	v, err := o.db.GetKnownHost(id)
+	o.observe("GetKnownHost", err)
+	return v, err
+}
+
+// PutKnownHost implements same signature of the DB interface.
+func (o *ObservedDB) PutKnownHost(host KnownHost) error {
+	err := o.db.PutKnownHost(host)
+	o.observe("PutKnownHost", err)
+	return err
+}
+
+// DeleteKnownHost implements same signature of the DB interface.
+func (o *ObservedDB) DeleteKnownHost(id string) error {
+	err := o.db.DeleteKnownHost(id)
+	o.observe("DeleteKnownHost", err)
+	return err
+}
+
+// PutCommandLog implements same signature of the DB interface.
+func (o *ObservedDB) PutCommandLog(entry CommandLog) error {
+	err := o.db.PutCommandLog(entry)
+	o.observe("PutCommandLog", err)
+	return err
+}
+
+// ListCommandLogs implements same signature of the DB interface.
+func (o *ObservedDB) ListCommandLogs(nodeID string, limit int) ([]CommandLog, error) {
+	v, err := o.db.ListCommandLogs(nodeID, limit)
+	o.observe("ListCommandLogs", err)
+	return v, err
+}
+
+// ListCredentialProfiles implements same signature of the DB interface.
+func (o *ObservedDB) ListCredentialProfiles() ([]CredentialProfile, error) {
+	v, err := o.db.ListCredentialProfiles()
+	o.observe("ListCredentialProfiles", err)
+	return v, err
+}
+
+// GetCredentialProfile implements same signature of the DB interface.
+func (o *ObservedDB) GetCredentialProfile(name string) (*CredentialProfile, error) {
+	v, err := o.db.GetCredentialProfile(name)
+	o.observe("GetCredentialProfile", err)
+	return v, err
+}
+
+// PutCredentialProfile implements same signature of the DB interface.
+func (o *ObservedDB) PutCredentialProfile(profile CredentialProfile) error {
+	err := o.db.PutCredentialProfile(profile)
+	o.observe("PutCredentialProfile", err)
+	return err
+}
+
+// DeleteCredentialProfile implements same signature of the DB interface.
+func (o *ObservedDB) DeleteCredentialProfile(name string) error {
+	err := o.db.DeleteCredentialProfile(name)
+	o.observe("DeleteCredentialProfile", err)
+	return err
+}
+
+// ListSavedCommands implements same signature of the DB interface.
+func (o *ObservedDB) ListSavedCommands() ([]SavedCommand, error) {
+	v, err := o.db.ListSavedCommands()
+	o.observe("ListSavedCommands", err)
+	return v, err
+}
+
+// GetSavedCommand implements same signature of the DB interface.
+func (o *ObservedDB) GetSavedCommand(name string) (*SavedCommand, error) {
+	v, err := o.db.GetSavedCommand(name)
+	o.observe("GetSavedCommand", err)
+	return v, err
+}
+
+// PutSavedCommand implements same signature of the DB interface.
+func (o *ObservedDB) PutSavedCommand(command SavedCommand) error {
+	err := o.db.PutSavedCommand(command)
+	o.observe("PutSavedCommand", err)
+	return err
+}
+
+// DeleteSavedCommand implements same signature of the DB interface.
+func (o *ObservedDB) DeleteSavedCommand(name string) error {
+	err := o.db.DeleteSavedCommand(name)
+	o.observe("DeleteSavedCommand", err)
+	return err
+}
+
+// ListSchedules implements same signature of the DB interface.
+func (o *ObservedDB) ListSchedules() ([]Schedule, error) {
+	v, err := o.db.ListSchedules()
+	o.observe("ListSchedules", err)
+	return v, err
+}
+
+// GetSchedule implements same signature of the DB interface.
+func (o *ObservedDB) GetSchedule(name string) (*Schedule, error) {
+	v, err := o.db.GetSchedule(name)
+	o.observe("GetSchedule", err)
+	return v, err
+}
+
+// PutSchedule implements same signature of the DB interface.
+func (o *ObservedDB) PutSchedule(schedule Schedule) error {
+	err := o.db.PutSchedule(schedule)
+	o.observe("PutSchedule", err)
+	return err
+}
+
+// DeleteSchedule implements same signature of the DB interface.
+func (o *ObservedDB) DeleteSchedule(name string) error {
+	err := o.db.DeleteSchedule(name)
+	o.observe("DeleteSchedule", err)
+	return err
+}
+
+// ClaimScheduleRun implements same signature of the DB interface.
+func (o *ObservedDB) ClaimScheduleRun(name string, last, at int64) (bool, error) {
+	v, err := o.db.ClaimScheduleRun(name, last, at)
+	o.observe("ClaimScheduleRun", err)
+	return v, err
+}
+
+// ClaimNodeEvent implements same signature of the DB interface.
+func (o *ObservedDB) ClaimNodeEvent(nodeID, eventType string, since int64) (bool, error) {
+	v, err := o.db.ClaimNodeEvent(nodeID, eventType, since)
+	o.observe("ClaimNodeEvent", err)
+	return v, err
+}
+
+// PutScheduleRun implements same signature of the DB interface.
+func (o *ObservedDB) PutScheduleRun(run ScheduleRun) error {
+	err := o.db.PutScheduleRun(run)
+	o.observe("PutScheduleRun", err)
+	return err
+}
+
+// ListScheduleRuns implements same signature of the DB interface.
+func (o *ObservedDB) ListScheduleRuns(schedule string, limit int) ([]ScheduleRun, error) {
+	v, err := o.db.ListScheduleRuns(schedule, limit)
+	o.observe("ListScheduleRuns", err)
+	return v, err
+}
+
+// ListNotifiers implements same signature of the DB interface.
+func (o *ObservedDB) ListNotifiers() ([]Notifier, error) {
+	v, err := o.db.ListNotifiers()
+	o.observe("ListNotifiers", err)
+	return v, err
+}
+
+// GetNotifier implements same signature of the DB interface.
+func (o *ObservedDB) GetNotifier(name string) (*Notifier, error) {
+	v, err := o.db.GetNotifier(name)
+	o.observe("GetNotifier", err)
+	return v, err
+}
+
+// PutNotifier implements same signature of the DB interface.
+func (o *ObservedDB) PutNotifier(notifier Notifier) error {
+	err := o.db.PutNotifier(notifier)
+	o.observe("PutNotifier", err)
+	return err
+}
+
+// DeleteNotifier implements same signature of the DB interface.
+func (o *ObservedDB) DeleteNotifier(name string) error {
+	err := o.db.DeleteNotifier(name)
+	o.observe("DeleteNotifier", err)
+	return err
+}
+
+// ListAlertRules implements same signature of the DB interface.
+func (o *ObservedDB) ListAlertRules() ([]AlertRule, error) {
+	v, err := o.db.ListAlertRules()
+	o.observe("ListAlertRules", err)
+	return v, err
+}
+
+// GetAlertRule implements same signature of the DB interface.
+func (o *ObservedDB) GetAlertRule(name string) (*AlertRule, error) {
+	v, err := o.db.GetAlertRule(name)
+	o.observe("GetAlertRule", err)
+	return v, err
+}
+
+// PutAlertRule implements same signature of the DB interface.
+func (o *ObservedDB) PutAlertRule(rule AlertRule) error {
+	err := o.db.PutAlertRule(rule)
+	o.observe("PutAlertRule", err)
+	return err
+}
+
+// DeleteAlertRule implements same signature of the DB interface.
+func (o *ObservedDB) DeleteAlertRule(name string) error {
+	err := o.db.DeleteAlertRule(name)
+	o.observe("DeleteAlertRule", err)
+	return err
+}
+
+// ListAlerts implements same signature of the DB interface.
+func (o *ObservedDB) ListAlerts(firingOnly bool) ([]Alert, error) {
+	v, err := o.db.ListAlerts(firingOnly)
+	o.observe("ListAlerts", err)
+	return v, err
+}
+
+// GetAlert implements same signature of the DB interface.
+func (o *ObservedDB) GetAlert(rule, nodeID string) (*Alert, error) {
+	v, err := o.db.GetAlert(rule, nodeID)
+	o.observe("GetAlert", err)
+	return v, err
+}
+
+// PutAlert implements same signature of the DB interface.
+func (o *ObservedDB) PutAlert(alert Alert) error {
+	err := o.db.PutAlert(alert)
+	o.observe("PutAlert", err)
+	return err
+}
+
+// DeleteAlert implements same signature of the DB interface.
+func (o *ObservedDB) DeleteAlert(rule, nodeID string) error {
+	err := o.db.DeleteAlert(rule, nodeID)
+	o.observe("DeleteAlert", err)
+	return err
+}
+
+// ListReportHooks implements same signature of the DB interface.
+func (o *ObservedDB) ListReportHooks() ([]ReportHook, error) {
+	v, err := o.db.ListReportHooks()
+	o.observe("ListReportHooks", err)
+	return v, err
+}
+
+// GetReportHook implements same signature of the DB interface.
+func (o *ObservedDB) GetReportHook(name string) (*ReportHook, error) {
+	v, err := o.db.GetReportHook(name)
+	o.observe("GetReportHook", err)
+	return v, err
+}
+
+// PutReportHook implements same signature of the DB interface.
+func (o *ObservedDB) PutReportHook(hook ReportHook) error {
+	err := o.db.PutReportHook(hook)
+	o.observe("PutReportHook", err)
+	return err
+}
+
+// DeleteReportHook implements same signature of the DB interface.
+func (o *ObservedDB) DeleteReportHook(name string) error {
+	err := o.db.DeleteReportHook(name)
+	o.observe("DeleteReportHook", err)
+	return err
+}
+
+// PutDeadLetter implements same signature of the DB interface.
+func (o *ObservedDB) PutDeadLetter(letter DeadLetter) error {
+	err := o.db.PutDeadLetter(letter)
+	o.observe("PutDeadLetter", err)
+	return err
+}
+
+// ListDeadLetters implements same signature of the DB interface.
+func (o *ObservedDB) ListDeadLetters(hook string, limit int) ([]DeadLetter, error) {
+	v, err := o.db.ListDeadLetters(hook, limit)
+	o.observe("ListDeadLetters", err)
+	return v, err
+}
+
+// CountDeadLetters implements same signature of the DB interface.
+func (o *ObservedDB) CountDeadLetters(hook string) (int, error) {
+	v, err := o.db.CountDeadLetters(hook)
+	o.observe("CountDeadLetters", err)
+	return v, err
+}
+
+// TrimDeadLetters implements same signature of the DB interface.
+func (o *ObservedDB) TrimDeadLetters(hook string, keep int) error {
+	err := o.db.TrimDeadLetters(hook, keep)
+	o.observe("TrimDeadLetters", err)
+	return err
+}
+
+// GetDeadLetter implements same signature of the DB interface.
+func (o *ObservedDB) GetDeadLetter(hook, id string) (*DeadLetter, error) {
+	v, err := o.db.GetDeadLetter(hook, id)
+	o.observe("GetDeadLetter", err)
+	return v, err
+}
+
+// DeleteDeadLetter implements same signature of the DB interface.
+func (o *ObservedDB) DeleteDeadLetter(hook, id string) error {
+	err := o.db.DeleteDeadLetter(hook, id)
+	o.observe("DeleteDeadLetter", err)
+	return err
+}
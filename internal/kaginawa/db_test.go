@@ -1,6 +1,10 @@
 package kaginawa
 
-import "testing"
+import (
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestServer(t *testing.T) {
 	server := SSHServer{
@@ -12,6 +16,170 @@ func TestServer(t *testing.T) {
 	}
 }
 
+func TestAPIKey_IsActive(t *testing.T) {
+	future := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Hour).Unix()
+	tests := []struct {
+		in     APIKey
+		active bool
+	}{
+		{APIKey{Key: "a"}, true},
+		{APIKey{Key: "b", ExpiresAt: future}, true},
+		{APIKey{Key: "c", ExpiresAt: past}, false},
+		{APIKey{Key: "d", Disabled: true}, false},
+		{APIKey{Key: "e", Disabled: true, ExpiresAt: future}, false},
+	}
+	for i, test := range tests {
+		if test.in.IsActive() != test.active {
+			t.Errorf("#%d: expected IsActive() = %v, got %v", i, test.active, test.in.IsActive())
+		}
+	}
+}
+
+func TestAPIKey_HasScope(t *testing.T) {
+	tests := []struct {
+		in      APIKey
+		scope   string
+		granted bool
+	}{
+		{APIKey{Key: "legacy"}, ScopeReportWrite, true},
+		{APIKey{Key: "legacy"}, ScopeNodesRead, false},
+		{APIKey{Key: "admin", Admin: true}, ScopeCommandExec, true},
+		{APIKey{Key: "scoped", Scopes: []string{ScopeNodesRead}}, ScopeNodesRead, true},
+		{APIKey{Key: "scoped", Scopes: []string{ScopeNodesRead}}, ScopeReportWrite, false},
+		{APIKey{Key: "scoped", Scopes: []string{ScopeNodesRead}}, ScopeServersRead, false},
+	}
+	for i, test := range tests {
+		if test.in.HasScope(test.scope) != test.granted {
+			t.Errorf("#%d: expected HasScope(%s) = %v, got %v", i, test.scope, test.granted, !test.granted)
+		}
+	}
+}
+
+func TestAPIKey_AllowsCustomID(t *testing.T) {
+	all := APIKey{Key: "all"}
+	if !all.AllowsCustomID("dev1") {
+		t.Error("expected AllowsCustomID(dev1) without restriction = true, got false")
+	}
+	restricted := APIKey{Key: "restricted", CustomIDs: []string{"dev1", "dev2"}}
+	if !restricted.AllowsCustomID("dev2") {
+		t.Error("expected AllowsCustomID(dev2) = true, got false")
+	}
+	if restricted.AllowsCustomID("dev3") {
+		t.Error("expected AllowsCustomID(dev3) = false, got true")
+	}
+}
+
+func TestDB_APIKeys(t *testing.T) {
+	var db DB = NewMemDB()
+	newKey := NewAPIKey("test")
+	newKey.Label = "test key"
+	if err := db.PutAPIKey(newKey); err != nil {
+		t.Fatal(err)
+	}
+	if valid, err := db.ValidateAPIKey("test"); err != nil || valid == nil || valid.Label != "test key" {
+		t.Errorf("expected ValidateAPIKey(test).Label = test key, got %v (err=%v)", valid, err)
+	}
+	apiKey, err := db.GetAPIKey(HashAPIKey("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apiKey == nil {
+		t.Fatal("expected GetAPIKey(test) = non-nil, got nil")
+	}
+	apiKey.Disabled = true
+	if err := db.PutAPIKey(*apiKey); err != nil {
+		t.Fatal(err)
+	}
+	if valid, _ := db.ValidateAPIKey("test"); valid != nil {
+		t.Errorf("expected ValidateAPIKey(test) after disabled = nil, got %v", valid)
+	}
+	if err := db.DeleteAPIKey(apiKey.Key); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := db.GetAPIKey(apiKey.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != nil {
+		t.Errorf("expected GetAPIKey(test) after delete = nil, got %v", deleted)
+	}
+}
+
+func TestNewAPIKey(t *testing.T) {
+	apiKey := NewAPIKey("1uoAOPhDSr5q5LTcPu2JKTfZaVm")
+	if apiKey.Key == "1uoAOPhDSr5q5LTcPu2JKTfZaVm" || !apiKey.IsHashed() {
+		t.Errorf("expected hashed key, got %s", apiKey.Key)
+	}
+	if apiKey.Key != HashAPIKey("1uoAOPhDSr5q5LTcPu2JKTfZaVm") {
+		t.Errorf("expected stable hash, got %s", apiKey.Key)
+	}
+	if apiKey.Prefix != "1uoAOP" {
+		t.Errorf("expected Prefix = %s, got %s", "1uoAOP", apiKey.Prefix)
+	}
+	if short := NewAPIKey("abc"); short.Prefix != "" {
+		t.Errorf("expected empty Prefix for short key, got %s", short.Prefix)
+	}
+}
+
+func TestMigrateAPIKeys(t *testing.T) {
+	var db DB = NewMemDB()
+	if err := db.PutAPIKey(APIKey{Key: "plaintext", Label: "legacy", Admin: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutAPIKey(NewAPIKey("hashed")); err != nil {
+		t.Fatal(err)
+	}
+	n, err := MigrateAPIKeys(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected MigrateAPIKeys() = %d, got %d", 1, n)
+	}
+	keys, err := db.ListAPIKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		if !k.IsHashed() {
+			t.Errorf("expected all keys are hashed, got %s", k.Key)
+		}
+	}
+	migrated, err := db.ValidateAPIKey("plaintext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated == nil || migrated.Label != "legacy" || !migrated.Admin {
+		t.Errorf("expected migrated key keeps attributes, got %v", migrated)
+	}
+}
+
+func TestMigrateAPIKeys_reports(t *testing.T) {
+	db := NewMemDB()
+	if err := db.PutAPIKey(APIKey{Key: "plaintext"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutReport(Report{ID: "node1", APIKey: "plaintext"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateAPIKeys(db); err != nil {
+		t.Fatal(err)
+	}
+	report, err := db.GetReportByID("node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.APIKey != HashAPIKey("plaintext") {
+		t.Errorf("expected hashed api key in the report, got %s", report.APIKey)
+	}
+	for _, history := range db.logs {
+		if history.APIKey != HashAPIKey("plaintext") {
+			t.Errorf("expected hashed api key in the history, got %s", history.APIKey)
+		}
+	}
+}
+
 func TestDB_SSHServers(t *testing.T) {
 	var db DB = NewMemDB()
 	if err := db.PutSSHServer(SSHServer{
@@ -39,6 +207,16 @@ func TestDB_SSHServers(t *testing.T) {
 	if server.Host != "localhost" {
 		t.Errorf("expected server.Host is %s, got %s", "localhost", server.Host)
 	}
+	if err := db.DeleteSSHServer("localhost"); err != nil {
+		t.Fatal(err)
+	}
+	server, err = db.GetSSHServerByHost("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server != nil {
+		t.Errorf("expected GetSSHServerByHost(localhost) = nil after delete, got %v", server)
+	}
 }
 
 func TestDB_Reports(t *testing.T) {
@@ -111,3 +289,292 @@ func TestDB_UserSessions(t *testing.T) {
 		t.Errorf("expected GetUserSession after delete is nil, got %v", s2)
 	}
 }
+
+func TestDB_KnownHosts(t *testing.T) {
+	var db DB = NewMemDB()
+	if err := db.PutKnownHost(KnownHost{ID: "node1", Key: "ssh-ed25519 AAAA", Timestamp: 1}); err != nil {
+		t.Fatal(err)
+	}
+	host, err := db.GetKnownHost("node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host == nil || host.Key != "ssh-ed25519 AAAA" {
+		t.Errorf("expected known host of node1, got %v", host)
+	}
+	if err := db.DeleteKnownHost("node1"); err != nil {
+		t.Fatal(err)
+	}
+	host, err = db.GetKnownHost("node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != nil {
+		t.Errorf("expected nil after delete, got %v", host)
+	}
+}
+
+func TestDB_CommandLogs(t *testing.T) {
+	var db DB = NewMemDB()
+	for _, entry := range []CommandLog{
+		{ID: "1", NodeID: "node1", Command: "uptime"},
+		{ID: "2", NodeID: "node2", Command: "uptime"},
+		{ID: "3", NodeID: "node1", Command: "df"},
+		{ID: "4", NodeID: "node1", Command: "free"},
+	} {
+		if err := db.PutCommandLog(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logs, err := db.ListCommandLogs("node1", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 || logs[0].ID != "4" || logs[1].ID != "3" {
+		t.Errorf("expected latest 2 logs of node1, got %v", logs)
+	}
+	logs, err = db.ListCommandLogs("node1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 3 {
+		t.Errorf("expected 3 logs of node1, got %d", len(logs))
+	}
+}
+
+func TestDB_CredentialProfiles(t *testing.T) {
+	var db DB = NewMemDB()
+	for _, profile := range []CredentialProfile{
+		{Name: "b", User: "admin", Password: "pass", NodeIDs: []string{"node1"}},
+		{Name: "a", User: "pi", Password: "pass", CustomIDs: []string{"site1"}},
+	} {
+		if err := db.PutCredentialProfile(profile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	profiles, err := db.ListCredentialProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 || profiles[0].Name != "a" {
+		t.Fatalf("expected 2 profiles sorted by name, got %v", profiles)
+	}
+	tests := []struct {
+		report   Report
+		expected string
+	}{
+		{Report{ID: "node1", CustomID: "site1"}, "b"},
+		{Report{ID: "node2", CustomID: "site1"}, "a"},
+		{Report{ID: "node3", CustomID: "site2"}, ""},
+		{Report{ID: "node4"}, ""},
+	}
+	for _, test := range tests {
+		profile := FindAssignedProfile(profiles, test.report)
+		if len(test.expected) == 0 && profile != nil {
+			t.Errorf("expected no profile for %s, got %s", test.report.ID, profile.Name)
+		}
+		if len(test.expected) > 0 && (profile == nil || profile.Name != test.expected) {
+			t.Errorf("expected profile %s for %s, got %v", test.expected, test.report.ID, profile)
+		}
+	}
+	if !(CredentialProfile{}).UsableFor(Report{ID: "node3", CustomID: "site2"}) {
+		t.Error("expected unassigned profile usable for any nodes")
+	}
+	if profiles[0].UsableFor(Report{ID: "node3", CustomID: "site2"}) {
+		t.Errorf("expected profile %s not usable for other nodes", profiles[0].Name)
+	}
+	if err := db.DeleteCredentialProfile("a"); err != nil {
+		t.Fatal(err)
+	}
+	profile, err := db.GetCredentialProfile("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile != nil {
+		t.Errorf("expected deleted, got %v", profile)
+	}
+}
+
+func TestDB_SavedCommands(t *testing.T) {
+	var db DB = NewMemDB()
+	for _, command := range []SavedCommand{
+		{Name: "uptime", Command: "uptime"},
+		{Name: "logs", Command: "journalctl -u {{service}}"},
+	} {
+		if err := db.PutSavedCommand(command); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commands, err := db.ListSavedCommands()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != 2 || commands[0].Name != "logs" {
+		t.Fatalf("expected 2 commands sorted by name, got %v", commands)
+	}
+	if err := db.DeleteSavedCommand("logs"); err != nil {
+		t.Fatal(err)
+	}
+	command, err := db.GetSavedCommand("logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if command != nil {
+		t.Errorf("expected deleted, got %v", command)
+	}
+}
+
+func TestDB_ScheduleRuns(t *testing.T) {
+	var db DB = NewMemDB()
+	if err := db.PutSchedule(Schedule{Name: "cleanup", Spec: "@daily", Command: "df", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, run := range []ScheduleRun{
+		{ID: "1", Schedule: "cleanup"},
+		{ID: "2", Schedule: "other"},
+		{ID: "3", Schedule: "cleanup"},
+		{ID: "4", Schedule: "cleanup"},
+	} {
+		if err := db.PutScheduleRun(run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runs, err := db.ListScheduleRuns("cleanup", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].ID != "4" || runs[1].ID != "3" {
+		t.Errorf("expected newest 2 runs, got %v", runs)
+	}
+	if err := db.DeleteSchedule("cleanup"); err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := db.GetSchedule("cleanup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schedule != nil {
+		t.Errorf("expected deleted, got %v", schedule)
+	}
+	if runs, _ := db.ListScheduleRuns("cleanup", 0); len(runs) != 3 {
+		t.Errorf("expected runs preserved, got %d", len(runs))
+	}
+}
+
+func TestDB_ClaimNodeEvent(t *testing.T) {
+	var db DB = NewMemDB()
+	if claimed, err := db.ClaimNodeEvent("node1", EventOffline, 10); err != nil || !claimed {
+		t.Fatalf("expected claimed, got %v, %v", claimed, err)
+	}
+	if claimed, err := db.ClaimNodeEvent("node1", EventOffline, 10); err != nil || claimed {
+		t.Errorf("expected claimed by others, got %v, %v", claimed, err)
+	}
+	if claimed, err := db.ClaimNodeEvent("node1", EventRecovered, 10); err != nil || !claimed {
+		t.Errorf("expected recovered event claimed, got %v, %v", claimed, err)
+	}
+	if claimed, err := db.ClaimNodeEvent("node1", EventOffline, 20); err != nil || !claimed {
+		t.Errorf("expected next offline period claimed, got %v, %v", claimed, err)
+	}
+	if claimed, err := db.ClaimNodeEvent("node2", EventOffline, 10); err != nil || !claimed {
+		t.Errorf("expected other node claimed, got %v, %v", claimed, err)
+	}
+}
+
+func TestDB_ClaimScheduleRun(t *testing.T) {
+	var db DB = NewMemDB()
+	if err := db.PutSchedule(Schedule{Name: "cleanup", Spec: "@daily", Command: "df", LastRunAt: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, err := db.ClaimScheduleRun("cleanup", 10, 20); err != nil || !claimed {
+		t.Fatalf("expected claimed, got %v, %v", claimed, err)
+	}
+	if claimed, err := db.ClaimScheduleRun("cleanup", 10, 20); err != nil || claimed {
+		t.Errorf("expected claimed by others, got %v, %v", claimed, err)
+	}
+	if claimed, err := db.ClaimScheduleRun("unknown", 0, 20); err != nil || claimed {
+		t.Errorf("expected not claimed for unknown schedule, got %v, %v", claimed, err)
+	}
+	schedule, err := db.GetSchedule("cleanup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schedule.LastRunAt != 20 || schedule.Command != "df" {
+		t.Errorf("unexpected schedule: %+v", schedule)
+	}
+	// Put with the stale last run time, e.g. toggled while claimed
+	if err := db.PutSchedule(Schedule{Name: "cleanup", Spec: "@daily", Command: "df -h", LastRunAt: 10}); err != nil {
+		t.Fatal(err)
+	}
+	schedule, err = db.GetSchedule("cleanup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schedule.LastRunAt != 20 || schedule.Command != "df -h" {
+		t.Errorf("expected claimed last run time kept, got %+v", schedule)
+	}
+}
+
+func TestDB_DeadLetters(t *testing.T) {
+	var db DB = NewMemDB()
+	for _, letter := range []DeadLetter{
+		{ID: "1", Hook: "warehouse"},
+		{ID: "2", Hook: "other"},
+		{ID: "3", Hook: "warehouse"},
+		{ID: "4", Hook: "warehouse"},
+	} {
+		if err := db.PutDeadLetter(letter); err != nil {
+			t.Fatal(err)
+		}
+	}
+	letters, err := db.ListDeadLetters("warehouse", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 2 || letters[0].ID != "4" || letters[1].ID != "3" {
+		t.Errorf("expected newest 2 dead letters, got %v", letters)
+	}
+	if err := db.DeleteDeadLetter("warehouse", "4"); err != nil {
+		t.Fatal(err)
+	}
+	if letter, _ := db.GetDeadLetter("warehouse", "4"); letter != nil {
+		t.Errorf("expected deleted, got %v", letter)
+	}
+	if letter, _ := db.GetDeadLetter("other", "2"); letter == nil || letter.Hook != "other" {
+		t.Errorf("expected dead letter of other hook, got %v", letter)
+	}
+	if err := db.TrimDeadLetters("warehouse", 1); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := db.CountDeadLetters("warehouse"); count != 1 {
+		t.Errorf("expected 1 dead letter kept, got %d", count)
+	}
+	if letter, _ := db.GetDeadLetter("warehouse", "3"); letter == nil {
+		t.Errorf("expected newest dead letter kept")
+	}
+	if count, _ := db.CountDeadLetters("other"); count != 1 {
+		t.Errorf("expected dead letters of other hook kept, got %d", count)
+	}
+}
+
+func TestObservedDB(t *testing.T) {
+	var methods []string
+	var errs int
+	db := NewObservedDB(NewMemDB(), func(method string, err error) {
+		methods = append(methods, method)
+		if err != nil {
+			errs++
+		}
+	})
+	if err := db.PutReport(Report{ID: "node1"}); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := db.GetReportByID("node1"); err != nil || report == nil {
+		t.Fatalf("expected report, got %v %v", report, err)
+	}
+	if reports, n, err := db.CountAndListReports(0, 0, 0, AllAttributes); err != nil || n != 1 || len(reports) != 1 {
+		t.Fatalf("expected 1 report, got %d %d %v", len(reports), n, err)
+	}
+	if strings.Join(methods, ",") != "PutReport,GetReportByID,CountAndListReports" || errs != 0 {
+		t.Errorf("unexpected observations: %v (%d errors)", methods, errs)
+	}
+}
@@ -0,0 +1,107 @@
+package kaginawa
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Node event types.
+const (
+	EventOffline       = "offline"        // No reports within the expected interval
+	EventRecovered     = "recovered"      // Reported again after offline
+	EventNewNode       = "new_node"       // First report of a node
+	EventReportError   = "report_error"   // Report contains errors
+	EventAlertFiring   = "alert_firing"   // Alert rule exceeded
+	EventAlertResolved = "alert_resolved" // Alert rule no longer exceeded
+	EventTest          = "test"           // Sent from the admin page to check a notifier
+)
+
+// EventTypes defines list of event types selectable by notifiers.
+var EventTypes = []string{
+	EventOffline,
+	EventRecovered,
+	EventNewNode,
+	EventReportError,
+	EventAlertFiring,
+	EventAlertResolved,
+}
+
+// nodeEventKey identifies the liveness event of a node by the type and the offline period.
+func nodeEventKey(eventType string, since int64) string {
+	return eventType + "/" + strconv.FormatInt(since, 10)
+}
+
+// NodeEvent defines a state change of a node detected by the server.
+type NodeEvent struct {
+	Type      string   `json:"type"`
+	NodeID    string   `json:"node_id"`
+	CustomID  string   `json:"custom_id,omitempty"`
+	Hostname  string   `json:"hostname,omitempty"`
+	LastSeen  int64    `json:"last_seen"`              // Server time of the latest report (UTC)
+	Interval  int      `json:"interval_min,omitempty"` // Expected report interval minutes
+	Errors    []string `json:"errors,omitempty"`       // Errors of the report
+	Rule      string   `json:"rule,omitempty"`         // Alert rule name
+	Condition string   `json:"condition,omitempty"`    // Condition of the alert rule, e.g. "disk_usage > 90"
+	Value     *float64 `json:"value,omitempty"`        // Metric value of the alert rule
+	Timestamp int64    `json:"timestamp"`              // Detected time (UTC)
+}
+
+// NewReportEvent creates an event of the report.
+func NewReportEvent(eventType string, report Report) NodeEvent {
+	return NodeEvent{
+		Type:      eventType,
+		NodeID:    report.ID,
+		CustomID:  report.CustomID,
+		Hostname:  report.Hostname,
+		LastSeen:  report.ServerTime,
+		Errors:    report.Errors,
+		Timestamp: report.ServerTime,
+	}
+}
+
+// Name returns custom ID, hostname or node ID of the node.
+func (e NodeEvent) Name() string {
+	switch {
+	case len(e.CustomID) > 0:
+		return e.CustomID
+	case len(e.Hostname) > 0:
+		return e.Hostname
+	default:
+		return e.NodeID
+	}
+}
+
+// Message returns a human readable summary of the event.
+func (e NodeEvent) Message() string {
+	lastSeen := time.Unix(e.LastSeen, 0).UTC().Format(time.RFC3339)
+	switch e.Type {
+	case EventOffline:
+		return fmt.Sprintf("node %s (%s) is offline: last report at %s, expected every %d minutes",
+			e.Name(), e.NodeID, lastSeen, e.Interval)
+	case EventRecovered:
+		return fmt.Sprintf("node %s (%s) is recovered: reported at %s", e.Name(), e.NodeID, lastSeen)
+	case EventNewNode:
+		return fmt.Sprintf("new node %s (%s) reported at %s", e.Name(), e.NodeID, lastSeen)
+	case EventReportError:
+		return fmt.Sprintf("node %s (%s) reported errors: %s", e.Name(), e.NodeID, strings.Join(e.Errors, "; "))
+	case EventAlertFiring:
+		return fmt.Sprintf("node %s (%s) alert %s is firing: %s (value: %s)",
+			e.Name(), e.NodeID, e.Rule, e.Condition, e.formatValue())
+	case EventAlertResolved:
+		return fmt.Sprintf("node %s (%s) alert %s is resolved: %s (value: %s)",
+			e.Name(), e.NodeID, e.Rule, e.Condition, e.formatValue())
+	case EventTest:
+		return "test notification from kaginawa server"
+	default:
+		return fmt.Sprintf("node %s (%s): %s", e.Name(), e.NodeID, e.Type)
+	}
+}
+
+func (e NodeEvent) formatValue() string {
+	if e.Value == nil {
+		return "-"
+	}
+	return strconv.FormatFloat(*e.Value, 'f', -1, 64)
+}
@@ -0,0 +1,79 @@
+package kaginawa
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ReportHook defines database item of an outgoing webhook of received reports.
+type ReportHook struct {
+	Name       string    `json:"name" bson:"name"`
+	URL        string    `json:"url" bson:"url"`                                    // Endpoint of JSON reports
+	SigningKey string    `json:"signing_key,omitempty" bson:"signing_key"`          // HMAC-SHA256 key of payloads
+	CustomIDs  []string  `json:"custom_ids,omitempty" bson:"custom_ids"`            // Delivered custom IDs (all if empty)
+	APIKeys    []string  `json:"api_keys,omitempty" bson:"api_keys"`                // Hashes of submitting api keys (all if empty)
+	Enabled    bool      `json:"enabled" bson:"enabled"`                            // Disabled hooks receive nothing
+	UpdatedBy  string    `json:"updated_by,omitempty" bson:"updated_by"`            // API key label or user email
+	UpdatedAt  int64     `json:"updated_at,omitempty" bson:"updated_at"`            // Last updated time (UTC)
+	Secret     *Envelope `json:"-" bson:"secret,omitempty" dynamodbav:",omitempty"` // Encrypted signing key
+}
+
+// DeadLetter defines database item of a report delivery abandoned after all retries.
+type DeadLetter struct {
+	ID        string `json:"id" bson:"id"`                 // Delivery ID (KSUID)
+	Hook      string `json:"hook" bson:"hook"`             // Report hook name
+	NodeID    string `json:"node_id" bson:"node_id"`       // Node ID of the report
+	CustomID  string `json:"custom_id" bson:"custom_id"`   // Custom ID of the report
+	Payload   string `json:"payload" bson:"payload"`       // Undelivered JSON body
+	Attempts  int    `json:"attempts" bson:"attempts"`     // Number of delivery attempts
+	LastError string `json:"last_error" bson:"last_error"` // Error of the last attempt
+	CreatedAt int64  `json:"created_at" bson:"created_at"` // Abandoned time (UTC)
+}
+
+// Accepts reports whether the report should be delivered to the hook. The api key is matched by the hash of the key
+// which submitted the report.
+func (h ReportHook) Accepts(report Report) bool {
+	return h.Enabled && containsOrEmpty(h.CustomIDs, report.CustomID) && containsOrEmpty(h.APIKeys, report.APIKey)
+}
+
+// MigrateReportHookAPIKeys replaces labels of api keys in all report hooks with hashes of the keys. Labels matching no
+// keys or several keys are kept, and match no reports. Returns number of migrated report hooks.
+func MigrateReportHookAPIKeys(db DB) (int, error) {
+	hooks, err := db.ListReportHooks()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list report hooks: %w", err)
+	}
+	keys, err := db.ListAPIKeys()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	hashes := make(map[string][]string)
+	for _, key := range keys {
+		hashes[key.Label] = append(hashes[key.Label], key.Key)
+	}
+	migrated := 0
+	for _, hook := range hooks {
+		changed := false
+		for i, label := range hook.APIKeys {
+			if strings.HasPrefix(label, apiKeyHashPrefix) {
+				continue
+			}
+			if len(hashes[label]) != 1 {
+				log.Printf("report hook %s: api key label %q matches %d keys, select the key again", hook.Name, label,
+					len(hashes[label]))
+				continue
+			}
+			hook.APIKeys[i] = hashes[label][0]
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		if err := db.PutReportHook(hook); err != nil {
+			return migrated, fmt.Errorf("failed to put report hook %s: %w", hook.Name, err)
+		}
+		migrated++
+	}
+	return migrated, nil
+}
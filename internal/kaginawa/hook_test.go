@@ -0,0 +1,59 @@
+package kaginawa
+
+import "testing"
+
+func TestReportHook_Accepts(t *testing.T) {
+	tests := []struct {
+		hook     ReportHook
+		customID string
+		apiKey   string
+		expected bool
+	}{
+		{ReportHook{Enabled: true}, "site1", "hmac-sha256:agent", true},
+		{ReportHook{}, "site1", "hmac-sha256:agent", false},
+		{ReportHook{Enabled: true, CustomIDs: []string{"site1"}}, "site1", "hmac-sha256:agent", true},
+		{ReportHook{Enabled: true, CustomIDs: []string{"site1"}}, "site2", "hmac-sha256:agent", false},
+		{ReportHook{Enabled: true, APIKeys: []string{"hmac-sha256:agent"}}, "site1", "hmac-sha256:agent", true},
+		{ReportHook{Enabled: true, APIKeys: []string{"hmac-sha256:agent"}}, "site1", "hmac-sha256:other", false},
+	}
+	for i, test := range tests {
+		if actual := test.hook.Accepts(Report{CustomID: test.customID, APIKey: test.apiKey}); actual != test.expected {
+			t.Errorf("#%d: expected %v, got %v", i, test.expected, actual)
+		}
+	}
+}
+
+func TestMigrateReportHookAPIKeys(t *testing.T) {
+	var db DB = NewMemDB()
+	agent := NewAPIKey("agent-key")
+	agent.Label = "agent"
+	duplicated1 := NewAPIKey("duplicated-key1")
+	duplicated1.Label = "duplicated"
+	duplicated2 := NewAPIKey("duplicated-key2")
+	duplicated2.Label = "duplicated"
+	for _, key := range []APIKey{agent, duplicated1, duplicated2} {
+		if err := db.PutAPIKey(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.PutReportHook(ReportHook{Name: "legacy", APIKeys: []string{"agent", "duplicated"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutReportHook(ReportHook{Name: "current", APIKeys: []string{agent.Key}}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := MigrateReportHookAPIKeys(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected MigrateReportHookAPIKeys() = %d, got %d", 1, n)
+	}
+	hook, err := db.GetReportHook("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hook == nil || len(hook.APIKeys) != 2 || hook.APIKeys[0] != agent.Key || hook.APIKeys[1] != "duplicated" {
+		t.Errorf("expected unique label replaced by hash, got %+v", hook)
+	}
+}
@@ -0,0 +1,54 @@
+package kaginawa
+
+// Notifier types.
+const (
+	NotifierWebhook = "webhook" // Generic HTTP webhook of JSON events
+	NotifierSlack   = "slack"   // Slack-compatible incoming webhook
+	NotifierEmail   = "email"   // Email via SMTP
+)
+
+// NotifierTypes defines list of all notifier types.
+var NotifierTypes = []string{NotifierWebhook, NotifierSlack, NotifierEmail}
+
+// Notifier defines database item of a destination of node events.
+type Notifier struct {
+	Name         string    `json:"name" bson:"name"`
+	Type         string    `json:"type" bson:"type"`                                  // webhook, slack or email
+	URL          string    `json:"url,omitempty" bson:"url"`                          // Endpoint of webhook and slack
+	SigningKey   string    `json:"signing_key,omitempty" bson:"signing_key"`          // HMAC-SHA256 key of webhook payloads
+	SMTPHost     string    `json:"smtp_host,omitempty" bson:"smtp_host"`              // SMTP server address (host:port)
+	SMTPUser     string    `json:"smtp_user,omitempty" bson:"smtp_user"`              // SMTP user name (no auth if empty)
+	SMTPPassword string    `json:"smtp_password,omitempty" bson:"smtp_password"`      // SMTP password
+	From         string    `json:"from,omitempty" bson:"from"`                        // Sender address of email
+	To           []string  `json:"to,omitempty" bson:"to"`                            // Recipient addresses of email
+	Events       []string  `json:"events,omitempty" bson:"events"`                    // Delivered event types (all if empty)
+	CustomIDs    []string  `json:"custom_ids,omitempty" bson:"custom_ids"`            // Delivered custom IDs (all if empty)
+	Enabled      bool      `json:"enabled" bson:"enabled"`                            // Disabled notifiers receive nothing
+	UpdatedBy    string    `json:"updated_by,omitempty" bson:"updated_by"`            // API key label or user email
+	UpdatedAt    int64     `json:"updated_at,omitempty" bson:"updated_at"`            // Last updated time (UTC)
+	Secret       *Envelope `json:"-" bson:"secret,omitempty" dynamodbav:",omitempty"` // Encrypted signing key and SMTP password
+}
+
+// Accepts reports whether the event should be delivered to the notifier. Test events are always accepted.
+func (n Notifier) Accepts(event NodeEvent) bool {
+	if event.Type == EventTest {
+		return true
+	}
+	if !n.Enabled || !containsOrEmpty(n.Events, event.Type) {
+		return false
+	}
+	return containsOrEmpty(n.CustomIDs, event.CustomID)
+}
+
+func containsOrEmpty(values []string, v string) bool {
+	return len(values) == 0 || containsString(values, v)
+}
+
+func containsString(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
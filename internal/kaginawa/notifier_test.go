@@ -0,0 +1,25 @@
+package kaginawa
+
+import "testing"
+
+func TestNotifier_Accepts(t *testing.T) {
+	tests := []struct {
+		notifier Notifier
+		event    NodeEvent
+		expected bool
+	}{
+		{Notifier{Enabled: true}, NodeEvent{Type: EventOffline, CustomID: "site1"}, true},
+		{Notifier{}, NodeEvent{Type: EventOffline}, false},
+		{Notifier{}, NodeEvent{Type: EventTest}, true},
+		{Notifier{Enabled: true, Events: []string{EventOffline}}, NodeEvent{Type: EventOffline}, true},
+		{Notifier{Enabled: true, Events: []string{EventOffline}}, NodeEvent{Type: EventNewNode}, false},
+		{Notifier{Enabled: true, CustomIDs: []string{"site1"}}, NodeEvent{Type: EventNewNode, CustomID: "site1"}, true},
+		{Notifier{Enabled: true, CustomIDs: []string{"site1"}}, NodeEvent{Type: EventNewNode, CustomID: "site2"}, false},
+		{Notifier{Enabled: true, CustomIDs: []string{"site1"}}, NodeEvent{Type: EventNewNode}, false},
+	}
+	for i, test := range tests {
+		if actual := test.notifier.Accepts(test.event); actual != test.expected {
+			t.Errorf("#%d: expected %v, got %v", i, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package kaginawa
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Relay selection policies.
+const (
+	// RelayPolicyRandom picks a random server.
+	RelayPolicyRandom = "random"
+	// RelayPolicySticky keeps the server that the node is currently connected to.
+	RelayPolicySticky = "sticky"
+	// RelayPolicyLeastLoaded picks a server with the least number of connected and assigned nodes.
+	RelayPolicyLeastLoaded = "least-loaded"
+	// RelayPolicyWeighted picks a random server by the weight of servers.
+	RelayPolicyWeighted = "weighted"
+	// RelayPolicyPinned picks a server pinned to the custom ID of the node.
+	RelayPolicyPinned = "pinned"
+)
+
+// RelaySelector selects a ssh server for the node. Returns nil if the selector can not decide.
+type RelaySelector interface {
+	Select(servers []SSHServer, report Report) *SSHServer
+}
+
+// RandomSelector selects a random server.
+type RandomSelector struct{}
+
+// Select implements the RelaySelector interface.
+func (RandomSelector) Select(servers []SSHServer, _ Report) *SSHServer {
+	if len(servers) == 0 {
+		return nil
+	}
+	return &servers[rand.Intn(len(servers))]
+}
+
+// StickySelector selects the server that the node is currently connected to.
+type StickySelector struct{}
+
+// Select implements the RelaySelector interface.
+func (StickySelector) Select(servers []SSHServer, report Report) *SSHServer {
+	return findServer(servers, report.SSHServerHost)
+}
+
+// PinnedSelector selects the server pinned to the custom ID of the node.
+type PinnedSelector struct {
+	Pins map[string]string // Custom ID to host
+}
+
+// Select implements the RelaySelector interface.
+func (s PinnedSelector) Select(servers []SSHServer, report Report) *SSHServer {
+	if len(report.CustomID) == 0 {
+		return nil
+	}
+	host, ok := s.Pins[report.CustomID]
+	if !ok {
+		return nil
+	}
+	return findServer(servers, host)
+}
+
+// WeightedSelector selects a random server by the weight of servers. Zero or negative weight counts as 1.
+type WeightedSelector struct{}
+
+// Select implements the RelaySelector interface.
+func (WeightedSelector) Select(servers []SSHServer, _ Report) *SSHServer {
+	if len(servers) == 0 {
+		return nil
+	}
+	total := 0
+	for _, s := range servers {
+		total += s.weight()
+	}
+	n := rand.Intn(total)
+	for i := range servers {
+		n -= servers[i].weight()
+		if n < 0 {
+			return &servers[i]
+		}
+	}
+	return &servers[len(servers)-1]
+}
+
+// LeastLoadedSelector selects a server with the least number of connected and assigned nodes counted by RelayLoads.
+// Ties are broken randomly.
+type LeastLoadedSelector struct {
+	Loads *RelayLoads
+}
+
+// Select implements the RelaySelector interface.
+func (s LeastLoadedSelector) Select(servers []SSHServer, report Report) *SSHServer {
+	if len(servers) == 0 {
+		return nil
+	}
+	counts := s.Loads.Counts(report.ID)
+	var candidates []int
+	least := -1
+	for i, server := range servers {
+		c := counts[server.Host]
+		if least < 0 || c < least {
+			least = c
+			candidates = candidates[:0]
+		}
+		if c == least {
+			candidates = append(candidates, i)
+		}
+	}
+	return &servers[candidates[rand.Intn(len(candidates))]]
+}
+
+// ChainSelector tries selectors in order, and falls back to random selection.
+type ChainSelector []RelaySelector
+
+// Select implements the RelaySelector interface.
+func (c ChainSelector) Select(servers []SSHServer, report Report) *SSHServer {
+	for _, s := range c {
+		if server := s.Select(servers, report); server != nil {
+			return server
+		}
+	}
+	return RandomSelector{}.Select(servers, report)
+}
+
+// NewRelaySelector builds a selector from comma separated policies such as "pinned,sticky,least-loaded".
+func NewRelaySelector(policies string, pins map[string]string, loads *RelayLoads) (RelaySelector, error) {
+	var chain ChainSelector
+	for _, policy := range strings.Split(policies, ",") {
+		switch strings.TrimSpace(policy) {
+		case "":
+			continue
+		case RelayPolicyRandom:
+			chain = append(chain, RandomSelector{})
+		case RelayPolicySticky:
+			chain = append(chain, StickySelector{})
+		case RelayPolicyLeastLoaded:
+			chain = append(chain, LeastLoadedSelector{loads})
+		case RelayPolicyWeighted:
+			chain = append(chain, WeightedSelector{})
+		case RelayPolicyPinned:
+			chain = append(chain, PinnedSelector{pins})
+		default:
+			return nil, fmt.Errorf("unknown relay policy: %s", policy)
+		}
+	}
+	return chain, nil
+}
+
+// ParseRelayPins parses comma separated list of "custom-id=host" pairs.
+func ParseRelayPins(pins string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, pin := range strings.Split(pins, ",") {
+		pin = strings.TrimSpace(pin)
+		if len(pin) == 0 {
+			continue
+		}
+		kv := strings.SplitN(pin, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 || len(kv[1]) == 0 {
+			return nil, fmt.Errorf("invalid relay pin format, expected custom-id=host: %s", pin)
+		}
+		m[kv[0]] = kv[1]
+	}
+	return m, nil
+}
+
+func findServer(servers []SSHServer, host string) *SSHServer {
+	if len(host) == 0 {
+		return nil
+	}
+	for i := range servers {
+		if servers[i].Host == host {
+			return &servers[i]
+		}
+	}
+	return nil
+}
+
+// RelayLoads tracks ssh servers of nodes on memory. Nodes connected to ssh servers are observed from stored reports,
+// which include nodes assigned by other server instances, and assignments of this instance are counted until the ttl
+// so that nodes assigned between observations are not concentrated on one server.
+type RelayLoads struct {
+	ttl       time.Duration
+	mutex     sync.Mutex
+	entries   map[string]relayAssignment // node ID to assignment
+	connected map[string]string          // node ID to host of the last observation
+}
+
+type relayAssignment struct {
+	host string
+	time time.Time
+}
+
+// NewRelayLoads creates a RelayLoads. Assignments older than the ttl are not counted.
+func NewRelayLoads(ttl time.Duration) *RelayLoads {
+	return &RelayLoads{ttl: ttl, entries: make(map[string]relayAssignment), connected: make(map[string]string)}
+}
+
+// Observe replaces connected nodes with the nodes reporting ssh servers and remote ports.
+func (l *RelayLoads) Observe(reports []Report) {
+	connected := make(map[string]string)
+	for _, r := range reports {
+		if len(r.SSHServerHost) > 0 && r.SSHRemotePort > 0 {
+			connected[r.ID] = r.SSHServerHost
+		}
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.connected = connected
+}
+
+// Assign records the node is assigned to the host.
+func (l *RelayLoads) Assign(nodeID, host string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.entries[nodeID] = relayAssignment{host, time.Now()}
+}
+
+// Counts returns number of connected or assigned nodes by host, excluding the specified node.
+// Recent assignments take precedence over observed connections of the same node.
+func (l *RelayLoads) Counts(excludeNodeID string) map[string]int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	counts := make(map[string]int)
+	deadline := time.Now().Add(-l.ttl)
+	for id, e := range l.entries {
+		if e.time.Before(deadline) {
+			delete(l.entries, id)
+			continue
+		}
+		if id != excludeNodeID {
+			counts[e.host]++
+		}
+	}
+	for id, host := range l.connected {
+		if _, assigned := l.entries[id]; !assigned && id != excludeNodeID {
+			counts[host]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,145 @@
+package kaginawa
+
+import (
+	"testing"
+	"time"
+)
+
+var testRelays = []SSHServer{
+	{Host: "a.example.com", Port: 22},
+	{Host: "b.example.com", Port: 22},
+	{Host: "c.example.com", Port: 22},
+}
+
+func TestStickySelector(t *testing.T) {
+	server := (StickySelector{}).Select(testRelays, Report{SSHServerHost: "b.example.com"})
+	if server == nil || server.Host != "b.example.com" {
+		t.Errorf("expected b.example.com, got %v", server)
+	}
+	if server := (StickySelector{}).Select(testRelays, Report{SSHServerHost: "gone.example.com"}); server != nil {
+		t.Errorf("expected nil for removed server, got %v", server)
+	}
+	if server := (StickySelector{}).Select(testRelays, Report{}); server != nil {
+		t.Errorf("expected nil for unconnected node, got %v", server)
+	}
+}
+
+func TestPinnedSelector(t *testing.T) {
+	s := PinnedSelector{Pins: map[string]string{"dev1": "c.example.com"}}
+	if server := s.Select(testRelays, Report{CustomID: "dev1"}); server == nil || server.Host != "c.example.com" {
+		t.Errorf("expected c.example.com, got %v", server)
+	}
+	if server := s.Select(testRelays, Report{CustomID: "dev2"}); server != nil {
+		t.Errorf("expected nil for unpinned node, got %v", server)
+	}
+}
+
+func TestWeightedSelector(t *testing.T) {
+	servers := []SSHServer{{Host: "heavy", Weight: 9}, {Host: "light"}}
+	heavy := 0
+	for i := 0; i < 10000; i++ {
+		if (WeightedSelector{}).Select(servers, Report{}).Host == "heavy" {
+			heavy++
+		}
+	}
+	if heavy < 8500 || heavy > 9500 {
+		t.Errorf("expected about 9000 selections of heavy, got %d", heavy)
+	}
+	if server := (WeightedSelector{}).Select(nil, Report{}); server != nil {
+		t.Errorf("expected nil for empty servers, got %v", server)
+	}
+}
+
+func TestLeastLoadedSelector(t *testing.T) {
+	loads := NewRelayLoads(time.Minute)
+	loads.Assign("n1", "a.example.com")
+	loads.Assign("n2", "a.example.com")
+	loads.Assign("n3", "b.example.com")
+	s := LeastLoadedSelector{Loads: loads}
+	if server := s.Select(testRelays, Report{ID: "n4"}); server == nil || server.Host != "c.example.com" {
+		t.Errorf("expected c.example.com, got %v", server)
+	}
+	loads.Assign("n4", "c.example.com")
+	loads.Assign("n5", "c.example.com")
+	if server := s.Select(testRelays, Report{ID: "n6"}); server == nil || server.Host != "b.example.com" {
+		t.Errorf("expected b.example.com, got %v", server)
+	}
+	// own assignment is not counted
+	if server := s.Select(testRelays, Report{ID: "n3"}); server == nil || server.Host != "b.example.com" {
+		t.Errorf("expected b.example.com, got %v", server)
+	}
+}
+
+func TestRelayLoads_expiry(t *testing.T) {
+	loads := NewRelayLoads(-time.Second)
+	loads.Assign("n1", "a.example.com")
+	if counts := loads.Counts(""); len(counts) != 0 {
+		t.Errorf("expected no counts, got %v", counts)
+	}
+}
+
+func TestRelayLoads_Observe(t *testing.T) {
+	loads := NewRelayLoads(time.Minute)
+	loads.Observe([]Report{
+		{ID: "n1", SSHServerHost: "a.example.com", SSHRemotePort: 10001},
+		{ID: "n2", SSHServerHost: "a.example.com", SSHRemotePort: 10002},
+		{ID: "n3", SSHServerHost: "b.example.com"}, // not connected
+		{ID: "n4", SSHServerHost: "b.example.com", SSHRemotePort: 10004},
+	})
+	loads.Assign("n2", "c.example.com") // reassigned after the observation
+	counts := loads.Counts("n4")
+	if counts["a.example.com"] != 1 || counts["b.example.com"] != 0 || counts["c.example.com"] != 1 {
+		t.Errorf("unexpected counts: %v", counts)
+	}
+	loads.Observe(nil)
+	if counts := loads.Counts(""); counts["a.example.com"] != 0 || counts["c.example.com"] != 1 {
+		t.Errorf("expected disconnected nodes not counted, got %v", counts)
+	}
+}
+
+func TestNewRelaySelector(t *testing.T) {
+	loads := NewRelayLoads(time.Minute)
+	pins := map[string]string{"dev1": "a.example.com"}
+	s, err := NewRelaySelector("pinned, sticky, least-loaded", pins, loads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		report Report
+		host   string
+	}{
+		{Report{ID: "n1", CustomID: "dev1", SSHServerHost: "b.example.com"}, "a.example.com"},
+		{Report{ID: "n2", CustomID: "dev2", SSHServerHost: "b.example.com"}, "b.example.com"},
+	}
+	for _, test := range tests {
+		if server := s.Select(testRelays, test.report); server == nil || server.Host != test.host {
+			t.Errorf("expected %s for %v, got %v", test.host, test.report, server)
+		}
+	}
+	if _, err := NewRelaySelector("unknown", nil, loads); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+	s, err = NewRelaySelector("", nil, loads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server := s.Select(testRelays, Report{}); server == nil {
+		t.Error("expected random fallback, got nil")
+	}
+	if server := s.Select(nil, Report{}); server != nil {
+		t.Errorf("expected nil for empty servers, got %v", server)
+	}
+}
+
+func TestParseRelayPins(t *testing.T) {
+	pins, err := ParseRelayPins("dev1=a.example.com, dev2=b.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != 2 || pins["dev2"] != "b.example.com" {
+		t.Errorf("unexpected pins: %v", pins)
+	}
+	if _, err := ParseRelayPins("dev1"); err == nil {
+		t.Error("expected error for invalid format")
+	}
+}
@@ -45,7 +45,7 @@ type Report struct {
 	GlobalIP   string    `json:"ip_global,omitempty" bson:"ip_global"`     // Global IP address
 	GlobalHost string    `json:"host_global,omitempty" bson:"host_global"` // Reverse lookup result for global IP address
 	ServerTime int64     `json:"server_time" bson:"server_time"`           // Server-side consumed UTC time
-	APIKey     string    `json:"api_key,omitempty" bson:"api_key"`         // Used api key
+	APIKey     string    `json:"api_key,omitempty" bson:"api_key"`         // Hash of used api key
 	TTL        time.Time `json:"-" bson:"-" dynamodbav:",unixtime"`        // DynamoDB TTL
 }
 
@@ -0,0 +1,189 @@
+package kaginawa
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCronSearchYears bounds the search of the next run time, e.g. "0 0 30 2 *" never matches.
+const maxCronSearchYears = 5
+
+// Schedule defines database item of a command executed on nodes periodically.
+type Schedule struct {
+	Name        string `json:"name" bson:"name"`
+	Spec        string `json:"spec" bson:"spec"`                         // Cron expression (UTC)
+	Command     string `json:"command" bson:"command"`                   // Executed command
+	Selector    string `json:"selector" bson:"selector"`                 // Node selector as query string, e.g. "custom-id=site1"
+	Profile     string `json:"profile,omitempty" bson:"profile"`         // Credential profile name (assigned profiles if empty)
+	Timeout     int    `json:"timeout,omitempty" bson:"timeout"`         // Timeout seconds of each node
+	Concurrency int    `json:"concurrency,omitempty" bson:"concurrency"` // Number of nodes executed in parallel
+	Enabled     bool   `json:"enabled" bson:"enabled"`
+	UpdatedBy   string `json:"updated_by,omitempty" bson:"updated_by"`   // API key label or user email
+	UpdatedAt   int64  `json:"updated_at,omitempty" bson:"updated_at"`   // Last updated time (UTC)
+	LastRunAt   int64  `json:"last_run_at,omitempty" bson:"last_run_at"` // Last started time (UTC)
+}
+
+// ScheduleRun defines database item of a result of a scheduled command execution.
+type ScheduleRun struct {
+	ID         string           `json:"id" bson:"id"`             // Time-ordered unique ID (KSUID)
+	Schedule   string           `json:"schedule" bson:"schedule"` // Schedule name
+	Command    string           `json:"command" bson:"command"`   // Executed command
+	Total      int              `json:"total" bson:"total"`       // Number of selected nodes
+	Succeeded  int              `json:"succeeded" bson:"succeeded"`
+	Failed     int              `json:"failed" bson:"failed"` // Connection errors and non-zero exit codes
+	StartedAt  int64            `json:"started_at" bson:"started_at"`
+	FinishedAt int64            `json:"finished_at" bson:"finished_at"`
+	Results    []ScheduleResult `json:"results" bson:"results"`
+}
+
+// ScheduleResult defines the command execution result of a node of a scheduled run.
+type ScheduleResult struct {
+	NodeID   string `json:"node_id" bson:"node_id"`
+	CustomID string `json:"custom_id,omitempty" bson:"custom_id"`
+	Hostname string `json:"hostname,omitempty" bson:"hostname"`
+	ExitCode int    `json:"exit_code" bson:"exit_code"`           // Exit code (-1 if unknown)
+	Output   string `json:"output,omitempty" bson:"output"`       // Combined output, truncated
+	Error    string `json:"error,omitempty" bson:"error"`         // Error message
+	Duration int64  `json:"duration_ms" bson:"duration_ms"`       // Execution time in milliseconds
+	TimedOut bool   `json:"timed_out,omitempty" bson:"timed_out"` // Killed by timeout
+}
+
+// CronSpec defines a parsed cron expression of five fields: minute, hour, day of month, month and day of week.
+type CronSpec struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a cron expression. Supports "*", numbers, ranges ("1-5"), lists ("1,3") and steps ("*/15")
+// in addition to the macros such as "@daily". Day of week accepts both 0 and 7 as Sunday.
+func ParseCron(spec string) (*CronSpec, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields", spec)
+	}
+	var c CronSpec
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minute of %q: %w", spec, err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hour of %q: %w", spec, err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day of month of %q: %w", spec, err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month of %q: %w", spec, err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day of week of %q: %w", spec, err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1 // 7 is also Sunday
+	}
+	c.domAny = strings.HasPrefix(fields[2], "*")
+	c.dowAny = strings.HasPrefix(fields[4], "*")
+	return &c, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step: %s", part)
+			}
+			step = n
+			part = part[:i]
+		}
+		begin, end := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			b, err1 := strconv.Atoi(bounds[0])
+			e, err2 := strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil || b > e {
+				return 0, fmt.Errorf("invalid range: %s", part)
+			}
+			begin, end = b, e
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value: %s", part)
+			}
+			begin, end = n, n
+			if step > 1 {
+				end = max
+			}
+		}
+		if begin < min || end > max {
+			return 0, fmt.Errorf("out of range: %s", part)
+		}
+		for i := begin; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	if bits == 0 {
+		return 0, errors.New("empty field")
+	}
+	return bits, nil
+}
+
+// Match reports whether the minute of the time matches the expression.
+func (c *CronSpec) Match(t time.Time) bool {
+	return c.minute&(1<<uint(t.Minute())) != 0 && c.hour&(1<<uint(t.Hour())) != 0 && c.matchDay(t)
+}
+
+// matchDay follows the cron convention: if both day of month and day of week are restricted, either matches.
+func (c *CronSpec) matchDay(t time.Time) bool {
+	if c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first matching minute after the time. Returns zero time if no time matches.
+func (c *CronSpec) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxCronSearchYears, 0, 0)
+	for t.Before(limit) {
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
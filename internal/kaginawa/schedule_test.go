@@ -0,0 +1,45 @@
+package kaginawa
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("expected error for %q", spec)
+		}
+	}
+}
+
+func TestCronSpec_Next(t *testing.T) {
+	base := time.Date(2026, 1, 30, 10, 7, 30, 0, time.UTC) // Friday
+	tests := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2026, 1, 30, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 1, 30, 10, 15, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2026, 1, 31, 3, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{"30 1 1,15 * *", time.Date(2026, 2, 1, 1, 30, 0, 0, time.UTC)},
+		{"0 0 * * 1-5", time.Date(2026, 2, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2026, 2, 6, 0, 0, 0, 0, time.UTC)}, // day of month or day of week
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		spec, err := ParseCron(test.spec)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", test.spec, err)
+		}
+		if next := spec.Next(base); !next.Equal(test.expected) {
+			t.Errorf("expected %v for %q, got %v", test.expected, test.spec, next)
+		}
+		if !test.expected.IsZero() && !spec.Match(test.expected) {
+			t.Errorf("expected %q matches %v", test.spec, test.expected)
+		}
+	}
+}
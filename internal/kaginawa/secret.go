@@ -0,0 +1,277 @@
+package kaginawa
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const dataKeyLength = 32 // AES-256
+
+// MasterKeys holds master keys for envelope encryption of secrets at rest. Nil means encryption is disabled.
+var MasterKeys *KeyRing
+
+// KeyRing holds master keys by key ID. The primary key seals new secrets, others are kept for opening old ones.
+type KeyRing struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// Envelope defines an encrypted secret. The data is encrypted by a random data key, and the data key is
+// encrypted by a master key identified by the KeyID.
+type Envelope struct {
+	KeyID   string `bson:"key_id"`
+	DataKey []byte `bson:"data_key"`
+	Data    []byte `bson:"data"`
+}
+
+// NewKeyRing creates a KeyRing from list of "id:base64-encoded-32-bytes-key" specs. The first one is primary.
+func NewKeyRing(specs ...string) (*KeyRing, error) {
+	ring := &KeyRing{keys: make(map[string][]byte)}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if len(spec) == 0 || strings.HasPrefix(spec, "#") {
+			continue
+		}
+		sep := strings.Index(spec, ":")
+		if sep < 1 {
+			return nil, fmt.Errorf("invalid master key format, expected id:base64key")
+		}
+		id := spec[:sep]
+		key, err := base64.StdEncoding.DecodeString(spec[sep+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key %s: %w", id, err)
+		}
+		if len(key) != dataKeyLength {
+			return nil, fmt.Errorf("invalid master key %s: expected %d bytes, got %d", id, dataKeyLength, len(key))
+		}
+		if _, ok := ring.keys[id]; ok {
+			return nil, fmt.Errorf("duplicated master key id: %s", id)
+		}
+		ring.keys[id] = key
+		if len(ring.primary) == 0 {
+			ring.primary = id
+		}
+	}
+	if len(ring.primary) == 0 {
+		return nil, errors.New("no master keys")
+	}
+	return ring, nil
+}
+
+// LoadKeyRing loads master keys from MASTER_KEYS (comma separated) or MASTER_KEYS_FILE (one per line).
+// Returns (nil, nil) if both are not configured.
+func LoadKeyRing() (*KeyRing, error) {
+	if keys := os.Getenv("MASTER_KEYS"); len(keys) > 0 {
+		return NewKeyRing(strings.Split(keys, ",")...)
+	}
+	path := os.Getenv("MASTER_KEYS_FILE")
+	if len(path) == 0 {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master keys file: %w", err)
+	}
+	return NewKeyRing(strings.Split(string(raw), "\n")...)
+}
+
+// PrimaryKeyID returns ID of the primary master key.
+func (k *KeyRing) PrimaryKeyID() string {
+	return k.primary
+}
+
+// Seal encrypts plaintext using a new data key wrapped by the primary master key.
+func (k *KeyRing) Seal(plaintext []byte) (*Envelope, error) {
+	dataKey := make([]byte, dataKeyLength)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	data, err := encrypt(dataKey, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := encrypt(k.keys[k.primary], dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{KeyID: k.primary, DataKey: wrapped, Data: data}, nil
+}
+
+// Open decrypts the envelope.
+func (k *KeyRing) Open(envelope Envelope) ([]byte, error) {
+	masterKey, ok := k.keys[envelope.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key id: %s", envelope.KeyID)
+	}
+	dataKey, err := decrypt(masterKey, envelope.DataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return decrypt(dataKey, envelope.Data)
+}
+
+func encrypt(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func decrypt(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, body := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, body, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// secretField defines a plaintext attribute of a database item encrypted into its envelope. The name is the key of
+// the attribute in the encrypted JSON object.
+type secretField struct {
+	name  string
+	value *string
+}
+
+// secretHolder is implemented by pointers of database items holding secrets encrypted into an envelope.
+type secretHolder[T any] interface {
+	*T
+	// secrets returns description of the item for error messages, the envelope and the secret attributes.
+	secrets() (owner string, envelope **Envelope, fields []secretField)
+}
+
+func (s *SSHServer) secrets() (string, **Envelope, []secretField) {
+	return "credentials of " + s.Host, &s.Secret, []secretField{{"key", &s.Key}, {"password", &s.Password}}
+}
+
+func (p *CredentialProfile) secrets() (string, **Envelope, []secretField) {
+	return "credential profile " + p.Name, &p.Secret, []secretField{{"key", &p.Key}, {"password", &p.Password}}
+}
+
+func (n *Notifier) secrets() (string, **Envelope, []secretField) {
+	return "notifier " + n.Name, &n.Secret, []secretField{
+		{"signing_key", &n.SigningKey},
+		{"smtp_password", &n.SMTPPassword},
+	}
+}
+
+func (h *ReportHook) secrets() (string, **Envelope, []secretField) {
+	return "report hook " + h.Name, &h.Secret, []secretField{{"signing_key", &h.SigningKey}}
+}
+
+// sealSecrets encrypts secret attributes of the item if master keys are configured, and clears them.
+func sealSecrets[T any, P secretHolder[T]](item T) (T, error) {
+	owner, envelope, fields := P(&item).secrets()
+	if MasterKeys == nil {
+		*envelope = nil
+		return item, nil
+	}
+	values := make(map[string]string, len(fields))
+	for _, field := range fields {
+		if len(*field.value) > 0 {
+			values[field.name] = *field.value
+		}
+	}
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return item, err
+	}
+	sealed, err := MasterKeys.Seal(raw)
+	if err != nil {
+		return item, fmt.Errorf("failed to seal %s: %w", owner, err)
+	}
+	for _, field := range fields {
+		*field.value = ""
+	}
+	*envelope = sealed
+	return item, nil
+}
+
+// openSecrets decrypts secret attributes of the item. Plaintext items are returned as is.
+func openSecrets[T any, P secretHolder[T]](item T) (T, error) {
+	owner, envelope, fields := P(&item).secrets()
+	if *envelope == nil {
+		return item, nil
+	}
+	if MasterKeys == nil {
+		return item, fmt.Errorf("master keys required to open %s", owner)
+	}
+	raw, err := MasterKeys.Open(**envelope)
+	if err != nil {
+		return item, fmt.Errorf("failed to open %s: %w", owner, err)
+	}
+	var values map[string]string
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return item, fmt.Errorf("failed to decode %s: %w", owner, err)
+	}
+	for _, field := range fields {
+		*field.value = values[field.name]
+	}
+	return item, nil
+}
+
+// SecretTable defines a kind of database items holding encrypted secrets.
+type SecretTable struct {
+	Name   string              // Plural name of the items, e.g. "ssh servers"
+	Rotate func() (int, error) // Re-encrypts the items, returns number of updated items
+}
+
+// SecretTables returns all kinds of database items holding encrypted secrets.
+func SecretTables(db DB) []SecretTable {
+	return []SecretTable{
+		{"ssh servers", func() (int, error) { return rotateSecrets(db.ListSSHServers, db.PutSSHServer) }},
+		{"credential profiles", func() (int, error) {
+			return rotateSecrets(db.ListCredentialProfiles, db.PutCredentialProfile)
+		}},
+		{"notifiers", func() (int, error) { return rotateSecrets(db.ListNotifiers, db.PutNotifier) }},
+		{"report hooks", func() (int, error) { return rotateSecrets(db.ListReportHooks, db.PutReportHook) }},
+	}
+}
+
+// rotateSecrets re-encrypts secrets of all items that are not sealed by the primary master key, including plaintext
+// items. Returns number of updated items.
+func rotateSecrets[T any, P secretHolder[T]](list func() ([]T, error), put func(T) error) (int, error) {
+	if MasterKeys == nil {
+		return 0, nil
+	}
+	items, err := list()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list: %w", err)
+	}
+	rotated := 0
+	for _, item := range items {
+		owner, envelope, _ := P(&item).secrets()
+		if *envelope != nil && (*envelope).KeyID == MasterKeys.PrimaryKeyID() {
+			continue
+		}
+		if err := put(item); err != nil {
+			return rotated, fmt.Errorf("failed to put %s: %w", owner, err)
+		}
+		rotated++
+	}
+	return rotated, nil
+}
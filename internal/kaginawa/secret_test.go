@@ -0,0 +1,289 @@
+package kaginawa
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+func newTestKeySpec(t *testing.T, id string) string {
+	t.Helper()
+	key := make([]byte, dataKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(key)
+}
+
+func TestNewKeyRing(t *testing.T) {
+	ring, err := NewKeyRing("", "# comment", newTestKeySpec(t, "k1"), newTestKeySpec(t, "k2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ring.PrimaryKeyID() != "k1" {
+		t.Errorf("expected primary k1, got %s", ring.PrimaryKeyID())
+	}
+	invalids := [][]string{
+		nil,
+		{"nokey"},
+		{"k1:not-base64!"},
+		{"k1:" + base64.StdEncoding.EncodeToString([]byte("short"))},
+		{newTestKeySpec(t, "k1"), newTestKeySpec(t, "k1")},
+	}
+	for _, specs := range invalids {
+		if _, err := NewKeyRing(specs...); err == nil {
+			t.Errorf("expected error for %v", specs)
+		}
+	}
+}
+
+func TestKeyRing_SealOpen(t *testing.T) {
+	ring, err := NewKeyRing(newTestKeySpec(t, "k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("secret")
+	envelope, err := ring.Seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.KeyID != "k1" {
+		t.Errorf("expected key id k1, got %s", envelope.KeyID)
+	}
+	if bytes.Contains(envelope.Data, plaintext) {
+		t.Error("expected encrypted data")
+	}
+	opened, err := ring.Open(*envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("expected %s, got %s", plaintext, opened)
+	}
+
+	other, err := NewKeyRing(newTestKeySpec(t, "k2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Open(*envelope); err == nil {
+		t.Error("expected error for unknown key id")
+	}
+	envelope.Data[len(envelope.Data)-1] ^= 0xff
+	if _, err := ring.Open(*envelope); err == nil {
+		t.Error("expected error for tampered data")
+	}
+}
+
+func TestDB_SSHServerSecrets(t *testing.T) {
+	defer func() { MasterKeys = nil }()
+	oldSpec := newTestKeySpec(t, "old")
+	ring, err := NewKeyRing(oldSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	MasterKeys = ring
+
+	db := NewMemDB()
+	server := SSHServer{Host: "localhost", Port: 22, User: "kaginawa", Key: "private-key", Password: "pass"}
+	if err := db.PutSSHServer(server); err != nil {
+		t.Fatal(err)
+	}
+	stored := db.servers["localhost"]
+	if len(stored.Key) > 0 || len(stored.Password) > 0 || stored.Secret == nil {
+		t.Fatalf("expected sealed credentials, got %+v", stored)
+	}
+	got, err := db.GetSSHServerByHost("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Key != server.Key || got.Password != server.Password {
+		t.Errorf("expected credentials %s/%s, got %s/%s", server.Key, server.Password, got.Key, got.Password)
+	}
+
+	// Rotate to the new primary key
+	ring, err = NewKeyRing(newTestKeySpec(t, "new"), oldSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	MasterKeys = ring
+	n, err := rotateTable(t, db, "ssh servers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 rotated, got %d", n)
+	}
+	if db.servers["localhost"].Secret.KeyID != "new" {
+		t.Errorf("expected key id new, got %s", db.servers["localhost"].Secret.KeyID)
+	}
+	if n, err := rotateTable(t, db, "ssh servers"); err != nil || n != 0 {
+		t.Errorf("expected 0 rotated, got %d (%v)", n, err)
+	}
+	servers, err := db.ListSSHServers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 1 || servers[0].Key != server.Key || servers[0].Password != server.Password {
+		t.Errorf("expected opened credentials, got %+v", servers)
+	}
+
+	// Missing master keys
+	MasterKeys = nil
+	if _, err := db.ListSSHServers(); err == nil {
+		t.Error("expected error without master keys")
+	}
+}
+
+func TestOpenSecrets(t *testing.T) {
+	defer func() { MasterKeys = nil }()
+	ring, err := NewKeyRing(newTestKeySpec(t, "k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	MasterKeys = ring
+	envelope, err := ring.Seal([]byte(`{"signing_key":"secret","smtp_password":"pass"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := openSecrets(Notifier{Name: "mail", Secret: envelope})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.SigningKey != "secret" || opened.SMTPPassword != "pass" {
+		t.Errorf("expected secrets opened, got %+v", opened)
+	}
+	sealed, err := sealSecrets(opened)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed.SigningKey) > 0 || len(sealed.SMTPPassword) > 0 || sealed.Secret == nil || sealed.Secret == envelope {
+		t.Errorf("expected secrets sealed, got %+v", sealed)
+	}
+}
+
+func TestSecretTables_plaintext(t *testing.T) {
+	defer func() { MasterKeys = nil }()
+	db := NewMemDB()
+	if err := db.PutSSHServer(SSHServer{Host: "localhost", Port: 22, User: "kaginawa", Password: "pass"}); err != nil {
+		t.Fatal(err)
+	}
+	if db.servers["localhost"].Password != "pass" {
+		t.Fatal("expected plaintext without master keys")
+	}
+	ring, err := NewKeyRing(newTestKeySpec(t, "k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	MasterKeys = ring
+	n, err := rotateTable(t, db, "ssh servers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || len(db.servers["localhost"].Password) > 0 {
+		t.Errorf("expected plaintext to be sealed, got %d %+v", n, db.servers["localhost"])
+	}
+}
+
+func TestDB_CredentialProfileSecrets(t *testing.T) {
+	defer func() { MasterKeys = nil }()
+	db := NewMemDB()
+	profile := CredentialProfile{Name: "default", User: "pi", Key: "PRIVATE KEY", Password: "pass"}
+	if err := db.PutCredentialProfile(profile); err != nil {
+		t.Fatal(err)
+	}
+	ring, err := NewKeyRing(newTestKeySpec(t, "k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	MasterKeys = ring
+	n, err := rotateTable(t, db, "credential profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := db.profiles["default"]
+	if n != 1 || len(stored.Key) > 0 || len(stored.Password) > 0 || stored.Secret == nil {
+		t.Errorf("expected sealed profile, got %d %+v", n, stored)
+	}
+	opened, err := db.GetCredentialProfile("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.Key != profile.Key || opened.Password != profile.Password {
+		t.Errorf("expected opened credentials, got %+v", opened)
+	}
+	MasterKeys = nil
+	if _, err := db.ListCredentialProfiles(); err == nil {
+		t.Error("expected error without master keys")
+	}
+}
+
+func TestDB_NotifierSecrets(t *testing.T) {
+	defer func() { MasterKeys = nil }()
+	db := NewMemDB()
+	notifier := Notifier{Name: "mail", Type: NotifierEmail, SMTPPassword: "pass", SigningKey: "key"}
+	if err := db.PutNotifier(notifier); err != nil {
+		t.Fatal(err)
+	}
+	ring, err := NewKeyRing(newTestKeySpec(t, "k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	MasterKeys = ring
+	n, err := rotateTable(t, db, "notifiers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := db.notifiers["mail"]
+	if n != 1 || len(stored.SMTPPassword) > 0 || len(stored.SigningKey) > 0 || stored.Secret == nil {
+		t.Errorf("expected sealed notifier, got %d %+v", n, stored)
+	}
+	opened, err := db.GetNotifier("mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.SMTPPassword != notifier.SMTPPassword || opened.SigningKey != notifier.SigningKey {
+		t.Errorf("expected opened secrets, got %+v", opened)
+	}
+}
+
+func TestDB_ReportHookSecrets(t *testing.T) {
+	defer func() { MasterKeys = nil }()
+	db := NewMemDB()
+	hook := ReportHook{Name: "warehouse", URL: "https://example.com/reports", SigningKey: "key"}
+	if err := db.PutReportHook(hook); err != nil {
+		t.Fatal(err)
+	}
+	ring, err := NewKeyRing(newTestKeySpec(t, "k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	MasterKeys = ring
+	n, err := rotateTable(t, db, "report hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := db.reportHooks["warehouse"]
+	if n != 1 || len(stored.SigningKey) > 0 || stored.Secret == nil {
+		t.Errorf("expected sealed report hook, got %d %+v", n, stored)
+	}
+	opened, err := db.GetReportHook("warehouse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.SigningKey != hook.SigningKey {
+		t.Errorf("expected opened secrets, got %+v", opened)
+	}
+}
+
+// rotateTable rotates secrets of the table of the name.
+func rotateTable(t *testing.T, db DB, name string) (int, error) {
+	t.Helper()
+	for _, table := range SecretTables(db) {
+		if table.Name == name {
+			return table.Rotate()
+		}
+	}
+	t.Fatalf("unknown secret table: %s", name)
+	return 0, nil
+}